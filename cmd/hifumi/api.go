@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/vinymeuh/hifumi/engine"
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// api serves the analysis of positions over HTTP.
+func api(args []string) error {
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: hifumi api [options]")
+		fs.PrintDefaults()
+	}
+	address := fs.String("address", ":8080", "address listened as host:port")
+	variant := fs.String("variant", "shogi", "variant of the positions")
+	rule := fs.String("rule", shogi.CSARule27.String(), "entering king rule")
+	command := fs.String("engine", "", "command of the USI engine analysing the positions, the built-in engine if empty")
+	var options listFlag
+	fs.Var(&options, "option", "USI option of the engine as name=value (repeatable)")
+	engines := fs.Int("engines", 1, "number of engine processes analysing at the same time, one for the built-in engine")
+	queue := fs.Int("queue", 16, "number of analyses waiting for an engine, beyond which the requests are refused")
+	moveTime := fs.Duration("movetime", time.Second, "duration of the analyses requested without limits")
+	maxMoveTime := fs.Duration("maxmovetime", 10*time.Second, "maximum duration of an analysis")
+	maxPerft := fs.Int("maxperft", 5, "maximum depth of perft")
+	perfts := fs.Int("perfts", runtime.NumCPU(), "number of perft computed at the same time")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+
+	v, err := shogi.VariantByName(*variant)
+	if err != nil {
+		return err
+	}
+	enteringKingRule, err := shogi.NewEnteringKingRule(*rule)
+	if err != nil {
+		return err
+	}
+	opts := engine.APIOptions{
+		Variant:          v,
+		EnteringKingRule: enteringKingRule,
+		Engine:           nil,
+		Engines:          *engines,
+		MaxQueue:         *queue,
+		MoveTime:         *moveTime,
+		MaxMoveTime:      *maxMoveTime,
+		MaxPerftDepth:    *maxPerft,
+		Perfts:           *perfts,
+	}
+	if *command != "" {
+		e, err := matchEngine("", *command, options)
+		if err != nil {
+			return err
+		}
+		opts.Engine = &e
+	}
+	handler, err := engine.NewAPI(opts)
+	if err != nil {
+		return err
+	}
+	defer handler.Close()
+
+	s := &http.Server{ //nolint:exhaustruct
+		Addr:              *address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s.ListenAndServe()
+}
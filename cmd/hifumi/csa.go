@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/vinymeuh/hifumi/engine"
+)
+
+// csa plays on a CSA server like Floodgate.
+func csa(args []string) error {
+	fs := flag.NewFlagSet("csa", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: hifumi csa [options] host:port name password")
+		fs.PrintDefaults()
+	}
+	command := fs.String("engine", "", "command of the USI engine playing the games, the built-in engine if empty")
+	var options listFlag
+	fs.Var(&options, "option", "USI option of the engine as name=value (repeatable)")
+	games := fs.Int("games", 1, "number of games played before logging out, unlimited if zero")
+	margin := fs.Duration("margin", time.Second, "time kept on the clock for the network delays")
+	moveTime := fs.Duration("movetime", time.Second, "thinking time of the engine when the time is unlimited")
+	reconnects := fs.Int("reconnects", 5, "number of attempts to connect again after losing the connection")
+	delay := fs.Duration("reconnect-delay", 5*time.Second, "delay before the first attempt to connect again, doubled at each attempt")
+	keepAlive := fs.Duration("keepalive", 30*time.Second, "interval of the empty lines keeping the connection alive, none if zero")
+	records := fs.String("records", "", "directory where the games are written as CSA files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 3 {
+		fs.Usage()
+		return fmt.Errorf("expected the server address, the login name and the password")
+	}
+
+	opts := engine.CSAClientOptions{
+		Address:        fs.Arg(0),
+		Name:           fs.Arg(1),
+		Password:       fs.Arg(2),
+		Engine:         nil,
+		Games:          *games,
+		Margin:         *margin,
+		MoveTime:       *moveTime,
+		Reconnects:     *reconnects,
+		ReconnectDelay: *delay,
+		KeepAlive:      *keepAlive,
+		Records:        *records,
+	}
+	if *command != "" {
+		e, err := matchEngine("", *command, options)
+		if err != nil {
+			return err
+		}
+		opts.Engine = &e
+	}
+	return engine.CSAClient(os.Stdout, opts)
+}
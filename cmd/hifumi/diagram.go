@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/render"
+)
+
+// listFlag collects the values of a repeatable flag.
+type listFlag []string
+
+func (a *listFlag) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *listFlag) Set(value string) error {
+	*a = append(*a, value)
+	return nil
+}
+
+// diagram writes the SVG or PNG diagram of a position given as in the USI position command.
+func diagram(args []string) error {
+	fs := flag.NewFlagSet("diagram", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: hifumi diagram [options] <sfen|startpos|handicap name> [moves <move1> ... <movei>]")
+		fs.PrintDefaults()
+	}
+	variant := fs.String("variant", "shogi", "variant of the position")
+	flip := fs.Bool("flip", false, "draw the board seen from White's side")
+	size := fs.Int("size", render.DefaultSquareSize, "size of a square in pixels")
+	output := fs.String("o", "", "output file, PNG if its extension is .png, SVG on the standard output if empty")
+	var arrows listFlag
+	fs.Var(&arrows, "arrow", "move drawn as an arrow, e.g. 7g7f or P*5e (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("missing position")
+	}
+
+	_, g, pos, err := startGame(*variant, fs.Args())
+	if err != nil {
+		return err
+	}
+	var last shogi.Move
+	if moves := g.MainLine(); len(moves) > 0 {
+		last = moves[len(moves)-1]
+	}
+	opts := render.DiagramOptions{Flip: *flip, LastMove: last, Arrows: nil, SquareSize: *size}
+	for _, a := range arrows {
+		m, err := parseArrow(pos, a)
+		if err != nil {
+			return err
+		}
+		opts.Arrows = append(opts.Arrows, m)
+	}
+
+	write := render.WriteSVG
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		if strings.EqualFold(filepath.Ext(*output), ".png") {
+			write = render.WritePNG
+		}
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return write(w, pos, opts)
+}
+
+// parseArrow parses a move in USI notation without checking it is legal, e.g. 7g7f or P*5e,
+// the dropped piece belonging to the side to move.
+func parseArrow(pos *shogi.Position, str string) (shogi.Move, error) {
+	str = strings.TrimRight(str, "+-")
+	if len(str) != 4 {
+		return 0, fmt.Errorf("invalid arrow '%s'", str)
+	}
+	to, err := arrowSquare(pos, str[2:])
+	if err != nil {
+		return 0, err
+	}
+	if str[1] == '*' {
+		piece, err := shogi.NewPiece(str[:1])
+		if err != nil || piece.Color() != shogi.Black {
+			return 0, fmt.Errorf("invalid arrow '%s'", str)
+		}
+		if pos.Side == shogi.White {
+			piece = piece.ToOpponentHand()
+		}
+		return shogi.NewMove(shogi.MoveFlagDrop, 0, to, piece), nil
+	}
+	from, err := arrowSquare(pos, str[:2])
+	if err != nil {
+		return 0, err
+	}
+	return shogi.NewMove(0, from, to, shogi.NoPiece), nil
+}
+
+// arrowSquare parses a square of the board of the position, e.g. 7g.
+func arrowSquare(pos *shogi.Position, str string) (uint8, error) {
+	if str[0] < '1' || str[0] > '9' || str[1] < 'a' || str[1] > 'i' {
+		return 0, fmt.Errorf("invalid square '%s'", str)
+	}
+	sq := shogi.NewSquareIndex(str)
+	if !pos.Variant.Contains(sq) {
+		return 0, fmt.Errorf("square '%s' is outside of the board", str)
+	}
+	return sq, nil
+}
@@ -21,16 +21,66 @@ func main() {
 		switch arg {
 		case "perfttest":
 			n := i + 1 // because of os.Args[1:]
+			variant := "shogi"
+			if n+3 == len(os.Args)-1 { // optional variant
+				n++
+				variant = os.Args[n]
+			}
 			if n+2 == len(os.Args)-1 {
 				startpos := os.Args[n+1]
 				depth, err := strconv.Atoi(os.Args[n+2])
 				if err == nil && depth > 0 {
-					engine.Perfttest(startpos, depth)
+					if err := engine.Perfttest(variant, startpos, depth); err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						os.Exit(1)
+					}
 					return
 				}
 			}
-			fmt.Fprintln(os.Stderr, "Usage: hifumi perfttest startpos depth")
+			fmt.Fprintln(os.Stderr, "Usage: hifumi perfttest [variant] <sfen|startpos|handicap> depth")
 			os.Exit(1)
+		case "diagram":
+			if err := diagram(os.Args[i+2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "play":
+			if err := play(os.Args[i+2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "match":
+			if err := match(os.Args[i+2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "tournament":
+			if err := tournament(os.Args[i+2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "csa":
+			if err := csa(os.Args[i+2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "server":
+			if err := server(os.Args[i+2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "api":
+			if err := api(os.Args[i+2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
 		case "-pprof":
 			profiler = pprofiler_start()
 			defer profiler.stop()
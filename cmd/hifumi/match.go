@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vinymeuh/hifumi/engine"
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// match plays a match between two USI engines.
+func match(args []string) error {
+	fs := flag.NewFlagSet("match", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: hifumi match [options] "<engine1 command>" "<engine2 command>"`)
+		fs.PrintDefaults()
+	}
+	gf := newGameFlags(fs)
+	games := fs.Int("games", 2, "number of games, each opening being played twice with colors swapped; with -sprt, the maximum, unlimited by default")
+	sprt := fs.Bool("sprt", false, "stop the match when the SPRT of -elo0 against -elo1 accepts a hypothesis")
+	elo0 := fs.Float64("elo0", 0, "Elo difference of the SPRT hypothesis H0")
+	elo1 := fs.Float64("elo1", 5, "Elo difference of the SPRT hypothesis H1")
+	alpha := fs.Float64("alpha", 0.05, "probability of the SPRT to accept H1 when H0 is true")
+	beta := fs.Float64("beta", 0.05, "probability of the SPRT to accept H0 when H1 is true")
+	var names [2]string
+	var options [2]listFlag
+	for i := range names {
+		fs.StringVar(&names[i], fmt.Sprintf("name%d", i+1), "", fmt.Sprintf("name of engine %d, its USI name if empty", i+1))
+		fs.Var(&options[i], fmt.Sprintf("option%d", i+1), fmt.Sprintf("USI option of engine %d as name=value (repeatable)", i+1))
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("expected two engine commands")
+	}
+
+	settings, err := gf.settings()
+	if err != nil {
+		return err
+	}
+	opts := engine.MatchOptions{GameSettings: settings, Engines: [2]engine.MatchEngine{}, Games: *games, SPRT: nil}
+	if *sprt {
+		if opts.SPRT, err = engine.NewSPRT(*elo0, *elo1, *alpha, *beta); err != nil {
+			return err
+		}
+		gamesSet := false
+		fs.Visit(func(f *flag.Flag) { gamesSet = gamesSet || f.Name == "games" })
+		if !gamesSet {
+			opts.Games = 0
+		}
+	}
+	for i := range opts.Engines {
+		if opts.Engines[i], err = matchEngine(names[i], fs.Arg(i), options[i]); err != nil {
+			return err
+		}
+	}
+
+	_, err = engine.Match(os.Stdout, opts)
+	return err
+}
+
+// gameFlags are the flags of the game settings shared by the match and tournament commands.
+type gameFlags struct {
+	variant   *string
+	mainTime  *time.Duration
+	byoyomi   *time.Duration
+	increment *time.Duration
+	moveTime  *time.Duration
+	maxMoves  *int
+	rule      *string
+	openings  *string
+	records   *string
+	event     *string
+}
+
+// newGameFlags defines the flags of the game settings.
+func newGameFlags(fs *flag.FlagSet) *gameFlags {
+	return &gameFlags{
+		variant:   fs.String("variant", "shogi", "variant played"),
+		mainTime:  fs.Duration("time", 0, "main time of each player, unlimited if zero"),
+		byoyomi:   fs.Duration("byoyomi", 0, "time for each move once the main time is exhausted"),
+		increment: fs.Duration("inc", 0, "time added after each move"),
+		moveTime:  fs.Duration("movetime", time.Second, "thinking time of the engines when the time is unlimited"),
+		maxMoves:  fs.Int("maxmoves", 256, "number of moves played by the engines after which a game is a draw, no limit if zero"),
+		rule:      fs.String("rule", shogi.CSARule27.String(), "entering king rule"),
+		openings:  fs.String("openings", "", "file of openings, one '<sfen|startpos|handicap name> [moves ...]' per line"),
+		records:   fs.String("records", "", "directory where the games are written as KIF files"),
+		event:     fs.String("event", "", "name of the event in the game records"),
+	}
+}
+
+// settings returns the game settings given by the flags.
+func (gf *gameFlags) settings() (engine.GameSettings, error) {
+	gs := engine.GameSettings{
+		Variant:          nil,
+		EnteringKingRule: 0,
+		TimeControl:      shogi.TimeControl{Main: *gf.mainTime, Byoyomi: *gf.byoyomi, Increment: *gf.increment},
+		MoveTime:         *gf.moveTime,
+		Openings:         nil,
+		MaxMoves:         *gf.maxMoves,
+		Event:            *gf.event,
+		Records:          *gf.records,
+	}
+	var err error
+	if gs.Variant, err = shogi.VariantByName(*gf.variant); err != nil {
+		return gs, err
+	}
+	if gs.EnteringKingRule, err = shogi.NewEnteringKingRule(*gf.rule); err != nil {
+		return gs, err
+	}
+	if *gf.openings != "" {
+		if gs.Openings, err = readOpenings(*gf.variant, *gf.openings); err != nil {
+			return gs, err
+		}
+	}
+	return gs, nil
+}
+
+// matchEngine returns the settings of an engine from its command line and its options as name=value.
+func matchEngine(name, command string, options []string) (engine.MatchEngine, error) {
+	e := engine.MatchEngine{Name: name, Command: strings.Fields(command), Options: nil}
+	if len(e.Command) == 0 {
+		return e, fmt.Errorf("empty engine command")
+	}
+	for _, option := range options {
+		key, value, ok := strings.Cut(option, "=")
+		if !ok {
+			return e, fmt.Errorf("invalid option '%s', expected name=value", option)
+		}
+		e.Options = append(e.Options, [2]string{key, value})
+	}
+	return e, nil
+}
+
+// readOpenings reads a file of openings, one position per line as in the USI position command.
+// The keywords position and sfen are optional. Empty lines and lines starting with # are ignored.
+func readOpenings(variant, name string) ([]*shogi.Game, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var openings []*shogi.Game
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(strings.TrimPrefix(line, "position "), "sfen ")
+		_, g, _, err := startGame(variant, strings.Fields(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, n, err)
+		}
+		openings = append(openings, g)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(openings) == 0 {
+		return nil, fmt.Errorf("%s: no opening", name)
+	}
+	return openings, nil
+}
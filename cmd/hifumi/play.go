@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/vinymeuh/hifumi/engine"
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/render"
+)
+
+// play runs a game between a human on the terminal and the engine.
+func play(args []string) error {
+	fs := flag.NewFlagSet("play", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: hifumi play [options] [<sfen|startpos|handicap name> [moves <move1> ... <movei>]]")
+		fs.PrintDefaults()
+	}
+	variant := fs.String("variant", "shogi", "variant played")
+	color := fs.String("color", "black", "color played by the human, black or white")
+	mainTime := fs.Duration("time", 0, "main time of each player, unlimited if zero")
+	byoyomi := fs.Duration("byoyomi", 0, "time for each move once the main time is exhausted")
+	increment := fs.Duration("inc", 0, "time added after each move")
+	moveTime := fs.Duration("movetime", time.Second, "thinking time of the engine when the time is unlimited")
+	rule := fs.String("rule", shogi.CSARule27.String(), "entering king rule")
+	style := fs.String("style", render.Kanji.String(), "board style")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var human shogi.Color
+	switch *color {
+	case "black":
+		human = shogi.Black
+	case "white":
+		human = shogi.White
+	default:
+		return fmt.Errorf("invalid color '%s'", *color)
+	}
+	enteringKingRule, err := shogi.NewEnteringKingRule(*rule)
+	if err != nil {
+		return err
+	}
+	displayStyle, err := render.NewStyle(*style)
+	if err != nil {
+		return err
+	}
+	position := fs.Args()
+	if len(position) == 0 {
+		position = []string{"startpos"}
+	}
+	v, g, _, err := startGame(*variant, position)
+	if err != nil {
+		return err
+	}
+	g.Header.TimeControl = shogi.TimeControl{Main: *mainTime, Byoyomi: *byoyomi, Increment: *increment}
+	g.Header.Date = time.Now().Truncate(time.Second)
+	g.Header.Players[human] = "Human"
+	g.Header.Players[human.Opponent()] = "Hifumi " + engine.EngineVersion
+
+	return engine.Play(os.Stdin, os.Stdout, engine.PlayOptions{
+		Variant:          v,
+		Game:             g,
+		EnteringKingRule: enteringKingRule,
+		Human:            human,
+		MoveTime:         *moveTime,
+		Display:          render.Options{Style: displayStyle, Flip: human == shogi.White},
+	})
+}
@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"strings"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	_ "github.com/vinymeuh/hifumi/shogi/movegen" // registers the shogi.MoveGenerator
+)
+
+// startGame returns the game described as in the USI position command by
+// "<sfen|startpos|[handicap] name> [moves <move1> ... <movei>]", with its variant and
+// the position after its moves.
+func startGame(variant string, args []string) (*shogi.Variant, *shogi.Game, *shogi.Position, error) {
+	v, err := shogi.VariantByName(variant)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	movesIndex := len(args)
+	for i, arg := range args {
+		if arg == "moves" {
+			movesIndex = i
+			break
+		}
+	}
+	startpos := strings.TrimPrefix(strings.Join(args[:movesIndex], " "), "handicap ")
+	if startpos == "startpos" {
+		startpos = v.StartPos
+	} else if sfen, err := shogi.HandicapSfen(startpos); err == nil && v == shogi.Standard {
+		startpos = sfen
+	}
+	pos, err := v.NewPositionFromSfen(startpos)
+	if err == nil {
+		err = pos.Validate()
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	g := shogi.NewGame(startpos)
+	g.Variant = v
+	if movesIndex < len(args) {
+		for _, arg := range args[movesIndex+1:] {
+			m, err := shogi.ParseMove(pos, arg)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			pos.DoMove(m)
+			g.AddMove(m, 0)
+		}
+	}
+	return v, g, pos, nil
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/vinymeuh/hifumi/engine"
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// server runs a CSA game server.
+func server(args []string) error {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: hifumi server [options]")
+		fs.PrintDefaults()
+	}
+	address := fs.String("address", ":4081", "address listened as host:port")
+	password := fs.String("password", "", "password required to log in, any password being accepted if empty")
+	mainTime := fs.Duration("time", 5*time.Minute, "main time of each player, unlimited if zero with no byoyomi or increment")
+	byoyomi := fs.Duration("byoyomi", 0, "time for each move once the main time is exhausted")
+	increment := fs.Duration("inc", 10*time.Second, "time added after each move, zero by default with a byoyomi")
+	unit := fs.Duration("unit", time.Second, "time unit in which the times are counted, rounded down")
+	least := fs.Duration("least", 0, "minimum time counted for a move")
+	maxMoves := fs.Int("maxmoves", 256, "number of moves after which a game is a draw, no limit if zero")
+	declaration := fs.Bool("declaration", true, "allow the entering king declaration of the CSA rule")
+	records := fs.String("records", "", "directory where the games are written as CSA files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if *byoyomi > 0 {
+		incrementSet := false
+		fs.Visit(func(f *flag.Flag) { incrementSet = incrementSet || f.Name == "inc" })
+		if incrementSet && *increment > 0 {
+			return fmt.Errorf("byoyomi and increment can't be used together")
+		}
+		*increment = 0
+	}
+
+	s, err := engine.NewCSAServer(os.Stdout, engine.CSAServerOptions{
+		Address:          *address,
+		Password:         *password,
+		TimeUnit:         *unit,
+		TimeControl:      shogi.TimeControl{Main: *mainTime, Byoyomi: *byoyomi, Increment: *increment},
+		LeastTimePerMove: *least,
+		MaxMoves:         *maxMoves,
+		Declaration:      *declaration,
+		Records:          *records,
+	})
+	if err != nil {
+		return err
+	}
+	return s.Serve()
+}
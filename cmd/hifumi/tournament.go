@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/vinymeuh/hifumi/engine"
+)
+
+// tournament plays a tournament between USI engines.
+func tournament(args []string) error {
+	fs := flag.NewFlagSet("tournament", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: hifumi tournament [options] "<engine1 command>" "<engine2 command>" ...`)
+		fs.PrintDefaults()
+	}
+	gf := newGameFlags(fs)
+	schedule := fs.String("schedule", engine.RoundRobin.String(), "pairing of the engines: "+strings.Join(engine.Schedules(), " or "))
+	rounds := fs.Int("rounds", 1, "number of game pairs played by each pairing, the engines swapping colors")
+	concurrency := fs.Int("concurrency", runtime.NumCPU(), "number of games played at the same time")
+	var names, options listFlag
+	fs.Var(&names, "name", "name of an engine as n:name, n counted from 1 (repeatable)")
+	fs.Var(&options, "option", "USI option of an engine as n:name=value, n counted from 1 (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return fmt.Errorf("expected at least two engine commands")
+	}
+
+	settings, err := gf.settings()
+	if err != nil {
+		return err
+	}
+	opts := engine.TournamentOptions{
+		GameSettings: settings,
+		Engines:      nil,
+		Schedule:     engine.RoundRobin,
+		Rounds:       *rounds,
+		Concurrency:  *concurrency,
+	}
+	if opts.Schedule, err = engine.NewSchedule(*schedule); err != nil {
+		return err
+	}
+	engineNames := make([]string, fs.NArg())
+	engineOptions := make([][]string, fs.NArg())
+	for _, name := range names {
+		n, value, err := engineArg(name, fs.NArg())
+		if err != nil {
+			return err
+		}
+		engineNames[n] = value
+	}
+	for _, option := range options {
+		n, value, err := engineArg(option, fs.NArg())
+		if err != nil {
+			return err
+		}
+		engineOptions[n] = append(engineOptions[n], value)
+	}
+	for i, command := range fs.Args() {
+		e, err := matchEngine(engineNames[i], command, engineOptions[i])
+		if err != nil {
+			return err
+		}
+		opts.Engines = append(opts.Engines, e)
+	}
+
+	result, err := engine.Tournament(os.Stdout, opts)
+	if result != nil && len(result.Games) > 0 {
+		fmt.Println()
+		result.WriteCrosstable(os.Stdout)
+	}
+	return err
+}
+
+// engineArg splits a flag value given for an engine as n:value, returning the index of the engine counted from 0.
+func engineArg(arg string, engines int) (int, string, error) {
+	prefix, value, ok := strings.Cut(arg, ":")
+	n, err := strconv.Atoi(prefix)
+	if !ok || err != nil || n < 1 || n > engines {
+		return 0, "", fmt.Errorf("invalid value '%s', expected n:value with n between 1 and %d", arg, engines)
+	}
+	return n - 1, value, nil
+}
@@ -0,0 +1,565 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/movegen"
+	"github.com/vinymeuh/hifumi/shogi/perft"
+)
+
+// APIOptions are the settings of the HTTP analysis API.
+type APIOptions struct {
+	// Variant of the positions
+	Variant *shogi.Variant
+	// EnteringKingRule given to the engines
+	EnteringKingRule shogi.EnteringKingRule
+	// Engine analysing the positions, the built-in engine if nil
+	Engine *MatchEngine
+	// Engines is the number of engine processes, the number of analyses run at the same time.
+	// The built-in engine runs a single analysis at a time.
+	Engines int
+	// MaxQueue is the number of analyses waiting for an engine, beyond which the requests are refused
+	MaxQueue int
+	// MoveTime is the duration of the analyses requested without limits
+	MoveTime time.Duration
+	// MaxMoveTime is the maximum duration of an analysis
+	MaxMoveTime time.Duration
+	// MaxPerftDepth is the maximum depth of perft
+	MaxPerftDepth int
+	// Perfts is the number of perft computations run at the same time, beyond which the requests are refused
+	Perfts int
+}
+
+// apiMaxBody is the maximum size of a request body.
+const apiMaxBody = 1 << 20
+
+// errAPIBusy is returned when the limit of concurrent requests is reached.
+var errAPIBusy = errors.New("too many requests, try again later")
+
+// An API serves the analysis of positions over HTTP with JSON requests and responses:
+//
+//	POST /analyse    {"sfen": "startpos", "moves": ["7g7f"], "limits": {"movetime": 1000, "depth": 0, "nodes": 0}}
+//	POST /legalmoves {"sfen": "startpos", "moves": ["7g7f"]}
+//	POST /perft      {"sfen": "startpos", "moves": [], "depth": 3, "divide": false}
+//	GET  /status
+//
+// The sfen is a SFEN string, "startpos" or a handicap name, the start position of the variant if empty.
+// Errors are returned as {"error": "message"} with an HTTP error status.
+type API struct {
+	opts    APIOptions
+	name    string          // name of the engine, set by NewAPI
+	idle    chan *apiEngine // engines not analysing
+	mu      sync.Mutex
+	engines map[*apiEngine]bool // engines running
+	closed  bool
+	perfts  chan struct{} // one token per perft running
+	mux     *http.ServeMux
+	queued  atomic.Int64 // analyses waiting for an engine
+	served  atomic.Int64 // analyses completed
+}
+
+// apiEngine is an engine of the API with its name and the function stopping it.
+type apiEngine struct {
+	*usiClient
+	name  string
+	close func()
+}
+
+// NewAPI starts the engines of the analysis API.
+func NewAPI(opts APIOptions) (*API, error) {
+	if opts.Engine == nil || opts.Engines < 1 {
+		opts.Engines = 1
+	}
+	api := &API{
+		opts:    opts,
+		name:    "",
+		idle:    make(chan *apiEngine, opts.Engines),
+		mu:      sync.Mutex{},
+		engines: make(map[*apiEngine]bool),
+		closed:  false,
+		perfts:  make(chan struct{}, max(1, opts.Perfts)),
+		mux:     http.NewServeMux(),
+		queued:  atomic.Int64{},
+		served:  atomic.Int64{},
+	}
+	for i := 0; i < opts.Engines; i++ {
+		e, err := api.startEngine()
+		if err != nil {
+			api.Close()
+			return nil, err
+		}
+		api.name = e.name
+		api.idle <- e
+	}
+	api.mux.HandleFunc("/analyse", api.handle(http.MethodPost, api.analyse))
+	api.mux.HandleFunc("/legalmoves", api.handle(http.MethodPost, api.legalMoves))
+	api.mux.HandleFunc("/perft", api.handle(http.MethodPost, api.perft))
+	api.mux.HandleFunc("/status", api.handle(http.MethodGet, api.status))
+	return api, nil
+}
+
+// startEngine starts an engine.
+func (api *API) startEngine() (*apiEngine, error) {
+	e, err := api.newEngine()
+	if err != nil {
+		return nil, err
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.closed {
+		e.close()
+		return nil, errors.New("API closed")
+	}
+	api.engines[e] = true
+	return e, nil
+}
+
+// stopEngine stops an engine.
+func (api *API) stopEngine(e *apiEngine) {
+	api.mu.Lock()
+	running := api.engines[e]
+	delete(api.engines, e)
+	api.mu.Unlock()
+	if running {
+		e.close()
+	}
+}
+
+// replaceEngine stops an engine which failed, as its state is unknown, and adds a new one
+// to the idle engines. The API runs with one engine less if the new one can't be started.
+func (api *API) replaceEngine(e *apiEngine) {
+	api.stopEngine(e)
+	if e, err := api.startEngine(); err == nil {
+		api.idle <- e
+	}
+}
+
+// newEngine starts the built-in engine or a process of the external engine.
+func (api *API) newEngine() (*apiEngine, error) {
+	if api.opts.Engine != nil {
+		p, err := startUsiProcess(*api.opts.Engine, &GameSettings{
+			Variant:          api.opts.Variant,
+			EnteringKingRule: api.opts.EnteringKingRule,
+			TimeControl:      shogi.TimeControl{Main: 0, Byoyomi: 0, Increment: 0},
+			MoveTime:         0,
+			Openings:         nil,
+			MaxMoves:         0,
+			Event:            "",
+			Records:          "",
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &apiEngine{usiClient: p.usiClient, name: p.name, close: p.close}, nil
+	}
+
+	uc := newLocalUsiClient()
+	options := [][2]string{{"EnteringKingRule", api.opts.EnteringKingRule.String()}}
+	if api.opts.Variant != shogi.Standard {
+		options = append(options, [2]string{"USI_Variant", api.opts.Variant.Name})
+	}
+	name, err := uc.handshake(options)
+	if err != nil {
+		uc.quit()
+		return nil, err
+	}
+	return &apiEngine{usiClient: uc, name: name, close: uc.quit}, nil
+}
+
+// Close stops the engines.
+func (api *API) Close() {
+	api.mu.Lock()
+	api.closed = true
+	engines := api.engines
+	api.engines = make(map[*apiEngine]bool)
+	api.mu.Unlock()
+	for e := range engines {
+		e.close()
+	}
+}
+
+// ServeHTTP serves a request of the API.
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mux.ServeHTTP(w, r)
+}
+
+// apiError is an error with its HTTP status.
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+// badRequest returns an error of the request.
+func badRequest(format string, a ...any) error {
+	return &apiError{status: http.StatusBadRequest, err: fmt.Errorf(format, a...)}
+}
+
+// handle returns the handler of an endpoint accepting a method, writing the response or the error as JSON.
+func (api *API) handle(method string, endpoint func(r *http.Request) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		status, response := http.StatusOK, any(nil)
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			status, response = http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"}
+		} else if result, err := endpoint(r); err != nil {
+			var ae *apiError
+			switch {
+			case errors.As(err, &ae):
+				status = ae.status
+			case errors.Is(err, errAPIBusy):
+				status = http.StatusServiceUnavailable
+				w.Header().Set("Retry-After", "1")
+			default:
+				status = http.StatusInternalServerError
+			}
+			response = map[string]string{"error": err.Error()}
+		} else {
+			response = result
+		}
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+// decode reads the JSON body of a request.
+func decode(r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, apiMaxBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return badRequest("invalid request: %v", err)
+	}
+	return nil
+}
+
+// apiPosition is a position given by its start position and the moves played from it.
+type apiPosition struct {
+	SFEN  string   `json:"sfen"`
+	Moves []string `json:"moves"`
+}
+
+// position returns the start position as a SFEN string, and the position after the moves.
+func (api *API) position(p apiPosition) (string, *shogi.Position, error) {
+	sfen := p.SFEN
+	switch {
+	case sfen == "" || sfen == "startpos":
+		sfen = api.opts.Variant.StartPos
+	case api.opts.Variant == shogi.Standard && !strings.Contains(sfen, " "):
+		handicap, err := shogi.HandicapSfen(sfen)
+		if err != nil {
+			return "", nil, badRequest("%v", err)
+		}
+		sfen = handicap
+	}
+	pos, err := api.opts.Variant.NewPositionFromSfen(sfen)
+	if err == nil {
+		err = pos.Validate()
+	}
+	if err != nil {
+		return "", nil, badRequest("%v", err)
+	}
+	pos.EnteringKingRule = api.opts.EnteringKingRule
+	for _, str := range p.Moves {
+		m, err := shogi.ParseMove(pos, str)
+		if err != nil {
+			return "", nil, badRequest("%v", err)
+		}
+		pos.DoMove(m)
+	}
+	return sfen, pos, nil
+}
+
+// apiLimits are the limits of an analysis, none if zero.
+type apiLimits struct {
+	MoveTime int64 `json:"movetime"` // milliseconds
+	Depth    int   `json:"depth"`
+	Nodes    int64 `json:"nodes"`
+}
+
+type apiAnalyseRequest struct {
+	apiPosition
+	Limits apiLimits `json:"limits"`
+}
+
+// apiScore is a score in centipawns or a mate in a number of plies, negative when mated.
+type apiScore struct {
+	CP   *int `json:"cp,omitempty"`
+	Mate *int `json:"mate,omitempty"`
+}
+
+type apiAnalysis struct {
+	BestMove string    `json:"bestmove"` // a move, "resign" or "win"
+	Score    *apiScore `json:"score,omitempty"`
+	Depth    int       `json:"depth"`
+	Nodes    int64     `json:"nodes"`
+	PV       []string  `json:"pv"`
+	Time     int64     `json:"time"` // milliseconds
+}
+
+// analyse searches the best move of a position.
+func (api *API) analyse(r *http.Request) (any, error) {
+	var req apiAnalyseRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	if req.Limits.MoveTime < 0 || req.Limits.Depth < 0 || req.Limits.Nodes < 0 {
+		return nil, badRequest("invalid limits")
+	}
+	sfen, _, err := api.position(req.apiPosition)
+	if err != nil {
+		return nil, err
+	}
+
+	moveTime := time.Duration(req.Limits.MoveTime) * time.Millisecond
+	if moveTime == 0 {
+		moveTime = api.opts.MoveTime
+		if req.Limits.Depth > 0 || req.Limits.Nodes > 0 {
+			moveTime = api.opts.MaxMoveTime
+		}
+	}
+	goArgs := fmt.Sprintf("movetime %d", min(moveTime, api.opts.MaxMoveTime).Milliseconds())
+	if req.Limits.Depth > 0 {
+		goArgs += fmt.Sprintf(" depth %d", req.Limits.Depth)
+	}
+	if req.Limits.Nodes > 0 {
+		goArgs += fmt.Sprintf(" nodes %d", req.Limits.Nodes)
+	}
+	position := "position sfen " + sfen
+	if len(req.Moves) > 0 {
+		position += " moves " + strings.Join(req.Moves, " ")
+	}
+
+	e, err := api.acquire(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	analysis, err := analysePosition(r.Context(), e.usiClient, position, goArgs, min(moveTime, api.opts.MaxMoveTime)+usiStopTimeout)
+	if err != nil {
+		// the engine may be dead or still searching, its next output can't be trusted
+		api.replaceEngine(e)
+		return nil, err
+	}
+	api.idle <- e
+	if err := r.Context().Err(); err != nil {
+		return nil, err
+	}
+	api.served.Add(1)
+	return analysis, nil
+}
+
+// acquire waits for an idle engine, unless too many analyses are already waiting.
+func (api *API) acquire(ctx context.Context) (*apiEngine, error) {
+	select {
+	case e := <-api.idle:
+		return e, nil
+	default:
+	}
+	if api.running() == 0 {
+		return nil, &apiError{status: http.StatusServiceUnavailable, err: errors.New("no engine running")}
+	}
+	if api.queued.Add(1) > int64(api.opts.MaxQueue) {
+		api.queued.Add(-1)
+		return nil, errAPIBusy
+	}
+	defer api.queued.Add(-1)
+	select {
+	case e := <-api.idle:
+		return e, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// running returns the number of engines running.
+func (api *API) running() int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return len(api.engines)
+}
+
+// analysePosition runs the search of an engine, collecting the last informations sent before its bestmove.
+// The search is stopped when ctx is canceled or after timeout. An error means that the engine didn't
+// send its bestmove.
+func analysePosition(ctx context.Context, uc *usiClient, position, goArgs string, timeout time.Duration) (*apiAnalysis, error) {
+	analysis := &apiAnalysis{BestMove: "", Score: nil, Depth: 0, Nodes: 0, PV: []string{}, Time: 0}
+	if err := uc.send("%s", position); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if err := uc.send("go %s", goArgs); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	stopped := false
+	canceled := ctx.Done()
+	for {
+		select {
+		case line, ok := <-uc.lines:
+			if !ok {
+				return nil, fmt.Errorf("engine output closed while waiting for 'bestmove'")
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) > 1 && fields[0] == "bestmove":
+				analysis.BestMove = fields[1]
+				analysis.Time = time.Since(start).Milliseconds()
+				return analysis, nil
+			case len(fields) > 0 && fields[0] == "info":
+				analysis.update(fields[1:])
+			}
+		case <-canceled:
+			canceled = nil
+			if !stopped {
+				_ = uc.send("stop")
+				stopped = true
+				timer.Reset(usiStopTimeout)
+			}
+		case <-timer.C:
+			if stopped {
+				return nil, fmt.Errorf("no bestmove after stop")
+			}
+			_ = uc.send("stop")
+			stopped = true
+			timer.Reset(usiStopTimeout)
+		}
+	}
+}
+
+// update updates an analysis with the fields of an info line.
+func (a *apiAnalysis) update(fields []string) {
+	for i := 0; i < len(fields)-1; i++ {
+		switch fields[i] {
+		case "depth":
+			a.Depth, _ = strconv.Atoi(fields[i+1])
+		case "nodes":
+			a.Nodes, _ = strconv.ParseInt(fields[i+1], 10, 64)
+		case "score":
+			if i+2 < len(fields) {
+				value, err := strconv.Atoi(strings.TrimPrefix(fields[i+2], "+"))
+				switch {
+				case err != nil:
+				case fields[i+1] == "cp":
+					a.Score = &apiScore{CP: &value, Mate: nil}
+				case fields[i+1] == "mate":
+					a.Score = &apiScore{CP: nil, Mate: &value}
+				}
+			}
+		case "string":
+			return
+		case "pv":
+			a.PV = append([]string{}, fields[i+1:]...)
+			return
+		}
+	}
+}
+
+type apiLegalMoves struct {
+	SFEN   string   `json:"sfen"` // position after the moves
+	Side   string   `json:"side"`
+	Status string   `json:"status"`
+	Moves  []string `json:"moves"`
+}
+
+// legalMoves returns the legal moves of a position and its status.
+func (api *API) legalMoves(r *http.Request) (any, error) {
+	var req apiPosition
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	_, pos, err := api.position(req)
+	if err != nil {
+		return nil, err
+	}
+	result := apiLegalMoves{SFEN: pos.Sfen(), Side: pos.Side.String(), Status: pos.Status().String(), Moves: []string{}}
+	for _, m := range movegen.LegalMoves(pos) {
+		result.Moves = append(result.Moves, m.String())
+	}
+	sort.Strings(result.Moves)
+	return result, nil
+}
+
+type apiPerftRequest struct {
+	apiPosition
+	Depth  int  `json:"depth"`
+	Divide bool `json:"divide"`
+}
+
+type apiPerft struct {
+	Depth  int            `json:"depth"`
+	Nodes  int            `json:"nodes"`
+	Divide map[string]int `json:"divide,omitempty"` // nodes by move
+	Time   int64          `json:"time"`             // milliseconds
+}
+
+// perft counts the leaf nodes of the move tree of a position.
+func (api *API) perft(r *http.Request) (any, error) {
+	var req apiPerftRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	if req.Depth < 1 || req.Depth > api.opts.MaxPerftDepth {
+		return nil, badRequest("depth must be between 1 and %d", api.opts.MaxPerftDepth)
+	}
+	_, pos, err := api.position(req.apiPosition)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case api.perfts <- struct{}{}:
+		defer func() { <-api.perfts }()
+	default:
+		return nil, errAPIBusy
+	}
+	p := perft.Compute(pos, req.Depth)
+	result := apiPerft{Depth: req.Depth, Nodes: p.NodesCount, Divide: nil, Time: p.Duration.Milliseconds()}
+	if req.Divide {
+		result.Divide = make(map[string]int)
+		for m, nodes := range p.Moves {
+			result.Divide[m.String()] = nodes
+		}
+	}
+	return result, nil
+}
+
+type apiStatus struct {
+	Version  string `json:"version"`
+	Engine   string `json:"engine"`
+	Variant  string `json:"variant"`
+	Engines  int    `json:"engines"`
+	Busy     int    `json:"busy"`
+	Queued   int64  `json:"queued"`
+	Analyses int64  `json:"analyses"`
+}
+
+// status returns the state of the engines.
+func (api *API) status(*http.Request) (any, error) {
+	running := api.running()
+	return apiStatus{
+		Version:  EngineVersion,
+		Engine:   api.name,
+		Variant:  api.opts.Variant.Name,
+		Engines:  running,
+		Busy:     running - len(api.idle),
+		Queued:   api.queued.Load(),
+		Analyses: api.served.Load(),
+	}, nil
+}
@@ -0,0 +1,323 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/kifu"
+)
+
+// The CSA server protocol is the line based protocol of the computer shogi servers like
+// Floodgate, version 1.2.1: http://www2.computer-shogi.org/protocol/tcp_ip_server_121.html
+
+// csaConn is a connection between a CSA client and a CSA server.
+type csaConn struct {
+	conn  net.Conn
+	lines chan string // closed when the connection is lost
+}
+
+// errCSAClosed is returned when the connection has been lost.
+var errCSAClosed = errors.New("connection closed")
+
+// newCSAConn starts reading the lines received on a connection.
+func newCSAConn(conn net.Conn) *csaConn {
+	lines := make(chan string, 64)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- strings.TrimRight(scanner.Text(), "\r")
+		}
+	}()
+	return &csaConn{conn: conn, lines: lines}
+}
+
+// send sends a line.
+func (c *csaConn) send(format string, a ...any) error {
+	_, err := fmt.Fprintf(c.conn, format+"\n", a...)
+	return err
+}
+
+// next returns the next line received. It fails with errCSAClosed when the connection
+// is lost and with errUsiTimeout after timeout, unless zero.
+func (c *csaConn) next(timeout time.Duration) (string, error) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	select {
+	case line, ok := <-c.lines:
+		if !ok {
+			return "", errCSAClosed
+		}
+		return line, nil
+	case <-deadline:
+		return "", errUsiTimeout
+	}
+}
+
+// close closes the connection.
+func (c *csaConn) close() {
+	_ = c.conn.Close()
+}
+
+// CSAGameSummary is the description of a game sent by a CSA server before it starts.
+type CSAGameSummary struct {
+	// GameID identifies the game on the server
+	GameID string
+	// Names of the players by color
+	Names [shogi.COLORS]string
+	// YourTurn is the color of the player receiving the summary
+	YourTurn shogi.Color
+	// MaxMoves is the number of moves after which the game is a draw, no limit if zero
+	MaxMoves int
+	// Declaration is the entering king rule, e.g. "Jishogi 1.1", none if empty
+	Declaration string
+	// TimeUnit of the times of the summary and of the moves
+	TimeUnit time.Duration
+	// TimeControl of the game
+	TimeControl shogi.TimeControl
+	// LeastTimePerMove is the minimum time counted for a move
+	LeastTimePerMove time.Duration
+	// ReconnectToken allows the client to resume the game after losing the connection, none if empty
+	ReconnectToken string
+	// Game holds the start position and the moves already played with their time
+	Game *shogi.Game
+}
+
+// EnteringKingRule returns the entering king rule of the declaration.
+func (s *CSAGameSummary) EnteringKingRule() shogi.EnteringKingRule {
+	if s.Declaration == "" {
+		return shogi.NoEnteringKing
+	}
+	return shogi.CSARule27
+}
+
+var csaTimeUnitRegexp = regexp.MustCompile(`^(\d+)(msec|sec|min)$`)
+
+// parseCSATimeUnit parses a time unit like "1sec", "1msec" or "1min".
+func parseCSATimeUnit(str string) (time.Duration, error) {
+	m := csaTimeUnitRegexp.FindStringSubmatch(str)
+	if m == nil {
+		return 0, fmt.Errorf("invalid time unit '%s'", str)
+	}
+	n, _ := strconv.Atoi(m[1])
+	unit := map[string]time.Duration{"msec": time.Millisecond, "sec": time.Second, "min": time.Minute}[m[2]]
+	return time.Duration(n) * unit, nil
+}
+
+// csaColor returns the color of a CSA sign, "+" or "-".
+func csaColor(sign string) (shogi.Color, error) {
+	switch sign {
+	case "+":
+		return shogi.Black, nil
+	case "-":
+		return shogi.White, nil
+	}
+	return shogi.NoColor, fmt.Errorf("invalid color '%s'", sign)
+}
+
+// readCSAGameSummary reads a game summary from the line following "BEGIN Game_Summary"
+// to "END Game_Summary", the lines being returned by next.
+func readCSAGameSummary(next func() (string, error)) (*CSAGameSummary, error) {
+	s := &CSAGameSummary{
+		GameID:           "",
+		Names:            [shogi.COLORS]string{},
+		YourTurn:         shogi.NoColor,
+		MaxMoves:         0,
+		Declaration:      "",
+		TimeUnit:         time.Second,
+		TimeControl:      shogi.TimeControl{Main: 0, Byoyomi: 0, Increment: 0},
+		LeastTimePerMove: 0,
+		ReconnectToken:   "",
+		Game:             nil,
+	}
+	var times [4]int // Total_Time, Byoyomi, Increment, Least_Time_Per_Move
+	var position []string
+	inPosition := false
+	for {
+		line, err := next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case line == "END Game_Summary":
+			return s.finish(times, position)
+		case line == "BEGIN Position":
+			inPosition = true
+			continue
+		case line == "END Position":
+			inPosition = false
+			continue
+		case inPosition:
+			position = append(position, line)
+			continue
+		case strings.HasPrefix(line, "BEGIN ") || strings.HasPrefix(line, "END "):
+			continue // Time sections
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid game summary line '%s'", line)
+		}
+		switch key {
+		case "Game_ID":
+			s.GameID = value
+		case "Name+":
+			s.Names[shogi.Black] = value
+		case "Name-":
+			s.Names[shogi.White] = value
+		case "Your_Turn":
+			if s.YourTurn, err = csaColor(value); err != nil {
+				return nil, err
+			}
+		case "Max_Moves":
+			s.MaxMoves, err = strconv.Atoi(value)
+		case "Declaration":
+			s.Declaration = value
+		case "Reconnect_Token":
+			s.ReconnectToken = value
+		case "Time_Unit":
+			s.TimeUnit, err = parseCSATimeUnit(value)
+		case "Total_Time":
+			times[0], err = strconv.Atoi(value)
+		case "Byoyomi":
+			times[1], err = strconv.Atoi(value)
+		case "Increment":
+			times[2], err = strconv.Atoi(value)
+		case "Least_Time_Per_Move":
+			times[3], err = strconv.Atoi(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid game summary line '%s'", line)
+		}
+	}
+}
+
+// finish converts the times in time units and reads the position of the summary.
+func (s *CSAGameSummary) finish(times [4]int, position []string) (*CSAGameSummary, error) {
+	if s.GameID == "" || s.YourTurn == shogi.NoColor {
+		return nil, fmt.Errorf("incomplete game summary")
+	}
+	s.TimeControl = shogi.TimeControl{
+		Main:      time.Duration(times[0]) * s.TimeUnit,
+		Byoyomi:   time.Duration(times[1]) * s.TimeUnit,
+		Increment: time.Duration(times[2]) * s.TimeUnit,
+	}
+	s.LeastTimePerMove = time.Duration(times[3]) * s.TimeUnit
+
+	g, err := kifu.ReadCSA(strings.NewReader(strings.Join(position, "\n")))
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range g.Moves { // read in seconds
+		n.Time = time.Duration(math.Round(n.Time.Seconds() * float64(s.TimeUnit)))
+	}
+	g.Header.Players = s.Names
+	g.Header.Event = s.GameID
+	g.Header.TimeControl = s.TimeControl
+	s.Game = g
+	return s, nil
+}
+
+// write writes the game summary from "BEGIN Game_Summary" to "END Game_Summary".
+func (s *CSAGameSummary) write(w io.Writer) error {
+	pos, err := s.Game.Position()
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	b.WriteString("BEGIN Game_Summary\n")
+	b.WriteString("Protocol_Version:1.2\n")
+	b.WriteString("Protocol_Mode:Server\n")
+	b.WriteString("Format:Shogi 1.0\n")
+	if s.Declaration != "" {
+		fmt.Fprintf(&b, "Declaration:%s\n", s.Declaration)
+	}
+	fmt.Fprintf(&b, "Game_ID:%s\n", s.GameID)
+	fmt.Fprintf(&b, "Name+:%s\n", s.Names[shogi.Black])
+	fmt.Fprintf(&b, "Name-:%s\n", s.Names[shogi.White])
+	fmt.Fprintf(&b, "Your_Turn:%s\n", csaSign(s.YourTurn))
+	b.WriteString("Rematch_On_Draw:NO\n")
+	fmt.Fprintf(&b, "To_Move:%s\n", csaSign(pos.Side))
+	if s.MaxMoves > 0 {
+		fmt.Fprintf(&b, "Max_Moves:%d\n", s.MaxMoves)
+	}
+	if s.ReconnectToken != "" {
+		fmt.Fprintf(&b, "Reconnect_Token:%s\n", s.ReconnectToken)
+	}
+
+	b.WriteString("BEGIN Time\n")
+	fmt.Fprintf(&b, "Time_Unit:%s\n", formatCSATimeUnit(s.TimeUnit))
+	fmt.Fprintf(&b, "Total_Time:%d\n", s.TimeControl.Main/s.TimeUnit)
+	if s.TimeControl.Increment > 0 {
+		fmt.Fprintf(&b, "Increment:%d\n", s.TimeControl.Increment/s.TimeUnit)
+	} else {
+		fmt.Fprintf(&b, "Byoyomi:%d\n", s.TimeControl.Byoyomi/s.TimeUnit)
+	}
+	fmt.Fprintf(&b, "Least_Time_Per_Move:%d\n", s.LeastTimePerMove/s.TimeUnit)
+	b.WriteString("END Time\n")
+
+	b.WriteString("BEGIN Position\n")
+	kifu.WriteCSAPosition(&b, pos)
+	for _, n := range s.Game.Moves {
+		if n.Special != "" {
+			break
+		}
+		fmt.Fprintf(&b, "%s,T%d\n", kifu.CSAMoveString(pos, n.Move), n.Time/s.TimeUnit)
+		pos.DoMove(n.Move)
+	}
+	b.WriteString("END Position\n")
+	b.WriteString("END Game_Summary\n")
+	_, err = b.WriteTo(w)
+	return err
+}
+
+// formatCSATimeUnit formats a time unit, e.g. "1sec".
+func formatCSATimeUnit(unit time.Duration) string {
+	switch {
+	case unit%time.Minute == 0:
+		return fmt.Sprintf("%dmin", unit/time.Minute)
+	case unit%time.Second == 0:
+		return fmt.Sprintf("%dsec", unit/time.Second)
+	}
+	return fmt.Sprintf("%dmsec", unit/time.Millisecond)
+}
+
+// csaSign returns the CSA sign of a color, "+" or "-".
+func csaSign(c shogi.Color) string {
+	if c == shogi.White {
+		return "-"
+	}
+	return "+"
+}
+
+// writeCSARecord writes a game as a CSA file named after its game id, the event of the game.
+func writeCSARecord(dir string, g *shogi.Game) error {
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(g.Header.Event)
+	f, err := os.Create(filepath.Join(dir, name+".csa"))
+	if err != nil {
+		return err
+	}
+	err = kifu.WriteCSA(f, g)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,511 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/kifu"
+)
+
+// CSAClientOptions are the settings of a player connected to a CSA server.
+type CSAClientOptions struct {
+	// Address of the server as host:port, e.g. wdoor.c.u-tokyo.ac.jp:4081 for Floodgate
+	Address string
+	// Name is the login name, with the game name for Floodgate, e.g. hifumi+floodgate-300-10F
+	Name string
+	// Password of the login
+	Password string
+	// Engine playing the games, the built-in engine if nil
+	Engine *MatchEngine
+	// Games is the number of games played before logging out, no limit if zero
+	Games int
+	// Margin is the time kept on the clock for the network delays
+	Margin time.Duration
+	// MoveTime is the thinking time of the engine when the server gives unlimited time
+	MoveTime time.Duration
+	// Reconnects is the number of attempts to connect again after losing the connection
+	Reconnects int
+	// ReconnectDelay is the delay before the first attempt, doubled at each new attempt
+	ReconnectDelay time.Duration
+	// KeepAlive is the interval of the empty lines sent while waiting, none if zero
+	KeepAlive time.Duration
+	// Records is the directory where the games are written as CSA files, none if empty
+	Records string
+}
+
+// csaDialTimeout is the timeout of the connection to a CSA server.
+const csaDialTimeout = 10 * time.Second
+
+// CSAClient logs in a CSA server and plays games with the engine, printing the
+// progress of the games on out.
+func CSAClient(out io.Writer, opts CSAClientOptions) error {
+	cc := &csaClient{opts: opts, out: out, engine: nil, conn: nil}
+	if opts.Engine != nil {
+		p, err := startUsiProcess(*opts.Engine, &GameSettings{
+			Variant:          shogi.Standard,
+			EnteringKingRule: shogi.CSARule27,
+			TimeControl:      shogi.TimeControl{Main: 0, Byoyomi: 0, Increment: 0},
+			MoveTime:         0,
+			Openings:         nil,
+			MaxMoves:         0,
+			Event:            "",
+			Records:          "",
+		})
+		if err != nil {
+			return err
+		}
+		defer p.close()
+		cc.engine = p.usiClient
+	} else {
+		cc.engine = newLocalUsiClient()
+		defer cc.engine.quit()
+		if _, err := cc.engine.handshake([][2]string{{"EnteringKingRule", shogi.CSARule27.String()}}); err != nil {
+			return err
+		}
+	}
+	if opts.Records != "" {
+		if err := os.MkdirAll(opts.Records, 0o755); err != nil {
+			return err
+		}
+	}
+	return cc.run()
+}
+
+// csaClient is a player connected to a CSA server.
+type csaClient struct {
+	opts   CSAClientOptions
+	out    io.Writer
+	engine *usiClient
+	conn   *csaConn // connection to the server, closed once lost
+}
+
+func (cc *csaClient) run() error {
+	if err := cc.login(""); err != nil {
+		return err
+	}
+	defer cc.logout()
+	for played := 0; cc.opts.Games <= 0 || played < cc.opts.Games; {
+		summary, err := cc.waitGame()
+		if errors.Is(err, errCSAClosed) {
+			if err := cc.reconnect(""); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if summary == nil { // rejected
+			continue
+		}
+		if err := cc.playGame(summary); err != nil {
+			return err
+		}
+		played++
+		if cc.opts.Games <= 0 || played < cc.opts.Games { // the servers expect a new login for each game
+			cc.logout()
+			if err := cc.login(""); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// login connects to the server and logs in, resuming a game when reconnect is
+// "<game id>+<reconnect token>".
+func (cc *csaClient) login(reconnect string) error {
+	conn, err := net.DialTimeout("tcp", cc.opts.Address, csaDialTimeout)
+	if err != nil {
+		return err
+	}
+	cc.conn = newCSAConn(conn)
+	login := fmt.Sprintf("LOGIN %s %s", cc.opts.Name, cc.opts.Password)
+	if reconnect != "" {
+		login += " reconnect:" + reconnect
+	}
+	if err := cc.conn.send("%s", login); err != nil {
+		return err
+	}
+	line, err := cc.conn.next(csaDialTimeout)
+	if err != nil {
+		cc.conn.close()
+		return fmt.Errorf("login: %w", err)
+	}
+	if line != fmt.Sprintf("LOGIN:%s OK", cc.opts.Name) {
+		cc.conn.close()
+		return fmt.Errorf("login refused: %s", line)
+	}
+	fmt.Fprintf(cc.out, "Logged in %s as %s\n", cc.opts.Address, cc.opts.Name)
+	return nil
+}
+
+// reconnect connects again after losing the connection, waiting longer after each failed attempt.
+func (cc *csaClient) reconnect(resume string) error {
+	cc.conn.close()
+	delay := cc.opts.ReconnectDelay
+	var err error
+	for attempt := 1; attempt <= cc.opts.Reconnects; attempt++ {
+		fmt.Fprintf(cc.out, "Connection lost, reconnecting in %s (attempt %d/%d)\n", delay, attempt, cc.opts.Reconnects)
+		time.Sleep(delay)
+		if err = cc.login(resume); err == nil {
+			return nil
+		}
+		delay *= 2
+	}
+	if err == nil {
+		err = errCSAClosed
+	}
+	return err
+}
+
+// logout logs out, if still connected, and closes the connection.
+func (cc *csaClient) logout() {
+	if err := cc.conn.send("LOGOUT"); err == nil {
+		for {
+			line, err := cc.conn.next(csaDialTimeout)
+			if err != nil || line == "LOGOUT:completed" {
+				break
+			}
+		}
+	}
+	cc.conn.close()
+}
+
+// nextLine returns the next line from the server, sending empty lines to keep the connection alive.
+func (cc *csaClient) nextLine() (string, error) {
+	for {
+		line, err := cc.conn.next(cc.opts.KeepAlive)
+		if errors.Is(err, errUsiTimeout) {
+			if err := cc.conn.send(""); err != nil {
+				return "", errCSAClosed
+			}
+			continue
+		}
+		return line, err
+	}
+}
+
+// readSummary waits for a game summary.
+func (cc *csaClient) readSummary() (*CSAGameSummary, error) {
+	for {
+		line, err := cc.nextLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "BEGIN Game_Summary" {
+			return readCSAGameSummary(cc.nextLine)
+		}
+	}
+}
+
+// waitGame waits for a game summary, agrees to play and waits for the start of the game.
+// It returns a nil summary when the game is rejected by the opponent.
+func (cc *csaClient) waitGame() (*CSAGameSummary, error) {
+	summary, err := cc.readSummary()
+	if err != nil {
+		return nil, err
+	}
+	if err := cc.conn.send("AGREE %s", summary.GameID); err != nil {
+		return nil, errCSAClosed
+	}
+	return cc.waitStart(summary)
+}
+
+// waitStart waits for the start of the game of a summary.
+func (cc *csaClient) waitStart(summary *CSAGameSummary) (*CSAGameSummary, error) {
+	line, err := cc.nextLine()
+	switch {
+	case err != nil:
+		return nil, err
+	case line == "START:"+summary.GameID:
+		return summary, nil
+	case strings.HasPrefix(line, "REJECT:"+summary.GameID):
+		fmt.Fprintf(cc.out, "Game %s rejected\n", summary.GameID)
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unexpected answer '%s' to AGREE", line)
+}
+
+// csaGame is a game in progress on a CSA server.
+type csaGame struct {
+	summary *CSAGameSummary
+	game    *shogi.Game
+	pos     *shogi.Position
+	clock   *shogi.Clock
+	sent    bool   // true when our move is sent and not yet confirmed by the server
+	reason  string // first # line ending the game
+}
+
+// newCSAGame sets the position and the clock of a game after the moves of its summary.
+func newCSAGame(summary *CSAGameSummary) (*csaGame, error) {
+	cg := &csaGame{
+		summary: summary,
+		game:    summary.Game,
+		pos:     nil,
+		clock:   shogi.NewClock(summary.TimeControl),
+		sent:    false,
+		reason:  "",
+	}
+	pos, err := cg.game.Position()
+	if err != nil {
+		return nil, err
+	}
+	pos.EnteringKingRule = summary.EnteringKingRule()
+	for _, n := range cg.game.Moves {
+		if n.Special != "" {
+			return nil, fmt.Errorf("game %s is already over", summary.GameID)
+		}
+		cg.clock.Consume(pos.Side, n.Time)
+		pos.DoMove(n.Move)
+	}
+	cg.pos = pos
+	return cg, nil
+}
+
+// engineResult is the answer of the engine to a search.
+type engineResult struct {
+	bestmove string
+	err      error
+}
+
+// playGame plays a game until its end, resuming it after losing the connection if the server allows it.
+func (cc *csaClient) playGame(summary *CSAGameSummary) error {
+	cg, err := newCSAGame(summary)
+	if err != nil {
+		return err
+	}
+	me := summary.YourTurn
+	fmt.Fprintf(cc.out, "Game %s: %s vs %s, playing %s\n", summary.GameID,
+		summary.Names[shogi.Black], summary.Names[shogi.White], me)
+	if err := cc.newEngineGame(summary); err != nil {
+		return err
+	}
+
+	var thinking chan engineResult // nil when the engine is not thinking
+	for {
+		if thinking == nil && !cg.sent && cg.reason == "" && !cg.game.IsOver() && cg.pos.Side == me {
+			thinking = make(chan engineResult, 1)
+			go func(g *shogi.Game, clock *shogi.Clock, result chan engineResult) {
+				bestmove, _, err := cc.engine.bestmove(g, me, clock, cc.opts.MoveTime, cc.opts.Margin)
+				result <- engineResult{bestmove: bestmove, err: err}
+			}(cloneGame(cg.game), cc.engineClock(cg.clock, me), thinking)
+		}
+
+		var keepAlive <-chan time.Time
+		if cc.opts.KeepAlive > 0 {
+			keepAlive = time.After(cc.opts.KeepAlive)
+		}
+		select {
+		case result := <-thinking:
+			thinking = nil
+			if result.err != nil {
+				return result.err
+			}
+			cg.sent = true
+			if err := cc.conn.send("%s", cc.csaMove(cg, result.bestmove)); err != nil {
+				fmt.Fprintln(cc.out, err)
+			}
+		case line, ok := <-cc.conn.lines:
+			if !ok {
+				if thinking != nil {
+					_ = cc.engine.send("stop")
+					<-thinking
+					thinking = nil
+				}
+				resumed, err := cc.resume(cg)
+				if err != nil || resumed == nil {
+					return err
+				}
+				cg = resumed
+				continue
+			}
+			if done, err := cc.serverLine(cg, line); err != nil || done {
+				if thinking != nil {
+					_ = cc.engine.send("stop")
+					<-thinking
+				}
+				return err
+			}
+		case <-keepAlive:
+			if err := cc.conn.send(""); err != nil {
+				fmt.Fprintln(cc.out, err)
+			}
+		}
+	}
+}
+
+// cloneGame returns a copy of the main line of a game, safe to read while the game continues.
+func cloneGame(g *shogi.Game) *shogi.Game {
+	clone := *g
+	clone.Moves = append([]*shogi.GameNode(nil), g.Moves...)
+	return &clone
+}
+
+// newEngineGame prepares the engine for a new game.
+func (cc *csaClient) newEngineGame(summary *CSAGameSummary) error {
+	if err := cc.engine.setOption("EnteringKingRule", summary.EnteringKingRule().String()); err != nil {
+		return err
+	}
+	if err := cc.engine.send("isready"); err != nil {
+		return err
+	}
+	if _, err := cc.engine.expect("readyok", 0); err != nil {
+		return err
+	}
+	return cc.engine.send("usinewgame")
+}
+
+// engineClock returns the clock given to the engine, a margin being kept for the network delays.
+func (cc *csaClient) engineClock(clock *shogi.Clock, side shogi.Color) *shogi.Clock {
+	c := *clock
+	if c.TimeControl.Byoyomi > 0 {
+		c.TimeControl.Byoyomi -= min(cc.opts.Margin, c.TimeControl.Byoyomi/2)
+	} else {
+		c.Remaining[side] -= min(cc.opts.Margin, c.Remaining[side]/2)
+	}
+	return &c
+}
+
+// csaMove returns the CSA message for the move chosen by the engine.
+func (cc *csaClient) csaMove(cg *csaGame, bestmove string) string {
+	switch bestmove {
+	case "resign", "timeout":
+		return "%TORYO"
+	case "win":
+		return "%KACHI"
+	}
+	m, err := shogi.ParseMove(cg.pos, bestmove)
+	if err != nil {
+		fmt.Fprintf(cc.out, "The engine played an illegal move: %s\n", err)
+		return "%TORYO"
+	}
+	return kifu.CSAMoveString(cg.pos, m)
+}
+
+// serverLine handles a line received during a game, returning true at the end of the game.
+func (cc *csaClient) serverLine(cg *csaGame, line string) (bool, error) {
+	if line == "" {
+		return false, nil
+	}
+	fmt.Fprintln(cc.out, line)
+	statement, used := cc.parseTime(cg, line)
+	switch {
+	case statement == "":
+	case statement[0] == '+' || statement[0] == '-':
+		m, err := kifu.ParseCSAMove(cg.pos, statement)
+		if err != nil {
+			return false, fmt.Errorf("game %s: %w", cg.summary.GameID, err)
+		}
+		cg.clock.Consume(cg.pos.Side, used)
+		cg.pos.DoMove(m)
+		cg.game.AddMove(m, used)
+		cg.sent = false
+	case statement[0] == '%':
+		if special := shogi.SpecialMove(statement[1:]); !cg.game.IsOver() {
+			cg.game.End(special, used)
+		}
+	case statement == "#WIN" || statement == "#LOSE" || statement == "#DRAW" || statement == "#CENSORED" || statement == "#CHUDAN":
+		cc.endGame(cg, statement)
+		return true, nil
+	case statement[0] == '#':
+		if cg.reason == "" {
+			cg.reason = statement
+		}
+	}
+	return false, nil
+}
+
+// parseTime splits the time consumed from a move like "+7776FU,T12".
+func (cc *csaClient) parseTime(cg *csaGame, line string) (string, time.Duration) {
+	statement, t, ok := strings.Cut(line, ",T")
+	if !ok {
+		return line, 0
+	}
+	units, err := strconv.Atoi(t)
+	if err != nil {
+		return statement, 0
+	}
+	return statement, time.Duration(units) * cg.summary.TimeUnit
+}
+
+// csaSpecialMoves are the special moves recording the end of a game given by the server.
+var csaSpecialMoves = map[string]shogi.SpecialMove{
+	"#RESIGN":          shogi.SpecialResign,
+	"#TIME_UP":         shogi.SpecialTimeUp,
+	"#ILLEGAL_MOVE":    shogi.SpecialIllegalMove,
+	"#SENNICHITE":      shogi.SpecialSennichite,
+	"#OUTE_SENNICHITE": shogi.SpecialSennichite,
+	"#JISHOGI":         shogi.SpecialJishogi,
+	"#MAX_MOVES":       shogi.SpecialHikiwake,
+	"#CHUDAN":          shogi.SpecialAbort,
+}
+
+// endGame records the end of a game, tells its result to the engine and writes the game record.
+func (cc *csaClient) endGame(cg *csaGame, result string) {
+	if !cg.game.IsOver() {
+		reason := cg.reason
+		if result == "#CHUDAN" {
+			reason = result
+		}
+		special, ok := csaSpecialMoves[reason]
+		if !ok {
+			special = shogi.SpecialError
+		}
+		cg.game.End(special, 0)
+	}
+	fmt.Fprintf(cc.out, "Game %s over: %s\n", cg.summary.GameID, strings.TrimSpace(cg.reason+" "+result))
+
+	switch result {
+	case "#WIN":
+		_ = cc.engine.send("gameover win")
+	case "#LOSE":
+		_ = cc.engine.send("gameover lose")
+	default:
+		_ = cc.engine.send("gameover draw")
+	}
+	if cc.opts.Records != "" {
+		if err := writeCSARecord(cc.opts.Records, cg.game); err != nil {
+			fmt.Fprintln(cc.out, err)
+		}
+	}
+}
+
+// resume reconnects after losing the connection during a game. The server sends again the summary
+// of the game with the moves played, followed by its start. Without a reconnect token, the game is
+// recorded as interrupted and the connection is established again for the next game, a nil game
+// being returned.
+func (cc *csaClient) resume(cg *csaGame) (*csaGame, error) {
+	token := cg.summary.ReconnectToken
+	if token == "" {
+		if err := cc.reconnect(""); err != nil {
+			return nil, err
+		}
+		cc.endGame(cg, "#CHUDAN")
+		return nil, nil
+	}
+	if err := cc.reconnect(cg.summary.GameID + "+" + token); err != nil {
+		return nil, err
+	}
+	summary, err := cc.readSummary()
+	if err == nil {
+		summary, err = cc.waitStart(summary)
+	}
+	if err == nil && summary == nil {
+		err = fmt.Errorf("game %s not resumed", cg.summary.GameID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(cc.out, "Game %s resumed\n", summary.GameID)
+	return newCSAGame(summary)
+}
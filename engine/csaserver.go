@@ -0,0 +1,466 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/kifu"
+)
+
+// CSAServerOptions are the settings of a CSA server.
+type CSAServerOptions struct {
+	// Address listened as host:port, e.g. :4081
+	Address string
+	// Password required to log in, any password being accepted if empty
+	Password string
+	// TimeUnit in which the times are counted, rounded down, and sent to the players
+	TimeUnit time.Duration
+	// TimeControl of the games
+	TimeControl shogi.TimeControl
+	// LeastTimePerMove is the minimum time counted for a move
+	LeastTimePerMove time.Duration
+	// MaxMoves is the number of moves after which a game is a draw, no limit if zero
+	MaxMoves int
+	// Declaration enables the entering king declaration of the CSA rule, 28 points for Black and 27 for White
+	Declaration bool
+	// Records is the directory where the games are written as CSA files, none if empty
+	Records string
+}
+
+// csaLoginTimeout is the delay given to a client to log in after connecting.
+const csaLoginTimeout = time.Minute
+
+// A CSAServer is a game server of the CSA protocol. The players logging in with the same game name,
+// e.g. "alice+league" and "bob+league", are paired in their order of arrival, the first one playing
+// Black. A player plays one game per login, and is matched again when the game is rejected.
+type CSAServer struct {
+	opts     CSAServerOptions
+	out      io.Writer
+	listener net.Listener
+
+	mu      sync.Mutex            // protects the fields below, serializes the output
+	players map[string]net.Conn   // connections by login name
+	waiting map[string]*csaPlayer // player waiting for an opponent by game name
+	games   int                   // number of games started
+	closed  bool                  // true once Close has been called
+	wg      sync.WaitGroup        // connections being handled
+}
+
+// NewCSAServer starts listening for the players of a CSA server, the progress of the games being
+// printed on out.
+func NewCSAServer(out io.Writer, opts CSAServerOptions) (*CSAServer, error) {
+	if opts.TimeUnit <= 0 {
+		return nil, fmt.Errorf("invalid time unit %s", opts.TimeUnit)
+	}
+	if opts.Records != "" {
+		if err := os.MkdirAll(opts.Records, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("tcp", opts.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &CSAServer{
+		opts:     opts,
+		out:      out,
+		listener: listener,
+		mu:       sync.Mutex{},
+		players:  make(map[string]net.Conn),
+		waiting:  make(map[string]*csaPlayer),
+		games:    0,
+		closed:   false,
+		wg:       sync.WaitGroup{},
+	}, nil
+}
+
+// Addr returns the address listened.
+func (s *CSAServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve accepts the players until the server is closed.
+func (s *CSAServer) Serve() error {
+	s.logf("Listening on %s", s.Addr())
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// Close stops listening, disconnects the players and waits for the end of the games in progress,
+// which are interrupted.
+func (s *CSAServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	err := s.listener.Close()
+	for _, conn := range s.players {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// logf prints a line on the output of the server.
+func (s *CSAServer) logf(format string, a ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.out, format+"\n", a...)
+}
+
+// csaPlayer is a player logged in a CSA server.
+type csaPlayer struct {
+	*csaConn
+	login   string              // login name, e.g. alice+league
+	name    string              // player name, the part of the login name before the first '+'
+	game    string              // game name, the part of the login name after the first '+'
+	matched chan *csaServerGame // receives the game when an opponent is found
+	lost    bool                // true when the connection has been lost during a game
+}
+
+// handle serves a connection from its login to its logout.
+func (s *CSAServer) handle(conn net.Conn) {
+	c := newCSAConn(conn)
+	defer c.close()
+	p, err := s.login(c)
+	if err != nil {
+		s.logf("%s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer func() {
+		s.mu.Lock()
+		delete(s.players, p.login)
+		s.mu.Unlock()
+		s.logf("%s logged out", p.login)
+	}()
+
+	for {
+		g, ok := s.match(p)
+		if !ok {
+			return
+		}
+		if g.players[shogi.White] == p { // the last player to arrive runs the game
+			g.started = s.play(g)
+			close(g.done)
+		} else {
+			<-g.done
+		}
+		if p.lost {
+			return
+		}
+		if g.started {
+			break
+		}
+	}
+
+	// wait for the logout
+	for line := range p.lines {
+		if line == "LOGOUT" {
+			_ = p.send("LOGOUT:completed")
+			return
+		}
+	}
+}
+
+// login reads the login of a player, e.g. "LOGIN alice+league password".
+func (s *CSAServer) login(c *csaConn) (*csaPlayer, error) {
+	line, err := c.next(csaLoginTimeout)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[0] != "LOGIN" {
+		_ = c.send("LOGIN:incorrect")
+		return nil, fmt.Errorf("invalid login '%s'", line)
+	}
+	name, password := fields[1], fields[2]
+	s.mu.Lock()
+	_, loggedIn := s.players[name]
+	refused := loggedIn || s.closed || (s.opts.Password != "" && password != s.opts.Password)
+	if !refused {
+		s.players[name] = c.conn
+	}
+	s.mu.Unlock()
+	if refused {
+		_ = c.send("LOGIN:incorrect")
+		return nil, fmt.Errorf("login of %s refused", name)
+	}
+
+	if err := c.send("LOGIN:%s OK", name); err != nil {
+		return nil, err
+	}
+	s.logf("%s logged in", name)
+	player, game, _ := strings.Cut(name, "+")
+	return &csaPlayer{csaConn: c, login: name, name: player, game: game, matched: make(chan *csaServerGame, 1), lost: false}, nil
+}
+
+// match waits for an opponent, returning false when the player logs out or is disconnected.
+func (s *CSAServer) match(p *csaPlayer) (*csaServerGame, bool) {
+	s.mu.Lock()
+	if opponent := s.waiting[p.game]; opponent != nil {
+		delete(s.waiting, p.game)
+		s.games++
+		g := newCSAServerGame(opponent, p, s.games)
+		s.mu.Unlock()
+		opponent.matched <- g
+		return g, true
+	}
+	s.waiting[p.game] = p
+	s.mu.Unlock()
+
+	for {
+		select {
+		case g := <-p.matched:
+			return g, true
+		case line, ok := <-p.lines:
+			if ok && line != "LOGOUT" {
+				continue // keep alive or command out of a game
+			}
+			s.mu.Lock()
+			stillWaiting := s.waiting[p.game] == p
+			if stillWaiting {
+				delete(s.waiting, p.game)
+			}
+			s.mu.Unlock()
+			if ok {
+				_ = p.send("LOGOUT:completed")
+			}
+			if !stillWaiting { // matched meanwhile, the game is aborted by the disconnection
+				p.close()
+				<-(<-p.matched).done
+			}
+			return nil, false
+		}
+	}
+}
+
+// csaServerGame is a game played on a CSA server.
+type csaServerGame struct {
+	id      string
+	players [shogi.COLORS]*csaPlayer
+	started bool          // false if the game has been rejected
+	done    chan struct{} // closed at the end of the game
+}
+
+// newCSAServerGame creates the n-th game of the server, its id being like the ones of Floodgate
+// followed by n, e.g. league+alice+bob+20230102150405+12.
+func newCSAServerGame(black, white *csaPlayer, n int) *csaServerGame {
+	game := black.game
+	if game == "" {
+		game = "hifumi"
+	}
+	id := fmt.Sprintf("%s+%s+%s+%s+%d", game, black.name, white.name, time.Now().Format("20060102150405"), n)
+	return &csaServerGame{id: id, players: [shogi.COLORS]*csaPlayer{black, white}, started: false, done: make(chan struct{})}
+}
+
+// receive waits for a line from one of the players, returning its color and false if its connection
+// is lost, or NoColor when the deadline is reached.
+func (g *csaServerGame) receive(deadline <-chan time.Time) (shogi.Color, string, bool) {
+	select {
+	case line, ok := <-g.players[shogi.Black].lines:
+		g.players[shogi.Black].lost = !ok
+		return shogi.Black, line, ok
+	case line, ok := <-g.players[shogi.White].lines:
+		g.players[shogi.White].lost = !ok
+		return shogi.White, line, ok
+	case <-deadline:
+		return shogi.NoColor, "", true
+	}
+}
+
+// broadcast sends a line to both players.
+func (g *csaServerGame) broadcast(format string, a ...any) {
+	for _, p := range g.players {
+		_ = p.send(format, a...)
+	}
+}
+
+// play sends the game summary to the players, and plays the game if they both agree.
+// It returns false if the game has been rejected.
+func (s *CSAServer) play(g *csaServerGame) bool {
+	game := shogi.NewGame(shogi.StartPos)
+	game.Header.Players = [shogi.COLORS]string{g.players[shogi.Black].name, g.players[shogi.White].name}
+	game.Header.Event = g.id
+	game.Header.TimeControl = s.opts.TimeControl
+	summary := CSAGameSummary{
+		GameID:           g.id,
+		Names:            game.Header.Players,
+		YourTurn:         shogi.NoColor,
+		MaxMoves:         s.opts.MaxMoves,
+		Declaration:      "",
+		TimeUnit:         s.opts.TimeUnit,
+		TimeControl:      s.opts.TimeControl,
+		LeastTimePerMove: s.opts.LeastTimePerMove,
+		ReconnectToken:   "",
+		Game:             game,
+	}
+	if s.opts.Declaration {
+		summary.Declaration = "Jishogi 1.1"
+	}
+	for c, p := range g.players {
+		summary.YourTurn = shogi.Color(c)
+		_ = summary.write(p.conn)
+	}
+
+	if !s.agree(g) {
+		return false
+	}
+	g.broadcast("START:%s", g.id)
+	s.logf("Game %s started: %s vs %s", g.id, game.Header.Players[shogi.Black], game.Header.Players[shogi.White])
+	s.playMoves(g, game, summary.EnteringKingRule())
+
+	result := game.Result()
+	winner := "draw"
+	if result.Winner != shogi.NoColor {
+		winner = result.Winner.String() + " wins"
+	}
+	s.logf("Game %s over after %d moves: %s, %s", g.id, len(game.MainLine()), result.Reason, winner)
+	if s.opts.Records != "" {
+		if err := writeCSARecord(s.opts.Records, game); err != nil {
+			s.logf("%v", err)
+		}
+	}
+	return true
+}
+
+// agree waits for the agreement of both players, returning false if a player rejects the game or
+// is disconnected.
+func (s *CSAServer) agree(g *csaServerGame) bool {
+	agreed := [shogi.COLORS]bool{}
+	for !agreed[shogi.Black] || !agreed[shogi.White] {
+		from, line, ok := g.receive(nil)
+		command, _, _ := strings.Cut(line, " ")
+		switch {
+		case !ok || command == "REJECT":
+			g.broadcast("REJECT:%s by %s", g.id, g.players[from].name)
+			s.logf("Game %s rejected by %s", g.id, g.players[from].name)
+			return false
+		case command == "AGREE":
+			agreed[from] = true
+		}
+	}
+	return true
+}
+
+// playMoves plays the moves of the players until the end of the game.
+func (s *CSAServer) playMoves(g *csaServerGame, game *shogi.Game, rule shogi.EnteringKingRule) {
+	pos, _ := game.Position()
+	pos.EnteringKingRule = rule
+	clock := shogi.NewClock(s.opts.TimeControl)
+	for turnStart := time.Now(); ; {
+		side := pos.Side
+		var timer *time.Timer
+		var deadline <-chan time.Time
+		if !clock.Unlimited() {
+			// the time is rounded down to the time unit
+			timer = time.NewTimer(time.Until(turnStart.Add(clock.Available(side) + s.opts.TimeUnit)))
+			deadline = timer.C
+		}
+		from, line, ok := g.receive(deadline)
+		if timer != nil {
+			timer.Stop()
+		}
+		units := time.Since(turnStart) / s.opts.TimeUnit
+		used := max(units*s.opts.TimeUnit, s.opts.LeastTimePerMove)
+		switch {
+		case from == shogi.NoColor:
+			s.endGame(g, game, shogi.SpecialTimeUp, used, "#TIME_UP")
+			return
+		case !ok:
+			s.endGame(g, game, shogi.SpecialAbort, used, "#CHUDAN")
+			return
+		case from != side || line == "" || !strings.ContainsAny(line[:1], "+-%"): // keep alive, out of turn or unknown
+			continue
+		}
+
+		statement, _, _ := strings.Cut(line, ",") // the moves can be followed by a comment
+		switch statement {
+		case "%TORYO":
+			g.broadcast("%s,T%d", statement, units)
+			s.endGame(g, game, shogi.SpecialResign, used, "#RESIGN")
+			return
+		case "%KACHI":
+			g.broadcast("%s,T%d", statement, units)
+			if pos.CanDeclareWin() {
+				s.endGame(g, game, shogi.SpecialKachi, used, "#JISHOGI")
+			} else {
+				s.endGame(g, game, shogi.SpecialIllegalMove, used, "#ILLEGAL_MOVE")
+			}
+			return
+		}
+		if !clock.Consume(side, used) {
+			s.endGame(g, game, shogi.SpecialTimeUp, used, "#TIME_UP")
+			return
+		}
+		m, err := kifu.ParseCSAMove(pos, statement)
+		if err != nil {
+			s.endGame(g, game, shogi.SpecialIllegalMove, used, "#ILLEGAL_MOVE")
+			return
+		}
+		pos.DoMove(m)
+		game.AddMove(m, used)
+		g.broadcast("%s,T%d", statement, units)
+		turnStart = time.Now()
+
+		switch o := pos.Outcome(); o.Status { //nolint:exhaustive
+		case shogi.PawnDropMate:
+			s.endGame(g, game, o.SpecialMove(), 0, "#ILLEGAL_MOVE")
+			return
+		case shogi.Sennichite:
+			s.endGame(g, game, shogi.SpecialSennichite, 0, "#SENNICHITE")
+			return
+		case shogi.PerpetualCheck:
+			s.endGame(g, game, shogi.SpecialSennichite, 0, "#OUTE_SENNICHITE")
+			return
+		}
+		if s.opts.MaxMoves > 0 && len(game.Moves) >= s.opts.MaxMoves {
+			s.endGame(g, game, shogi.SpecialHikiwake, 0, "#MAX_MOVES")
+			return
+		}
+	}
+}
+
+// endGame ends a game with a special move and sends the reason and the result to the players.
+func (s *CSAServer) endGame(g *csaServerGame, game *shogi.Game, special shogi.SpecialMove, used time.Duration, reason string) {
+	game.End(special, used)
+	if special == shogi.SpecialAbort {
+		g.broadcast("#CHUDAN")
+		return
+	}
+	g.broadcast("%s", reason)
+	winner := game.Result().Winner
+	for c, p := range g.players {
+		switch {
+		case special == shogi.SpecialHikiwake:
+			_ = p.send("#CENSORED")
+		case winner == shogi.NoColor:
+			_ = p.send("#DRAW")
+		case winner == shogi.Color(c):
+			_ = p.send("#WIN")
+		default:
+			_ = p.send("#LOSE")
+		}
+	}
+}
@@ -0,0 +1,351 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/kifu"
+)
+
+// MatchEngine is a USI engine playing a match, run as a subprocess.
+type MatchEngine struct {
+	// Name of the engine in the results and the game records, its USI id name if empty
+	Name string
+	// Command runs the engine, starting with the executable
+	Command []string
+	// Options are set after the handshake, e.g. {"Threads", "4"}
+	Options [][2]string
+}
+
+// GameSettings are the settings of the games between engines.
+type GameSettings struct {
+	// Variant played
+	Variant *shogi.Variant
+	// EnteringKingRule decides the games where kings have entered the opponent camp
+	EnteringKingRule shogi.EnteringKingRule
+	// TimeControl of the games
+	TimeControl shogi.TimeControl
+	// MoveTime is the thinking time of the engines when the time is unlimited
+	MoveTime time.Duration
+	// Openings give the start positions and the first moves of the games, in turn.
+	// Each opening is played twice, the engines swapping colors. The variant start position if empty.
+	Openings []*shogi.Game
+	// MaxMoves is the number of moves played by the engines after which a game is adjudicated
+	// a draw, the moves of the opening excluded, no limit if zero
+	MaxMoves int
+	// Event is the name of the match or the tournament in the game records
+	Event string
+	// Records is the directory where the games are written as KIF files, none if empty.
+	// Only games of standard shogi can be recorded.
+	Records string
+}
+
+// MatchOptions are the settings of a match between two engines.
+type MatchOptions struct {
+	GameSettings
+	// Engines playing the match
+	Engines [2]MatchEngine
+	// Games is the number of games of the match, its maximum with a SPRT, unlimited if zero
+	Games int
+	// SPRT stops the match once a hypothesis is accepted, none if nil
+	SPRT *SPRT
+}
+
+// MatchResult holds the games of a match.
+type MatchResult struct {
+	// Names of the engines
+	Names [2]string
+	// Games played, the first engine playing Black in the even games
+	Games []*shogi.Game
+	// Decision of the SPRT, SPRTContinue without SPRT or when the games ran out first
+	Decision SPRTDecision
+}
+
+// Score returns the score of the first engine in the n-th game, counted from 0:
+// 1 for a win, 0.5 for a draw and 0 for a loss.
+func (r *MatchResult) Score(n int) float64 {
+	first := shogi.Black
+	if n%2 == 1 {
+		first = shogi.White
+	}
+	switch r.Games[n].Result().Winner {
+	case shogi.NoColor:
+		return 0.5
+	case first:
+		return 1
+	}
+	return 0
+}
+
+// Record returns the wins, losses and draws of the first engine.
+func (r *MatchResult) Record() (wins, losses, draws int) {
+	for n := range r.Games {
+		switch r.Score(n) {
+		case 1:
+			wins++
+		case 0:
+			losses++
+		default:
+			draws++
+		}
+	}
+	return wins, losses, draws
+}
+
+// matchTimeMargin is the delay given to the engines beyond their time to send their move.
+const matchTimeMargin = 200 * time.Millisecond
+
+// Match plays a match between two engines, printing the result of each game on out.
+// The match is interrupted by the failure of an engine, the games already played being returned.
+func Match(out io.Writer, opts MatchOptions) (*MatchResult, error) {
+	if opts.Games <= 0 && opts.SPRT == nil {
+		return nil, fmt.Errorf("the number of games is unlimited only with a SPRT")
+	}
+	openings, err := opts.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	var engines [2]*usiProcess
+	for i, e := range opts.Engines {
+		p, err := startUsiProcess(e, &opts.GameSettings)
+		if err != nil {
+			return nil, err
+		}
+		defer p.close()
+		engines[i] = p
+	}
+
+	result := &MatchResult{Names: [2]string{engines[0].name, engines[1].name}, Games: nil, Decision: SPRTContinue}
+	for n := 0; opts.Games <= 0 || n < opts.Games; n++ {
+		players := [shogi.COLORS]*usiProcess{engines[n%2], engines[1-n%2]}
+		g, err := playEngineGame(players, openings[n/2%len(openings)], &opts.GameSettings)
+		if err != nil {
+			return result, err
+		}
+		result.Games = append(result.Games, g)
+		if err := opts.record(n, g); err != nil {
+			return result, err
+		}
+		wins, losses, draws := result.Record()
+		writeGameResult(out, n, g)
+		fmt.Fprintf(out, "Score of %s vs %s: %d - %d - %d [%.3f] %d\n", result.Names[0], result.Names[1],
+			wins, losses, draws, (float64(wins)+float64(draws)/2)/float64(len(result.Games)), len(result.Games))
+		if n%2 == 1 {
+			result.Decision = writeMatchStats(out, result, opts.SPRT)
+			if result.Decision != SPRTContinue {
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// writeMatchStats prints the statistics of the complete game pairs of a match and the state of
+// the SPRT, if any, which is returned.
+func writeMatchStats(out io.Writer, result *MatchResult, sprt *SPRT) SPRTDecision {
+	wins, losses, draws := result.Record()
+	penta := result.Pentanomial()
+	elo, margin := penta.Elo()
+	fmt.Fprintf(out, "Elo difference: %.1f +/- %.1f, LOS: %.1f %%, DrawRatio: %.1f %%\n",
+		elo, margin, 100*LOS(wins, losses), 100*float64(draws)/float64(len(result.Games)))
+	fmt.Fprintf(out, "Ptnml(0-2): %s\n", penta)
+	if sprt == nil {
+		return SPRTContinue
+	}
+	lower, upper := sprt.Bounds()
+	decision := sprt.Decision(penta)
+	fmt.Fprintf(out, "SPRT (%s): llr %.2f, bounds [%.2f, %.2f], %s\n", sprt, sprt.LLR(penta), lower, upper, decision)
+	return decision
+}
+
+// writeGameResult prints the result of the n-th game, counted from 0, e.g.
+// "Finished game 3 (Hifumi vs Fairy-Stockfish): 0-1 {TSUMI}", 1-0 meaning that Black has won.
+func writeGameResult(out io.Writer, n int, g *shogi.Game) {
+	result := g.Result()
+	score := "1/2-1/2"
+	switch result.Winner {
+	case shogi.Black:
+		score = "1-0"
+	case shogi.White:
+		score = "0-1"
+	}
+	fmt.Fprintf(out, "Finished game %d (%s vs %s): %s {%s}\n",
+		n+1, g.Header.Players[shogi.Black], g.Header.Players[shogi.White], score, result.Reason)
+}
+
+// prepare checks the settings, creating the directory of the records,
+// and returns the openings to play in turn.
+func (gs *GameSettings) prepare() ([]*shogi.Game, error) {
+	if gs.Records != "" {
+		if gs.Variant != shogi.Standard {
+			return nil, fmt.Errorf("only games of standard shogi can be recorded")
+		}
+		if err := os.MkdirAll(gs.Records, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	if len(gs.Openings) == 0 {
+		return []*shogi.Game{shogi.NewGame(gs.Variant.StartPos)}, nil
+	}
+	return gs.Openings, nil
+}
+
+// record writes the n-th game, counted from 0, as a KIF file in the directory of the records if any.
+func (gs *GameSettings) record(n int, g *shogi.Game) error {
+	if gs.Records == "" {
+		return nil
+	}
+	f, err := os.Create(filepath.Join(gs.Records, fmt.Sprintf("%04d.kif", n+1)))
+	if err != nil {
+		return err
+	}
+	err = kifu.WriteKIF(f, g)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// usiProcess is a USI engine run as a subprocess.
+type usiProcess struct {
+	*usiClient
+	name string
+	cmd  *exec.Cmd
+}
+
+// startUsiProcess starts an engine and initializes it for the variant and the entering king rule of the games.
+func startUsiProcess(e MatchEngine, gs *GameSettings) (*usiProcess, error) {
+	if len(e.Command) == 0 {
+		return nil, fmt.Errorf("missing engine command")
+	}
+	cmd := exec.Command(e.Command[0], e.Command[1:]...) //nolint:gosec
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &usiProcess{usiClient: newUsiClient(w, r), name: e.Name, cmd: cmd}
+
+	options := [][2]string{{"EnteringKingRule", gs.EnteringKingRule.String()}}
+	if gs.Variant != shogi.Standard {
+		options = append(options, [2]string{"USI_Variant", gs.Variant.Name})
+	}
+	name, err := p.handshake(append(options, e.Options...))
+	if err != nil {
+		p.close()
+		return nil, fmt.Errorf("%s: %w", e.Command[0], err)
+	}
+	if p.name == "" {
+		p.name = name
+	}
+	if p.name == "" {
+		p.name = filepath.Base(e.Command[0])
+	}
+	return p, nil
+}
+
+// close asks the engine to quit, killing it if it doesn't, and waits for its end.
+func (p *usiProcess) close() {
+	done := make(chan struct{})
+	go func() {
+		p.quit()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(usiStopTimeout):
+		_ = p.cmd.Process.Kill()
+	}
+	_ = p.cmd.Wait()
+}
+
+// playEngineGame plays a game between two engines by color from an opening. The runner
+// adjudicates the end of the game with the rules, including the impasses: the side to move
+// wins by declaration as soon as it meets the conditions of the entering king rule, without
+// waiting for its engine to declare. The other impasses end in a draw at MaxMoves.
+func playEngineGame(players [shogi.COLORS]*usiProcess, opening *shogi.Game, opts *GameSettings) (*shogi.Game, error) {
+	g := shogi.NewGame(opening.StartPos)
+	g.Variant = opts.Variant
+	g.Header.Players = [shogi.COLORS]string{players[shogi.Black].name, players[shogi.White].name}
+	g.Header.Event = opts.Event
+	g.Header.Date = time.Now().Truncate(time.Second)
+	g.Header.TimeControl = opts.TimeControl
+	pos, err := opts.Variant.NewPositionFromSfen(g.StartPos)
+	if err != nil {
+		return nil, err
+	}
+	pos.EnteringKingRule = opts.EnteringKingRule
+	for _, m := range opening.MainLine() {
+		pos.DoMove(m)
+		g.AddMove(m, 0)
+	}
+
+	for _, p := range players {
+		if err := p.send("usinewgame"); err != nil {
+			return nil, err
+		}
+	}
+	clock := shogi.NewClock(opts.TimeControl)
+	opened := len(g.Moves)
+	for !g.IsOver() {
+		if outcome := pos.Outcome(); outcome.Status != shogi.Ongoing {
+			g.End(outcome.SpecialMove(), 0)
+			break
+		}
+		if opts.MaxMoves > 0 && len(g.Moves)-opened >= opts.MaxMoves {
+			g.End(shogi.SpecialHikiwake, 0)
+			break
+		}
+
+		side := pos.Side
+		bestmove, used, err := players[side].bestmove(g, side, clock, opts.MoveTime, matchTimeMargin)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", players[side].name, err)
+		}
+		switch {
+		case bestmove == "timeout" || !clock.Consume(side, used):
+			g.End(shogi.SpecialTimeUp, used)
+		case bestmove == "resign":
+			g.End(shogi.SpecialResign, used)
+		case bestmove == "win" && pos.CanDeclareWin():
+			g.End(shogi.SpecialKachi, used)
+		case bestmove == "win": // wrong declaration
+			g.End(shogi.SpecialIllegalMove, used)
+		default:
+			m, err := shogi.ParseMove(pos, bestmove)
+			if err != nil {
+				g.End(shogi.SpecialIllegalMove, used)
+				break
+			}
+			pos.DoMove(m)
+			g.AddMove(m, used)
+		}
+	}
+
+	result := g.Result()
+	for c, p := range players {
+		switch {
+		case result.Winner == shogi.NoColor:
+			_ = p.send("gameover draw")
+		case result.Winner == shogi.Color(c):
+			_ = p.send("gameover win")
+		default:
+			_ = p.send("gameover lose")
+		}
+	}
+	return g, nil
+}
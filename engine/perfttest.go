@@ -14,8 +14,28 @@ type outJson struct {
 	Moves    []string `json:"moves"`
 }
 
-func Perfttest(startpos string, depth int) {
-	position, _ := shogi.NewPositionFromSfen(startpos) // FIXME - when error
+// Perfttest runs perft for a variant from startpos, which can be a SFEN string,
+// "startpos" for the variant starting position or a handicap name.
+func Perfttest(variant string, startpos string, depth int) error {
+	v, err := shogi.VariantByName(variant)
+	if err != nil {
+		return err
+	}
+	if startpos == "startpos" {
+		startpos = v.StartPos
+	} else if sfen, err := shogi.HandicapSfen(startpos); err == nil {
+		if v != shogi.Standard {
+			return fmt.Errorf("handicaps exist only for standard shogi")
+		}
+		startpos = sfen
+	}
+	position, err := v.NewPositionFromSfen(startpos)
+	if err != nil {
+		return err
+	}
+	if err := position.Validate(); err != nil {
+		return err
+	}
 	result := perft.Compute(position, depth)
 
 	if depth == 1 {
@@ -34,4 +54,5 @@ func Perfttest(startpos string, depth int) {
 	} else {
 		fmt.Fprintf(os.Stdout, "{\"depth\": %d, \"nodes\": %d}\n", depth, result.NodesCount)
 	}
+	return nil
 }
@@ -0,0 +1,414 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/kifu"
+	"github.com/vinymeuh/hifumi/shogi/movegen"
+	"github.com/vinymeuh/hifumi/shogi/render"
+)
+
+// PlayOptions are the settings of a game between a human and the engine.
+type PlayOptions struct {
+	// Variant played
+	Variant *shogi.Variant
+	// Game gives the start position, the moves already played and the time control
+	Game *shogi.Game
+	// EnteringKingRule decides the games where kings have entered the opponent camp
+	EnteringKingRule shogi.EnteringKingRule
+	// Human is the color played by the human
+	Human shogi.Color
+	// MoveTime is the thinking time of the engine when the time is unlimited
+	MoveTime time.Duration
+	// Display are the options of the board drawing
+	Display render.Options
+}
+
+const playHelp = `Enter a move in USI (7g7f, P*5e, 8h2b+) or Japanese (７六歩, 76歩, 同　銀, ２二角成) notation, or a command:
+  board            draw the board again
+  moves            list the legal moves
+  undo             take back your last move and the answer of the engine
+  time <main> [<byoyomi>] [+<increment>]
+                   set the time control and reset the clocks, e.g. time 10m 30s or time 5m +10s
+  style <name>     set the board style (%s)
+  flip             turn the board around
+  win              declare a win under the entering king rule
+  resign           resign the game
+  save <file>      save the game as KIF, KI2, CSA or JKF depending on the file extension
+  quit             leave`
+
+// Play runs a game between a human, entering moves and commands on in, and the engine.
+func Play(in io.Reader, out io.Writer, opts PlayOptions) error {
+	ps := &playSession{
+		opts:    opts,
+		in:      bufio.NewScanner(in),
+		out:     out,
+		engine:  newLocalUsiClient(),
+		game:    opts.Game,
+		pos:     nil,
+		clock:   nil,
+		display: opts.Display,
+	}
+	defer ps.engine.quit()
+	options := [][2]string{{"USI_Variant", opts.Variant.Name}, {"EnteringKingRule", opts.EnteringKingRule.String()}}
+	if _, err := ps.engine.handshake(options); err != nil {
+		return err
+	}
+	if err := ps.replay(); err != nil {
+		return err
+	}
+	return ps.run()
+}
+
+// playSession is a game in progress between a human and the engine.
+type playSession struct {
+	opts    PlayOptions
+	in      *bufio.Scanner
+	out     io.Writer
+	engine  *usiClient
+	game    *shogi.Game
+	pos     *shogi.Position // after the moves of the main line
+	clock   *shogi.Clock
+	display render.Options
+}
+
+// replay sets the position after the moves of the main line, and the clock from the time
+// control of the game and the times of these moves.
+func (ps *playSession) replay() error {
+	pos, err := ps.opts.Variant.NewPositionFromSfen(ps.game.StartPos)
+	if err != nil {
+		return err
+	}
+	pos.EnteringKingRule = ps.opts.EnteringKingRule
+	clock := shogi.NewClock(ps.game.Header.TimeControl)
+	for _, n := range ps.game.Moves {
+		clock.Consume(pos.Side, n.Time)
+		if n.Special == "" {
+			pos.DoMove(n.Move)
+		}
+	}
+	ps.pos = pos
+	ps.clock = clock
+	return nil
+}
+
+// lastMove returns the last move of the main line, zero if none.
+func (ps *playSession) lastMove() shogi.Move {
+	moves := ps.game.MainLine()
+	if len(moves) == 0 {
+		return 0
+	}
+	return moves[len(moves)-1]
+}
+
+func (ps *playSession) run() error {
+	fmt.Fprintln(ps.out, "Type help for the commands.")
+	ps.show()
+	start := time.Now() // of the human thinking
+	for {
+		if !ps.game.IsOver() && ps.pos.Side != ps.opts.Human {
+			if err := ps.engineMove(); err != nil {
+				return err
+			}
+			ps.show()
+			start = time.Now()
+			continue
+		}
+
+		fmt.Fprintf(ps.out, "%s> ", colorMark(ps.pos.Side))
+		if !ps.in.Scan() {
+			return ps.in.Err()
+		}
+		fields := strings.Fields(ps.in.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "help", "?":
+			fmt.Fprintf(ps.out, playHelp+"\n", strings.Join(render.Styles(), ", "))
+		case "quit", "exit":
+			return nil
+		case "board", "d":
+			ps.show()
+		case "moves":
+			ps.showMoves()
+		case "undo", "takeback":
+			ps.undo()
+			start = time.Now()
+		case "time":
+			ps.setTime(fields[1:])
+		case "style":
+			ps.setStyle(fields[1:])
+		case "flip":
+			ps.display.Flip = !ps.display.Flip
+			ps.show()
+		case "win":
+			if ps.playable() {
+				if !ps.pos.CanDeclareWin() {
+					fmt.Fprintln(ps.out, "The conditions of the entering king rule are not met.")
+					continue
+				}
+				ps.end(shogi.SpecialKachi, time.Since(start))
+			}
+		case "resign":
+			if ps.playable() {
+				ps.end(shogi.SpecialResign, time.Since(start))
+			}
+		case "save":
+			ps.save(fields[1:])
+		default:
+			if ps.playable() {
+				if ps.humanMove(strings.Join(fields, " "), time.Since(start)) {
+					ps.show()
+				}
+			}
+		}
+	}
+}
+
+// playable returns true if the human can play, printing a message otherwise.
+func (ps *playSession) playable() bool {
+	if ps.game.IsOver() {
+		fmt.Fprintln(ps.out, "The game is over, undo or quit.")
+		return false
+	}
+	return true
+}
+
+// humanMove plays a move entered by the human, returning false if it is illegal.
+func (ps *playSession) humanMove(text string, used time.Duration) bool {
+	m, err := shogi.ParseMove(ps.pos, text)
+	if err != nil && !isASCII(text) {
+		m, err = kifu.ParseMove(ps.pos, text, ps.lastMove())
+	}
+	if err != nil {
+		fmt.Fprintln(ps.out, err)
+		return false
+	}
+	ps.play(m, used)
+	return true
+}
+
+// isASCII returns true if the string has no Japanese character.
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r > 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// engineMove asks the engine for its move and plays it.
+func (ps *playSession) engineMove() error {
+	fmt.Fprintln(ps.out, "Thinking...")
+	bestmove, used, err := ps.engine.bestmove(ps.game, ps.pos.Side, ps.clock, ps.opts.MoveTime, playTimeMargin)
+	if err != nil {
+		return err
+	}
+
+	switch bestmove {
+	case "timeout":
+		ps.end(shogi.SpecialTimeUp, used)
+	case "resign":
+		ps.end(shogi.SpecialResign, used)
+	case "win":
+		ps.end(shogi.SpecialKachi, used)
+	default:
+		m, err := shogi.ParseMove(ps.pos, bestmove)
+		if err != nil {
+			fmt.Fprintf(ps.out, "The engine played an illegal move: %s\n", err)
+			ps.end(shogi.SpecialIllegalMove, used)
+			return nil
+		}
+		ps.play(m, used)
+	}
+	return nil
+}
+
+// playTimeMargin is the delay given to the engine beyond its time to send its move.
+const playTimeMargin = 500 * time.Millisecond
+
+// play plays a legal move of the side to move, ending the game on time or when it is over.
+func (ps *playSession) play(m shogi.Move, used time.Duration) {
+	side := ps.pos.Side
+	if !ps.clock.Consume(side, used) {
+		ps.end(shogi.SpecialTimeUp, used)
+		return
+	}
+	fmt.Fprintf(ps.out, "%s (%s)\n", kifu.MoveString(ps.pos, m, ps.lastMove()), m)
+	ps.pos.DoMove(m)
+	ps.game.AddMove(m, used)
+	if outcome := ps.pos.Outcome(); outcome.Status != shogi.Ongoing {
+		ps.end(outcome.SpecialMove(), 0)
+	}
+}
+
+// end ends the game with a special move and prints the result.
+func (ps *playSession) end(special shogi.SpecialMove, used time.Duration) {
+	ps.game.End(special, used)
+	result := ps.game.Result()
+	if result.Winner == shogi.NoColor {
+		fmt.Fprintf(ps.out, "Game over, no winner (%s).\n", result.Reason)
+		return
+	}
+	winner := "the engine wins"
+	if result.Winner == ps.opts.Human {
+		winner = "you win"
+	}
+	fmt.Fprintf(ps.out, "Game over, %s (%s).\n", winner, result.Reason)
+}
+
+// undo takes back the moves played since the previous move of the human.
+func (ps *playSession) undo() {
+	nodes := ps.game.Moves
+	if n := len(nodes); n > 0 && nodes[n-1].Special != "" {
+		nodes = nodes[:n-1]
+	}
+	i := len(nodes) - 1
+	for ; i >= 0; i-- {
+		if ps.sideOf(i) == ps.opts.Human {
+			break
+		}
+	}
+	if i < 0 {
+		fmt.Fprintln(ps.out, "There is no move to take back.")
+		return
+	}
+	ps.game.Moves = nodes[:i]
+	_ = ps.replay() // the start position has already been checked
+	ps.show()
+}
+
+// sideOf returns the side which played the n-th move of the main line, counted from 0.
+func (ps *playSession) sideOf(n int) shogi.Color {
+	start, _ := ps.opts.Variant.NewPositionFromSfen(ps.game.StartPos)
+	if n%2 == 0 {
+		return start.Side
+	}
+	return start.Side.Opponent()
+}
+
+// setTime sets the time control from durations like "10m", "30s" or "+10s".
+func (ps *playSession) setTime(args []string) {
+	tc := shogi.TimeControl{Main: 0, Byoyomi: 0, Increment: 0}
+	var err error
+	for i, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "+"):
+			tc.Increment, err = time.ParseDuration(arg[1:])
+		case i == 0:
+			tc.Main, err = time.ParseDuration(arg)
+		default:
+			tc.Byoyomi, err = time.ParseDuration(arg)
+		}
+		if err != nil || tc.Main < 0 || tc.Byoyomi < 0 || tc.Increment < 0 {
+			fmt.Fprintln(ps.out, "Invalid command: time <main> [<byoyomi>] [+<increment>]")
+			return
+		}
+	}
+	ps.game.Header.TimeControl = tc
+	ps.clock = shogi.NewClock(tc)
+	fmt.Fprintln(ps.out, ps.clockString())
+}
+
+// setStyle sets the style of the board drawing.
+func (ps *playSession) setStyle(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(ps.out, "Invalid command: style <%s>\n", strings.Join(render.Styles(), "|"))
+		return
+	}
+	style, err := render.NewStyle(args[0])
+	if err != nil {
+		fmt.Fprintln(ps.out, err)
+		return
+	}
+	ps.display.Style = style
+	ps.show()
+}
+
+// save writes the game in the format given by the file extension.
+func (ps *playSession) save(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(ps.out, "Invalid command: save <file.kif|file.ki2|file.csa|file.jkf>")
+		return
+	}
+	writers := map[string]func(io.Writer, *shogi.Game) error{
+		".kif": kifu.WriteKIF, ".kifu": kifu.WriteKIF, ".ki2": kifu.WriteKI2,
+		".csa": kifu.WriteCSA, ".jkf": kifu.WriteJKF, ".json": kifu.WriteJKF,
+	}
+	write, ok := writers[strings.ToLower(filepath.Ext(args[0]))]
+	if !ok || ps.opts.Variant != shogi.Standard {
+		fmt.Fprintln(ps.out, "Games of standard shogi can be saved as .kif, .ki2, .csa or .jkf files.")
+		return
+	}
+	f, err := os.Create(args[0])
+	if err == nil {
+		err = write(f, ps.game)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(ps.out, err)
+		return
+	}
+	fmt.Fprintf(ps.out, "Game saved to %s.\n", args[0])
+}
+
+// show draws the board, followed by the clocks.
+func (ps *playSession) show() {
+	fmt.Fprint(ps.out, render.Render(ps.pos, ps.display))
+	if !ps.clock.Unlimited() {
+		fmt.Fprintln(ps.out, ps.clockString())
+	}
+}
+
+// showMoves lists the legal moves.
+func (ps *playSession) showMoves() {
+	moves := movegen.LegalMoves(ps.pos)
+	names := make([]string, len(moves))
+	for i, m := range moves {
+		names[i] = m.String()
+	}
+	sort.Strings(names)
+	fmt.Fprintln(ps.out, strings.Join(names, " "))
+}
+
+// clockString returns the time left to the players, e.g. "☗ 9:58 ☖ 10:00 (byoyomi 30s)".
+func (ps *playSession) clockString() string {
+	if ps.clock.Unlimited() {
+		return "Unlimited time"
+	}
+	format := func(d time.Duration) string {
+		d = d.Round(time.Second)
+		return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+	}
+	text := fmt.Sprintf("%s %s %s %s", colorMark(shogi.Black), format(ps.clock.Remaining[shogi.Black]),
+		colorMark(shogi.White), format(ps.clock.Remaining[shogi.White]))
+	if byoyomi := ps.clock.TimeControl.Byoyomi; byoyomi > 0 {
+		text += fmt.Sprintf(" (byoyomi %s)", byoyomi)
+	}
+	if inc := ps.clock.TimeControl.Increment; inc > 0 {
+		text += fmt.Sprintf(" (increment %s)", inc)
+	}
+	return text
+}
+
+// colorMark returns the mark of a color, ☗ for Black and ☖ for White.
+func colorMark(c shogi.Color) string {
+	if c == shogi.White {
+		return "☖"
+	}
+	return "☗"
+}
@@ -4,8 +4,10 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/vinymeuh/hifumi/shogi"
@@ -16,6 +18,7 @@ const maxSearchDepth = 1
 
 type searchConstraints struct {
 	infinite bool
+	ponder   bool // the time limits apply once the GUI sends ponderhit
 	depth    uint
 	nodes    uint
 	duration time.Duration
@@ -24,6 +27,7 @@ type searchConstraints struct {
 func newSeachConstraints() searchConstraints {
 	return searchConstraints{
 		infinite: false,
+		ponder:   false,
 		depth:    0,
 		nodes:    0,
 		duration: 0,
@@ -34,91 +38,277 @@ type principalVariation struct {
 	line [maxSearchDepth]shogi.Move
 }
 
-func think(constraints searchConstraints) {
-	var ctx context.Context
-	var cancel context.CancelFunc
-	if constraints.duration > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), constraints.duration)
-	} else {
-		ctx, cancel = context.WithCancel(context.Background())
+// errStopRequested is the cancellation cause of a search interrupted by the GUI.
+var errStopRequested = errors.New("stop requested")
+
+// searchManager coordinates the lifecycle of the search goroutine.
+// At most one search runs at a time and all methods are safe for concurrent use.
+type searchManager struct {
+	mu       sync.Mutex
+	cancel   context.CancelCauseFunc // cancels the running search
+	started  chan struct{}           // closed once the running search is set up
+	done     chan struct{}           // closed once the running search has sent its bestmove
+	hit      chan struct{}           // closed when the GUI sends ponderhit, nil once closed
+	stopping bool                    // true when the running search has been asked to stop
+}
+
+func newSearchManager() *searchManager {
+	return &searchManager{
+		mu:       sync.Mutex{},
+		cancel:   nil,
+		started:  nil,
+		done:     nil,
+		hit:      nil,
+		stopping: false,
 	}
-	defer cancel()
+}
 
+// start launches a new search on its own copy of the position.
+// A search already running is stopped and waited for first, so that
+// its bestmove is always sent before the new search begins.
+func (sm *searchManager) start(pos *shogi.Position, constraints searchConstraints) {
+	sm.stop()
+	sm.wait()
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	started := make(chan struct{})
+	done := make(chan struct{})
+	hit := make(chan struct{})
+	pos = pos.Clone()
+	sm.cancel = cancel
+	sm.started = started
+	sm.done = done
+	sm.hit = hit
+	sm.stopping = false
+
+	setUp := sync.OnceFunc(func() { close(started) })
+	go func() {
+		defer close(done)
+		defer setUp()
+		defer cancel(nil)
+		think(ctx, pos, constraints, hit, setUp)
+	}()
+}
+
+// stop asks the running search, if any, to send its bestmove as soon as possible.
+// It returns without waiting for the search to complete.
+func (sm *searchManager) stop() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.cancel != nil {
+		sm.cancel(errStopRequested)
+		sm.stopping = true
+	}
+}
+
+// ponderHit tells the running search, if any, that the move it was pondering on has been played.
+func (sm *searchManager) ponderHit() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.hit != nil {
+		close(sm.hit)
+		sm.hit = nil
+	}
+}
+
+// wait blocks until the running search, if any, has completed.
+func (sm *searchManager) wait() {
+	sm.mu.Lock()
+	done := sm.done
+	sm.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// waitPending blocks until the running search, if any, is set up, and until a search
+// which has been asked to stop has completed. A search set up and still running normally
+// is not waited for, so that 'isready' is answered while thinking, as allowed by USI,
+// but never while the search is still starting.
+func (sm *searchManager) waitPending() {
+	sm.mu.Lock()
+	started := sm.started
+	done := sm.done
+	stopping := sm.stopping
+	sm.mu.Unlock()
+	if started != nil {
+		<-started
+	}
+	if done != nil && stopping {
+		<-done
+	}
+}
+
+// think searches the position until completion or cancellation of ctx, then sends the bestmove.
+// It calls setUp once the search is started, before waiting for its result.
+// In ponder mode, hit is closed when the GUI sends ponderhit.
+func think(ctx context.Context, pos *shogi.Position, constraints searchConstraints, hit <-chan struct{}, setUp func()) {
+	if pos.CanDeclareWin() {
+		engineOutput.infoString("entering king declaration (%s)", pos.EnteringKingRule)
+		setUp()
+		holdResult(ctx, constraints, hit)
+		engineOutput.send("bestmove win")
+		return
+	}
+	if m := tryMove(pos); m != 0 {
+		engineOutput.send("info depth 1 score mate 1 pv %s", m)
+		setUp()
+		holdResult(ctx, constraints, hit)
+		engineOutput.send("bestmove %s", m)
+		return
+	}
+
+	switch {
+	case constraints.ponder && !constraints.infinite:
+		var cancel context.CancelFunc
+		ctx, cancel = ponderContext(ctx, constraints.duration, hit)
+		defer cancel()
+		constraints.infinite = true
+	case constraints.duration > 0:
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, constraints.duration)
+		defer cancel()
+	}
+
+	var pv principalVariation
 	done := make(chan struct{})
 	msg := make(chan string, 8)
 
 	// go iterativeDeepening(searchCompleted)
-	go iFeelLucky(ctx, constraints, done, msg)
+	go iFeelLucky(ctx, pos, constraints, &pv, done, msg)
+	setUp()
 
 loop:
 	for {
 		select {
 		case txt := <-msg:
-			fmt.Println(txt)
-		case <-ctx.Done():
-			fmt.Println("timeout")
-			break loop
-		case <-engineStatus.stopRequested:
-			fmt.Println("stop requested")
-			cancel()
+			engineOutput.send("%s", txt)
 		case <-done:
-			fmt.Println("search completed")
 			break loop
 		}
 	}
 	// end loop
 
-	move := engineStatus.pv.line[0]
+	// flush messages sent before the search returned
+	for len(msg) > 0 {
+		engineOutput.send("%s", <-msg)
+	}
+
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, context.DeadlineExceeded):
+		engineOutput.infoString("timeout")
+	case errors.Is(cause, errStopRequested):
+		engineOutput.infoString("stop requested")
+	default:
+		engineOutput.infoString("search completed")
+	}
+
+	move := pv.line[0]
 	if move == 0 {
-		fmt.Println("bestmove resign") // only valid for Shogidokoro ?
+		engineOutput.send("bestmove resign")
 	} else {
-		fmt.Printf("bestmove %s\n", engineStatus.pv.line[0])
+		engineOutput.send("bestmove %s", move)
 	}
+}
 
-	if engineStatus.stopRequested != nil {
-		close(engineStatus.stopRequested)
-		engineStatus.stopRequested = nil
+// tryMove returns the legal move winning by try, if any.
+func tryMove(pos *shogi.Position) shogi.Move {
+	var moves movegen.MoveList
+	movegen.GenerateAllMoves(pos, &moves)
+	for i := 0; i < moves.Count; i++ {
+		m := moves.Moves[i]
+		pos.DoMove(m)
+		win := pos.TryWin() && !movegen.InCheck(pos, pos.Side.Opponent())
+		pos.UndoMove(m)
+		if win {
+			return m
+		}
+	}
+	return 0
+}
+
+// holdResult waits, in infinite and ponder modes, until the bestmove can be sent:
+// USI forbids it before 'stop', or 'ponderhit' when pondering.
+func holdResult(ctx context.Context, constraints searchConstraints, hit <-chan struct{}) {
+	switch {
+	case constraints.infinite:
+		<-ctx.Done()
+	case constraints.ponder:
+		select {
+		case <-ctx.Done():
+		case <-hit:
+		}
 	}
 }
 
-func iFeelLucky(ctx context.Context, constraints searchConstraints, done chan struct{}, msgout chan string) {
+// ponderContext returns the context of a search in ponder mode, canceled by a stop, or once
+// duration has elapsed after hit is closed.
+func ponderContext(ctx context.Context, duration time.Duration, hit <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hit:
+		}
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+			cancel(context.DeadlineExceeded)
+		}
+	}()
+	return ctx, func() { cancel(nil) }
+}
+
+// iFeelLucky plays a random legal move. It closes done when returning,
+// after which pv is no more modified.
+func iFeelLucky(ctx context.Context, pos *shogi.Position, constraints searchConstraints, pv *principalVariation, done chan struct{}, msgout chan string) {
+	defer close(done)
+
 	var moves movegen.MoveList
-	movegen.GenerateAllMoves(enginePosition, &moves)
+	movegen.GenerateAllMoves(pos, &moves)
 
 	var m shogi.Move
-	for {
+	for moves.Count > 0 {
 		n := rand.Intn(moves.Count)
 		m = moves.Moves[n]
-		enginePosition.DoMove(m)
-		enginePosition.UndoMove(m)
-		if len(movegen.Checkers(enginePosition, enginePosition.Side)) == 0 {
+		pos.DoMove(m)
+		inCheck := movegen.InCheck(pos, pos.Side.Opponent())
+		pos.UndoMove(m)
+		if !inCheck {
 			break
 		}
 		moves.Moves[n] = moves.Moves[moves.Count-1]
 		moves.Count--
-		if moves.Count == 0 {
-			m = shogi.Move(0)
-			break
-		}
+		m = shogi.Move(0)
 	}
-	engineStatus.pv.line[0] = m
+	pv.line[0] = m
 
 	// fake thinking
 	depth := 0
 	for {
-		time.Sleep(1000 * time.Millisecond)
-		depth++
-		msgout <- fmt.Sprintf("info depth %d pv %s", depth, engineStatus.pv.line[0])
-
-		if depth >= int(constraints.depth) && constraints.infinite == false {
-			close(done)
-			return
-		}
 		select {
 		case <-ctx.Done():
 			return
+		case <-time.After(1000 * time.Millisecond):
+		}
+		depth++
+		info := fmt.Sprintf("info depth %d", depth)
+		if pv.line[0] != 0 {
+			info += fmt.Sprintf(" pv %s", pv.line[0])
+		}
+		select {
+		case msgout <- info:
 		default:
-			break
+		}
+
+		if depth >= int(constraints.depth) && !constraints.infinite {
+			return
 		}
 	}
 }
@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"fmt"
+	"math"
+)
+
+// Statistics of the matches use the logistic Elo model, where a difference of d Elo gives
+// an expected score of 1/(1+10^(-d/400)). The games are grouped by pairs playing the same
+// opening with swapped colors, whose results are correlated: the pentanomial statistics,
+// counting the pairs by score, give better estimations than the wins, losses and draws.
+
+// A Pentanomial counts the game pairs by score of the first engine: 0, 0.5, 1, 1.5 and 2.
+type Pentanomial [5]int
+
+// Pentanomial returns the pentanomial statistics of the complete game pairs of a match.
+func (r *MatchResult) Pentanomial() Pentanomial {
+	var p Pentanomial
+	for n := 0; n+1 < len(r.Games); n += 2 {
+		p[int(2*(r.Score(n)+r.Score(n+1)))]++
+	}
+	return p
+}
+
+// Pairs returns the number of game pairs.
+func (p Pentanomial) Pairs() int {
+	return p[0] + p[1] + p[2] + p[3] + p[4]
+}
+
+// pentanomialPrior is the minimum count of each score in the statistics, as done by fishtest.
+// Without it, the variance is zero when all the pairs have the same score, for example when
+// an engine wins all of them, and the statistics would never reach a conclusion.
+const pentanomialPrior = 1e-3
+
+// scoreStats returns the mean score by game and its variance by pair, computed from the
+// counts regularized by pentanomialPrior.
+func (p Pentanomial) scoreStats() (mean, variance float64) {
+	if p.Pairs() == 0 {
+		return 0.5, 0
+	}
+	var counts [5]float64
+	n := 0.0
+	for i, count := range p {
+		counts[i] = max(float64(count), pentanomialPrior)
+		n += counts[i]
+	}
+	for i, count := range counts {
+		mean += float64(i) / 4 * count / n
+	}
+	for i, count := range counts {
+		variance += math.Pow(float64(i)/4-mean, 2) * count / n
+	}
+	return mean, variance
+}
+
+// String returns the counts as "0, 2, 5, 3, 1".
+func (p Pentanomial) String() string {
+	return fmt.Sprintf("%d, %d, %d, %d, %d", p[0], p[1], p[2], p[3], p[4])
+}
+
+// eloConfidence is the number of standard deviations of the 95% confidence intervals.
+const eloConfidence = 1.959964
+
+// Elo returns the Elo difference between the first and the second engine estimated from
+// the pentanomial statistics, and the half width of its 95% confidence interval.
+// The margin is infinite when the interval reaches a score of 0 or 1.
+func (p Pentanomial) Elo() (elo, margin float64) {
+	mean, variance := p.scoreStats()
+	elo = ScoreToElo(mean)
+	if p.Pairs() == 0 {
+		return elo, math.Inf(1)
+	}
+	deviation := eloConfidence * math.Sqrt(variance/float64(p.Pairs()))
+	return elo, (ScoreToElo(mean+deviation) - ScoreToElo(mean-deviation)) / 2
+}
+
+// ScoreToElo returns the Elo difference giving an expected score between 0 and 1.
+func ScoreToElo(score float64) float64 {
+	switch {
+	case score <= 0:
+		return math.Inf(-1)
+	case score >= 1:
+		return math.Inf(1)
+	}
+	return -400 * math.Log10(1/score-1)
+}
+
+// EloToScore returns the expected score for an Elo difference.
+func EloToScore(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// LOS returns the likelihood of superiority of the first engine, the probability
+// that it is stronger given its wins and losses, draws being ignored.
+func LOS(wins, losses int) float64 {
+	if wins+losses == 0 {
+		return 0.5
+	}
+	return 0.5 * (1 + math.Erf(float64(wins-losses)/math.Sqrt(2*float64(wins+losses))))
+}
+
+// SPRT is a sequential probability ratio test of the hypothesis H1, the first engine being
+// Elo1 stronger than the second, against H0, it being Elo0 stronger. Alpha is the probability
+// to accept H1 when H0 is true and Beta the probability to accept H0 when H1 is true.
+type SPRT struct {
+	Elo0  float64
+	Elo1  float64
+	Alpha float64
+	Beta  float64
+}
+
+// NewSPRT returns a SPRT, checking its parameters.
+func NewSPRT(elo0, elo1, alpha, beta float64) (*SPRT, error) {
+	if elo0 >= elo1 {
+		return nil, fmt.Errorf("elo0 must be lower than elo1")
+	}
+	if alpha <= 0 || alpha >= 0.5 || beta <= 0 || beta >= 0.5 {
+		return nil, fmt.Errorf("alpha and beta must be between 0 and 0.5")
+	}
+	return &SPRT{Elo0: elo0, Elo1: elo1, Alpha: alpha, Beta: beta}, nil
+}
+
+// Bounds returns the log-likelihood ratios below which H0 is accepted and above which H1 is accepted.
+func (s *SPRT) Bounds() (lower, upper float64) {
+	return math.Log(s.Beta / (1 - s.Alpha)), math.Log((1 - s.Beta) / s.Alpha)
+}
+
+// LLR returns the log-likelihood ratio of H1 against H0 given the pentanomial statistics.
+// It uses the normal approximation of the generalized SPRT, zero without any pair.
+func (s *SPRT) LLR(p Pentanomial) float64 {
+	mean, variance := p.scoreStats()
+	if p.Pairs() == 0 {
+		return 0
+	}
+	s0, s1 := EloToScore(s.Elo0), EloToScore(s.Elo1)
+	return float64(p.Pairs()) * (s1 - s0) * (2*mean - s0 - s1) / (2 * variance)
+}
+
+// An SPRTDecision is the state of a SPRT.
+type SPRTDecision int
+
+const (
+	SPRTContinue SPRTDecision = iota // More games are needed
+	SPRTAcceptH0                     // The lower bound has been crossed
+	SPRTAcceptH1                     // The upper bound has been crossed
+)
+
+var sprtDecisionNames = []string{"continue", "H0 accepted", "H1 accepted"}
+
+// String returns a description of the decision.
+func (d SPRTDecision) String() string {
+	return sprtDecisionNames[d]
+}
+
+// Decision returns the decision of the test given the pentanomial statistics.
+func (s *SPRT) Decision(p Pentanomial) SPRTDecision {
+	llr := s.LLR(p)
+	lower, upper := s.Bounds()
+	switch {
+	case llr <= lower:
+		return SPRTAcceptH0
+	case llr >= upper:
+		return SPRTAcceptH1
+	}
+	return SPRTContinue
+}
+
+// String returns the parameters as "elo0=0 elo1=5 alpha=0.05 beta=0.05".
+func (s *SPRT) String() string {
+	return fmt.Sprintf("elo0=%g elo1=%g alpha=%g beta=%g", s.Elo0, s.Elo1, s.Alpha, s.Beta)
+}
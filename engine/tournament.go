@@ -0,0 +1,360 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// A Schedule is the way the engines of a tournament are paired.
+type Schedule int
+
+const (
+	RoundRobin Schedule = iota // Every engine plays all the others
+	Gauntlet                   // The first engine plays all the others
+)
+
+var scheduleNames = []string{"roundrobin", "gauntlet"}
+
+// Schedules returns the names of the schedules.
+func Schedules() []string {
+	return append([]string(nil), scheduleNames...)
+}
+
+// NewSchedule returns the Schedule from its name.
+func NewSchedule(name string) (Schedule, error) {
+	for i, n := range scheduleNames {
+		if n == name {
+			return Schedule(i), nil
+		}
+	}
+	return RoundRobin, fmt.Errorf("unknown schedule '%s'", name)
+}
+
+// String returns the name of the schedule.
+func (s Schedule) String() string {
+	return scheduleNames[s]
+}
+
+// pairings returns the pairs of engines playing each other.
+func (s Schedule) pairings(engines int) [][2]int {
+	var pairs [][2]int
+	for i := 0; i < engines; i++ {
+		for j := i + 1; j < engines; j++ {
+			if s == Gauntlet && i > 0 {
+				break
+			}
+			pairs = append(pairs, [2]int{i, j})
+		}
+	}
+	return pairs
+}
+
+// TournamentOptions are the settings of a tournament between engines.
+type TournamentOptions struct {
+	GameSettings
+	// Engines playing the tournament
+	Engines []MatchEngine
+	// Schedule pairing the engines
+	Schedule Schedule
+	// Rounds is the number of game pairs played by each pairing, the engines swapping colors
+	// on the same opening. All the pairings play the same openings in the same round.
+	Rounds int
+	// Concurrency is the number of games played at the same time, one if zero.
+	// Each of them runs its own processes of the two engines playing it.
+	Concurrency int
+}
+
+// A TournamentGame is a game of a tournament.
+type TournamentGame struct {
+	// Players are the indexes of the engines by color
+	Players [shogi.COLORS]int
+	// Game played
+	Game *shogi.Game
+}
+
+// TournamentResult holds the games of a tournament.
+type TournamentResult struct {
+	// Names of the engines
+	Names []string
+	// Games played, in the order of the schedule
+	Games []TournamentGame
+}
+
+// Tournament plays a tournament between engines, printing the result of each game on out as
+// it finishes. The tournament is interrupted by the failure of an engine, the games already
+// played being returned.
+func Tournament(out io.Writer, opts TournamentOptions) (*TournamentResult, error) {
+	if len(opts.Engines) < 2 {
+		return nil, fmt.Errorf("a tournament needs at least two engines")
+	}
+	openings, err := opts.prepare()
+	if err != nil {
+		return nil, err
+	}
+	type job struct {
+		players [shogi.COLORS]int
+		opening *shogi.Game
+	}
+	var schedule []job
+	for round := 0; round < opts.Rounds; round++ {
+		for _, pair := range opts.Schedule.pairings(len(opts.Engines)) {
+			opening := openings[round%len(openings)]
+			schedule = append(schedule,
+				job{players: [shogi.COLORS]int{pair[0], pair[1]}, opening: opening},
+				job{players: [shogi.COLORS]int{pair[1], pair[0]}, opening: opening})
+		}
+	}
+
+	names := make([]string, len(opts.Engines)) // given by the processes of the engines
+	var (
+		mu       sync.Mutex // protects games, names and firstErr, serializes the output
+		games    = make([]*shogi.Game, len(schedule))
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	jobs := make(chan int)
+	for i := 0; i < max(1, min(opts.Concurrency, len(schedule))); i++ {
+		w := &tournamentWorker{opts: &opts, processes: make(map[int]*usiProcess)}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer w.close()
+			for n := range jobs {
+				players := schedule[n].players
+				var g *shogi.Game
+				engines, err := w.players(players)
+				if err == nil {
+					g, err = playEngineGame(engines, schedule[n].opening, &opts.GameSettings)
+				}
+				if err == nil {
+					err = opts.record(n, g)
+				}
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				if g != nil {
+					for c, i := range players {
+						names[i] = engines[c].name
+					}
+					games[n] = g
+					writeGameResult(out, n, g)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for n := range schedule {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		jobs <- n
+	}
+	close(jobs)
+	wg.Wait()
+
+	result := &TournamentResult{Names: names, Games: nil}
+	for i, e := range opts.Engines {
+		if result.Names[i] == "" { // the engine hasn't played
+			result.Names[i] = e.Name
+		}
+		if result.Names[i] == "" && len(e.Command) > 0 {
+			result.Names[i] = filepath.Base(e.Command[0])
+		}
+	}
+	for n, g := range games {
+		if g != nil {
+			result.Games = append(result.Games, TournamentGame{Players: schedule[n].players, Game: g})
+		}
+	}
+	return result, firstErr
+}
+
+// A tournamentWorker plays games one after the other, running the processes of the engines
+// of its current game.
+type tournamentWorker struct {
+	opts      *TournamentOptions
+	processes map[int]*usiProcess // by index of the engine
+}
+
+// players returns the processes of the engines playing a game, by color. The processes of the
+// previous game are kept for the engines playing again and stopped for the others.
+func (w *tournamentWorker) players(players [shogi.COLORS]int) ([shogi.COLORS]*usiProcess, error) {
+	var processes [shogi.COLORS]*usiProcess
+	for i, p := range w.processes {
+		if i != players[shogi.Black] && i != players[shogi.White] {
+			p.close()
+			delete(w.processes, i)
+		}
+	}
+	for c, i := range players {
+		if w.processes[i] == nil {
+			p, err := startUsiProcess(w.opts.Engines[i], &w.opts.GameSettings)
+			if err != nil {
+				return processes, err
+			}
+			w.processes[i] = p
+		}
+		processes[c] = w.processes[i]
+	}
+	return processes, nil
+}
+
+// close stops the processes of the engines.
+func (w *tournamentWorker) close() {
+	for i, p := range w.processes {
+		p.close()
+		delete(w.processes, i)
+	}
+}
+
+// Crosstable returns the points scored by each engine against each other and the number of games they played.
+func (r *TournamentResult) Crosstable() (points [][]float64, games [][]int) {
+	points, games = make([][]float64, len(r.Names)), make([][]int, len(r.Names))
+	for i := range r.Names {
+		points[i], games[i] = make([]float64, len(r.Names)), make([]int, len(r.Names))
+	}
+	for _, tg := range r.Games {
+		black, white := tg.Players[shogi.Black], tg.Players[shogi.White]
+		games[black][white]++
+		games[white][black]++
+		switch tg.Game.Result().Winner {
+		case shogi.Black:
+			points[black][white]++
+		case shogi.White:
+			points[white][black]++
+		default:
+			points[black][white] += 0.5
+			points[white][black] += 0.5
+		}
+	}
+	return points, games
+}
+
+// eloPriorDraws is the number of virtual draws added between the engines which have played each other,
+// as in BayesElo, keeping the ratings finite when an engine wins or loses all its games.
+const eloPriorDraws = 1
+
+// Ratings returns the Elo ratings of the engines maximizing the likelihood of the results,
+// with an average of zero. Draws count as half a win and half a loss. The engines which
+// haven't played are rated zero and left out of the average.
+func (r *TournamentResult) Ratings() []float64 {
+	points, games := r.Crosstable()
+	n := len(r.Names)
+	wins := make([]float64, n) // points with the prior
+	played := make([][]float64, n)
+	for i := range played {
+		played[i] = make([]float64, n)
+		for j := range played[i] {
+			if games[i][j] > 0 {
+				played[i][j] = float64(games[i][j]) + eloPriorDraws
+				wins[i] += points[i][j] + eloPriorDraws/2.0
+			}
+		}
+	}
+
+	// minorization-maximization of the Bradley-Terry model, where the strength of an engine is 10^(elo/400)
+	strengths := make([]float64, n)
+	for i := range strengths {
+		strengths[i] = 1
+	}
+	for iteration := 0; iteration < 10000; iteration++ {
+		change := 0.0
+		for i := range strengths {
+			denominator := 0.0
+			for j := range strengths {
+				if played[i][j] > 0 {
+					denominator += played[i][j] / (strengths[i] + strengths[j])
+				}
+			}
+			if denominator == 0 {
+				continue
+			}
+			s := wins[i] / denominator
+			change = math.Max(change, math.Abs(s-strengths[i])/strengths[i])
+			strengths[i] = s
+		}
+		if change < 1e-10 {
+			break
+		}
+	}
+
+	ratings := make([]float64, n)
+	mean, rated := 0.0, 0
+	for i, s := range strengths {
+		if wins[i] > 0 { // the prior gives points to the engines which have played
+			ratings[i] = 400 * math.Log10(s)
+			mean += ratings[i]
+			rated++
+		}
+	}
+	for i := range ratings {
+		if wins[i] > 0 {
+			ratings[i] -= mean / float64(rated)
+		}
+	}
+	return ratings
+}
+
+// WriteCrosstable prints the engines ranked by rating, with their points and their results
+// against each other, e.g.
+//
+//	Rank Name       Elo  Points  Games  Score        1       2       3
+//	   1 Alpha      +74     5.5      8  68.8%        -   3.0/4   2.5/4
+func (r *TournamentResult) WriteCrosstable(w io.Writer) {
+	points, games := r.Crosstable()
+	ratings := r.Ratings()
+	ranking := make([]int, len(r.Names))
+	for i := range ranking {
+		ranking[i] = i
+	}
+	sort.SliceStable(ranking, func(a, b int) bool { return ratings[ranking[a]] > ratings[ranking[b]] })
+
+	width := len("Name")
+	for _, name := range r.Names {
+		width = max(width, len(name))
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Rank %-*s  Elo  Points  Games  Score ", width, "Name")
+	for rank := range ranking {
+		fmt.Fprintf(&sb, " %7d", rank+1)
+	}
+	sb.WriteString("\n")
+	for rank, i := range ranking {
+		var line strings.Builder
+		total, played := 0.0, 0
+		for j := range r.Names {
+			total += points[i][j]
+			played += games[i][j]
+		}
+		score := 0.0
+		if played > 0 {
+			score = 100 * total / float64(played)
+		}
+		fmt.Fprintf(&line, "%4d %-*s %+4.0f %7.1f %6d %5.1f%% ", rank+1, width, r.Names[i], ratings[i], total, played, score)
+		for _, j := range ranking {
+			switch {
+			case i == j:
+				fmt.Fprintf(&line, " %7s", "-")
+			case games[i][j] == 0:
+				fmt.Fprintf(&line, " %7s", "")
+			default:
+				fmt.Fprintf(&line, " %7s", fmt.Sprintf("%.1f/%d", points[i][j], games[i][j]))
+			}
+		}
+		sb.WriteString(strings.TrimRight(line.String(), " ") + "\n")
+	}
+	_, _ = io.WriteString(w, sb.String())
+}
@@ -0,0 +1,194 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// usiClient drives a USI engine, sending commands to its input and reading its output lines.
+type usiClient struct {
+	w       io.WriteCloser
+	lines   chan string     // closed at the end of the engine output
+	options map[string]bool // options declared by the engine
+}
+
+// newUsiClient creates a usiClient writing the commands to w and reading the output from r.
+func newUsiClient(w io.WriteCloser, r io.Reader) *usiClient {
+	lines := make(chan string, 64)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- strings.TrimRight(scanner.Text(), "\r")
+		}
+	}()
+	return &usiClient{w: w, lines: lines, options: make(map[string]bool)}
+}
+
+// newLocalUsiClient starts the hifumi USI loop in a goroutine and returns a usiClient driving it.
+// As the USI loop uses the engine global variables, only one can run at a time.
+func newLocalUsiClient() *usiClient {
+	cmdReader, cmdWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	go func() {
+		usiLoop(cmdReader, outWriter)
+		_ = outWriter.Close()
+		_ = cmdReader.Close()
+	}()
+	return newUsiClient(cmdWriter, outReader)
+}
+
+// send sends a command to the engine.
+func (uc *usiClient) send(format string, a ...any) error {
+	_, err := fmt.Fprintf(uc.w, format+"\n", a...)
+	return err
+}
+
+// errUsiTimeout is returned by expect when the engine doesn't answer in time.
+var errUsiTimeout = errors.New("timeout")
+
+// expect reads the output of the engine until a line starting with the first word of prefix,
+// and returns this line. It fails with errUsiTimeout after timeout, unless zero.
+func (uc *usiClient) expect(prefix string, timeout time.Duration) (string, error) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		select {
+		case line, ok := <-uc.lines:
+			if !ok {
+				return "", fmt.Errorf("engine output closed while waiting for '%s'", prefix)
+			}
+			if line == prefix || strings.HasPrefix(line, prefix+" ") {
+				return line, nil
+			}
+		case <-deadline:
+			return "", errUsiTimeout
+		}
+	}
+}
+
+// handshake initializes the engine with its options, e.g. {"USI_Variant", "minishogi"}, and returns
+// its name. Options not declared by the engine are skipped, except the reserved USI_ options.
+func (uc *usiClient) handshake(options [][2]string) (string, error) {
+	if err := uc.send("usi"); err != nil {
+		return "", err
+	}
+	name := ""
+	for {
+		line, ok := <-uc.lines
+		if !ok {
+			return "", fmt.Errorf("engine output closed while waiting for 'usiok'")
+		}
+		if line == "usiok" {
+			break
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) > 2 && fields[0] == "id" && fields[1] == "name":
+			name = strings.Join(fields[2:], " ")
+		case len(fields) > 2 && fields[0] == "option" && fields[1] == "name":
+			uc.options[fields[2]] = true
+		}
+	}
+	for _, option := range options {
+		if err := uc.setOption(option[0], option[1]); err != nil {
+			return "", err
+		}
+	}
+	if err := uc.send("isready"); err != nil {
+		return "", err
+	}
+	_, err := uc.expect("readyok", 0)
+	return name, err
+}
+
+// setOption sets an option declared by the engine or a reserved USI_ option, other options being skipped.
+func (uc *usiClient) setOption(name, value string) error {
+	if !uc.options[name] && !strings.HasPrefix(name, "USI_") {
+		return nil
+	}
+	return uc.send("setoption name %s value %s", name, value)
+}
+
+// usiStopTimeout is the delay given to the engine to send its move after the stop command.
+const usiStopTimeout = 5 * time.Second
+
+// bestmove sends the position reached in a game to the engine, starts the search with the
+// limits of the clock and returns the bestmove argument and the time used. The engine loses
+// on time, bestmove returning "timeout", when it doesn't answer before its time is up plus margin;
+// an answer within the margin is charged the time available.
+func (uc *usiClient) bestmove(g *shogi.Game, side shogi.Color, clock *shogi.Clock, moveTime, margin time.Duration) (string, time.Duration, error) {
+	var sb strings.Builder
+	sb.WriteString("position sfen " + g.StartPos)
+	if moves := g.MainLine(); len(moves) > 0 {
+		sb.WriteString(" moves")
+		for _, m := range moves {
+			sb.WriteString(" " + m.String())
+		}
+	}
+	if err := uc.send("%s", sb.String()); err != nil {
+		return "", 0, err
+	}
+
+	start := time.Now()
+	if err := uc.send("go %s", usiGoLimits(clock, moveTime)); err != nil {
+		return "", 0, err
+	}
+	var timeout time.Duration
+	if !clock.Unlimited() {
+		timeout = clock.Available(side) + margin
+	}
+	line, err := uc.expect("bestmove", timeout)
+	used := time.Since(start)
+	if errors.Is(err, errUsiTimeout) {
+		_ = uc.send("stop")
+		if _, err = uc.expect("bestmove", usiStopTimeout); err != nil {
+			return "", used, fmt.Errorf("no bestmove after stop: %w", err)
+		}
+		return "timeout", used, nil
+	}
+	if err != nil {
+		return "", used, err
+	}
+	if !clock.Unlimited() && used > clock.Available(side) { // late within the margin
+		used = clock.Available(side)
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return "", used, fmt.Errorf("invalid engine answer '%s'", line)
+	}
+	return fields[1], used, nil
+}
+
+// usiGoLimits returns the arguments of the USI go command for the clock,
+// or a fixed move time if the time is unlimited.
+func usiGoLimits(clock *shogi.Clock, moveTime time.Duration) string {
+	if clock.Unlimited() {
+		return fmt.Sprintf("movetime %d", moveTime.Milliseconds())
+	}
+	limits := fmt.Sprintf("btime %d wtime %d", clock.Remaining[shogi.Black].Milliseconds(), clock.Remaining[shogi.White].Milliseconds())
+	if inc := clock.TimeControl.Increment; inc > 0 {
+		return limits + fmt.Sprintf(" binc %d winc %d", inc.Milliseconds(), inc.Milliseconds())
+	}
+	return limits + fmt.Sprintf(" byoyomi %d", clock.TimeControl.Byoyomi.Milliseconds())
+}
+
+// quit asks the engine to quit and waits for the end of its output.
+func (uc *usiClient) quit() {
+	_ = uc.send("quit")
+	_ = uc.w.Close()
+	for range uc.lines { //nolint:revive
+	}
+}
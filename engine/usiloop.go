@@ -5,6 +5,7 @@ package engine
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"sort"
 	"strconv"
@@ -14,6 +15,7 @@ import (
 	"github.com/vinymeuh/hifumi/shogi"
 	"github.com/vinymeuh/hifumi/shogi/movegen"
 	"github.com/vinymeuh/hifumi/shogi/perft"
+	"github.com/vinymeuh/hifumi/shogi/render"
 )
 
 const (
@@ -26,32 +28,61 @@ const (
 var (
 	engineOptions = map[string]usiOption{
 		"USI_Variant": comboOption{
-			value:    "shogi",
-			values:   []string{"shogi"},
-			callback: noopStringCallback,
+			value:    shogi.Standard.Name,
+			values:   shogi.Variants(),
+			callback: variantCallback,
+		},
+		"EnteringKingRule": comboOption{
+			value:    shogi.CSARule27.String(),
+			values:   shogi.EnteringKingRules(),
+			callback: enteringKingRuleCallback,
+		},
+		"DisplayStyle": comboOption{
+			value:    render.ASCII.String(),
+			values:   render.Styles(),
+			callback: displayStyleCallback,
+		},
+		"DisplayFlip": checkOption{
+			value:    false,
+			callback: displayFlipCallback,
 		},
 	}
 
-	engineStatus = struct {
-		stopRequested chan struct{}
-		pv            principalVariation
-	}{
-		stopRequested: nil,
-		pv:            principalVariation{line: [1]shogi.Move{0}},
-	}
+	engineVariant = shogi.Standard
+
+	engineEnteringKingRule = shogi.CSARule27
+
+	engineDisplay = render.Options{Style: render.ASCII, Flip: false}
+
+	engineSearch *searchManager
 
 	enginePosition *shogi.Position
+
+	engineOutput *usiWriter
 )
 
 // ================================== //
 // ============ Usi Loop ============ //
 // ================================== //
 func Start() {
-	fmt.Printf("Hifumi version %s (☗_☗), :? for help\n", EngineVersion)
+	usiLoop(os.Stdin, os.Stdout)
+}
 
-	enginePosition, _ = shogi.NewPositionFromSfen(shogi.StartPos)
+func usiLoop(in io.Reader, out io.Writer) {
+	engineOutput = newUsiWriter(out)
+	engineOutput.infoString("Hifumi version %s (☗_☗), :? for help", EngineVersion)
 
-	reader := bufio.NewScanner(os.Stdin)
+	engineSearch = newSearchManager()
+	engineVariant = shogi.Standard
+	engineEnteringKingRule = shogi.CSARule27
+	engineDisplay = render.Options{Style: render.ASCII, Flip: false}
+	enginePosition, _ = engineVariant.NewPositionFromSfen(engineVariant.StartPos)
+
+	// on 'quit' or end of input, stop the search and wait for its bestmove
+	defer engineSearch.wait()
+	defer engineSearch.stop()
+
+	reader := bufio.NewScanner(in)
 	for reader.Scan() {
 		text := reader.Text()
 		if text == "" {
@@ -61,29 +92,29 @@ func Start() {
 		cmd := strings.Fields(text)[0]
 		switch cmd {
 		case "usi":
-			fmt.Printf("id name Hifumi %s\n", EngineVersion)
-			fmt.Println("id author vinymeuh")
+			engineOutput.send("id name Hifumi %s", EngineVersion)
+			engineOutput.send("id author vinymeuh")
 			for name, option := range engineOptions {
-				fmt.Println("option name", name, option)
+				engineOutput.send("option name %s %s", name, option)
 			}
-			fmt.Println("usiok")
+			engineOutput.send("usiok")
 		case "usinewgame":
-			enginePosition, _ = shogi.NewPositionFromSfen(shogi.StartPos)
+			enginePosition, _ = engineVariant.NewPositionFromSfen(engineVariant.StartPos)
 		case "isready":
-			fmt.Println("readyok")
+			engineSearch.waitPending()
+			engineOutput.send("readyok")
 		case "setoption":
 			setoptionHandler(strings.Fields(text))
 		case "position":
 			positionHandler(strings.Fields(text))
 		case "go":
-			if engineStatus.stopRequested == nil {
-				goHandler(strings.Fields(text))
-			}
+			goHandler(strings.Fields(text))
 		case "stop":
-			if engineStatus.stopRequested != nil {
-				close(engineStatus.stopRequested)
-				engineStatus.stopRequested = nil
-			}
+			engineSearch.stop()
+		case "ponderhit":
+			engineSearch.ponderHit()
+		case "gameover":
+			engineSearch.stop()
 		case "quit":
 			return
 		case "perft":
@@ -94,9 +125,9 @@ func Start() {
 			displayHandler()
 		case ":?":
 			// helpHandler()
-			fmt.Println("TODO")
+			engineOutput.infoString("TODO")
 		default:
-			fmt.Printf("Unknown command '%s', :? for help\n", text)
+			engineOutput.infoString("Unknown command '%s', :? for help", text)
 		}
 	}
 }
@@ -111,26 +142,26 @@ func setoptionHandler(args []string) {
 		if option, ok := engineOptions[optionName]; ok {
 			option.set("")
 		} else {
-			fmt.Println("No such option:", optionName)
+			engineOutput.infoString("No such option: %s", optionName)
 		}
 	case len(args) == 5 && args[1] == "name" && args[3] == "value":
 		optionName := args[2]
 		optionValue := args[4]
 		if option, ok := engineOptions[optionName]; ok {
 			if err := option.set(optionValue); err != nil {
-				fmt.Println("Invalid value:", err)
+				engineOutput.infoString("Invalid value: %s", err)
 			}
 		} else {
-			fmt.Println("No such option:", optionName)
+			engineOutput.infoString("No such option: %s", optionName)
 		}
 	default:
-		fmt.Println("Invalid command: setoption name <id> [value <val>]")
+		engineOutput.infoString("Invalid command: setoption name <id> [value <val>]")
 	}
 }
 
 func positionHandler(args []string) {
-	if len(args) < 2 || (args[1] != "sfen" && args[1] != "startpos") {
-		fmt.Println("Invalid command: position [sfen <sfenstring> | startpos ] moves <move1> ... <movei>")
+	if len(args) < 2 || (args[1] != "sfen" && args[1] != "startpos" && args[1] != "handicap") {
+		engineOutput.infoString("Invalid command: position [sfen <sfenstring> | startpos | handicap <name>] moves <move1> ... <movei>")
 		return
 	}
 
@@ -142,7 +173,7 @@ func positionHandler(args []string) {
 		}
 	}
 	if movesIndex == len(args)-1 {
-		fmt.Println("Invalid command: position [sfen <sfenstring> | startpos ] moves <move1> ... <movei>")
+		engineOutput.infoString("Invalid command: position [sfen <sfenstring> | startpos | handicap <name>] moves <move1> ... <movei>")
 		return
 	}
 
@@ -153,23 +184,38 @@ func positionHandler(args []string) {
 	switch args[1] {
 	case "sfen":
 		// use all args between 'sfen' and 'moves' as the sfen => args[2:moves_index]
-		pos, err = shogi.NewPositionFromSfen(strings.Join(args[2:movesIndex], " "))
+		pos, err = engineVariant.NewPositionFromSfen(strings.Join(args[2:movesIndex], " "))
 	case "startpos":
-		pos, err = shogi.NewPositionFromSfen(shogi.StartPos)
+		pos, err = engineVariant.NewPositionFromSfen(engineVariant.StartPos)
+	case "handicap":
+		if engineVariant != shogi.Standard {
+			engineOutput.infoString("Handicaps are only available for %s", shogi.Standard.Name)
+			return
+		}
+		// handicap name is the only arg between 'handicap' and 'moves'
+		if movesIndex != 3 {
+			engineOutput.infoString("Invalid command: position handicap <%s> moves <move1> ... <movei>", strings.Join(shogi.Handicaps(), "|"))
+			return
+		}
+		pos, err = shogi.NewPositionFromHandicap(args[2])
+	}
+	if err == nil {
+		err = pos.Validate()
 	}
 	if err != nil {
-		fmt.Println(err)
+		engineOutput.infoString("%s", err)
 		return
 	}
 
 	// applyMoves
 	if movesIndex < len(args) {
 		for _, str := range args[movesIndex+1:] {
-			_, err := applyUsiMove(pos, str)
+			m, err := shogi.ParseMove(pos, str)
 			if err != nil {
-				fmt.Println("Invalid move: ", str)
+				engineOutput.infoString("%s", err)
 				return
 			}
+			pos.DoMove(m)
 		}
 	}
 
@@ -190,7 +236,8 @@ func goHandler(args []string) {
 			constraints.infinite = true
 			break
 		}
-		if token == "ponder" { // not implemented
+		if token == "ponder" {
+			constraints.ponder = true
 			continue
 		}
 		if i+1 >= len(args) {
@@ -246,87 +293,76 @@ func goHandler(args []string) {
 		constraints.duration = 0
 	}
 
-	engineStatus.stopRequested = make(chan struct{})
-	go think(constraints)
+	enginePosition.EnteringKingRule = engineEnteringKingRule
+	engineSearch.start(enginePosition, constraints)
 }
 
 func perftHandler(args []string, divide bool) {
 	depth, _ := strconv.Atoi(args[1])
 
 	result := perft.Compute(enginePosition, depth)
-	moves := make([]string, 0, result.MovesCount)
+	moves := make([]shogi.Move, 0, result.MovesCount)
 	for m := range result.Moves {
-		moves = append(moves, m.String())
+		moves = append(moves, m)
 	}
 
+	var sb strings.Builder
 	if divide {
-		fmt.Println()
-		sort.Strings(moves)
-		for _, move := range moves {
-			m := result.FindMove(move)
-			fmt.Printf("%s: %d\n", move, result.Moves[m])
+		sort.Slice(moves, func(i, j int) bool { return moves[i].String() < moves[j].String() })
+		for _, m := range moves {
+			fmt.Fprintf(&sb, "%s: %d\n", m, result.Moves[m])
 		}
 	}
 
-	fmt.Printf("\nMoves           : %d\n", result.MovesCount)
-	fmt.Printf("Nodes searched  : %d\n", result.NodesCount)
-	fmt.Printf("Duration        : %s\n", result.Duration)
-	fmt.Printf("NPS             : %.0f\n\n", float64(result.NodesCount)/result.Duration.Seconds())
+	fmt.Fprintf(&sb, "Moves           : %d\n", result.MovesCount)
+	fmt.Fprintf(&sb, "Nodes searched  : %d\n", result.NodesCount)
+	fmt.Fprintf(&sb, "Duration        : %s\n", result.Duration)
+	fmt.Fprintf(&sb, "NPS             : %.0f\n", float64(result.NodesCount)/result.Duration.Seconds())
+	engineOutput.infoString("%s", sb.String())
 }
 
 func displayHandler() {
 	var sb strings.Builder
-	const hLine = " +---+---+---+---+---+---+---+---+---+"
-
-	// board
-	fmt.Fprintf(&sb, "   9   8   7   6   5   4   3   2   1\n%s\n", hLine)
-	for rank := 0; rank < shogi.RANKS; rank++ {
-		fmt.Fprintf(&sb, " |")
-		for file := 0; file < shogi.FILES; file++ {
-			fmt.Fprintf(&sb, "%2s |", enginePosition.Board[9*rank+file])
-		}
-		fmt.Fprintf(&sb, "%c", 'a'+rank)
-		if rank == 0 {
-			if enginePosition.Side == shogi.White {
-				sb.WriteString(" * [")
-			} else {
-				sb.WriteString("   [")
-			}
-			enginePosition.Hands[shogi.White].SfenString(&sb)
-			sb.WriteString("]")
-		}
-		if rank == shogi.RANKS-1 {
-			if enginePosition.Side == shogi.Black {
-				sb.WriteString(" * [")
-			} else {
-				sb.WriteString("   [")
-			}
-			enginePosition.Hands[shogi.Black].SfenString(&sb)
-			sb.WriteString("]")
-		}
-
-		fmt.Fprintf(&sb, "\n%s\n", hLine)
-	}
+	sb.WriteString(render.Render(enginePosition, engineDisplay))
 
 	// other informations
 	fmt.Fprintf(&sb, "\nSfen: %s\n", enginePosition.Sfen())
 	checkers := movegen.Checkers(enginePosition, enginePosition.Side)
 	fmt.Fprintf(&sb, "Checkers: %s\n", checkers)
+	fmt.Fprintf(&sb, "Status: %s\n", enginePosition.Status())
 
-	fmt.Printf("\n%s\n", sb.String())
+	engineOutput.infoString("%s", sb.String())
 }
 
-// applyUsiMove updates Position based on provided USI move string.
-// Move must be valid otherwise returns an error.
-func applyUsiMove(pos *shogi.Position, str string) (shogi.Move, error) {
-	var list movegen.MoveList
-	movegen.GenerateAllMoves(pos, &list)
-	for i := 0; i < list.Count; i++ {
-		m := list.Moves[i]
-		if m.String() == str {
-			pos.DoMove(m)
-			return m, nil
-		}
+// variantCallback switches the engine to a new variant, resetting the position to its starting position.
+func variantCallback(name string) {
+	v, err := shogi.VariantByName(name)
+	if err != nil {
+		return
+	}
+	engineVariant = v
+	enginePosition, _ = v.NewPositionFromSfen(v.StartPos)
+}
+
+// enteringKingRuleCallback sets the rule used to decide games with entering kings.
+func enteringKingRuleCallback(name string) {
+	rule, err := shogi.NewEnteringKingRule(name)
+	if err != nil {
+		return
 	}
-	return shogi.Move(0), fmt.Errorf("invalid move")
+	engineEnteringKingRule = rule
+}
+
+// displayStyleCallback sets the style used by :d to draw the board.
+func displayStyleCallback(name string) {
+	style, err := render.NewStyle(name)
+	if err != nil {
+		return
+	}
+	engineDisplay.Style = style
+}
+
+// displayFlipCallback sets whether :d draws the board seen from White's side.
+func displayFlipCallback(flip bool) {
+	engineDisplay.Flip = flip
 }
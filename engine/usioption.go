@@ -4,6 +4,7 @@ package engine
 
 import (
 	"fmt"
+	"strconv"
 )
 
 type usiOption interface {
@@ -11,26 +12,26 @@ type usiOption interface {
 	set(value string) error
 }
 
-// type checkOption struct {
-// 	callback func(value bool)
-// 	value    bool
-// }
+type checkOption struct {
+	callback func(value bool)
+	value    bool
+}
 
-// func (co checkOption) String() string {
-// 	return fmt.Sprintf("type check default %s", strconv.FormatBool(co.value))
-// }
+func (co checkOption) String() string {
+	return fmt.Sprintf("type check default %s", strconv.FormatBool(co.value))
+}
 
-// func (co checkOption) set(value string) error {
-// 	switch value {
-// 	case "true":
-// 		co.callback(true)
-// 	case "false":
-// 		co.callback(false)
-// 	default:
-// 		return fmt.Errorf("valid values are [true, false]")
-// 	}
-// 	return nil
-// }
+func (co checkOption) set(value string) error {
+	switch value {
+	case "true":
+		co.callback(true)
+	case "false":
+		co.callback(false)
+	default:
+		return fmt.Errorf("valid values are [true, false]")
+	}
+	return nil
+}
 
 type comboOption struct {
 	callback func(value string)
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// usiWriter is the single path for everything the engine sends to the GUI.
+// It serializes writes coming from the USI loop and from the search goroutine
+// and only emits lines allowed by the USI protocol: anything which is not a
+// protocol command is sent as an "info string".
+type usiWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newUsiWriter(w io.Writer) *usiWriter {
+	return &usiWriter{
+		mu: sync.Mutex{},
+		w:  w,
+	}
+}
+
+// send writes a protocol command line, e.g. "readyok" or "bestmove 7g7f".
+func (uw *usiWriter) send(format string, a ...any) {
+	uw.mu.Lock()
+	defer uw.mu.Unlock()
+	fmt.Fprintf(uw.w, format+"\n", a...)
+}
+
+// infoString writes a diagnostic message as one or several "info string" lines.
+// Trailing spaces are trimmed and empty lines dropped as "info string" requires a non empty text.
+func (uw *usiWriter) infoString(format string, a ...any) {
+	text := fmt.Sprintf(format, a...)
+
+	uw.mu.Lock()
+	defer uw.mu.Unlock()
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			continue
+		}
+		fmt.Fprintf(uw.w, "info string %s\n", line)
+	}
+}
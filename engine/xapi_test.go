@@ -0,0 +1,243 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+func startTestAPI(t *testing.T, engine *MatchEngine, maxQueue int) (*API, *httptest.Server) {
+	t.Helper()
+	api, err := NewAPI(APIOptions{
+		Variant:          shogi.Standard,
+		EnteringKingRule: shogi.CSARule27,
+		Engine:           engine,
+		Engines:          1,
+		MaxQueue:         maxQueue,
+		MoveTime:         50 * time.Millisecond,
+		MaxMoveTime:      time.Second,
+		MaxPerftDepth:    3,
+		Perfts:           1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(api)
+	t.Cleanup(func() {
+		server.Close()
+		api.Close()
+	})
+	return api, server
+}
+
+// callAPI sends a request to the API and decodes its response.
+func callAPI(t *testing.T, server *httptest.Server, method, path, body string, response any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	return resp.StatusCode
+}
+
+func TestAPILegalMoves(t *testing.T) {
+	_, server := startTestAPI(t, nil, 0)
+
+	var result apiLegalMoves
+	if status := callAPI(t, server, http.MethodPost, "/legalmoves", `{"sfen": "startpos", "moves": ["7g7f"]}`, &result); status != http.StatusOK {
+		t.Fatalf("unexpected status %d", status)
+	}
+	if result.Side != "white" || result.Status != "ongoing" || len(result.Moves) != 30 || !slices.Contains(result.Moves, "3c3d") {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	testCases := []string{
+		`{"moves": ["7g7e"]}`,
+		`{"sfen": "unknown"}`,
+		`{"position": "startpos"}`,
+		`not json`,
+	}
+	for _, body := range testCases {
+		var e map[string]string
+		if status := callAPI(t, server, http.MethodPost, "/legalmoves", body, &e); status != http.StatusBadRequest || e["error"] == "" {
+			t.Errorf("%s: expected an error, got %d %v", body, status, e)
+		}
+	}
+}
+
+func TestAPIPerft(t *testing.T) {
+	_, server := startTestAPI(t, nil, 0)
+
+	var result apiPerft
+	if status := callAPI(t, server, http.MethodPost, "/perft", `{"depth": 2, "divide": true}`, &result); status != http.StatusOK {
+		t.Fatalf("unexpected status %d", status)
+	}
+	if result.Nodes != 900 || len(result.Divide) != 30 || result.Divide["7g7f"] != 30 {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	var e map[string]string
+	if status := callAPI(t, server, http.MethodPost, "/perft", `{"depth": 4}`, &e); status != http.StatusBadRequest {
+		t.Errorf("expected depth 4 to be refused, got %d %v", status, e)
+	}
+}
+
+func TestAPIAnalyse(t *testing.T) {
+	api, server := startTestAPI(t, nil, 0)
+
+	var result apiAnalysis
+	body := `{"sfen": "startpos", "moves": ["7g7f"], "limits": {"movetime": 100}}`
+	if status := callAPI(t, server, http.MethodPost, "/analyse", body, &result); status != http.StatusOK {
+		t.Fatalf("unexpected status %d", status)
+	}
+	pos, _ := shogi.NewGame(shogi.StartPos).Position()
+	m, _ := shogi.ParseMove(pos, "7g7f")
+	pos.DoMove(m)
+	if _, err := shogi.ParseMove(pos, result.BestMove); err != nil {
+		t.Errorf("unexpected bestmove %+v", result)
+	}
+
+	// all engines busy and no queue
+	uc := <-api.idle
+	var e map[string]string
+	if status := callAPI(t, server, http.MethodPost, "/analyse", body, &e); status != http.StatusServiceUnavailable {
+		t.Errorf("expected the analysis to be refused, got %d %v", status, e)
+	}
+	api.idle <- uc
+
+	var status apiStatus
+	callAPI(t, server, http.MethodGet, "/status", "", &status)
+	if !strings.HasPrefix(status.Engine, "Hifumi") || status.Engines != 1 || status.Busy != 0 || status.Analyses != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+// TestAPIEngineProcess is not a real test: it runs an engine exiting at its first search, unless
+// the marker file given as argument exists, when the test binary is started by TestAPIEngineFailure.
+func TestAPIEngineProcess(t *testing.T) {
+	args := flag.Args()
+	if len(args) != 2 || args[0] != "api-engine" {
+		t.Skip("only run as an engine subprocess")
+	}
+	if _, err := os.Stat(args[1]); err == nil {
+		usiLoop(os.Stdin, os.Stdout)
+		os.Exit(0)
+	}
+	_ = os.WriteFile(args[1], nil, 0o600)
+	r, w := io.Pipe()
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "go") {
+				os.Exit(1)
+			}
+			fmt.Fprintln(w, scanner.Text())
+		}
+		w.Close()
+	}()
+	usiLoop(r, os.Stdout)
+	os.Exit(0)
+}
+
+func TestAPIEngineFailure(t *testing.T) {
+	engine := MatchEngine{
+		Name:    "crashing",
+		Command: []string{os.Args[0], "-test.run=^TestAPIEngineProcess$", "--", "api-engine", filepath.Join(t.TempDir(), "crashed")},
+		Options: nil,
+	}
+	_, server := startTestAPI(t, &engine, 1)
+
+	body := `{"sfen": "startpos", "limits": {"movetime": 100}}`
+	var e map[string]string
+	if status := callAPI(t, server, http.MethodPost, "/analyse", body, &e); status != http.StatusInternalServerError {
+		t.Fatalf("expected the crash to be reported, got %d %v", status, e)
+	}
+	// the next analysis is run by a new engine
+	var result apiAnalysis
+	if status := callAPI(t, server, http.MethodPost, "/analyse", body, &result); status != http.StatusOK {
+		t.Fatalf("unexpected status %d", status)
+	}
+	pos, _ := shogi.NewGame(shogi.StartPos).Position()
+	if _, err := shogi.ParseMove(pos, result.BestMove); err != nil {
+		t.Errorf("unexpected bestmove %+v", result)
+	}
+	var status apiStatus
+	callAPI(t, server, http.MethodGet, "/status", "", &status)
+	if status.Engines != 1 || status.Busy != 0 || status.Analyses != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+// TestAPIStatusDuringReplacement is meant to be run with -race: the status is requested while
+// the failed engine is replaced.
+func TestAPIStatusDuringReplacement(t *testing.T) {
+	engine := MatchEngine{
+		Name:    "crashing",
+		Command: []string{os.Args[0], "-test.run=^TestAPIEngineProcess$", "--", "api-engine", filepath.Join(t.TempDir(), "crashed")},
+		Options: nil,
+	}
+	_, server := startTestAPI(t, &engine, 1)
+
+	done := make(chan struct{})
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			resp, err := server.Client().Get(server.URL + "/status")
+			if err != nil {
+				return
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+
+	var e map[string]string
+	status := callAPI(t, server, http.MethodPost, "/analyse", `{"sfen": "startpos", "limits": {"movetime": 100}}`, &e)
+	close(done)
+	<-polled
+	if status != http.StatusInternalServerError {
+		t.Fatalf("expected the crash to be reported, got %d %v", status, e)
+	}
+	var s apiStatus
+	callAPI(t, server, http.MethodGet, "/status", "", &s)
+	if s.Engine != "crashing" || s.Engines != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestAPIMethod(t *testing.T) {
+	_, server := startTestAPI(t, nil, 0)
+
+	var e map[string]string
+	if status := callAPI(t, server, http.MethodGet, "/analyse", "", &e); status != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status %d", status)
+	}
+}
@@ -0,0 +1,270 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/kifu"
+	"github.com/vinymeuh/hifumi/shogi/movegen"
+)
+
+// fakeCSAServer plays a game against the client, the opponent playing the first legal move.
+type fakeCSAServer struct {
+	t        *testing.T
+	listener net.Listener
+	pos      *shogi.Position
+	moves    []string // CSA moves played, with their time
+}
+
+func newFakeCSAServer(t *testing.T) *fakeCSAServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	pos, _ := shogi.NewGame(shogi.StartPos).Position()
+	return &fakeCSAServer{t: t, listener: listener, pos: pos, moves: nil}
+}
+
+// accept waits for a client and answers its login.
+func (s *fakeCSAServer) accept(login string) *csaConn {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		s.t.Error(err)
+		return nil
+	}
+	c := newCSAConn(conn)
+	s.expect(c, login)
+	_ = c.send("LOGIN:hifumi OK")
+	return c
+}
+
+// expect reads the next line, the empty lines keeping the connection alive being skipped.
+func (s *fakeCSAServer) expect(c *csaConn, expected string) string {
+	for {
+		line, err := c.next(5 * time.Second)
+		if err != nil {
+			s.t.Errorf("expected '%s', got %v", expected, err)
+			return ""
+		}
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, expected) {
+			s.t.Errorf("expected '%s', got '%s'", expected, line)
+		}
+		return line
+	}
+}
+
+// startGame sends the summary of the game, with the moves already played, and starts it.
+func (s *fakeCSAServer) startGame(c *csaConn) {
+	summary := []string{
+		"BEGIN Game_Summary",
+		"Protocol_Version:1.2",
+		"Format:Shogi 1.0",
+		"Game_ID:test-game",
+		"Name+:hifumi",
+		"Name-:fake",
+		"Your_Turn:+",
+		"To_Move:+",
+		"Max_Moves:256",
+		"Reconnect_Token:secret",
+		"BEGIN Time",
+		"Time_Unit:1msec",
+		"Total_Time:0",
+		"Byoyomi:40",
+		"END Time",
+		"BEGIN Position",
+		"PI",
+		"+",
+	}
+	summary = append(summary, s.moves...)
+	summary = append(summary, "END Position", "END Game_Summary")
+	for _, line := range summary {
+		_ = c.send("%s", line)
+	}
+	if len(s.moves) == 0 { // a resumed game starts without agreement
+		s.expect(c, "AGREE test-game")
+	}
+	_ = c.send("START:test-game")
+}
+
+// play reads a move of the client and echoes it.
+func (s *fakeCSAServer) play(c *csaConn) {
+	line := s.expect(c, "+")
+	m, err := kifu.ParseCSAMove(s.pos, line)
+	if err != nil {
+		s.t.Errorf("invalid move from the client: %v", err)
+		return
+	}
+	s.echo(c, m)
+}
+
+// reply plays the first legal move of the opponent.
+func (s *fakeCSAServer) reply(c *csaConn) {
+	s.echo(c, movegen.LegalMoves(s.pos)[0])
+}
+
+func (s *fakeCSAServer) echo(c *csaConn, m shogi.Move) {
+	move := kifu.CSAMoveString(s.pos, m) + ",T1"
+	s.pos.DoMove(m)
+	s.moves = append(s.moves, move)
+	_ = c.send("%s", move)
+}
+
+// resign makes the opponent resign and ends the session.
+func (s *fakeCSAServer) resign(c *csaConn) {
+	for _, line := range []string{"%TORYO,T1", "#RESIGN", "#WIN"} {
+		_ = c.send("%s", line)
+	}
+	s.expect(c, "LOGOUT")
+	_ = c.send("LOGOUT:completed")
+	c.close()
+}
+
+func testCSAClientOptions(address string) CSAClientOptions {
+	return CSAClientOptions{
+		Address:        address,
+		Name:           "hifumi",
+		Password:       "password",
+		Engine:         nil,
+		Games:          1,
+		Margin:         10 * time.Millisecond,
+		MoveTime:       0,
+		Reconnects:     1,
+		ReconnectDelay: time.Millisecond,
+		KeepAlive:      0,
+		Records:        "",
+	}
+}
+
+func TestCSAClient(t *testing.T) {
+	server := newFakeCSAServer(t)
+	go func() {
+		c := server.accept("LOGIN hifumi password")
+		if c == nil {
+			return
+		}
+		server.startGame(c)
+		for i := 0; i < 3; i++ {
+			server.play(c)
+			server.reply(c)
+		}
+		server.play(c)
+		server.resign(c)
+	}()
+
+	records := t.TempDir()
+	opts := testCSAClientOptions(server.listener.Addr().String())
+	opts.Records = records
+	var out bytes.Buffer
+	if err := CSAClient(&out, opts); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "Game test-game over: #RESIGN #WIN") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+
+	f, err := os.Open(filepath.Join(records, "test-game.csa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	g, err := kifu.ReadCSA(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := g.Result(); len(g.MainLine()) != 7 || r.Winner != shogi.Black || r.Reason != shogi.SpecialResign {
+		t.Errorf("unexpected record: %d moves, %v\n%s", len(g.MainLine()), r, out.String())
+	}
+	if g.Header.Players != [shogi.COLORS]string{"hifumi", "fake"} {
+		t.Errorf("unexpected players %v", g.Header.Players)
+	}
+}
+
+func TestCSAClientReconnect(t *testing.T) {
+	server := newFakeCSAServer(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c := server.accept("LOGIN hifumi password")
+		if c == nil {
+			return
+		}
+		server.startGame(c)
+		server.play(c)
+		c.close() // connection lost while the client waits for the reply
+
+		c = server.accept("LOGIN hifumi password reconnect:test-game+secret")
+		if c == nil {
+			return
+		}
+		server.startGame(c)
+		server.reply(c)
+		server.play(c)
+		server.resign(c)
+	}()
+
+	var out bytes.Buffer
+	if err := CSAClient(&out, testCSAClientOptions(server.listener.Addr().String())); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, out.String())
+	}
+	for _, expected := range []string{"reconnecting", "Game test-game resumed", "Game test-game over: #RESIGN #WIN"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected '%s' in output:\n%s", expected, out.String())
+		}
+	}
+	<-done
+	if len(server.moves) != 3 {
+		t.Errorf("expected 3 moves, got %v", server.moves)
+	}
+}
+
+func TestReadCSAGameSummary(t *testing.T) {
+	lines := strings.Split(`Protocol_Version:1.2
+Game_ID:wdoor+floodgate-300-10F+a+b+20230101000000
+Name+:a
+Name-:b
+Your_Turn:-
+To_Move:+
+Declaration:Jishogi 1.1
+BEGIN Time
+Time_Unit:1sec
+Total_Time:300
+Increment:10
+END Time
+BEGIN Position
+PI
++
++7776FU,T12
+END Position
+END Game_Summary`, "\n")
+	s, err := readCSAGameSummary(func() (string, error) {
+		if len(lines) == 0 {
+			return "", fmt.Errorf("unexpected end")
+		}
+		line := lines[0]
+		lines = lines[1:]
+		return line, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.YourTurn != shogi.White || s.EnteringKingRule() != shogi.CSARule27 ||
+		s.TimeControl != (shogi.TimeControl{Main: 300 * time.Second, Byoyomi: 0, Increment: 10 * time.Second}) {
+		t.Errorf("unexpected summary %+v", s)
+	}
+	if moves := s.Game.MainLine(); len(moves) != 1 || moves[0].String() != "7g7f" || s.Game.Moves[0].Time != 12*time.Second {
+		t.Errorf("unexpected moves %v", moves)
+	}
+}
@@ -0,0 +1,266 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/kifu"
+)
+
+func startTestCSAServer(t *testing.T, opts CSAServerOptions) *CSAServer {
+	t.Helper()
+	opts.Address = "127.0.0.1:0"
+	server, err := NewCSAServer(&bytes.Buffer{}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = server.Serve() }()
+	t.Cleanup(func() { _ = server.Close() })
+	return server
+}
+
+func testCSAServerOptions() CSAServerOptions {
+	return CSAServerOptions{
+		Address:          "",
+		Password:         "",
+		TimeUnit:         time.Millisecond,
+		TimeControl:      shogi.TimeControl{Main: 0, Byoyomi: 300 * time.Millisecond, Increment: 0},
+		LeastTimePerMove: 0,
+		MaxMoves:         0,
+		Declaration:      true,
+		Records:          "",
+	}
+}
+
+// loginCSA connects to a server and logs in.
+func loginCSA(t *testing.T, server *CSAServer, login, password string) (*csaConn, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newCSAConn(conn)
+	t.Cleanup(c.close)
+	if err := c.send("LOGIN %s %s", login, password); err != nil {
+		t.Fatal(err)
+	}
+	return c, nextCSALine(t, c)
+}
+
+// nextCSALine returns the next line received, without the time of the moves.
+func nextCSALine(t *testing.T, c *csaConn) string {
+	t.Helper()
+	line, err := c.next(5 * time.Second)
+	if err != nil {
+		t.Fatalf("no line received: %v", err)
+	}
+	statement, _, _ := strings.Cut(line, ",T")
+	return statement
+}
+
+// expectCSALines checks the next lines received by a player.
+func expectCSALines(t *testing.T, c *csaConn, expected ...string) {
+	t.Helper()
+	for _, e := range expected {
+		if line := nextCSALine(t, c); line != e {
+			t.Fatalf("expected '%s', got '%s'", e, line)
+		}
+	}
+}
+
+// startCSAGame logs in two players with a game name and starts their game, returning their
+// connections by color and the summary of the game.
+func startCSAGame(t *testing.T, server *CSAServer, game string) ([shogi.COLORS]*csaConn, *CSAGameSummary) {
+	t.Helper()
+	var logins []*csaConn
+	for _, name := range []string{"alice", "bob"} {
+		c, line := loginCSA(t, server, name+"+"+game, "password")
+		if line != "LOGIN:"+name+"+"+game+" OK" {
+			t.Fatalf("login refused: %s", line)
+		}
+		logins = append(logins, c)
+	}
+	var players [shogi.COLORS]*csaConn
+	var summary *CSAGameSummary
+	for _, c := range logins {
+		expectCSALines(t, c, "BEGIN Game_Summary")
+		s, err := readCSAGameSummary(func() (string, error) { return c.next(5 * time.Second) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		players[s.YourTurn], summary = c, s
+	}
+	if players[shogi.Black] == nil || players[shogi.White] == nil || !strings.HasPrefix(summary.GameID, game+"+") {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	for _, c := range players {
+		_ = c.send("AGREE %s", summary.GameID)
+	}
+	for _, c := range players {
+		expectCSALines(t, c, "START:"+summary.GameID)
+	}
+	return players, summary
+}
+
+// playCSAMoves plays moves alternately, checking that both players receive them.
+func playCSAMoves(t *testing.T, players [shogi.COLORS]*csaConn, moves ...string) {
+	t.Helper()
+	for _, m := range moves {
+		side := shogi.Black
+		if m[0] == '-' {
+			side = shogi.White
+		}
+		_ = players[side].send("%s", m)
+		for _, c := range players {
+			expectCSALines(t, c, m)
+		}
+	}
+}
+
+func TestCSAServerLogin(t *testing.T) {
+	opts := testCSAServerOptions()
+	opts.Password = "secret"
+	server := startTestCSAServer(t, opts)
+
+	if _, line := loginCSA(t, server, "alice", "wrong"); line != "LOGIN:incorrect" {
+		t.Errorf("expected a wrong password to be refused, got '%s'", line)
+	}
+	c, line := loginCSA(t, server, "alice", "secret")
+	if line != "LOGIN:alice OK" {
+		t.Fatalf("unexpected login answer '%s'", line)
+	}
+	if _, line := loginCSA(t, server, "alice", "secret"); line != "LOGIN:incorrect" {
+		t.Errorf("expected a second login to be refused, got '%s'", line)
+	}
+	_ = c.send("LOGOUT")
+	expectCSALines(t, c, "LOGOUT:completed")
+}
+
+func TestCSAServerGames(t *testing.T) {
+	records := t.TempDir()
+	opts := testCSAServerOptions()
+	opts.Records = records
+	server := startTestCSAServer(t, opts)
+
+	testCases := []struct {
+		name     string
+		moves    []string
+		last     [2]string // last line sent by each player
+		expected []string  // lines received by both players
+		results  [shogi.COLORS]string
+		reason   shogi.SpecialMove
+	}{
+		{"resign", []string{"+7776FU"}, [2]string{"", "%TORYO"}, []string{"%TORYO", "#RESIGN"},
+			[shogi.COLORS]string{"#WIN", "#LOSE"}, shogi.SpecialResign},
+		{"illegal", []string{"+7776FU", "-3334FU"}, [2]string{"+7674FU", ""}, []string{"#ILLEGAL_MOVE"},
+			[shogi.COLORS]string{"#LOSE", "#WIN"}, shogi.SpecialIllegalMove},
+		{"wrong sign", nil, [2]string{"-7776FU", ""}, []string{"#ILLEGAL_MOVE"},
+			[shogi.COLORS]string{"#LOSE", "#WIN"}, shogi.SpecialIllegalMove},
+		{"declaration", []string{"+7776FU"}, [2]string{"", "%KACHI"}, []string{"%KACHI", "#ILLEGAL_MOVE"},
+			[shogi.COLORS]string{"#WIN", "#LOSE"}, shogi.SpecialIllegalMove},
+		{"time up", []string{"+7776FU"}, [2]string{"", ""}, []string{"#TIME_UP"},
+			[shogi.COLORS]string{"#WIN", "#LOSE"}, shogi.SpecialTimeUp},
+		{"sennichite", []string{
+			"+2838HI", "-8272HI", "+3828HI", "-7282HI",
+			"+2838HI", "-8272HI", "+3828HI", "-7282HI",
+			"+2838HI", "-8272HI", "+3828HI", "-7282HI",
+		}, [2]string{"", ""}, []string{"#SENNICHITE"}, [shogi.COLORS]string{"#DRAW", "#DRAW"}, shogi.SpecialSennichite},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			players, summary := startCSAGame(t, server, strings.ReplaceAll(tc.name, " ", "-"))
+			playCSAMoves(t, players, tc.moves...)
+			for c, line := range tc.last {
+				if line != "" {
+					_ = players[c].send("%s", line)
+				}
+			}
+			for c, p := range players {
+				expectCSALines(t, p, append(tc.expected, tc.results[c])...)
+			}
+
+			for _, p := range players {
+				_ = p.send("LOGOUT")
+				expectCSALines(t, p, "LOGOUT:completed")
+			}
+
+			f, err := os.Open(filepath.Join(records, summary.GameID+".csa"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			g, err := kifu.ReadCSA(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r := g.Result(); r.Reason != tc.reason || len(g.MainLine()) != len(tc.moves) {
+				t.Errorf("unexpected record: %v after %d moves", r, len(g.MainLine()))
+			}
+		})
+	}
+}
+
+func TestCSAServerReject(t *testing.T) {
+	server := startTestCSAServer(t, testCSAServerOptions())
+	var players [shogi.COLORS]*csaConn
+	for i, name := range []string{"alice", "bob"} {
+		players[i], _ = loginCSA(t, server, name, "password")
+	}
+	var id string
+	for _, c := range players {
+		expectCSALines(t, c, "BEGIN Game_Summary")
+		s, err := readCSAGameSummary(func() (string, error) { return c.next(5 * time.Second) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		id = s.GameID
+	}
+	_ = players[0].send("AGREE %s", id)
+	_ = players[1].send("REJECT %s", id)
+	for _, c := range players {
+		if line := nextCSALine(t, c); line != "REJECT:"+id+" by alice" && line != "REJECT:"+id+" by bob" {
+			t.Errorf("unexpected line '%s'", line)
+		}
+	}
+}
+
+func TestCSAServerClients(t *testing.T) {
+	opts := testCSAServerOptions()
+	opts.MaxMoves = 4
+	server := startTestCSAServer(t, opts)
+
+	var wg sync.WaitGroup
+	outputs := make([]bytes.Buffer, 2)
+	errs := make([]error, 2)
+	for i, name := range []string{"first", "second"} {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			e := testMatchEngine(name)
+			clientOpts := testCSAClientOptions(server.Addr().String())
+			clientOpts.Name = name
+			clientOpts.Engine = &e
+			clientOpts.Margin = 100 * time.Millisecond
+			clientOpts.Games = 2
+			errs[i] = CSAClient(&outputs[i], clientOpts)
+		}(i, name)
+	}
+	wg.Wait()
+	for i := range errs {
+		if errs[i] != nil {
+			t.Fatalf("unexpected error: %v\n%s", errs[i], outputs[i].String())
+		}
+		if n := strings.Count(outputs[i].String(), "over: #MAX_MOVES #CENSORED"); n != 2 {
+			t.Errorf("unexpected output:\n%s", outputs[i].String())
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// TestUsiEngineProcess is not a real test: it runs the USI loop when the test binary
+// is started as an engine by testMatchEngine.
+func TestUsiEngineProcess(t *testing.T) {
+	if !slices.Contains(flag.Args(), "usi-engine") {
+		t.Skip("only run as an engine subprocess")
+	}
+	usiLoop(os.Stdin, os.Stdout)
+	os.Exit(0)
+}
+
+func testMatchEngine(name string) MatchEngine {
+	return MatchEngine{
+		Name:    name,
+		Command: []string{os.Args[0], "-test.run=^TestUsiEngineProcess$", "--", "usi-engine"},
+		Options: nil,
+	}
+}
+
+func TestMatch(t *testing.T) {
+	opening := shogi.NewGame(shogi.StartPos)
+	for _, m := range []string{"7g7f", "3c3d"} {
+		pos, _ := opening.Position()
+		for _, played := range opening.MainLine() {
+			pos.DoMove(played)
+		}
+		move, err := shogi.ParseMove(pos, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opening.AddMove(move, 0)
+	}
+
+	records := t.TempDir()
+	var out bytes.Buffer
+	result, err := Match(&out, MatchOptions{
+		GameSettings: GameSettings{
+			Variant:          shogi.Standard,
+			EnteringKingRule: shogi.CSARule27,
+			TimeControl:      shogi.TimeControl{Main: 0, Byoyomi: 20 * time.Millisecond, Increment: 0},
+			MoveTime:         0,
+			Openings:         []*shogi.Game{opening},
+			MaxMoves:         16,
+			Event:            "test",
+			Records:          records,
+		},
+		Engines: [2]MatchEngine{testMatchEngine("first"), testMatchEngine("second")},
+		Games:   2,
+		SPRT:    nil,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, out.String())
+	}
+
+	if len(result.Games) != 2 {
+		t.Fatalf("expected 2 games, got %d", len(result.Games))
+	}
+	for n, g := range result.Games {
+		if moves := g.MainLine(); len(moves) < 2 || moves[0].String() != "7g7f" || moves[1].String() != "3c3d" {
+			t.Errorf("game %d doesn't start with the opening: %v", n, moves)
+		}
+		if !g.IsOver() || len(g.Moves) > 19 {
+			t.Errorf("game %d not adjudicated after 16 moves following the opening: %d nodes", n, len(g.Moves))
+		}
+		if _, err := os.Stat(filepath.Join(records, []string{"0001.kif", "0002.kif"}[n])); err != nil {
+			t.Errorf("game %d not recorded: %v", n, err)
+		}
+	}
+	if p := result.Games[0].Header.Players; p != [shogi.COLORS]string{"first", "second"} {
+		t.Errorf("unexpected players of the first game %v", p)
+	}
+	if p := result.Games[1].Header.Players; p != [shogi.COLORS]string{"second", "first"} {
+		t.Errorf("colors not swapped in the second game %v", p)
+	}
+
+	wins, losses, draws := result.Record()
+	if wins+losses+draws != 2 {
+		t.Errorf("unexpected record %d - %d - %d", wins, losses, draws)
+	}
+	for _, expected := range []string{"Finished game 1 (first vs second): ", "Finished game 2 (second vs first): ", "Score of first vs second: "} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("missing %q in:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestMatchImpasse(t *testing.T) {
+	// both kings have entered, Black can declare a win
+	opening := shogi.NewGame("+R+BGGSS+N+N+L/4K3L/9/9/9/9/9/9/4k4 b RB 1")
+	var out bytes.Buffer
+	result, err := Match(&out, MatchOptions{
+		GameSettings: GameSettings{
+			Variant:          shogi.Standard,
+			EnteringKingRule: shogi.CSARule27,
+			TimeControl:      shogi.TimeControl{Main: 0, Byoyomi: 0, Increment: 0},
+			MoveTime:         10 * time.Millisecond,
+			Openings:         []*shogi.Game{opening},
+			MaxMoves:         0,
+			Event:            "",
+			Records:          "",
+		},
+		Engines: [2]MatchEngine{testMatchEngine("first"), testMatchEngine("second")},
+		Games:   2,
+		SPRT:    nil,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, out.String())
+	}
+	for n, g := range result.Games {
+		// the runner declares without asking the engine, the declaration taking no time
+		if len(g.Moves) != 1 || g.Moves[0].Special != shogi.SpecialKachi || g.Moves[0].Time != 0 || g.Result().Winner != shogi.Black {
+			t.Errorf("game %d not adjudicated as a declaration win: %v", n, g.Moves)
+		}
+	}
+	if wins, losses, draws := result.Record(); wins != 1 || losses != 1 || draws != 0 {
+		t.Errorf("unexpected record %d - %d - %d", wins, losses, draws)
+	}
+}
+
+func TestMatchResultScore(t *testing.T) {
+	resign := shogi.NewGame(shogi.StartPos)
+	resign.End(shogi.SpecialResign, 0) // Black resigns
+	draw := shogi.NewGame(shogi.StartPos)
+	draw.End(shogi.SpecialHikiwake, 0)
+	r := MatchResult{Names: [2]string{"a", "b"}, Games: []*shogi.Game{resign, resign, draw}}
+
+	for n, expected := range []float64{0, 1, 0.5} {
+		if score := r.Score(n); score != expected {
+			t.Errorf("game %d: expected score %v, got %v", n, expected, score)
+		}
+	}
+	if wins, losses, draws := r.Record(); wins != 1 || losses != 1 || draws != 1 {
+		t.Errorf("unexpected record %d - %d - %d", wins, losses, draws)
+	}
+}
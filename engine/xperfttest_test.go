@@ -0,0 +1,25 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"testing"
+)
+
+func TestPerfttestErrors(t *testing.T) {
+	tests := []struct {
+		variant  string
+		startpos string
+		err      string
+	}{
+		{variant: "chess", startpos: "startpos", err: "unknown variant 'chess'"},
+		{variant: "minishogi", startpos: "lance", err: "handicaps exist only for standard shogi"},
+		{variant: "shogi", startpos: "9/9/9/9/9/9/9/9/9 b - 1", err: "black has 0 kings on the board\nwhite has 0 kings on the board"},
+		{variant: "shogi", startpos: "unknown", err: "SFEN string must have between 3 and 4 parts"},
+	}
+	for _, tc := range tests {
+		if err := Perfttest(tc.variant, tc.startpos, 1); err == nil || err.Error() != tc.err {
+			t.Errorf("%s %s: expected error '%s', got '%v'", tc.variant, tc.startpos, tc.err, err)
+		}
+	}
+}
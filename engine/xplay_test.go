@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/render"
+)
+
+func newPlayOptions(tc shogi.TimeControl) PlayOptions {
+	g := shogi.NewGame(shogi.StartPos)
+	g.Header.TimeControl = tc
+	return PlayOptions{
+		Variant:          shogi.Standard,
+		Game:             g,
+		EnteringKingRule: shogi.CSARule27,
+		Human:            shogi.Black,
+		MoveTime:         10 * time.Millisecond,
+		Display:          render.Options{Style: render.Kanji, Flip: false},
+	}
+}
+
+func TestPlay(t *testing.T) {
+	opts := newPlayOptions(shogi.TimeControl{Main: 0, Byoyomi: 0, Increment: 0})
+	in := strings.NewReader("7g7f\nundo\n７六歩\n7g7e\ntime 1m 10s\nresign\n7g7f\nquit\n")
+	var out bytes.Buffer
+	if err := Play(in, &out, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output := out.String()
+	if n := strings.Count(output, "▲７六歩 (7g7f)\n"); n != 2 {
+		t.Errorf("expected 2 moves 7g7f, got %d in:\n%s", n, output)
+	}
+	for _, expected := range []string{
+		"|v香v桂v銀v金v玉v金v銀v桂v香|一\n",
+		"Thinking...\n△",
+		"illegal move 7g7e: no piece on 7g\n",
+		"☗ 1:00 ☖ 1:00 (byoyomi 10s)\n",
+		"Game over, the engine wins (TORYO).\n",
+		"The game is over, undo or quit.\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("missing %q in:\n%s", expected, output)
+		}
+	}
+
+	g := opts.Game
+	if len(g.Moves) != 3 || g.Moves[0].Move.String() != "7g7f" || g.Moves[2].Special != shogi.SpecialResign {
+		t.Errorf("unexpected game %v", g.Moves)
+	}
+	if g.Header.TimeControl.Byoyomi != 10*time.Second {
+		t.Errorf("time control not recorded")
+	}
+}
+
+func TestPlayTimeUp(t *testing.T) {
+	opts := newPlayOptions(shogi.TimeControl{Main: time.Nanosecond, Byoyomi: 0, Increment: 0})
+	opts.Human = shogi.White
+	in := strings.NewReader("3c3d\nquit\n")
+	var out bytes.Buffer
+	if err := Play(in, &out, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "Game over, you win (TIME_UP).\n") {
+		t.Errorf("expected the engine to lose on time:\n%s", out.String())
+	}
+	if result := opts.Game.Result(); result.Winner != shogi.White || len(opts.Game.MainLine()) != 0 {
+		t.Errorf("unexpected result %v", result)
+	}
+}
+
+func TestPlayUndoClock(t *testing.T) {
+	opts := newPlayOptions(shogi.TimeControl{Main: 5 * time.Minute, Byoyomi: 0, Increment: 0})
+	pos, _ := shogi.NewPositionFromSfen(shogi.StartPos)
+	for i, move := range []string{"7g7f", "3c3d", "2g2f", "8c8d"} {
+		m, _ := shogi.ParseMove(pos, move)
+		pos.DoMove(m)
+		opts.Game.AddMove(m, time.Duration(i%2+1)*time.Minute)
+	}
+	in := strings.NewReader("undo\nquit\n")
+	var out bytes.Buffer
+	if err := Play(in, &out, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the moves of Black are played in 1 minute and those of White in 2 minutes
+	for _, expected := range []string{"☗ 3:00 ☖ 1:00\n", "☗ 4:00 ☖ 3:00\n"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("missing %q in:\n%s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"math"
+	"testing"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 0.01
+}
+
+func TestEloScore(t *testing.T) {
+	for _, tc := range []struct{ score, elo float64 }{
+		{0.5, 0}, {0.75, 190.85}, {0.25, -190.85}, {0.6, 70.44},
+	} {
+		if elo := ScoreToElo(tc.score); !almostEqual(elo, tc.elo) {
+			t.Errorf("score %v: expected %v Elo, got %v", tc.score, tc.elo, elo)
+		}
+		if score := EloToScore(tc.elo); !almostEqual(score, tc.score) {
+			t.Errorf("elo %v: expected score %v, got %v", tc.elo, tc.score, score)
+		}
+	}
+	if !math.IsInf(ScoreToElo(1), 1) || !math.IsInf(ScoreToElo(0), -1) {
+		t.Errorf("expected infinite Elo differences for the extreme scores")
+	}
+}
+
+func TestLOS(t *testing.T) {
+	for _, tc := range []struct {
+		wins, losses int
+		los          float64
+	}{
+		{0, 0, 0.5}, {10, 10, 0.5}, {60, 40, 0.977}, {40, 60, 0.023},
+	} {
+		if los := LOS(tc.wins, tc.losses); !almostEqual(los, tc.los) {
+			t.Errorf("%d - %d: expected LOS %v, got %v", tc.wins, tc.losses, tc.los, los)
+		}
+	}
+}
+
+func TestPentanomial(t *testing.T) {
+	win, loss, draw := shogi.NewGame(shogi.StartPos), shogi.NewGame(shogi.StartPos), shogi.NewGame(shogi.StartPos)
+	win.End(shogi.SpecialTsumi, 0)  // Black checkmated
+	loss.End(shogi.SpecialKachi, 0) // Black wins
+	draw.End(shogi.SpecialHikiwake, 0)
+	r := MatchResult{
+		Names:    [2]string{"a", "b"},
+		Games:    []*shogi.Game{loss, win, loss, draw, draw, draw, win, draw, loss},
+		Decision: SPRTContinue,
+	}
+	// pairs scores of the first engine: 2, 1.5, 1, 0.5, and an unpaired game
+	if p := r.Pentanomial(); p != (Pentanomial{0, 1, 1, 1, 1}) {
+		t.Errorf("unexpected pentanomial %v", p)
+	}
+
+	elo, margin := Pentanomial{0, 0, 10, 0, 0}.Elo()
+	if !almostEqual(elo, 0) || margin > 5 {
+		t.Errorf("expected no Elo difference from draws, got %v +/- %v", elo, margin)
+	}
+	elo, margin = Pentanomial{5, 20, 40, 25, 10}.Elo()
+	if !almostEqual(elo, 26.11) || !almostEqual(margin, 34.83) {
+		t.Errorf("unexpected Elo %v +/- %v", elo, margin)
+	}
+	// an engine scoring all the points
+	for _, p := range []Pentanomial{{0, 0, 0, 0, 10000}, {1000, 0, 0, 0, 0}} {
+		if elo, margin := p.Elo(); math.IsInf(elo, 0) || math.IsNaN(elo) || math.IsNaN(margin) {
+			t.Errorf("%v: unexpected Elo %v +/- %v", p, elo, margin)
+		}
+	}
+}
+
+func TestSPRT(t *testing.T) {
+	if _, err := NewSPRT(5, 0, 0.05, 0.05); err == nil {
+		t.Errorf("expected error for elo0 > elo1")
+	}
+	if _, err := NewSPRT(0, 5, 0, 0.05); err == nil {
+		t.Errorf("expected error for alpha = 0")
+	}
+
+	sprt, err := NewSPRT(0, 5, 0.05, 0.05)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lower, upper := sprt.Bounds()
+	if !almostEqual(lower, -2.94) || !almostEqual(upper, 2.94) {
+		t.Errorf("unexpected bounds [%v, %v]", lower, upper)
+	}
+
+	for _, tc := range []struct {
+		penta    Pentanomial
+		decision SPRTDecision
+	}{
+		{Pentanomial{0, 0, 0, 0, 0}, SPRTContinue},
+		{Pentanomial{10, 20, 40, 20, 10}, SPRTContinue},
+		{Pentanomial{300, 1200, 3000, 1200, 300}, SPRTAcceptH0},
+		{Pentanomial{80, 380, 1000, 420, 120}, SPRTAcceptH1},
+		// one-sided results
+		{Pentanomial{0, 0, 0, 0, 10000}, SPRTAcceptH1},
+		{Pentanomial{1000, 0, 0, 0, 0}, SPRTAcceptH0},
+		{Pentanomial{0, 0, 0, 0, 1}, SPRTContinue},
+		{Pentanomial{0, 0, 0, 0, 20}, SPRTAcceptH1},
+		{Pentanomial{0, 0, 100, 0, 0}, SPRTAcceptH0},
+	} {
+		if decision := sprt.Decision(tc.penta); decision != tc.decision {
+			t.Errorf("%v: expected %s, got %s (llr %.2f)", tc.penta, tc.decision, decision, sprt.LLR(tc.penta))
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+func TestSchedules(t *testing.T) {
+	for _, name := range Schedules() {
+		s, err := NewSchedule(name)
+		if err != nil || s.String() != name {
+			t.Errorf("schedule %s: got %s, %v", name, s, err)
+		}
+	}
+	if _, err := NewSchedule("swiss"); err == nil {
+		t.Errorf("expected error for an unknown schedule")
+	}
+
+	if p := RoundRobin.pairings(3); !slices.Equal(p, [][2]int{{0, 1}, {0, 2}, {1, 2}}) {
+		t.Errorf("unexpected round robin pairings %v", p)
+	}
+	if p := Gauntlet.pairings(3); !slices.Equal(p, [][2]int{{0, 1}, {0, 2}}) {
+		t.Errorf("unexpected gauntlet pairings %v", p)
+	}
+}
+
+func TestTournamentRatings(t *testing.T) {
+	blackWins, whiteWins := shogi.NewGame(shogi.StartPos), shogi.NewGame(shogi.StartPos)
+	blackWins.End(shogi.SpecialKachi, 0)
+	whiteWins.End(shogi.SpecialTsumi, 0)
+	r := TournamentResult{
+		Names: []string{"a", "b", "c"},
+		Games: []TournamentGame{ // a scores 3/4 against b, c doesn't play
+			{Players: [shogi.COLORS]int{0, 1}, Game: blackWins},
+			{Players: [shogi.COLORS]int{1, 0}, Game: whiteWins},
+			{Players: [shogi.COLORS]int{0, 1}, Game: blackWins},
+			{Players: [shogi.COLORS]int{1, 0}, Game: blackWins},
+		},
+	}
+
+	points, games := r.Crosstable()
+	if points[0][1] != 3 || points[1][0] != 1 || games[0][1] != 4 || games[0][2] != 0 {
+		t.Errorf("unexpected crosstable %v %v", points, games)
+	}
+	// with the virtual draw, a scores 3.5/5 against b: 147.2 Elo
+	ratings := r.Ratings()
+	if !almostEqual(ratings[0]-ratings[1], 147.19) || !almostEqual(ratings[0]+ratings[1]+ratings[2], 0) {
+		t.Errorf("unexpected ratings %v", ratings)
+	}
+
+	var out bytes.Buffer
+	r.WriteCrosstable(&out)
+	expected := `Rank Name  Elo  Points  Games  Score        1       2       3
+   1 a     +74     3.0      4  75.0%        -           3.0/4
+   2 c      +0     0.0      0   0.0%                -
+   3 b     -74     1.0      4  25.0%    1.0/4               -
+`
+	if out.String() != expected {
+		t.Errorf("expected crosstable:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestTournament(t *testing.T) {
+	var out bytes.Buffer
+	result, err := Tournament(&out, TournamentOptions{
+		GameSettings: GameSettings{
+			Variant:          shogi.Minishogi,
+			EnteringKingRule: shogi.NoEnteringKing,
+			TimeControl:      shogi.TimeControl{Main: 0, Byoyomi: 0, Increment: 0},
+			MoveTime:         2 * time.Millisecond,
+			Openings:         nil,
+			MaxMoves:         10,
+			Event:            "",
+			Records:          "",
+		},
+		Engines:     []MatchEngine{testMatchEngine("a"), testMatchEngine("b"), testMatchEngine("c")},
+		Schedule:    RoundRobin,
+		Rounds:      1,
+		Concurrency: 2,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, out.String())
+	}
+
+	if len(result.Games) != 6 {
+		t.Fatalf("expected 6 games, got %d", len(result.Games))
+	}
+	_, games := result.Crosstable()
+	for i := range games {
+		for j := range games[i] {
+			if i != j && games[i][j] != 2 {
+				t.Errorf("expected 2 games between %d and %d, got %d", i, j, games[i][j])
+			}
+		}
+	}
+	for n, tg := range result.Games {
+		g := tg.Game
+		if g.Header.Players[shogi.Black] != result.Names[tg.Players[shogi.Black]] || !g.IsOver() {
+			t.Errorf("unexpected game %d: %v", n, g.Header.Players)
+		}
+	}
+	if n := strings.Count(out.String(), "Finished game "); n != 6 {
+		t.Errorf("expected 6 results, got %d in:\n%s", n, out.String())
+	}
+}
+
+func TestTournamentWorker(t *testing.T) {
+	opts := TournamentOptions{
+		GameSettings: GameSettings{
+			Variant:          shogi.Minishogi,
+			EnteringKingRule: shogi.NoEnteringKing,
+			TimeControl:      shogi.TimeControl{Main: 0, Byoyomi: 0, Increment: 0},
+			MoveTime:         0,
+			Openings:         nil,
+			MaxMoves:         0,
+			Event:            "",
+			Records:          "",
+		},
+		Engines:     []MatchEngine{testMatchEngine("a"), testMatchEngine("b"), testMatchEngine("c")},
+		Schedule:    RoundRobin,
+		Rounds:      1,
+		Concurrency: 1,
+	}
+	w := &tournamentWorker{opts: &opts, processes: make(map[int]*usiProcess)}
+	defer w.close()
+
+	first, err := w.players([shogi.COLORS]int{0, 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := w.players([shogi.COLORS]int{1, 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(w.processes) != 2 || second[shogi.Black] != first[shogi.White] || second[shogi.White].name != "c" {
+		t.Errorf("expected the process of b to be kept and the one of a to be replaced by c, got %v", w.processes)
+	}
+}
@@ -0,0 +1,552 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// ================================== //
+// ====== USI grammar validator ===== //
+// ================================== //
+
+// usiMoveRegexp matches the moves of all variants: promotions, demotions and drops of promoted pieces
+// of Kyoto shogi included.
+var usiMoveRegexp = regexp.MustCompile(`^([1-9][a-i][1-9][a-i][+-]?|\+?[PLNSGBR]\*[1-9][a-i])$`)
+
+func isUsiMove(s string) bool {
+	return usiMoveRegexp.MatchString(s)
+}
+
+func isInteger(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// validateUsiLine checks that a line sent by the engine is a valid USI engine-to-GUI command.
+func validateUsiLine(line string) error {
+	tokens := strings.Fields(line)
+	if len(tokens) == 0 {
+		return fmt.Errorf("empty line")
+	}
+	if line != strings.TrimSpace(line) {
+		return fmt.Errorf("leading or trailing spaces")
+	}
+
+	switch tokens[0] {
+	case "usiok", "readyok":
+		if len(tokens) != 1 {
+			return fmt.Errorf("%s takes no argument", tokens[0])
+		}
+	case "id":
+		if len(tokens) < 3 || (tokens[1] != "name" && tokens[1] != "author") {
+			return fmt.Errorf("expected 'id name <x>' or 'id author <x>'")
+		}
+	case "option":
+		return validateUsiOption(tokens)
+	case "bestmove":
+		return validateUsiBestmove(tokens)
+	case "checkmate":
+		if len(tokens) < 2 {
+			return fmt.Errorf("checkmate requires an argument")
+		}
+	case "info":
+		return validateUsiInfo(tokens)
+	default:
+		return fmt.Errorf("unknown command %q", tokens[0])
+	}
+	return nil
+}
+
+func validateUsiOption(tokens []string) error {
+	if len(tokens) < 5 || tokens[1] != "name" || tokens[3] != "type" {
+		return fmt.Errorf("expected 'option name <id> type <t> ...'")
+	}
+	switch tokens[4] {
+	case "check", "spin", "combo", "button", "string", "filename":
+	default:
+		return fmt.Errorf("invalid option type %q", tokens[4])
+	}
+	for i := 5; i < len(tokens); i += 2 {
+		switch tokens[i] {
+		case "default", "var":
+		case "min", "max":
+			if i+1 < len(tokens) && !isInteger(tokens[i+1]) {
+				return fmt.Errorf("%s must be an integer", tokens[i])
+			}
+		default:
+			return fmt.Errorf("unexpected option token %q", tokens[i])
+		}
+		if i+1 >= len(tokens) {
+			return fmt.Errorf("missing value for %s", tokens[i])
+		}
+	}
+	return nil
+}
+
+func validateUsiBestmove(tokens []string) error {
+	if len(tokens) != 2 && len(tokens) != 4 {
+		return fmt.Errorf("expected 'bestmove <move> [ponder <move>]'")
+	}
+	if tokens[1] != "resign" && tokens[1] != "win" && !isUsiMove(tokens[1]) {
+		return fmt.Errorf("invalid bestmove %q", tokens[1])
+	}
+	if len(tokens) == 4 && (tokens[2] != "ponder" || !isUsiMove(tokens[3])) {
+		return fmt.Errorf("expected 'ponder <move>'")
+	}
+	return nil
+}
+
+func validateUsiInfo(tokens []string) error {
+	if len(tokens) < 2 {
+		return fmt.Errorf("empty info")
+	}
+	for i := 1; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "string":
+			if i+1 >= len(tokens) {
+				return fmt.Errorf("empty info string")
+			}
+			return nil // consumes the rest of the line
+		case "depth", "seldepth", "time", "nodes", "multipv", "hashfull", "nps":
+			i++
+			if i >= len(tokens) || !isInteger(tokens[i]) {
+				return fmt.Errorf("%s must be followed by an integer", tokens[i-1])
+			}
+		case "currmove":
+			i++
+			if i >= len(tokens) || !isUsiMove(tokens[i]) {
+				return fmt.Errorf("currmove must be followed by a move")
+			}
+		case "score":
+			if i+2 >= len(tokens) || (tokens[i+1] != "cp" && tokens[i+1] != "mate") {
+				return fmt.Errorf("expected 'score cp <x>' or 'score mate <y>'")
+			}
+			if !isInteger(tokens[i+2]) && tokens[i+2] != "+" && tokens[i+2] != "-" {
+				return fmt.Errorf("invalid score value %q", tokens[i+2])
+			}
+			i += 2
+			if i+1 < len(tokens) && (tokens[i+1] == "lowerbound" || tokens[i+1] == "upperbound") {
+				i++
+			}
+		case "pv":
+			if i+1 >= len(tokens) {
+				return fmt.Errorf("empty pv")
+			}
+			for ; i+1 < len(tokens) && isUsiMove(tokens[i+1]); i++ {
+			}
+		default:
+			return fmt.Errorf("unexpected info token %q", tokens[i])
+		}
+	}
+	return nil
+}
+
+func TestValidateUsiLine(t *testing.T) {
+	valid := []string{
+		"usiok",
+		"readyok",
+		"id name Hifumi 0.0",
+		"option name USI_Variant type combo default shogi var shogi",
+		"bestmove 7g7f",
+		"bestmove P*5e ponder 3c3d",
+		"bestmove 5e5d- ponder +P*3c",
+		"bestmove resign",
+		"info depth 3 nodes 1200 score cp -25 pv 7g7f 3c3d 2g2f+",
+		"info string search completed",
+	}
+	invalid := []string{
+		"",
+		"timeout",
+		"search completed",
+		"bestmove",
+		"bestmove 7z7f",
+		"bestmove 7g7f=",
+		"bestmove +K*5e",
+		"info",
+		"info depth x",
+		"info string",
+		"option name X type unknown",
+	}
+
+	for _, line := range valid {
+		if err := validateUsiLine(line); err != nil {
+			t.Errorf("%q: unexpected error: %v", line, err)
+		}
+	}
+	for _, line := range invalid {
+		if err := validateUsiLine(line); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
+
+// ================================== //
+// ========= USI loop harness ======= //
+// ================================== //
+
+// usiSession drives an usiLoop through pipes and validates every line it emits.
+type usiSession struct {
+	t     *testing.T
+	in    *io.PipeWriter
+	lines chan string
+	done  chan struct{}
+}
+
+func newUsiSession(t *testing.T) *usiSession {
+	t.Helper()
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	s := &usiSession{
+		t:     t,
+		in:    inWriter,
+		lines: make(chan string, 1024),
+		done:  make(chan struct{}),
+	}
+
+	go func() {
+		usiLoop(inReader, outWriter)
+		outWriter.Close()
+		close(s.done)
+	}()
+	go func() {
+		scanner := bufio.NewScanner(outReader)
+		for scanner.Scan() {
+			s.lines <- scanner.Text()
+		}
+		close(s.lines)
+	}()
+	return s
+}
+
+func (s *usiSession) send(cmd string) {
+	s.t.Helper()
+	if _, err := io.WriteString(s.in, cmd+"\n"); err != nil {
+		s.t.Fatalf("send %q: %v", cmd, err)
+	}
+}
+
+// expect reads lines until one starts with prefix, failing on any invalid USI line.
+func (s *usiSession) expect(prefix string) []string {
+	s.t.Helper()
+	var received []string
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				s.t.Fatalf("output closed while waiting for %q, got %q", prefix, received)
+			}
+			if err := validateUsiLine(line); err != nil {
+				s.t.Errorf("invalid USI line %q: %v", line, err)
+			}
+			received = append(received, line)
+			if strings.HasPrefix(line, prefix) {
+				return received
+			}
+		case <-timeout:
+			s.t.Fatalf("timeout while waiting for %q, got %q", prefix, received)
+		}
+	}
+}
+
+// close ends the input stream and validates the remaining output.
+func (s *usiSession) close() {
+	s.t.Helper()
+	s.in.Close()
+	for line := range s.lines {
+		if err := validateUsiLine(line); err != nil {
+			s.t.Errorf("invalid USI line %q: %v", line, err)
+		}
+	}
+	<-s.done
+}
+
+func TestUsiLoopOutputIsValidUsi(t *testing.T) {
+	s := newUsiSession(t)
+	s.send("usi")
+	s.expect("usiok")
+	s.send("isready")
+	s.expect("readyok")
+	s.send("setoption name Unknown value 1")
+	s.send("setoption name USI_Variant value chess")
+	s.send("position startpos moves 7g7f 3c3d")
+	s.send("position startpos moves 7g7f 1a1i")
+	s.send("position nonsense")
+	s.send("position handicap two-piece moves 5a4b")
+	s.send("position handicap queen")
+	s.send("perft 1")
+	s.send("divide 1")
+	s.send(":d")
+	s.send("this is not a command")
+	s.send("go depth 1")
+	s.expect("bestmove")
+	s.send("isready")
+	s.expect("readyok")
+	s.close()
+}
+
+// The following tests are meant to be run with the race detector (make test/race).
+
+func TestUsiLoopStopThenIsready(t *testing.T) {
+	s := newUsiSession(t)
+	s.send("go infinite")
+	s.send("stop")
+	s.send("isready")
+	lines := s.expect("readyok")
+	if !strings.HasPrefix(lines[len(lines)-2], "bestmove") {
+		t.Errorf("expected bestmove before readyok, got %q", lines)
+	}
+	s.close()
+}
+
+func TestUsiLoopIsreadyWhileSearching(t *testing.T) {
+	s := newUsiSession(t)
+	s.send("go infinite")
+	s.send("isready")
+	for _, line := range s.expect("readyok") {
+		if strings.HasPrefix(line, "bestmove") {
+			t.Errorf("unexpected bestmove before readyok")
+		}
+	}
+	s.send("stop")
+	s.expect("bestmove")
+	s.close()
+}
+
+func TestUsiLoopGoThenIsready(t *testing.T) {
+	s := newUsiSession(t)
+	for _, goCmd := range []string{"go movetime 1", "go depth 1", "go infinite", "go btime 10 wtime 10"} {
+		for i := 0; i < 10; i++ {
+			s.send("position startpos moves 7g7f")
+			s.send(goCmd)
+			s.send("isready")
+			lines := s.expect("readyok")
+			s.send("stop")
+			if !slices.ContainsFunc(lines, func(l string) bool { return strings.HasPrefix(l, "bestmove") }) {
+				s.expect("bestmove")
+			}
+		}
+	}
+	s.close()
+}
+
+func TestUsiLoopGoWhileSearching(t *testing.T) {
+	s := newUsiSession(t)
+	s.send("go infinite")
+	s.send("position startpos moves 7g7f")
+	s.send("go infinite")
+	s.expect("bestmove")
+	s.send("stop")
+	s.expect("bestmove")
+	s.close()
+}
+
+func TestUsiLoopQuitWhileSearching(t *testing.T) {
+	s := newUsiSession(t)
+	s.send("go infinite")
+	s.send("quit")
+	s.expect("bestmove")
+	s.close()
+}
+
+func TestUsiLoopEndOfInputWhileSearching(t *testing.T) {
+	s := newUsiSession(t)
+	s.send("go btime 60000 wtime 60000 byoyomi 10000")
+	s.in.Close()
+	s.expect("bestmove")
+	s.close()
+}
+
+func TestUsiLoopStopStorm(t *testing.T) {
+	s := newUsiSession(t)
+	for i := 0; i < 20; i++ {
+		s.send("go infinite")
+		s.send("stop")
+		s.send("stop")
+	}
+	for i := 0; i < 20; i++ {
+		s.expect("bestmove")
+	}
+	s.send("isready")
+	s.expect("readyok")
+	s.close()
+}
+
+func TestUsiLoopVariant(t *testing.T) {
+	s := newUsiSession(t)
+	s.send("usi")
+	lines := s.expect("usiok")
+	if !slices.Contains(lines, "option name USI_Variant type combo default shogi var shogi var minishogi var judkins var kyotoshogi") {
+		t.Errorf("USI_Variant option does not advertise minishogi: %q", lines)
+	}
+	s.send("setoption name USI_Variant value minishogi")
+	s.send("position startpos moves 5e4d")
+	s.send("go depth 1")
+	lines = s.expect("bestmove")
+	move := strings.Fields(lines[len(lines)-1])[1]
+	if !regexp.MustCompile(`^[1-5][a-e][1-5][a-e]\+?$`).MatchString(move) {
+		t.Errorf("bestmove %s is outside of the minishogi board", move)
+	}
+	s.close()
+}
+
+func TestUsiLoopKyotoShogi(t *testing.T) {
+	s := newUsiSession(t)
+	s.send("setoption name USI_Variant value kyotoshogi")
+	// the king can't move, every move demotes a piece
+	s.send("position sfen 2k2/5/5/3+S+N/3+LK b - 1")
+	s.send("go depth 1")
+	lines := s.expect("bestmove")
+	if move := strings.Fields(lines[len(lines)-1])[1]; !strings.HasSuffix(move, "-") {
+		t.Errorf("expected a demotion, got %s", move)
+	}
+	// the pawn can be dropped on either face
+	s.send("position sfen 2k2/5/5/3+S+N/3+LK b P 1")
+	s.send("go depth 1")
+	s.expect("bestmove")
+	s.close()
+}
+
+func TestUsiLoopDeclarationWin(t *testing.T) {
+	s := newUsiSession(t)
+	s.send("position sfen +R+BGGSS+N+N+L/4K3L/9/9/9/9/9/9/4k4 b RB 1")
+	s.send("go depth 1")
+	if lines := s.expect("bestmove"); lines[len(lines)-1] != "bestmove win" {
+		t.Errorf("expected 'bestmove win', got %q", lines[len(lines)-1])
+	}
+	s.send("setoption name EnteringKingRule value none")
+	s.send("go depth 1")
+	if lines := s.expect("bestmove"); lines[len(lines)-1] == "bestmove win" {
+		t.Errorf("unexpected 'bestmove win' without entering king rule")
+	}
+	s.close()
+}
+
+func TestUsiLoopPonder(t *testing.T) {
+	s := newUsiSession(t)
+	s.send("position startpos moves 7g7f 3c3d")
+	s.send("go ponder btime 0 wtime 0 byoyomi 100")
+	time.Sleep(200 * time.Millisecond) // longer than the byoyomi
+	s.send("isready")
+	for _, line := range s.expect("readyok") {
+		if strings.HasPrefix(line, "bestmove") {
+			t.Errorf("unexpected bestmove before ponderhit")
+		}
+	}
+	s.send("ponderhit")
+	s.expect("bestmove")
+	s.close()
+}
+
+func TestUsiLoopDeclarationWinInfinite(t *testing.T) {
+	s := newUsiSession(t)
+	s.send("position sfen +R+BGGSS+N+N+L/4K3L/9/9/9/9/9/9/4k4 b RB 1")
+	for _, cmds := range [][2]string{{"go infinite", "stop"}, {"go ponder btime 0 wtime 0 byoyomi 100", "ponderhit"}} {
+		s.send(cmds[0])
+		s.send("isready")
+		for _, line := range s.expect("readyok") {
+			if strings.HasPrefix(line, "bestmove") {
+				t.Errorf("%s: unexpected bestmove before %s", cmds[0], cmds[1])
+			}
+		}
+		s.send(cmds[1])
+		if lines := s.expect("bestmove"); lines[len(lines)-1] != "bestmove win" {
+			t.Errorf("%s: expected 'bestmove win', got %q", cmds[0], lines[len(lines)-1])
+		}
+	}
+	s.close()
+}
+
+func TestUsiLoopTryRule(t *testing.T) {
+	s := newUsiSession(t)
+	s.send("setoption name EnteringKingRule value TryRule")
+	s.send("position sfen 9/4K4/9/9/9/9/9/9/4k4 b - 1")
+	s.send("go depth 1")
+	if lines := s.expect("bestmove"); lines[len(lines)-1] != "bestmove 5b5a" {
+		t.Errorf("expected 'bestmove 5b5a', got %q", lines[len(lines)-1])
+	}
+	// the try square is attacked by the gold, the try is not available
+	s.send("position sfen 3g5/4K4/9/9/9/9/9/9/4k4 b - 1")
+	s.send("go depth 1")
+	if lines := s.expect("bestmove"); lines[len(lines)-1] == "bestmove 5b5a" {
+		t.Errorf("unexpected illegal try")
+	}
+	s.close()
+}
+
+func TestUsiLoopTryRuleInfinite(t *testing.T) {
+	s := newUsiSession(t)
+	s.send("setoption name EnteringKingRule value TryRule")
+	s.send("position sfen 9/4K4/9/9/9/9/9/9/4k4 b - 1")
+	for _, cmds := range [][2]string{{"go infinite", "stop"}, {"go ponder btime 0 wtime 0 byoyomi 100", "ponderhit"}} {
+		s.send(cmds[0])
+		s.send("isready")
+		for _, line := range s.expect("readyok") {
+			if strings.HasPrefix(line, "bestmove") {
+				t.Errorf("%s: unexpected bestmove before %s", cmds[0], cmds[1])
+			}
+		}
+		s.send(cmds[1])
+		if lines := s.expect("bestmove"); lines[len(lines)-1] != "bestmove 5b5a" {
+			t.Errorf("%s: expected 'bestmove 5b5a', got %q", cmds[0], lines[len(lines)-1])
+		}
+	}
+	s.close()
+}
+
+func TestUsiLoopInvalidPosition(t *testing.T) {
+	s := newUsiSession(t)
+	s.send("position sfen 4k4/9/9/9/9/P8/P8/9/4K4 b - 1")
+	s.send(":d")
+	s.send("isready")
+	lines := s.expect("readyok")
+	if !slices.Contains(lines, "info string black has two pawns on file 9") {
+		t.Errorf("nifu is not reported: %q", lines)
+	}
+	if !slices.ContainsFunc(lines, func(l string) bool { return strings.HasPrefix(l, "info string Sfen: "+shogi.StartPos) }) {
+		t.Errorf("invalid position replaced the current position: %q", lines)
+	}
+	s.close()
+}
+
+func TestUsiLoopDisplayStyle(t *testing.T) {
+	s := newUsiSession(t)
+	s.send("usi")
+	lines := s.expect("usiok")
+	if !slices.Contains(lines, "option name DisplayFlip type check default false") {
+		t.Errorf("DisplayFlip option is not advertised: %q", lines)
+	}
+	s.send("setoption name DisplayStyle value kanji")
+	s.send("setoption name DisplayFlip value true")
+	s.send("position startpos moves 7g7f")
+	s.send(":d")
+	s.send("isready")
+	lines = s.expect("readyok")
+	for _, expected := range []string{
+		"info string ▲持駒：なし",
+		"info string   １ ２ ３ ４ ５ ６ ７ ８ ９",
+		"info string | 香 桂 銀 金 玉 金 銀 桂 香|九",
+		"info string △手番",
+	} {
+		if !slices.Contains(lines, expected) {
+			t.Errorf("missing %q in %q", expected, lines)
+		}
+	}
+	s.send("setoption name DisplayStyle value sixel")
+	s.send("isready")
+	if lines = s.expect("readyok"); !slices.Contains(lines, "info string Invalid value: valid values are [ascii kanji unicode ansi]") {
+		t.Errorf("invalid style is not reported: %q", lines)
+	}
+	s.close()
+}
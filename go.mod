@@ -1,3 +1,5 @@
 module github.com/vinymeuh/hifumi
 
 go 1.21.0
+
+require golang.org/x/text v0.22.0
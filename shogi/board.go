@@ -36,6 +36,8 @@ const (
 	sq1a uint8 = 8
 	sq1b uint8 = 17
 	sq1c uint8 = 25
+	sq5a uint8 = 4
+	sq5i uint8 = 76
 	sq9g uint8 = 54
 	sq9h uint8 = 63
 	sq9i uint8 = 72
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package shogi
+
+import (
+	"time"
+)
+
+// A Clock keeps the time left to the players under a TimeControl. Once its main time is
+// exhausted, a player has the byoyomi for each move. The increment is added after each move.
+// A zero TimeControl gives unlimited time.
+type Clock struct {
+	// TimeControl of the game
+	TimeControl TimeControl
+	// Remaining is the main time left to each player
+	Remaining [COLORS]time.Duration
+}
+
+// NewClock creates a Clock giving the main time of a TimeControl to both players.
+func NewClock(tc TimeControl) *Clock {
+	return &Clock{
+		TimeControl: tc,
+		Remaining:   [COLORS]time.Duration{tc.Main, tc.Main},
+	}
+}
+
+// Unlimited returns true if the players have unlimited time.
+func (c *Clock) Unlimited() bool {
+	return c.TimeControl == TimeControl{Main: 0, Byoyomi: 0, Increment: 0}
+}
+
+// Available returns the time a player can use for its next move.
+func (c *Clock) Available(side Color) time.Duration {
+	return c.Remaining[side] + c.TimeControl.Byoyomi
+}
+
+// Consume deducts the time used by a player for a move. It returns false if the player
+// has exceeded its time, and loses the game.
+func (c *Clock) Consume(side Color, used time.Duration) bool {
+	if c.Unlimited() {
+		return true
+	}
+	if used > c.Available(side) {
+		c.Remaining[side] = 0
+		return false
+	}
+	c.Remaining[side] = max(0, c.Remaining[side]-used) + c.TimeControl.Increment
+	return true
+}
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package shogi
+
+import "fmt"
+
+// EnteringKingRule is the rule applied to decide games where kings have entered
+// the opponent camp (nyugyoku).
+type EnteringKingRule int
+
+const (
+	NoEnteringKing EnteringKingRule = iota // No rule, game continues
+	CSARule24                              // Declaration wins with 31 points or more
+	CSARule27                              // Declaration wins with 28 points for Black, 27 points for White
+	TryRule                                // Moving the king onto the opponent's king starting square wins
+)
+
+var enteringKingRuleNames = []string{"none", "CSARule24", "CSARule27", "TryRule"}
+
+// EnteringKingRules returns the names of the supported entering king rules.
+func EnteringKingRules() []string {
+	return append([]string{}, enteringKingRuleNames...)
+}
+
+// NewEnteringKingRule returns the EnteringKingRule from its name.
+func NewEnteringKingRule(name string) (EnteringKingRule, error) {
+	for i, n := range enteringKingRuleNames {
+		if n == name {
+			return EnteringKingRule(i), nil
+		}
+	}
+	return NoEnteringKing, fmt.Errorf("unknown entering king rule '%s'", name)
+}
+
+// String returns the name of the rule.
+func (r EnteringKingRule) String() string {
+	return enteringKingRuleNames[r]
+}
+
+// DeclarationPoints returns the declaration points of a color and the number of its
+// pieces, king excepted, in the opponent camp. Rooks and bishops, promoted or not,
+// count for 5 points, other pieces for 1 point. Pieces in hand are included in the points.
+func (p *Position) DeclarationPoints(c Color) (points int, piecesInCamp int) {
+	camp := p.Variant.PromotionZone(c)
+	for sq := uint8(0); sq < SQUARES; sq++ {
+		piece := p.Board[sq]
+		if piece == NoPiece || camp.Bit(uint(sq)) == 0 || piece.Color() != c {
+			continue
+		}
+		switch piece.UnPromote() {
+		case BlackKing, WhiteKing:
+			continue
+		case BlackRook, BlackBishop, WhiteRook, WhiteBishop:
+			points += 5
+		default:
+			points++
+		}
+		piecesInCamp++
+	}
+
+	_, rooks := p.Hands[c].Rooks()
+	_, bishops := p.Hands[c].Bishops()
+	points += p.Hands[c].Count + 4*(rooks+bishops)
+	return points, piecesInCamp
+}
+
+// CanDeclareWin returns true if the side to move wins by declaration under the position's
+// EnteringKingRule. Conditions are: the king is in the opponent camp and not in check,
+// at least 10 other pieces are in the opponent camp and the declaration points are enough.
+// Only standard shogi supports declaration. Requires package movegen.
+func (p *Position) CanDeclareWin() bool {
+	if p.Variant != Standard || (p.EnteringKingRule != CSARule24 && p.EnteringKingRule != CSARule27) {
+		return false
+	}
+
+	king := BlackKing
+	if p.Side == White {
+		king = WhiteKing
+	}
+	if p.BBbyPiece[king].And(p.Variant.PromotionZone(p.Side)).PopCount() == 0 {
+		return false
+	}
+
+	points, piecesInCamp := p.DeclarationPoints(p.Side)
+	if piecesInCamp < 10 {
+		return false
+	}
+	switch {
+	case p.EnteringKingRule == CSARule24 && points < 31:
+		return false
+	case p.EnteringKingRule == CSARule27 && p.Side == Black && points < 28:
+		return false
+	case p.EnteringKingRule == CSARule27 && p.Side == White && points < 27:
+		return false
+	}
+
+	return !mustMoveGenerator().InCheck(p, p.Side)
+}
+
+// trySquares are the king starting squares to be reached by each color for a try.
+var trySquares = [COLORS]uint8{sq5a, sq5i}
+
+// TryWin returns true if, under the TryRule, the side which has just moved wins because
+// its king stands on the opponent's king starting square. To be called after DoMove on
+// legal moves, the move being illegal if the king is attacked on the try square.
+// Only standard shogi supports the try rule.
+func (p *Position) TryWin() bool {
+	if p.Variant != Standard || p.EnteringKingRule != TryRule {
+		return false
+	}
+	mover := p.Side.Opponent()
+	king := BlackKing
+	if mover == White {
+		king = WhiteKing
+	}
+	return p.Board[trySquares[mover]] == king
+}
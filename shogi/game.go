@@ -0,0 +1,246 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package shogi
+
+import (
+	"time"
+)
+
+// A Game is a game record: informations, start position and moves with their variations,
+// comments and clock times. It is the model read and written by the package kifu.
+type Game struct {
+	// Header holds the informations about the game
+	Header GameHeader
+	// Variant played, standard shogi if nil
+	Variant *Variant
+	// StartPos is the SFEN string of the start position
+	StartPos string
+	// Comment is the comment preceding the first move
+	Comment string
+	// Moves is the main line of the game, ended by a special move when the game is over
+	Moves []*GameNode
+}
+
+// A GameHeader holds the informations about a game.
+type GameHeader struct {
+	// Players are the names of the players by color
+	Players [COLORS]string
+	// Event is the name of the tournament or the match
+	Event string
+	// Site is where the game was played
+	Site string
+	// Date is the start of the game, zero if unknown
+	Date time.Time
+	// TimeControl is the time given to the players, zero if unknown
+	TimeControl TimeControl
+	// Tags are the other informations, in their original order
+	Tags []GameTag
+}
+
+// A GameTag is an information about a game without its own GameHeader field,
+// e.g. {"終了日時", "2023/04/01 10:15:32"}. Keys are the KIF header keys.
+type GameTag struct {
+	Key   string
+	Value string
+}
+
+// A TimeControl is the time given to each player.
+type TimeControl struct {
+	// Main is the time for the whole game
+	Main time.Duration
+	// Byoyomi is the time for each move once the main time is exhausted
+	Byoyomi time.Duration
+	// Increment is the time added after each move
+	Increment time.Duration
+}
+
+// A GameNode is a move of a line, or a special move ending the line.
+type GameNode struct {
+	// Move played, zero for a special move
+	Move Move
+	// Special is the reason why the game ended, empty for a regular move
+	Special SpecialMove
+	// Comment on the move
+	Comment string
+	// Time consumed by the move
+	Time time.Duration
+	// Variations are the alternative lines starting in place of this move
+	Variations [][]*GameNode
+}
+
+// A SpecialMove is a move ending a game. Values are the names used by the CSA format.
+type SpecialMove string
+
+const (
+	SpecialResign             SpecialMove = "TORYO"           // The side to move resigns
+	SpecialAbort              SpecialMove = "CHUDAN"          // The game is interrupted
+	SpecialSennichite         SpecialMove = "SENNICHITE"      // Repetition of positions
+	SpecialTimeUp             SpecialMove = "TIME_UP"         // The side to move lost on time
+	SpecialIllegalMove        SpecialMove = "ILLEGAL_MOVE"    // The side to move played an illegal move and loses
+	SpecialBlackIllegalAction SpecialMove = "+ILLEGAL_ACTION" // Black lost by an illegal action
+	SpecialWhiteIllegalAction SpecialMove = "-ILLEGAL_ACTION" // White lost by an illegal action
+	SpecialJishogi            SpecialMove = "JISHOGI"         // Impasse
+	SpecialKachi              SpecialMove = "KACHI"           // The side to move declares a win under the entering king rule
+	SpecialHikiwake           SpecialMove = "HIKIWAKE"        // Draw
+	SpecialMatta              SpecialMove = "MATTA"           // Takeback
+	SpecialTsumi              SpecialMove = "TSUMI"           // The side to move is checkmated
+	SpecialFuzumi             SpecialMove = "FUZUMI"          // No checkmate (tsume problems)
+	SpecialError              SpecialMove = "ERROR"           // Error
+)
+
+// SpecialMoves are all the special moves.
+var SpecialMoves = []SpecialMove{
+	SpecialResign, SpecialAbort, SpecialSennichite, SpecialTimeUp, SpecialIllegalMove,
+	SpecialBlackIllegalAction, SpecialWhiteIllegalAction, SpecialJishogi, SpecialKachi,
+	SpecialHikiwake, SpecialMatta, SpecialTsumi, SpecialFuzumi, SpecialError,
+}
+
+// A GameResult is the result of a game.
+type GameResult struct {
+	// Winner is NoColor for a draw or a game without winner
+	Winner Color
+	// Reason is the special move ending the main line, empty for an unfinished game
+	Reason SpecialMove
+}
+
+// NewGame creates a Game without moves starting from a SFEN string.
+func NewGame(sfen string) *Game {
+	return &Game{
+		Header: GameHeader{
+			Players:     [COLORS]string{},
+			Event:       "",
+			Site:        "",
+			Date:        time.Time{},
+			TimeControl: TimeControl{Main: 0, Byoyomi: 0, Increment: 0},
+			Tags:        nil,
+		},
+		Variant:  nil,
+		StartPos: sfen,
+		Comment:  "",
+		Moves:    nil,
+	}
+}
+
+// NewMoveNode creates a GameNode for a regular move.
+func NewMoveNode(m Move) *GameNode {
+	return &GameNode{Move: m, Special: "", Comment: "", Time: 0, Variations: nil}
+}
+
+// NewSpecialNode creates a GameNode for a special move.
+func NewSpecialNode(s SpecialMove) *GameNode {
+	return &GameNode{Move: 0, Special: s, Comment: "", Time: 0, Variations: nil}
+}
+
+// Tag returns the value of a tag, or an empty string if missing.
+func (h *GameHeader) Tag(key string) string {
+	for _, t := range h.Tags {
+		if t.Key == key {
+			return t.Value
+		}
+	}
+	return ""
+}
+
+// SetTag sets the value of a tag, adding it after the existing ones if missing.
+func (h *GameHeader) SetTag(key, value string) {
+	for i, t := range h.Tags {
+		if t.Key == key {
+			h.Tags[i].Value = value
+			return
+		}
+	}
+	h.Tags = append(h.Tags, GameTag{Key: key, Value: value})
+}
+
+// Position returns a new Position for the start position of the Game.
+func (g *Game) Position() (*Position, error) {
+	if g.Variant != nil {
+		return g.Variant.NewPositionFromSfen(g.StartPos)
+	}
+	return NewPositionFromSfen(g.StartPos)
+}
+
+// MainLine returns the moves of the main line, special move excluded.
+func (g *Game) MainLine() []Move {
+	moves := make([]Move, 0, len(g.Moves))
+	for _, n := range g.Moves {
+		if n.Special == "" {
+			moves = append(moves, n.Move)
+		}
+	}
+	return moves
+}
+
+// IsOver returns true if the main line is ended by a special move.
+func (g *Game) IsOver() bool {
+	return len(g.Moves) > 0 && g.Moves[len(g.Moves)-1].Special != ""
+}
+
+// AddMove appends a move to the main line, with the time consumed to play it.
+func (g *Game) AddMove(m Move, t time.Duration) {
+	n := NewMoveNode(m)
+	n.Time = t
+	g.Moves = append(g.Moves, n)
+}
+
+// End ends the main line with a special move, with the time consumed before it.
+func (g *Game) End(s SpecialMove, t time.Duration) {
+	n := NewSpecialNode(s)
+	n.Time = t
+	g.Moves = append(g.Moves, n)
+}
+
+// Result returns the result of the main line, given by its final special move.
+// Requires package movegen for the games ended by sennichite, to find perpetual checks.
+func (g *Game) Result() GameResult {
+	if !g.IsOver() {
+		return GameResult{Winner: NoColor, Reason: ""}
+	}
+	special := g.Moves[len(g.Moves)-1].Special
+	pos, err := g.Position()
+	if err != nil {
+		return GameResult{Winner: NoColor, Reason: special}
+	}
+	for _, m := range g.MainLine() {
+		pos.DoMove(m)
+	}
+
+	winner := NoColor
+	switch special { //nolint:exhaustive
+	case SpecialResign, SpecialTimeUp, SpecialIllegalMove, SpecialTsumi:
+		winner = pos.Side.Opponent()
+	case SpecialKachi:
+		winner = pos.Side
+	case SpecialBlackIllegalAction:
+		winner = White
+	case SpecialWhiteIllegalAction:
+		winner = Black
+	case SpecialSennichite:
+		if o := pos.Outcome(); o.Status == PerpetualCheck {
+			winner = o.Winner
+		}
+	}
+	return GameResult{Winner: winner, Reason: special}
+}
+
+// SpecialMove returns the special move ending a game with this outcome, empty for an ongoing game.
+// A try win ends the game record with SpecialTsumi, the king being as good as captured.
+func (o Outcome) SpecialMove() SpecialMove {
+	switch o.Status {
+	case Ongoing:
+		return ""
+	case Checkmate, NoLegalMoves, TryWin:
+		return SpecialTsumi
+	case PawnDropMate:
+		if o.Winner == Black {
+			return SpecialWhiteIllegalAction
+		}
+		return SpecialBlackIllegalAction
+	case Sennichite, PerpetualCheck:
+		return SpecialSennichite
+	case DeclarationWin:
+		return SpecialKachi
+	case IllegalPosition:
+	}
+	return SpecialError
+}
@@ -62,15 +62,7 @@ func (h *Hand) SfenString(w io.StringWriter) {
 		return
 	}
 
-	var pieceOrder []Piece
-	switch h.color { //nolint:exhaustive // Hand can be only created using NewBlackHand or NewWhiteHand
-	case Black:
-		pieceOrder = []Piece{BlackRook, BlackBishop, BlackGold, BlackSilver, BlackKnight, BlackLance, BlackPawn}
-	case White:
-		pieceOrder = []Piece{WhiteRook, WhiteBishop, WhiteGold, WhiteSilver, WhiteKnight, WhiteLance, WhitePawn}
-	}
-
-	for _, p := range pieceOrder {
+	for _, p := range handPieces[h.color] {
 		n := h.ByPiece[p]
 		switch {
 		case n == 0:
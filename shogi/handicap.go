@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package shogi
+
+import (
+	"fmt"
+	"sort"
+)
+
+// handicaps is the registry of standard handicaps (komaochi). Each handicap is
+// described by the squares emptied from the White side of StartPos, as the
+// stronger player takes White and gives the pieces.
+var handicaps = map[string][]string{
+	"lance":       {"1a"},
+	"right-lance": {"9a"},
+	"bishop":      {"2b"},
+	"rook":        {"8b"},
+	"rook-lance":  {"8b", "1a"},
+	"two-piece":   {"8b", "2b"},
+	"four-piece":  {"8b", "2b", "9a", "1a"},
+	"six-piece":   {"8b", "2b", "9a", "1a", "8a", "2a"},
+	"eight-piece": {"8b", "2b", "9a", "1a", "8a", "2a", "7a", "3a"},
+	"ten-piece":   {"8b", "2b", "9a", "1a", "8a", "2a", "7a", "3a", "6a", "4a"},
+}
+
+// Handicaps returns the sorted list of the handicap names known by HandicapSfen.
+func Handicaps() []string {
+	names := make([]string, 0, len(handicaps))
+	for name := range handicaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HandicapSfen returns the SFEN string of the starting position for a named handicap.
+// As required by handicap rules, White moves first.
+func HandicapSfen(name string) (string, error) {
+	squares, ok := handicaps[name]
+	if !ok {
+		return "", fmt.Errorf("unknown handicap '%s'", name)
+	}
+
+	p, _ := NewPositionFromSfen(StartPos)
+	for _, s := range squares {
+		sq := NewSquareIndex(s)
+		p.ClearPiece(p.Board[sq], sq)
+	}
+	p.Side = White
+	return p.Sfen(), nil
+}
+
+// NewPositionFromHandicap creates a new Position for the starting position of a named handicap.
+func NewPositionFromHandicap(name string) (*Position, error) {
+	sfen, err := HandicapSfen(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewPositionFromSfen(sfen)
+}
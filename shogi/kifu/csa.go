@@ -0,0 +1,641 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package kifu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// csaPieces are the CSA names of the black pieces.
+var csaPieces = map[string]shogi.Piece{
+	"FU": shogi.BlackPawn,
+	"KY": shogi.BlackLance,
+	"KE": shogi.BlackKnight,
+	"GI": shogi.BlackSilver,
+	"KI": shogi.BlackGold,
+	"KA": shogi.BlackBishop,
+	"HI": shogi.BlackRook,
+	"OU": shogi.BlackKing,
+	"TO": shogi.BlackPromotedPawn,
+	"NY": shogi.BlackPromotedLance,
+	"NK": shogi.BlackPromotedKnight,
+	"NG": shogi.BlackPromotedSilver,
+	"UM": shogi.BlackPromotedBishop,
+	"RY": shogi.BlackPromotedRook,
+}
+
+// csaPieceName returns the CSA name of a piece of any color.
+func csaPieceName(p shogi.Piece) string {
+	for name, piece := range csaPieces {
+		if piece == blackPiece(p) {
+			return name
+		}
+	}
+	return ""
+}
+
+// csaHandPieces are the pieces sharing the hands, with their count in a game.
+var csaHandPieces = []struct {
+	piece shogi.Piece
+	count int
+}{
+	{piece: shogi.BlackRook, count: 2},
+	{piece: shogi.BlackBishop, count: 2},
+	{piece: shogi.BlackGold, count: 4},
+	{piece: shogi.BlackSilver, count: 4},
+	{piece: shogi.BlackKnight, count: 4},
+	{piece: shogi.BlackLance, count: 4},
+	{piece: shogi.BlackPawn, count: 18},
+}
+
+// csaHeaders maps the CSA game informations to the KIF header keys.
+var csaHeaders = []struct {
+	csa string
+	kif string
+}{
+	{csa: "EVENT", kif: "棋戦"},
+	{csa: "SITE", kif: "場所"},
+	{csa: "START_TIME", kif: "開始日時"},
+	{csa: "END_TIME", kif: "終了日時"},
+	{csa: "OPENING", kif: "戦型"},
+}
+
+// csaSign returns the CSA sign of a color.
+func csaSign(c shogi.Color) string {
+	if c == shogi.White {
+		return "-"
+	}
+	return "+"
+}
+
+// ************************************************************* //
+// ************************ CSA reader ************************* //
+// ************************************************************* //
+
+// ReadCSA reads a game record in CSA format: game informations, start position given
+// by P1..P9 lines or the PI handicap syntax, moves with their time and comments.
+// Comments are the lines starting with '*, other comment lines are ignored. Only the
+// first game of a file is read.
+func ReadCSA(r io.Reader) (*shogi.Game, error) {
+	r, err := utf8Text(r)
+	if err != nil {
+		return nil, fmt.Errorf("CSA: %w", err)
+	}
+	p := newCsaParser()
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "/" { // separator of the games in a file
+			break
+		}
+		if err := p.parseLine(text); err != nil {
+			return nil, fmt.Errorf("CSA line %d: %w", lineno, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p.record()
+}
+
+type csaParser struct {
+	rec   *shogi.Game
+	start *shogi.Position // start position being read, nil if not given yet
+	pos   *shogi.Position // position after the last move read, nil before the first move
+}
+
+func newCsaParser() *csaParser {
+	return &csaParser{
+		rec:   shogi.NewGame(shogi.StartPos),
+		start: nil,
+		pos:   nil,
+	}
+}
+
+func (p *csaParser) parseLine(text string) error {
+	if strings.HasPrefix(text, "'") {
+		if comment, ok := strings.CutPrefix(text, "'*"); ok {
+			p.addComment(comment)
+		}
+		return nil
+	}
+	// a line can hold several statements separated by commas
+	for _, statement := range strings.Split(text, ",") {
+		if err := p.parseStatement(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *csaParser) parseStatement(text string) error {
+	switch {
+	case text == "" || text[0] == 'V':
+		return nil
+	case strings.HasPrefix(text, "N+"):
+		p.rec.Header.Players[shogi.Black] = text[2:]
+	case strings.HasPrefix(text, "N-"):
+		p.rec.Header.Players[shogi.White] = text[2:]
+	case text[0] == '$':
+		key, value, ok := strings.Cut(text[1:], ":")
+		if !ok {
+			return fmt.Errorf("invalid game information '%s'", text)
+		}
+		p.addHeader(key, value)
+	case text[0] == 'P' && p.pos == nil:
+		return p.parsePosition(text)
+	case (text == "+" || text == "-") && p.pos == nil:
+		p.startPosition().Side = shogi.Black
+		if text == "-" {
+			p.start.Side = shogi.White
+		}
+	case text[0] == '+' || text[0] == '-':
+		return p.parseMove(text)
+	case text[0] == '%':
+		return p.parseSpecial(text)
+	case text[0] == 'T':
+		return p.parseTime(text)
+	default:
+		return fmt.Errorf("invalid statement '%s'", text)
+	}
+	return nil
+}
+
+// addHeader sets a game information, as the KIF header with the same meaning if there is one.
+func (p *csaParser) addHeader(key, value string) {
+	for _, h := range csaHeaders {
+		if h.csa == key {
+			setKifHeader(&p.rec.Header, h.kif, value)
+			return
+		}
+	}
+	if key == "TIME_LIMIT" || key == "TIME" {
+		if tc, ok := parseCsaTimeControl(key, value); ok {
+			p.rec.Header.TimeControl = tc
+			return
+		}
+	}
+	p.rec.Header.Tags = append(p.rec.Header.Tags, shogi.GameTag{Key: "$" + key, Value: value})
+}
+
+// parseCsaTimeControl parses a time control given as "$TIME_LIMIT:HH:MM+SS" (main time
+// and byoyomi) or "$TIME:main+byoyomi+increment" in seconds.
+func parseCsaTimeControl(key, value string) (shogi.TimeControl, bool) {
+	tc := shogi.TimeControl{Main: 0, Byoyomi: 0, Increment: 0}
+	parts := strings.Split(value, "+")
+	if key == "TIME_LIMIT" {
+		var hours, minutes, seconds int
+		n, err := fmt.Sscanf(value, "%d:%d+%d", &hours, &minutes, &seconds)
+		if err != nil || n != 3 {
+			return tc, false
+		}
+		tc.Main = time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+		tc.Byoyomi = time.Duration(seconds) * time.Second
+		return tc, true
+	}
+
+	if len(parts) != 3 {
+		return tc, false
+	}
+	for i, field := range []*time.Duration{&tc.Main, &tc.Byoyomi, &tc.Increment} {
+		seconds, err := strconv.ParseFloat(parts[i], 64)
+		if err != nil || seconds < 0 {
+			return tc, false
+		}
+		*field = time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+	}
+	return tc, true
+}
+
+// addComment adds a comment line to the last move read, or to the record before the first move.
+func (p *csaParser) addComment(comment string) {
+	if len(p.rec.Moves) == 0 {
+		p.rec.Comment = joinComment(p.rec.Comment, comment)
+		return
+	}
+	n := p.rec.Moves[len(p.rec.Moves)-1]
+	n.Comment = joinComment(n.Comment, comment)
+}
+
+// startPosition returns the start position being read, creating an empty board if needed.
+func (p *csaParser) startPosition() *shogi.Position {
+	if p.start == nil {
+		p.start, _ = shogi.NewPositionFromSfen("9/9/9/9/9/9/9/9/9 b - 1")
+	}
+	return p.start
+}
+
+// parsePosition parses a line of the start position: PI, P1..P9, P+ or P-.
+func (p *csaParser) parsePosition(text string) error {
+	switch {
+	case strings.HasPrefix(text, "PI"):
+		if p.start != nil {
+			return fmt.Errorf("PI after the start position")
+		}
+		p.start, _ = shogi.NewPositionFromSfen(shogi.StartPos)
+		return p.parsePieces(text[2:], func(piece shogi.Piece, sq uint8) error {
+			if p.start.Board[sq] == shogi.NoPiece || blackPiece(p.start.Board[sq]) != piece {
+				return fmt.Errorf("PI: no %s on %s", csaPieceName(piece), csaSquare(sq))
+			}
+			p.start.ClearPiece(p.start.Board[sq], sq)
+			return nil
+		})
+	case len(text) >= 2 && text[1] >= '1' && text[1] <= '9':
+		return p.parseRank(text)
+	case strings.HasPrefix(text, "P+") || strings.HasPrefix(text, "P-"):
+		c := shogi.Black
+		if text[1] == '-' {
+			c = shogi.White
+		}
+		pos := p.startPosition()
+		if strings.HasSuffix(text, "00AL") {
+			defer p.addRemainingPieces(c)
+			text = strings.TrimSuffix(text, "00AL")
+		}
+		return p.parsePieces(text[2:], func(piece shogi.Piece, sq uint8) error {
+			switch {
+			case sq == csaHand && (piece.Promote() == piece && piece != shogi.BlackGold):
+				return fmt.Errorf("invalid piece in hand %s", csaPieceName(piece))
+			case sq == csaHand:
+				pos.Hands[c].Push(coloredPiece(piece, c))
+			case pos.Board[sq] != shogi.NoPiece:
+				return fmt.Errorf("square %s is not empty", csaSquare(sq))
+			default:
+				pos.SetPiece(coloredPiece(piece, c), sq)
+			}
+			return nil
+		})
+	}
+	return fmt.Errorf("invalid position line '%s'", text)
+}
+
+// parseRank parses a rank of the board like "P1-KY-KE-GI-KI-OU-KI-GI-KE-KY".
+func (p *csaParser) parseRank(text string) error {
+	rank := int(text[1] - '1')
+	cells := text[2:]
+	if len(cells) < 3*shogi.FILES { // trailing spaces of an empty last square may have been stripped
+		cells += strings.Repeat(" ", 3*shogi.FILES-len(cells))
+	}
+	if len(cells) != 3*shogi.FILES {
+		return fmt.Errorf("rank %c must have %d squares", text[1], shogi.FILES)
+	}
+	pos := p.startPosition()
+	for file := 0; file < shogi.FILES; file++ {
+		cell := cells[3*file : 3*file+3]
+		if cell == " * " {
+			continue
+		}
+		piece, ok := csaPieces[cell[1:]]
+		if !ok || (cell[0] != '+' && cell[0] != '-') {
+			return fmt.Errorf("rank %c: invalid piece '%s'", text[1], cell)
+		}
+		if cell[0] == '-' {
+			piece = coloredPiece(piece, shogi.White)
+		}
+		sq := uint8(rank*shogi.FILES + file)
+		if pos.Board[sq] != shogi.NoPiece {
+			pos.ClearPiece(pos.Board[sq], sq)
+		}
+		pos.SetPiece(piece, sq)
+	}
+	return nil
+}
+
+// csaHand is the square used for the pieces in hand.
+const csaHand = uint8(255)
+
+// parsePieces parses a list of pieces on squares like "82HI22KA", calling fn for each one.
+// The square 00 is returned as csaHand.
+func (p *csaParser) parsePieces(text string, fn func(piece shogi.Piece, sq uint8) error) error {
+	if len(text)%4 != 0 {
+		return fmt.Errorf("invalid piece list '%s'", text)
+	}
+	for i := 0; i < len(text); i += 4 {
+		piece, ok := csaPieces[text[i+2:i+4]]
+		if !ok {
+			return fmt.Errorf("invalid piece '%s'", text[i+2:i+4])
+		}
+		sq := csaHand
+		if text[i:i+2] != "00" {
+			var err error
+			if sq, err = parseCsaSquare(text[i : i+2]); err != nil {
+				return err
+			}
+		}
+		if err := fn(piece, sq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addRemainingPieces puts in the hand of a color all the pieces neither on the board nor in a hand.
+func (p *csaParser) addRemainingPieces(c shogi.Color) {
+	pos := p.start
+	for _, hp := range csaHandPieces {
+		n := hp.count - pos.Hands[shogi.Black].ByPiece[hp.piece] -
+			pos.Hands[shogi.White].ByPiece[coloredPiece(hp.piece, shogi.White)]
+		for _, piece := range pos.Board {
+			if piece != shogi.NoPiece && blackPiece(piece.UnPromote()) == hp.piece {
+				n--
+			}
+		}
+		if n > 0 {
+			pos.Hands[c].SetCount(coloredPiece(hp.piece, c), pos.Hands[c].ByPiece[coloredPiece(hp.piece, c)]+n)
+		}
+	}
+}
+
+// startMoves fixes the start position before the first move.
+func (p *csaParser) startMoves() error {
+	if p.pos != nil {
+		return nil
+	}
+	if p.start != nil {
+		p.rec.StartPos = p.start.Sfen()
+	}
+	pos, err := p.rec.Position()
+	if err != nil {
+		return err
+	}
+	p.pos = pos
+	return nil
+}
+
+// startMove checks that a new move can be appended to the main line.
+func (p *csaParser) startMove() error {
+	if err := p.startMoves(); err != nil {
+		return err
+	}
+	if len(p.rec.Moves) > 0 && p.rec.Moves[len(p.rec.Moves)-1].Special != "" {
+		return fmt.Errorf("move %d follows the end of the game", len(p.rec.Moves)+1)
+	}
+	return nil
+}
+
+func (p *csaParser) parseMove(text string) error {
+	if err := p.startMove(); err != nil {
+		return err
+	}
+	m, err := ParseCSAMove(p.pos, text)
+	if err != nil {
+		return err
+	}
+	p.pos.DoMove(m)
+	p.rec.Moves = append(p.rec.Moves, shogi.NewMoveNode(m))
+	return nil
+}
+
+func (p *csaParser) parseSpecial(text string) error {
+	if err := p.startMove(); err != nil {
+		return err
+	}
+	special := shogi.SpecialMove(text[1:])
+	if !slices.Contains(shogi.SpecialMoves, special) {
+		return fmt.Errorf("unknown special move '%s'", text)
+	}
+	p.rec.Moves = append(p.rec.Moves, shogi.NewSpecialNode(special))
+	return nil
+}
+
+// parseTime parses the time consumed by the last move, in seconds, e.g. "T12" or "T12.345".
+func (p *csaParser) parseTime(text string) error {
+	seconds, err := strconv.ParseFloat(text[1:], 64)
+	if err != nil || seconds < 0 {
+		return fmt.Errorf("invalid time '%s'", text)
+	}
+	if len(p.rec.Moves) == 0 {
+		return fmt.Errorf("time '%s' before the first move", text)
+	}
+	p.rec.Moves[len(p.rec.Moves)-1].Time = time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+	return nil
+}
+
+// record returns the game read.
+func (p *csaParser) record() (*shogi.Game, error) {
+	if err := p.startMoves(); err != nil {
+		return nil, err
+	}
+	return p.rec, nil
+}
+
+// parseCsaSquare parses CSA coordinates like "76".
+func parseCsaSquare(str string) (uint8, error) {
+	if len(str) != 2 || str[0] < '1' || str[0] > '9' || str[1] < '1' || str[1] > '9' {
+		return 0, fmt.Errorf("invalid square '%s'", str)
+	}
+	return shogi.NewSquareIndex(fmt.Sprintf("%c%c", str[0], 'a'+str[1]-'1')), nil
+}
+
+// csaSquare returns the CSA coordinates of a square, e.g. "76".
+func csaSquare(sq uint8) string {
+	return fmt.Sprintf("%d%d", shogi.SquareFile(sq), shogi.SquareRank(sq))
+}
+
+// ParseCSAMove parses a move in CSA notation like "+7776FU", "-3122GI" or "+0045KA",
+// the piece being the one after the move.
+func ParseCSAMove(pos *shogi.Position, text string) (shogi.Move, error) {
+	if len(text) != 7 {
+		return shogi.Move(0), fmt.Errorf("invalid move '%s'", text)
+	}
+	if text[:1] != csaSign(pos.Side) {
+		return shogi.Move(0), fmt.Errorf("move '%s': not the turn of %s", text, pos.Side.Opponent())
+	}
+	to, err := parseCsaSquare(text[3:5])
+	if err != nil {
+		return shogi.Move(0), fmt.Errorf("invalid move '%s': %w", text, err)
+	}
+	piece, ok := csaPieces[text[5:7]]
+	if !ok {
+		return shogi.Move(0), fmt.Errorf("invalid move '%s': unknown piece '%s'", text, text[5:7])
+	}
+
+	var usi string
+	if text[1:3] == "00" {
+		usi = strings.ToUpper(piece.String()) + "*" + shogi.SquareString(to)
+	} else {
+		from, err := parseCsaSquare(text[1:3])
+		if err != nil {
+			return shogi.Move(0), fmt.Errorf("invalid move '%s': %w", text, err)
+		}
+		usi = shogi.SquareString(from) + shogi.SquareString(to)
+		switch moved := pos.Board[from]; {
+		case moved == shogi.NoPiece:
+		case blackPiece(moved) != piece && blackPiece(moved).Promote() == piece:
+			usi += "+"
+		case blackPiece(moved) != piece:
+			return shogi.Move(0), fmt.Errorf("move '%s': no %s on %s", text, text[5:7], text[1:3])
+		}
+	}
+	m, err := shogi.ParseMove(pos, usi)
+	if err != nil {
+		return shogi.Move(0), fmt.Errorf("move '%s': %w", text, err)
+	}
+	return m, nil
+}
+
+// ************************************************************* //
+// ************************ CSA writer ************************* //
+// ************************************************************* //
+
+// WriteCSA writes the main line of a game record in CSA format, version 2.2. The start
+// position is written with the PI syntax when it is the initial position with pieces
+// removed, as P1..P9 lines otherwise. Headers without CSA equivalent are written as
+// comments, and the variations are not written.
+func WriteCSA(w io.Writer, rec *shogi.Game) error {
+	pos, err := rec.Position()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "V2.2")
+	writeCsaHeaders(bw, rec)
+	WriteCSAPosition(bw, pos)
+	writeCsaComment(bw, rec.Comment)
+	for _, n := range rec.Moves {
+		if n.Special != "" {
+			fmt.Fprintf(bw, "%%%s\n", n.Special)
+		} else {
+			fmt.Fprintln(bw, CSAMoveString(pos, n.Move))
+			pos.DoMove(n.Move)
+		}
+		if n.Time > 0 {
+			fmt.Fprintf(bw, "T%s\n", formatCsaSeconds(n.Time))
+		}
+		writeCsaComment(bw, n.Comment)
+		if n.Special != "" {
+			break
+		}
+	}
+	return bw.Flush()
+}
+
+// writeCsaHeaders writes the player names and the game informations.
+func writeCsaHeaders(w io.Writer, rec *shogi.Game) {
+	for c, name := range rec.Header.Players {
+		if name != "" {
+			fmt.Fprintf(w, "N%s%s\n", csaSign(shogi.Color(c)), name)
+		}
+	}
+
+	names := playerNames(rec.StartPos)
+	var others []shogi.GameTag
+headers:
+	for _, h := range kifHeaders(rec) {
+		switch {
+		case h.Key == names[shogi.Black] || h.Key == names[shogi.White] || h.Key == "持ち時間":
+			continue
+		case strings.HasPrefix(h.Key, "$"):
+			fmt.Fprintf(w, "%s:%s\n", h.Key, h.Value)
+			continue
+		}
+		for _, ch := range csaHeaders {
+			if ch.kif == h.Key {
+				fmt.Fprintf(w, "$%s:%s\n", ch.csa, h.Value)
+				continue headers
+			}
+		}
+		others = append(others, h)
+	}
+
+	if tc := rec.Header.TimeControl; tc != (shogi.TimeControl{Main: 0, Byoyomi: 0, Increment: 0}) {
+		if tc.Increment == 0 && tc.Main%time.Minute == 0 && tc.Byoyomi%time.Second == 0 {
+			minutes := int(tc.Main.Minutes())
+			fmt.Fprintf(w, "$TIME_LIMIT:%02d:%02d+%02d\n", minutes/60, minutes%60, int(tc.Byoyomi.Seconds()))
+		} else {
+			fmt.Fprintf(w, "$TIME:%s+%s+%s\n", formatCsaSeconds(tc.Main), formatCsaSeconds(tc.Byoyomi), formatCsaSeconds(tc.Increment))
+		}
+	}
+	for _, h := range others {
+		fmt.Fprintf(w, "'%s：%s\n", h.Key, h.Value)
+	}
+}
+
+// formatCsaSeconds formats a duration in seconds, e.g. "12" or "1.5".
+func formatCsaSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// WriteCSAPosition writes a position and its side to move, as in the start position of a CSA record.
+func WriteCSAPosition(w io.Writer, pos *shogi.Position) {
+	if removed, ok := removedPieces(pos); ok {
+		fmt.Fprintf(w, "PI%s\n", removed)
+		fmt.Fprintln(w, csaSign(pos.Side))
+		return
+	}
+
+	for rank := 0; rank < shogi.RANKS; rank++ {
+		fmt.Fprintf(w, "P%d", rank+1)
+		for file := 0; file < shogi.FILES; file++ {
+			piece := pos.Board[rank*shogi.FILES+file]
+			if piece == shogi.NoPiece {
+				fmt.Fprint(w, " * ")
+			} else {
+				fmt.Fprint(w, csaSign(piece.Color())+csaPieceName(piece))
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	for c := shogi.Black; c <= shogi.White; c++ {
+		var sb strings.Builder
+		for _, hp := range csaHandPieces {
+			n := pos.Hands[c].ByPiece[coloredPiece(hp.piece, c)]
+			sb.WriteString(strings.Repeat("00"+csaPieceName(hp.piece), n))
+		}
+		if sb.Len() > 0 {
+			fmt.Fprintf(w, "P%s%s\n", csaSign(c), sb.String())
+		}
+	}
+	fmt.Fprintln(w, csaSign(pos.Side))
+}
+
+// removedPieces returns the pieces removed from the initial position to get a position, e.g.
+// "82HI22KA", or false if the position has other differences.
+func removedPieces(pos *shogi.Position) (string, bool) {
+	if pos.Hands[shogi.Black].Count > 0 || pos.Hands[shogi.White].Count > 0 {
+		return "", false
+	}
+	initial, _ := shogi.NewPositionFromSfen(shogi.StartPos)
+	var sb strings.Builder
+	for sq, piece := range initial.Board {
+		switch {
+		case pos.Board[sq] == piece:
+		case pos.Board[sq] == shogi.NoPiece:
+			sb.WriteString(csaSquare(uint8(sq)) + csaPieceName(piece))
+		default:
+			return "", false
+		}
+	}
+	return sb.String(), true
+}
+
+// CSAMoveString returns a move in CSA notation, e.g. "+7776FU".
+func CSAMoveString(pos *shogi.Position, m shogi.Move) string {
+	if m.IsDrop() {
+		return fmt.Sprintf("%s00%s%s", csaSign(pos.Side), csaSquare(m.To()), csaPieceName(m.Piece()))
+	}
+	piece := pos.Board[m.From()]
+	if m.IsPromotion() {
+		piece = piece.Promote()
+	}
+	return fmt.Sprintf("%s%s%s%s", csaSign(pos.Side), csaSquare(m.From()), csaSquare(m.To()), csaPieceName(piece))
+}
+
+// writeCsaComment writes the lines of a comment.
+func writeCsaComment(w io.Writer, comment string) {
+	if comment == "" {
+		return
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		fmt.Fprintf(w, "'*%s\n", line)
+	}
+}
@@ -0,0 +1,311 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package kifu
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// Japanese notation shared by the KIF and KI2 formats.
+
+var (
+	fullWidthDigits = []rune("０１２３４５６７８９")
+	kanjiNumerals   = []rune("〇一二三四五六七八九")
+)
+
+// pieceNames are the names of the black pieces in KIF moves.
+var pieceNames = map[shogi.Piece]string{
+	shogi.BlackPawn:           "歩",
+	shogi.BlackLance:          "香",
+	shogi.BlackKnight:         "桂",
+	shogi.BlackSilver:         "銀",
+	shogi.BlackGold:           "金",
+	shogi.BlackBishop:         "角",
+	shogi.BlackRook:           "飛",
+	shogi.BlackKing:           "玉",
+	shogi.BlackPromotedPawn:   "と",
+	shogi.BlackPromotedLance:  "成香",
+	shogi.BlackPromotedKnight: "成桂",
+	shogi.BlackPromotedSilver: "成銀",
+	shogi.BlackPromotedBishop: "馬",
+	shogi.BlackPromotedRook:   "龍",
+}
+
+// pieceChars are the one character names of the black pieces in board diagrams.
+var pieceChars = map[shogi.Piece]string{
+	shogi.BlackPawn:           "歩",
+	shogi.BlackLance:          "香",
+	shogi.BlackKnight:         "桂",
+	shogi.BlackSilver:         "銀",
+	shogi.BlackGold:           "金",
+	shogi.BlackBishop:         "角",
+	shogi.BlackRook:           "飛",
+	shogi.BlackKing:           "玉",
+	shogi.BlackPromotedPawn:   "と",
+	shogi.BlackPromotedLance:  "杏",
+	shogi.BlackPromotedKnight: "圭",
+	shogi.BlackPromotedSilver: "全",
+	shogi.BlackPromotedBishop: "馬",
+	shogi.BlackPromotedRook:   "龍",
+}
+
+// namePieces returns the black piece from its name, including alternative names.
+var namePieces = map[string]shogi.Piece{
+	"王": shogi.BlackKing,
+	"竜": shogi.BlackPromotedRook,
+}
+
+func init() {
+	for piece, name := range pieceNames {
+		namePieces[name] = piece
+	}
+	for piece, name := range pieceChars {
+		namePieces[name] = piece
+	}
+}
+
+// blackPiece returns the black piece of the same kind as a piece.
+func blackPiece(p shogi.Piece) shogi.Piece {
+	return p % shogi.PIECE_TYPES
+}
+
+// coloredPiece returns the piece of a color from a black piece.
+func coloredPiece(p shogi.Piece, c shogi.Color) shogi.Piece {
+	if c == shogi.White {
+		return p + shogi.PIECE_TYPES
+	}
+	return p
+}
+
+// pieceName returns the KIF name of a piece of any color.
+func pieceName(p shogi.Piece) string {
+	return pieceNames[blackPiece(p)]
+}
+
+// japaneseSquare returns the coordinates of a square in Japanese, e.g. "７六".
+func japaneseSquare(sq uint8) string {
+	return string(fullWidthDigits[shogi.SquareFile(sq)]) + string(kanjiNumerals[shogi.SquareRank(sq)])
+}
+
+// parseJapaneseSquare parses coordinates in Japanese at the beginning of a string,
+// accepting full width or ASCII digits for the file and kanji or ASCII digits for the rank.
+// It returns the square and the rest of the string.
+func parseJapaneseSquare(str string) (uint8, string, error) {
+	runes := []rune(str)
+	if len(runes) < 2 {
+		return 0, "", fmt.Errorf("invalid square '%s'", str)
+	}
+	file := strings.IndexRune(string(fullWidthDigits), runes[0]) / len("０")
+	if runes[0] >= '1' && runes[0] <= '9' {
+		file = int(runes[0] - '0')
+	}
+	rank := strings.IndexRune(string(kanjiNumerals), runes[1]) / len("〇")
+	if runes[1] >= '1' && runes[1] <= '9' {
+		rank = int(runes[1] - '0')
+	}
+	if file < 1 || rank < 1 {
+		return 0, "", fmt.Errorf("invalid square '%s'", string(runes[:2]))
+	}
+	return shogi.NewSquareIndex(fmt.Sprintf("%d%c", file, 'a'+rank-1)), string(runes[2:]), nil
+}
+
+// trimSameSquare removes the mark of a move to the destination of the previous move,
+// "同" or its variant "仝", and the spaces following it.
+func trimSameSquare(str string) (string, bool) {
+	for _, mark := range []string{"同", "仝"} {
+		if rest, ok := strings.CutPrefix(str, mark); ok {
+			return strings.TrimLeft(rest, " 　"), true
+		}
+	}
+	return str, false
+}
+
+// kanjiNumber returns a number from 1 to 99 in kanji, e.g. "十八".
+func kanjiNumber(n int) string {
+	var sb strings.Builder
+	if n >= 20 {
+		sb.WriteRune(kanjiNumerals[n/10])
+	}
+	if n >= 10 {
+		sb.WriteString("十")
+	}
+	if n%10 > 0 {
+		sb.WriteRune(kanjiNumerals[n%10])
+	}
+	return sb.String()
+}
+
+// parseKanjiNumber parses a number from 1 to 99 written in kanji.
+func parseKanjiNumber(str string) (int, error) {
+	n, digit := 0, 0
+	for _, r := range str {
+		switch i := strings.IndexRune(string(kanjiNumerals), r); {
+		case r == '十':
+			n, digit = n+10*max(digit, 1), 0
+		case i > 0:
+			digit = i / len("〇")
+		default:
+			return 0, fmt.Errorf("invalid number '%s'", str)
+		}
+	}
+	if n+digit == 0 {
+		return 0, fmt.Errorf("invalid number '%s'", str)
+	}
+	return n + digit, nil
+}
+
+// kifHandicaps maps the KIF handicap names to the shogi handicap names, an empty name
+// being the even game, and to the JKF presets.
+var kifHandicaps = []struct {
+	kif  string
+	name string
+	jkf  string
+}{
+	{kif: "平手", name: "", jkf: "HIRATE"},
+	{kif: "香落ち", name: "lance", jkf: "KY"},
+	{kif: "右香落ち", name: "right-lance", jkf: "KY_R"},
+	{kif: "角落ち", name: "bishop", jkf: "KA"},
+	{kif: "飛車落ち", name: "rook", jkf: "HI"},
+	{kif: "飛香落ち", name: "rook-lance", jkf: "HIKY"},
+	{kif: "二枚落ち", name: "two-piece", jkf: "2"},
+	{kif: "四枚落ち", name: "four-piece", jkf: "4"},
+	{kif: "六枚落ち", name: "six-piece", jkf: "6"},
+	{kif: "八枚落ち", name: "eight-piece", jkf: "8"},
+	{kif: "十枚落ち", name: "ten-piece", jkf: "10"},
+}
+
+// handicapSfen returns the SFEN string of the start position for a KIF handicap name.
+func handicapSfen(kif string) (string, error) {
+	for _, h := range kifHandicaps {
+		if h.kif == kif && h.name == "" {
+			return shogi.StartPos, nil
+		}
+		if h.kif == kif {
+			return shogi.HandicapSfen(h.name)
+		}
+	}
+	return "", fmt.Errorf("unknown handicap '%s'", kif)
+}
+
+// handicapName returns the KIF handicap name of a start position, or false if it is not a
+// standard start position.
+func handicapName(sfen string) (string, bool) {
+	for _, h := range kifHandicaps {
+		if s, _ := handicapSfen(h.kif); samePosition(s, sfen) {
+			return h.kif, true
+		}
+	}
+	return "", false
+}
+
+// samePosition returns true if two SFEN strings describe the same position, ignoring the move count.
+func samePosition(a, b string) bool {
+	fa, fb := strings.Fields(a), strings.Fields(b)
+	return len(fa) >= 3 && len(fb) >= 3 && fa[0] == fb[0] && fa[1] == fb[1] && fa[2] == fb[2]
+}
+
+// isHandicap returns true if the start position is a handicap, where White is the stronger
+// player (uwate) and moves first.
+func isHandicap(sfen string) bool {
+	name, ok := handicapName(sfen)
+	return ok && name != "平手"
+}
+
+// playerNames returns the names of the players by color: sente and gote, or shitate and
+// uwate for handicap games.
+func playerNames(sfen string) [shogi.COLORS]string {
+	if isHandicap(sfen) {
+		return [shogi.COLORS]string{"下手", "上手"}
+	}
+	return [shogi.COLORS]string{"先手", "後手"}
+}
+
+// kifSpecials are the KIF names of the special moves.
+var kifSpecials = []struct {
+	kif     string
+	special shogi.SpecialMove
+}{
+	{kif: "投了", special: shogi.SpecialResign},
+	{kif: "中断", special: shogi.SpecialAbort},
+	{kif: "千日手", special: shogi.SpecialSennichite},
+	{kif: "切れ負け", special: shogi.SpecialTimeUp},
+	{kif: "反則負け", special: shogi.SpecialIllegalMove},
+	{kif: "反則勝ち", special: shogi.SpecialBlackIllegalAction},
+	{kif: "反則勝ち", special: shogi.SpecialWhiteIllegalAction},
+	{kif: "持将棋", special: shogi.SpecialJishogi},
+	{kif: "入玉勝ち", special: shogi.SpecialKachi},
+	{kif: "引き分け", special: shogi.SpecialHikiwake},
+	{kif: "待った", special: shogi.SpecialMatta},
+	{kif: "詰み", special: shogi.SpecialTsumi},
+	{kif: "不詰", special: shogi.SpecialFuzumi},
+	{kif: "エラー", special: shogi.SpecialError},
+}
+
+// parseSpecial returns the special move for its Japanese name, side being the side to move.
+func parseSpecial(name string, side shogi.Color) (shogi.SpecialMove, bool) {
+	if name == "反則勝ち" { // the opponent of the side to move made an illegal action
+		if side == shogi.Black {
+			return shogi.SpecialWhiteIllegalAction, true
+		}
+		return shogi.SpecialBlackIllegalAction, true
+	}
+	for _, s := range kifSpecials {
+		if s.kif == name {
+			return s.special, true
+		}
+	}
+	return "", false
+}
+
+// specialName returns the Japanese name of a special move, side being the side to move.
+func specialName(special shogi.SpecialMove, side shogi.Color) string {
+	switch {
+	case special == shogi.SpecialBlackIllegalAction && side == shogi.Black,
+		special == shogi.SpecialWhiteIllegalAction && side == shogi.White:
+		return "反則負け"
+	}
+	for _, s := range kifSpecials {
+		if s.special == special {
+			return s.kif
+		}
+	}
+	return "エラー"
+}
+
+// ParseMove parses a move of the side to move in Japanese notation, KI2 like "７六歩", "同　銀"
+// or "５八金右", or KIF like "７六歩(77)", optionally preceded by the side mark, e.g. "▲７六歩".
+// The coordinates can be written with ASCII digits, e.g. "76歩". prev is the previous move, zero if none,
+// giving the destination of "同".
+func ParseMove(pos *shogi.Position, text string, prev shogi.Move) (shogi.Move, error) {
+	text = strings.Trim(text, " 　")
+	for _, mark := range []string{"▲", "☗", "△", "☖", "▽"} {
+		if rest, found := strings.CutPrefix(text, mark); found {
+			side := shogi.Black
+			if mark != "▲" && mark != "☗" {
+				side = shogi.White
+			}
+			if side != pos.Side {
+				return shogi.Move(0), fmt.Errorf("move '%s' is not played by the side to move", text)
+			}
+			text = rest
+			break
+		}
+	}
+	if strings.Contains(text, "(") {
+		return parseKifMove(text, pos, prev.To(), prev != 0)
+	}
+	return parseKi2Move(text, pos, prev.To(), prev != 0)
+}
+
+// MoveString returns a legal move in KI2 notation with the mark of the side to move, e.g. "▲７六歩".
+// prev is the previous move, zero if none.
+func MoveString(pos *shogi.Position, m shogi.Move, prev shogi.Move) string {
+	mark := "▲"
+	if pos.Side == shogi.White {
+		mark = "△"
+	}
+	return mark + ki2Move(pos, m, prev.To(), prev != 0)
+}
@@ -0,0 +1,497 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package kifu
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/movegen"
+)
+
+// JSON Kifu Format, as defined by https://github.com/na2hiro/json-kifu-format.
+
+type jkfRecord struct {
+	Header  jkfHeader   `json:"header"`
+	Initial *jkfInitial `json:"initial,omitempty"`
+	Moves   []jkfMove   `json:"moves"`
+}
+
+// A jkfHeader is a JSON object keeping the order of the headers.
+type jkfHeader []shogi.GameTag
+
+type jkfInitial struct {
+	Preset string    `json:"preset"`
+	Data   *jkfState `json:"data,omitempty"`
+}
+
+type jkfState struct {
+	Color int              `json:"color"`
+	Board [][]jkfPiece     `json:"board"` // by file then by rank, from file 1
+	Hands []map[string]int `json:"hands"`
+}
+
+// A jkfPiece is a piece on the board, empty for an empty square.
+type jkfPiece struct {
+	Color *int   `json:"color,omitempty"`
+	Kind  string `json:"kind,omitempty"`
+}
+
+// A jkfMove is an element of the moves: a move or a special move, the first element
+// only holding the comments preceding the first move.
+type jkfMove struct {
+	Comments []string     `json:"comments,omitempty"`
+	Move     *jkfMoveData `json:"move,omitempty"`
+	Time     *jkfTime     `json:"time,omitempty"`
+	Special  string       `json:"special,omitempty"`
+	Forks    [][]jkfMove  `json:"forks,omitempty"`
+}
+
+type jkfMoveData struct {
+	From     *jkfPlace `json:"from,omitempty"` // nil for a drop
+	To       *jkfPlace `json:"to,omitempty"`
+	Color    int       `json:"color"`
+	Piece    string    `json:"piece"`             // piece before the move
+	Same     bool      `json:"same,omitempty"`    // same destination as the previous move
+	Promote  *bool     `json:"promote,omitempty"` // set when the piece could promote
+	Capture  string    `json:"capture,omitempty"`
+	Relative string    `json:"relative,omitempty"` // KI2 modifiers, e.g. "LM" for 左寄
+}
+
+type jkfPlace struct {
+	X int `json:"x"` // file
+	Y int `json:"y"` // rank
+}
+
+type jkfTime struct {
+	Now   jkfNowTime   `json:"now"`
+	Total jkfTotalTime `json:"total"`
+}
+
+type jkfNowTime struct {
+	M int `json:"m"`
+	S int `json:"s"`
+}
+
+type jkfTotalTime struct {
+	H int `json:"h"`
+	M int `json:"m"`
+	S int `json:"s"`
+}
+
+// jkfRelatives maps the letters of the relative field to the KI2 modifiers.
+var jkfRelatives = map[rune]rune{
+	'L': '左',
+	'C': '直',
+	'R': '右',
+	'U': '上',
+	'M': '寄',
+	'D': '引',
+	'H': '打',
+}
+
+func (h jkfHeader) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, header := range h {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(header.Key)
+		value, _ := json.Marshal(header.Value)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (h *jkfHeader) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return fmt.Errorf("header must be an object")
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var value string
+		if err := dec.Decode(&value); err != nil {
+			return fmt.Errorf("header %s: %w", t, err)
+		}
+		*h = append(*h, shogi.GameTag{Key: t.(string), Value: value})
+	}
+	return nil
+}
+
+// ************************************************************* //
+// ************************ JKF reader ************************* //
+// ************************************************************* //
+
+// ReadJKF reads a game record in JSON Kifu Format: header, initial position given by a
+// preset or a board, and moves with their forks, comments and time.
+func ReadJKF(r io.Reader) (*shogi.Game, error) {
+	var jkf jkfRecord
+	if err := json.NewDecoder(r).Decode(&jkf); err != nil {
+		return nil, fmt.Errorf("JKF: %w", err)
+	}
+
+	rec := shogi.NewGame(shogi.StartPos)
+	preset := ""
+	for _, h := range jkf.Header {
+		if h.Key == "手合割" {
+			preset = h.Value
+			continue
+		}
+		setKifHeader(&rec.Header, h.Key, h.Value)
+	}
+	var err error
+	switch {
+	case jkf.Initial != nil:
+		rec.StartPos, err = jkfStartPos(jkf.Initial)
+	case preset != "":
+		rec.StartPos, err = handicapSfen(preset)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("JKF: %w", err)
+	}
+	pos, err := rec.Position()
+	if err != nil {
+		return nil, fmt.Errorf("JKF: %w", err)
+	}
+
+	moves := jkf.Moves
+	if len(moves) > 0 && moves[0].Move == nil && moves[0].Special == "" {
+		rec.Comment = strings.Join(moves[0].Comments, "\n")
+		moves = moves[1:]
+	}
+	if rec.Moves, err = readJkfLine(pos, moves, 1, 0, false); err != nil {
+		return nil, fmt.Errorf("JKF: %w", err)
+	}
+	return rec, nil
+}
+
+// jkfMaxHandCount is the maximum count of a piece in hand, the number of pawns.
+const jkfMaxHandCount = 18
+
+// jkfStartPos returns the SFEN string of an initial position.
+func jkfStartPos(initial *jkfInitial) (string, error) {
+	if initial.Preset != "OTHER" {
+		for _, h := range kifHandicaps {
+			if h.jkf == initial.Preset {
+				return handicapSfen(h.kif)
+			}
+		}
+		return "", fmt.Errorf("unknown preset '%s'", initial.Preset)
+	}
+
+	if initial.Data == nil {
+		return "", fmt.Errorf("missing data of initial position")
+	}
+	pos, _ := shogi.NewPositionFromSfen("9/9/9/9/9/9/9/9/9 b - 1")
+	pos.Side = shogi.Color(initial.Data.Color)
+	if pos.Side != shogi.Black && pos.Side != shogi.White {
+		return "", fmt.Errorf("invalid color %d", initial.Data.Color)
+	}
+	if len(initial.Data.Board) != shogi.FILES {
+		return "", fmt.Errorf("board must have %d files", shogi.FILES)
+	}
+	for x, column := range initial.Data.Board {
+		if len(column) != shogi.RANKS {
+			return "", fmt.Errorf("file %d must have %d ranks", x+1, shogi.RANKS)
+		}
+		for y, cell := range column {
+			if cell.Kind == "" {
+				continue
+			}
+			piece, ok := csaPieces[cell.Kind]
+			if !ok || cell.Color == nil || (*cell.Color != int(shogi.Black) && *cell.Color != int(shogi.White)) {
+				return "", fmt.Errorf("invalid piece on %d%d", x+1, y+1)
+			}
+			pos.SetPiece(coloredPiece(piece, shogi.Color(*cell.Color)), uint8(y*shogi.FILES+shogi.FILES-1-x))
+		}
+	}
+	if len(initial.Data.Hands) != shogi.COLORS {
+		return "", fmt.Errorf("hands must have %d elements", shogi.COLORS)
+	}
+	for c, hand := range initial.Data.Hands {
+		for kind, n := range hand {
+			piece, ok := csaPieces[kind]
+			if !ok || (piece.Promote() == piece && piece != shogi.BlackGold) {
+				return "", fmt.Errorf("invalid piece in hand '%s'", kind)
+			}
+			if n < 0 || n > jkfMaxHandCount {
+				return "", fmt.Errorf("invalid count %d of '%s' in hand", n, kind)
+			}
+			pos.Hands[c].SetCount(coloredPiece(piece, shogi.Color(c)), n)
+		}
+	}
+	return pos.Sfen(), nil
+}
+
+// readJkfLine converts the moves of a line played from pos, number being the number of the first move.
+func readJkfLine(pos *shogi.Position, moves []jkfMove, number int, prevTo uint8, hasPrev bool) ([]*shogi.GameNode, error) {
+	var nodes []*shogi.GameNode
+	for _, jm := range moves {
+		if len(nodes) > 0 && nodes[len(nodes)-1].Special != "" {
+			return nil, fmt.Errorf("move %d follows the end of the game", number)
+		}
+
+		var n *shogi.GameNode
+		switch {
+		case jm.Special != "":
+			special := shogi.SpecialMove(jm.Special)
+			if !slices.Contains(shogi.SpecialMoves, special) {
+				return nil, fmt.Errorf("move %d: unknown special move '%s'", number, jm.Special)
+			}
+			n = shogi.NewSpecialNode(special)
+		case jm.Move != nil:
+			m, err := parseJkfMove(jm.Move, pos, prevTo, hasPrev)
+			if err != nil {
+				return nil, fmt.Errorf("move %d: %w", number, err)
+			}
+			n = shogi.NewMoveNode(m)
+		default:
+			return nil, fmt.Errorf("move %d: no move", number)
+		}
+		n.Comment = strings.Join(jm.Comments, "\n")
+		if jm.Time != nil {
+			n.Time = time.Duration(jm.Time.Now.M)*time.Minute + time.Duration(jm.Time.Now.S)*time.Second
+		}
+		for _, fork := range jm.Forks {
+			variation, err := readJkfLine(pos.Clone(), fork, number, prevTo, hasPrev)
+			if err != nil {
+				return nil, err
+			}
+			if len(variation) > 0 {
+				n.Variations = append(n.Variations, variation)
+			}
+		}
+
+		nodes = append(nodes, n)
+		if n.Special == "" {
+			pos.DoMove(n.Move)
+			prevTo, hasPrev = n.Move.To(), true
+		}
+		number++
+	}
+	return nodes, nil
+}
+
+// parseJkfMove converts a JKF move, which may only give the relative notation of KI2
+// instead of the origin square.
+func parseJkfMove(jm *jkfMoveData, pos *shogi.Position, prevTo uint8, hasPrev bool) (shogi.Move, error) {
+	piece, ok := csaPieces[jm.Piece]
+	if !ok {
+		return shogi.Move(0), fmt.Errorf("unknown piece '%s'", jm.Piece)
+	}
+	if shogi.Color(jm.Color) != pos.Side {
+		return shogi.Move(0), fmt.Errorf("not the turn of %s", pos.Side.Opponent())
+	}
+	var to uint8
+	switch {
+	case jm.To != nil:
+		var err error
+		if to, err = jkfSquare(jm.To); err != nil {
+			return shogi.Move(0), err
+		}
+	case jm.Same && hasPrev:
+		to = prevTo
+	default:
+		return shogi.Move(0), fmt.Errorf("missing destination")
+	}
+
+	if jm.From == nil {
+		var sb strings.Builder
+		sb.WriteString(japaneseSquare(to) + pieceNames[piece])
+		for _, r := range jm.Relative {
+			modifier, ok := jkfRelatives[r]
+			if !ok {
+				return shogi.Move(0), fmt.Errorf("invalid relative '%s'", jm.Relative)
+			}
+			sb.WriteRune(modifier)
+		}
+		if jm.Promote != nil && *jm.Promote {
+			sb.WriteString("成")
+		}
+		return parseKi2Move(sb.String(), pos, prevTo, hasPrev)
+	}
+
+	from, err := jkfSquare(jm.From)
+	if err != nil {
+		return shogi.Move(0), err
+	}
+	usi := shogi.SquareString(from) + shogi.SquareString(to)
+	if jm.Promote != nil && *jm.Promote {
+		usi += "+"
+	}
+	m, err := shogi.ParseMove(pos, usi)
+	if err != nil {
+		return shogi.Move(0), err
+	}
+	if blackPiece(pos.Board[from]) != piece {
+		return shogi.Move(0), fmt.Errorf("no %s on %d%d", jm.Piece, jm.From.X, jm.From.Y)
+	}
+	return m, nil
+}
+
+// jkfSquare returns the square of a place.
+func jkfSquare(p *jkfPlace) (uint8, error) {
+	if p.X < 1 || p.X > shogi.FILES || p.Y < 1 || p.Y > shogi.RANKS {
+		return 0, fmt.Errorf("invalid square {%d, %d}", p.X, p.Y)
+	}
+	return uint8((p.Y-1)*shogi.FILES + shogi.FILES - p.X), nil
+}
+
+// ************************************************************* //
+// ************************ JKF writer ************************* //
+// ************************************************************* //
+
+// WriteJKF writes a game record in JSON Kifu Format. The initial position is written as
+// a preset when it is a standard one, as a board otherwise.
+func WriteJKF(w io.Writer, rec *shogi.Game) error {
+	pos, err := rec.Position()
+	if err != nil {
+		return err
+	}
+
+	jkf := jkfRecord{
+		Header:  jkfHeader(kifHeaders(rec)),
+		Initial: jkfInitialPosition(rec.StartPos, pos),
+		Moves:   []jkfMove{{Comments: jkfComments(rec.Comment), Move: nil, Time: nil, Special: "", Forks: nil}},
+	}
+	if jkf.Header == nil {
+		jkf.Header = jkfHeader{}
+	}
+	state := kifWriterState{prevTo: 0, hasPrev: false, totals: [shogi.COLORS]time.Duration{}}
+	jkf.Moves = append(jkf.Moves, writeJkfLine(pos, rec.Moves, state)...)
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jkf)
+}
+
+// jkfInitialPosition returns the initial position of a record.
+func jkfInitialPosition(sfen string, pos *shogi.Position) *jkfInitial {
+	if name, ok := handicapName(sfen); ok {
+		for _, h := range kifHandicaps {
+			if h.kif == name {
+				return &jkfInitial{Preset: h.jkf, Data: nil}
+			}
+		}
+	}
+
+	state := jkfState{
+		Color: int(pos.Side),
+		Board: make([][]jkfPiece, shogi.FILES),
+		Hands: make([]map[string]int, shogi.COLORS),
+	}
+	for x := range state.Board {
+		state.Board[x] = make([]jkfPiece, shogi.RANKS)
+	}
+	for sq, piece := range pos.Board {
+		if piece == shogi.NoPiece {
+			continue
+		}
+		color := int(piece.Color())
+		x, y := shogi.SquareFile(uint8(sq)), shogi.SquareRank(uint8(sq))
+		state.Board[x-1][y-1] = jkfPiece{Color: &color, Kind: csaPieceName(piece)}
+	}
+	for c := shogi.Black; c <= shogi.White; c++ {
+		state.Hands[c] = make(map[string]int, len(csaHandPieces))
+		for _, hp := range csaHandPieces {
+			state.Hands[c][csaPieceName(hp.piece)] = pos.Hands[c].ByPiece[coloredPiece(hp.piece, c)]
+		}
+	}
+	return &jkfInitial{Preset: "OTHER", Data: &state}
+}
+
+// writeJkfLine converts the moves of a line played from pos, with their variations as forks.
+func writeJkfLine(pos *shogi.Position, nodes []*shogi.GameNode, state kifWriterState) []jkfMove {
+	moves := make([]jkfMove, 0, len(nodes))
+	for _, n := range nodes {
+		jm := jkfMove{Comments: jkfComments(n.Comment), Move: nil, Time: nil, Special: "", Forks: nil}
+		for _, v := range n.Variations {
+			jm.Forks = append(jm.Forks, writeJkfLine(pos.Clone(), v, state))
+		}
+
+		state.totals[pos.Side] += n.Time
+		if n.Time > 0 {
+			now, total := int(n.Time.Seconds()), int(state.totals[pos.Side].Seconds())
+			jm.Time = &jkfTime{
+				Now:   jkfNowTime{M: now / 60, S: now % 60},
+				Total: jkfTotalTime{H: total / 3600, M: total / 60 % 60, S: total % 60},
+			}
+		}
+		if n.Special != "" {
+			jm.Special = string(n.Special)
+		} else {
+			jm.Move = jkfMoveOf(pos, n.Move, state.prevTo, state.hasPrev)
+			pos.DoMove(n.Move)
+			state.prevTo, state.hasPrev = n.Move.To(), true
+		}
+		moves = append(moves, jm)
+	}
+	return moves
+}
+
+// jkfMoveOf returns the JKF description of a move.
+func jkfMoveOf(pos *shogi.Position, m shogi.Move, prevTo uint8, hasPrev bool) *jkfMoveData {
+	to := m.To()
+	jm := &jkfMoveData{
+		From:     nil,
+		To:       &jkfPlace{X: shogi.SquareFile(to), Y: shogi.SquareRank(to)},
+		Color:    int(pos.Side),
+		Piece:    "",
+		Same:     hasPrev && to == prevTo,
+		Promote:  nil,
+		Capture:  "",
+		Relative: "",
+	}
+
+	moves := movegen.LegalMoves(pos)
+	if m.IsDrop() {
+		jm.Piece = csaPieceName(m.Piece())
+		if len(ki2Candidates(pos, moves, m.Piece(), to)) > 0 {
+			jm.Relative = "H"
+		}
+		return jm
+	}
+
+	from := m.From()
+	piece := pos.Board[from]
+	jm.From = &jkfPlace{X: shogi.SquareFile(from), Y: shogi.SquareRank(from)}
+	jm.Piece = csaPieceName(piece)
+	if canPromote(pos, piece, from, to) {
+		promote := m.IsPromotion()
+		jm.Promote = &promote
+	}
+	if m.IsCapture() {
+		jm.Capture = csaPieceName(m.Piece())
+	}
+	for _, r := range ki2Modifiers(pos, ki2Candidates(pos, moves, piece, to), from, to) {
+		for letter, modifier := range jkfRelatives {
+			if modifier == r {
+				jm.Relative += string(letter)
+			}
+		}
+	}
+	return jm
+}
+
+// jkfComments returns the lines of a comment.
+func jkfComments(comment string) []string {
+	if comment == "" {
+		return nil
+	}
+	return strings.Split(comment, "\n")
+}
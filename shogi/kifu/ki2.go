@@ -0,0 +1,455 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package kifu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/movegen"
+)
+
+// KI2 moves are written with the relative notation of the Japan Shogi Association: when
+// several pieces of the same kind can move to the destination, the move is completed with
+// the movement of the piece (上 up, 引 down, 寄 sideways), then with its position seen
+// from its owner (右 right, 左 left, 直 straight up), 打 distinguishing a drop.
+
+// ************************************************************* //
+// ************************ KI2 reader ************************* //
+// ************************************************************* //
+
+// ReadKI2 reads a game record in KI2 format. Headers, start position, comments and
+// variations are read as in KIF, KI2 having no time.
+func ReadKI2(r io.Reader) (*shogi.Game, error) {
+	r, err := utf8Text(r)
+	if err != nil {
+		return nil, fmt.Errorf("KI2: %w", err)
+	}
+	p := newKifParser()
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if lineno == 1 {
+			text = strings.TrimPrefix(text, "\ufeff") // byte order mark
+		}
+		if err := parseKi2Line(p, text); err != nil {
+			return nil, fmt.Errorf("KI2 line %d: %w", lineno, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p.record()
+}
+
+// ki2MoveRegexp matches a move and its side mark.
+var ki2MoveRegexp = regexp.MustCompile(`([▲△☗☖▽])([^▲△☗☖▽]+)`)
+
+// ki2ResultRegexp matches the conclusion of a game.
+var ki2ResultRegexp = regexp.MustCompile(`^まで(\d+)手で(.+)$`)
+
+func parseKi2Line(p *kifParser, text string) error {
+	trimmed := strings.TrimSpace(text)
+	first, _ := utf8.DecodeRuneInString(trimmed)
+	switch {
+	case strings.ContainsRune("▲△☗☖▽", first):
+		if err := p.startMoves(); err != nil {
+			return err
+		}
+		for _, match := range ki2MoveRegexp.FindAllStringSubmatch(trimmed, -1) {
+			side := shogi.Black
+			if match[1] == "△" || match[1] == "☖" || match[1] == "▽" {
+				side = shogi.White
+			}
+			if side != p.pos.Side {
+				return fmt.Errorf("move '%s' is not played by the side to move", match[0])
+			}
+			m, err := parseKi2Move(strings.Trim(match[2], " 　"), p.pos, p.prevTo, p.hasPrev)
+			if err != nil {
+				return err
+			}
+			if err := p.appendNode(shogi.NewMoveNode(m)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case strings.HasPrefix(trimmed, "まで"):
+		if err := p.startMoves(); err != nil {
+			return err
+		}
+		match := ki2ResultRegexp.FindStringSubmatch(trimmed)
+		if match == nil {
+			return fmt.Errorf("invalid result '%s'", trimmed)
+		}
+		if n, _ := strconv.Atoi(match[1]); n != p.current.start+len(p.current.nodes)-1 {
+			return fmt.Errorf("result after %d moves, found %d moves", n, p.current.start+len(p.current.nodes)-1)
+		}
+		special, err := parseResult(match[2], p.pos.Side)
+		if err != nil {
+			return err
+		}
+		return p.appendNode(shogi.NewSpecialNode(special))
+	}
+	return p.parseLine(text)
+}
+
+// parseResult returns the special move ending a game from the conclusion of a KI2 record,
+// e.g. "先手の勝ち" for "まで77手で先手の勝ち", side being the side to move.
+func parseResult(text string, side shogi.Color) (shogi.SpecialMove, error) {
+	color := shogi.NoColor
+	switch {
+	case strings.HasPrefix(text, "先手") || strings.HasPrefix(text, "下手"):
+		color = shogi.Black
+	case strings.HasPrefix(text, "後手") || strings.HasPrefix(text, "上手"):
+		color = shogi.White
+	}
+
+	switch {
+	case strings.Contains(text, "時間切れ"):
+		return shogi.SpecialTimeUp, nil
+	case strings.HasSuffix(text, "反則負け") && color == side:
+		return shogi.SpecialIllegalMove, nil
+	case strings.HasSuffix(text, "反則負け") && color == shogi.Black:
+		return shogi.SpecialBlackIllegalAction, nil
+	case strings.HasSuffix(text, "反則負け") && color == shogi.White:
+		return shogi.SpecialWhiteIllegalAction, nil
+	case strings.HasSuffix(text, "入玉勝ち"):
+		return shogi.SpecialKachi, nil
+	case strings.HasSuffix(text, "の勝ち"):
+		return shogi.SpecialResign, nil
+	}
+	if special, ok := parseSpecial(text, side); ok {
+		return special, nil
+	}
+	return "", fmt.Errorf("unknown result '%s'", text)
+}
+
+// parseKi2Move parses a move like "７六歩", "同　銀", "５八金右", "２二角成" or "４五角打".
+func parseKi2Move(text string, pos *shogi.Position, prevTo uint8, hasPrev bool) (shogi.Move, error) {
+	var to uint8
+	rest := text
+	if same, ok := trimSameSquare(rest); ok {
+		if !hasPrev {
+			return shogi.Move(0), fmt.Errorf("invalid move '%s': no previous move", text)
+		}
+		to, rest = prevTo, same
+	} else {
+		var err error
+		if to, rest, err = parseJapaneseSquare(rest); err != nil {
+			return shogi.Move(0), fmt.Errorf("invalid move '%s': %w", text, err)
+		}
+	}
+
+	// piece name, the longest one first as promoted pieces can have two characters
+	runes := []rune(rest)
+	piece, ok := shogi.NoPiece, false
+	for n := min(2, len(runes)); n > 0 && !ok; n-- {
+		if piece, ok = namePieces[string(runes[:n])]; ok {
+			rest = string(runes[n:])
+		}
+	}
+	if !ok {
+		return shogi.Move(0), fmt.Errorf("invalid move '%s': unknown piece", text)
+	}
+	piece = coloredPiece(piece, pos.Side)
+
+	promote := strings.HasSuffix(rest, "成") && !strings.HasSuffix(rest, "不成")
+	rest = strings.TrimSuffix(strings.TrimSuffix(rest, "不成"), "成")
+	drop := strings.HasSuffix(rest, "打")
+	rest = strings.TrimSuffix(rest, "打")
+	if strings.Trim(rest, "右左直上引寄行") != "" {
+		return shogi.Move(0), fmt.Errorf("invalid move '%s': unknown modifier '%s'", text, rest)
+	}
+
+	moves := movegen.LegalMoves(pos)
+	candidates := ki2Candidates(pos, moves, piece, to)
+	if drop || (len(candidates) == 0 && rest == "" && !promote) {
+		for _, m := range moves {
+			if m.IsDrop() && m.Piece() == piece && m.To() == to {
+				return m, nil
+			}
+		}
+		return shogi.Move(0), fmt.Errorf("illegal move '%s': %s can't be dropped", text, pieceName(piece))
+	}
+
+	var matching []shogi.Move
+	for _, m := range moves {
+		if m.IsDrop() || m.To() != to || pos.Board[m.From()] != piece || m.IsPromotion() != promote {
+			continue
+		}
+		matching = append(matching, m)
+	}
+
+	// filter by movement, then by position
+	for _, modifier := range []rune(rest) {
+		switch modifier {
+		case '上', '行', '引', '寄':
+			if modifier == '行' {
+				modifier = '上'
+			}
+			matching = filterMoves(matching, func(m shogi.Move) bool { return movement(pos.Side, m.From(), to) == string(modifier) })
+		case '直':
+			matching = filterMoves(matching, func(m shogi.Move) bool { return isStraightUp(pos.Side, m.From(), to) })
+		}
+	}
+	for _, modifier := range []rune(rest) {
+		if modifier == '右' || modifier == '左' {
+			squares := make([]uint8, len(matching))
+			for i, m := range matching {
+				squares[i] = m.From()
+			}
+			matching = filterMoves(matching, func(m shogi.Move) bool {
+				return horizontalPosition(pos.Side, m.From(), squares) == string(modifier)
+			})
+		}
+	}
+
+	switch len(matching) {
+	case 0:
+		return shogi.Move(0), fmt.Errorf("illegal move '%s'", text)
+	case 1:
+		return matching[0], nil
+	}
+	return shogi.Move(0), fmt.Errorf("ambiguous move '%s'", text)
+}
+
+// filterMoves returns the moves satisfying a condition.
+func filterMoves(moves []shogi.Move, keep func(shogi.Move) bool) []shogi.Move {
+	var kept []shogi.Move
+	for _, m := range moves {
+		if keep(m) {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// ************************************************************* //
+// ************************ KI2 writer ************************* //
+// ************************************************************* //
+
+// ki2MovesByLine is the maximum count of moves written on a line.
+const ki2MovesByLine = 6
+
+// WriteKI2 writes a game record in KI2 format. Times are not written, the format
+// having no place for them.
+func WriteKI2(w io.Writer, rec *shogi.Game) error {
+	pos, err := rec.Position()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	writeKifHeaders(bw, rec, pos)
+	writeKifComment(bw, rec.Comment)
+	kw := ki2Writer{w: bw, names: playerNames(rec.StartPos)}
+	kw.writeLine(pos, rec.Moves, 1, 0, false)
+	return bw.Flush()
+}
+
+type ki2Writer struct {
+	w     *bufio.Writer
+	names [shogi.COLORS]string
+}
+
+// writeLine writes the moves of a line from pos, then its variations in the same order as KIF.
+func (kw *ki2Writer) writeLine(pos *shogi.Position, nodes []*shogi.GameNode, number int, prevTo uint8, hasPrev bool) {
+	type branch struct {
+		pos     *shogi.Position
+		number  int
+		prevTo  uint8
+		hasPrev bool
+		node    *shogi.GameNode
+	}
+	var branches []branch
+
+	var line strings.Builder
+	count := 0
+	flush := func() {
+		if count > 0 {
+			fmt.Fprintln(kw.w, strings.TrimRight(line.String(), " "))
+		}
+		line.Reset()
+		count = 0
+	}
+	for _, n := range nodes {
+		if len(n.Variations) > 0 {
+			branches = append(branches, branch{pos: pos.Clone(), number: number, prevTo: prevTo, hasPrev: hasPrev, node: n})
+		}
+		if n.Special != "" {
+			flush()
+			fmt.Fprintln(kw.w, resultText(n.Special, pos.Side, number-1, kw.names))
+			writeKifComment(kw.w, n.Comment)
+			break
+		}
+
+		mark := "▲"
+		if pos.Side == shogi.White {
+			mark = "△"
+		}
+		text := mark + ki2Move(pos, n.Move, prevTo, hasPrev)
+		line.WriteString(text + strings.Repeat(" ", max(12-displayWidth(text), 1)))
+		count++
+		if count == ki2MovesByLine || n.Comment != "" {
+			flush()
+		}
+		writeKifComment(kw.w, n.Comment)
+
+		pos.DoMove(n.Move)
+		prevTo, hasPrev = n.Move.To(), true
+		number++
+	}
+	flush()
+
+	for i := len(branches) - 1; i >= 0; i-- {
+		b := branches[i]
+		for _, v := range b.node.Variations {
+			fmt.Fprintf(kw.w, "\n変化：%d手\n", b.number)
+			kw.writeLine(b.pos.Clone(), v, b.number, b.prevTo, b.hasPrev)
+		}
+	}
+}
+
+// resultText returns the conclusion of a game, e.g. "まで77手で先手の勝ち", side being
+// the side to move when the game ended.
+func resultText(special shogi.SpecialMove, side shogi.Color, moves int, names [shogi.COLORS]string) string {
+	switch special { //nolint:exhaustive
+	case shogi.SpecialResign:
+		return fmt.Sprintf("まで%d手で%sの勝ち", moves, names[side.Opponent()])
+	case shogi.SpecialTimeUp:
+		return fmt.Sprintf("まで%d手で時間切れにより%sの勝ち", moves, names[side.Opponent()])
+	case shogi.SpecialIllegalMove:
+		return fmt.Sprintf("まで%d手で%sの反則負け", moves, names[side])
+	case shogi.SpecialBlackIllegalAction:
+		return fmt.Sprintf("まで%d手で%sの反則負け", moves, names[shogi.Black])
+	case shogi.SpecialWhiteIllegalAction:
+		return fmt.Sprintf("まで%d手で%sの反則負け", moves, names[shogi.White])
+	case shogi.SpecialKachi:
+		return fmt.Sprintf("まで%d手で%sの入玉勝ち", moves, names[side])
+	}
+	return fmt.Sprintf("まで%d手で%s", moves, specialName(special, side))
+}
+
+// ki2Move returns a move in KI2 notation, without the side mark.
+func ki2Move(pos *shogi.Position, m shogi.Move, prevTo uint8, hasPrev bool) string {
+	var sb strings.Builder
+	if hasPrev && m.To() == prevTo {
+		sb.WriteString("同　")
+	} else {
+		sb.WriteString(japaneseSquare(m.To()))
+	}
+
+	moves := movegen.LegalMoves(pos)
+	if m.IsDrop() {
+		sb.WriteString(pieceName(m.Piece()))
+		if len(ki2Candidates(pos, moves, m.Piece(), m.To())) > 0 {
+			sb.WriteString("打")
+		}
+		return sb.String()
+	}
+
+	from := m.From()
+	piece := pos.Board[from]
+	sb.WriteString(pieceName(piece))
+	sb.WriteString(ki2Modifiers(pos, ki2Candidates(pos, moves, piece, m.To()), from, m.To()))
+	switch {
+	case m.IsPromotion():
+		sb.WriteString("成")
+	case canPromote(pos, piece, from, m.To()):
+		sb.WriteString("不成")
+	}
+	return sb.String()
+}
+
+// ki2Candidates returns the squares of the pieces which can move to a square.
+func ki2Candidates(pos *shogi.Position, moves []shogi.Move, piece shogi.Piece, to uint8) []uint8 {
+	var squares []uint8
+	for _, m := range moves {
+		if m.IsDrop() || m.To() != to || pos.Board[m.From()] != piece {
+			continue
+		}
+		if len(squares) == 0 || squares[len(squares)-1] != m.From() { // promotion and non promotion are generated together
+			squares = append(squares, m.From())
+		}
+	}
+	return squares
+}
+
+// ki2Modifiers returns the minimal relative notation distinguishing the move of the piece
+// on from among the pieces of the same kind on candidates moving to the same square.
+func ki2Modifiers(pos *shogi.Position, candidates []uint8, from, to uint8) string {
+	if len(candidates) <= 1 {
+		return ""
+	}
+
+	mv := movement(pos.Side, from, to)
+	var sameMovement []uint8
+	for _, sq := range candidates {
+		if movement(pos.Side, sq, to) == mv {
+			sameMovement = append(sameMovement, sq)
+		}
+	}
+	if len(sameMovement) == 1 {
+		return mv
+	}
+
+	piece := blackPiece(pos.Board[from])
+	if piece != shogi.BlackPromotedRook && piece != shogi.BlackPromotedBishop && isStraightUp(pos.Side, from, to) {
+		return "直"
+	}
+	if position := horizontalPosition(pos.Side, from, candidates); position != "" {
+		return position
+	}
+	return horizontalPosition(pos.Side, from, sameMovement) + mv
+}
+
+// movement returns the movement of a piece seen from its owner: "上" up, "引" down or "寄" sideways.
+func movement(side shogi.Color, from, to uint8) string {
+	up := shogi.SquareRank(to) - shogi.SquareRank(from)
+	if side == shogi.Black {
+		up = -up
+	}
+	switch {
+	case up > 0:
+		return "上"
+	case up < 0:
+		return "引"
+	}
+	return "寄"
+}
+
+// isStraightUp returns true for a move one square straight up, seen from the owner.
+func isStraightUp(side shogi.Color, from, to uint8) bool {
+	return shogi.SquareFile(from) == shogi.SquareFile(to) && movement(side, from, to) == "上"
+}
+
+// horizontalPosition returns "右" if from is the rightmost square of the candidates seen
+// from the owner, "左" if it is the leftmost one, an empty string otherwise.
+func horizontalPosition(side shogi.Color, from uint8, candidates []uint8) string {
+	right, left := true, true
+	for _, sq := range candidates {
+		if sq == from {
+			continue
+		}
+		// from Black's side the right is file 1, from White's side file 9
+		diff := shogi.SquareFile(from) - shogi.SquareFile(sq)
+		if side == shogi.White {
+			diff = -diff
+		}
+		right = right && diff < 0
+		left = left && diff > 0
+	}
+	switch {
+	case right:
+		return "右"
+	case left:
+		return "左"
+	}
+	return ""
+}
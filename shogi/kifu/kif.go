@@ -0,0 +1,648 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package kifu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// ************************************************************* //
+// ************************ KIF reader ************************* //
+// ************************************************************* //
+
+// ReadKIF reads a game record in KIF format: headers, handicap or board diagram, moves
+// with their time and comments, and variations.
+func ReadKIF(r io.Reader) (*shogi.Game, error) {
+	r, err := utf8Text(r)
+	if err != nil {
+		return nil, fmt.Errorf("KIF: %w", err)
+	}
+	p := newKifParser()
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if lineno == 1 {
+			text = strings.TrimPrefix(text, "\ufeff") // byte order mark
+		}
+		if err := p.parseLine(text); err != nil {
+			return nil, fmt.Errorf("KIF line %d: %w", lineno, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p.record()
+}
+
+// kifMoveRegexp matches a move line: number, move, optional time and variation mark.
+var kifMoveRegexp = regexp.MustCompile(`^\s*(\d+)\s+(\S.*?)\s*(?:\(\s*(\d+):(\d+)(?:/\s*\d+:\d+:\d+)?\))?\s*\+?\s*$`)
+
+// kifVariationRegexp matches the beginning of a variation.
+var kifVariationRegexp = regexp.MustCompile(`^変化：\s*(\d+)手`)
+
+// A kifLine is a line of moves being read, the main line or a variation.
+type kifLine struct {
+	nodes  []*shogi.GameNode
+	start  int             // move number of the first node
+	pos    *shogi.Position // position before the first node
+	parent *shogi.GameNode // node the line is a variation of, nil for the main line
+}
+
+// contains returns true if the line has a node for a move number.
+func (l *kifLine) contains(number int) bool {
+	return number >= l.start && number < l.start+len(l.nodes)
+}
+
+// positionAt returns the position of the line before a move number.
+func (l *kifLine) positionAt(number int) *shogi.Position {
+	pos := l.pos.Clone()
+	for _, n := range l.nodes[:number-l.start] {
+		pos.DoMove(n.Move)
+	}
+	return pos
+}
+
+type kifParser struct {
+	rec      *shogi.Game
+	handicap string        // 手合割 header
+	diagram  *boardDiagram // board diagram of the start position, nil if missing
+	lines    []*kifLine    // lines in reading order, the first one being the main line
+	current  *kifLine      // line being read
+	pos      *shogi.Position
+	prevTo   uint8 // destination of the previous move, for 同
+	hasPrev  bool
+}
+
+func newKifParser() *kifParser {
+	return &kifParser{
+		rec:      shogi.NewGame(shogi.StartPos),
+		handicap: "",
+		diagram:  nil,
+		lines:    nil,
+		current:  nil,
+		pos:      nil,
+		prevTo:   0,
+		hasPrev:  false,
+	}
+}
+
+func (p *kifParser) parseLine(text string) error {
+	trimmed := strings.TrimSpace(text)
+	switch {
+	case trimmed == "", strings.HasPrefix(trimmed, "#"), strings.HasPrefix(trimmed, "&"),
+		strings.HasPrefix(trimmed, "まで"), strings.HasPrefix(trimmed, "手数"):
+		return nil
+	case strings.HasPrefix(trimmed, "*"):
+		p.addComment(strings.TrimPrefix(trimmed, "*"))
+		return nil
+	case strings.HasPrefix(trimmed, "変化"):
+		return p.parseVariation(trimmed)
+	case kifMoveRegexp.MatchString(text):
+		return p.parseMoveLine(text)
+	case p.current == nil && isDiagramLine(trimmed):
+		return p.boardDiagram().parseLine(trimmed)
+	case p.current == nil && strings.Contains(trimmed, "："):
+		key, value, _ := strings.Cut(trimmed, "：")
+		value = strings.TrimRight(value, " 　")
+		switch {
+		case key == "手合割":
+			p.handicap = value
+		case strings.HasSuffix(key, "の持駒"):
+			return p.boardDiagram().parseHand(key, value)
+		default:
+			setKifHeader(&p.rec.Header, key, value)
+		}
+	}
+	return nil
+}
+
+// boardDiagram returns the board diagram being read, creating it if needed.
+func (p *kifParser) boardDiagram() *boardDiagram {
+	if p.diagram == nil {
+		p.diagram = newBoardDiagram()
+	}
+	return p.diagram
+}
+
+// addComment adds a comment line to the last move read, or to the record before the first move.
+func (p *kifParser) addComment(comment string) {
+	switch {
+	case p.current == nil || (p.current.parent == nil && len(p.current.nodes) == 0):
+		p.rec.Comment = joinComment(p.rec.Comment, comment)
+	case len(p.current.nodes) > 0:
+		n := p.current.nodes[len(p.current.nodes)-1]
+		n.Comment = joinComment(n.Comment, comment)
+	}
+}
+
+// joinComment appends a line to a comment.
+func joinComment(comment, line string) string {
+	if comment == "" {
+		return line
+	}
+	return comment + "\n" + line
+}
+
+// startMoves creates the main line from the start position found in the headers.
+func (p *kifParser) startMoves() error {
+	if p.current != nil {
+		return nil
+	}
+
+	var err error
+	switch {
+	case p.diagram != nil && p.diagram.rank > 0:
+		p.rec.StartPos, err = p.diagram.sfen()
+	case p.handicap != "":
+		p.rec.StartPos, err = handicapSfen(p.handicap)
+	}
+	if err != nil {
+		return err
+	}
+	pos, err := p.rec.Position()
+	if err != nil {
+		return err
+	}
+
+	p.current = &kifLine{nodes: nil, start: 1, pos: pos, parent: nil}
+	p.lines = append(p.lines, p.current)
+	p.pos = pos.Clone()
+	return nil
+}
+
+// parseVariation starts a variation, which replaces the move of the same number in the
+// last line read containing it.
+func (p *kifParser) parseVariation(text string) error {
+	if err := p.startMoves(); err != nil {
+		return err
+	}
+	match := kifVariationRegexp.FindStringSubmatch(text)
+	if match == nil {
+		return fmt.Errorf("invalid variation '%s'", text)
+	}
+	number, _ := strconv.Atoi(match[1])
+
+	for i := len(p.lines) - 1; i >= 0; i-- {
+		l := p.lines[i]
+		if !l.contains(number) {
+			continue
+		}
+		variation := &kifLine{nodes: nil, start: number, pos: nil, parent: nil}
+		if l.start == number && l.parent != nil { // alternative to a variation: both are variations of the same move
+			variation.pos, variation.parent = l.pos, l.parent
+		} else {
+			variation.pos, variation.parent = l.positionAt(number), l.nodes[number-l.start]
+		}
+		p.lines = append(p.lines, variation)
+		p.current = variation
+		p.pos = variation.pos.Clone()
+		p.hasPrev = false
+		if number > l.start {
+			p.prevTo, p.hasPrev = l.nodes[number-l.start-1].Move.To(), true
+		}
+		return nil
+	}
+	return fmt.Errorf("no move %d for variation", number)
+}
+
+func (p *kifParser) parseMoveLine(text string) error {
+	if err := p.startMoves(); err != nil {
+		return err
+	}
+	match := kifMoveRegexp.FindStringSubmatch(text)
+	number, _ := strconv.Atoi(match[1])
+	if expected := p.current.start + len(p.current.nodes); number != expected {
+		return fmt.Errorf("expected move %d, found %d", expected, number)
+	}
+
+	var n *shogi.GameNode
+	if special, ok := parseSpecial(match[2], p.pos.Side); ok {
+		n = shogi.NewSpecialNode(special)
+	} else {
+		m, err := parseKifMove(match[2], p.pos, p.prevTo, p.hasPrev)
+		if err != nil {
+			return err
+		}
+		n = shogi.NewMoveNode(m)
+	}
+	if match[3] != "" {
+		minutes, _ := strconv.Atoi(match[3])
+		seconds, _ := strconv.Atoi(match[4])
+		n.Time = time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+	}
+	return p.appendNode(n)
+}
+
+// appendNode appends a node to the line being read and plays its move.
+func (p *kifParser) appendNode(n *shogi.GameNode) error {
+	l := p.current
+	if len(l.nodes) > 0 && l.nodes[len(l.nodes)-1].Special != "" {
+		return fmt.Errorf("move %d follows the end of the game", l.start+len(l.nodes))
+	}
+	if n.Special == "" {
+		p.pos.DoMove(n.Move)
+		p.prevTo, p.hasPrev = n.Move.To(), true
+	}
+	l.nodes = append(l.nodes, n)
+	return nil
+}
+
+// parseKifMove parses a move like "７六歩(77)", "同　銀(31)", "２二角成(88)" or "４五角打".
+func parseKifMove(text string, pos *shogi.Position, prevTo uint8, hasPrev bool) (shogi.Move, error) {
+	var to uint8
+	rest := text
+	if same, ok := trimSameSquare(rest); ok {
+		if !hasPrev {
+			return shogi.Move(0), fmt.Errorf("invalid move '%s': no previous move", text)
+		}
+		to, rest = prevTo, same
+	} else {
+		var err error
+		if to, rest, err = parseJapaneseSquare(rest); err != nil {
+			return shogi.Move(0), fmt.Errorf("invalid move '%s': %w", text, err)
+		}
+	}
+
+	from := ""
+	if i := strings.Index(rest, "("); i >= 0 {
+		src := strings.TrimSuffix(rest[i+1:], ")")
+		if len(src) != 2 || src[0] < '1' || src[0] > '9' || src[1] < '1' || src[1] > '9' {
+			return shogi.Move(0), fmt.Errorf("invalid move '%s': bad origin square", text)
+		}
+		from, rest = fmt.Sprintf("%c%c", src[0], 'a'+src[1]-'1'), rest[:i]
+	}
+
+	drop := strings.HasSuffix(rest, "打")
+	rest = strings.TrimSuffix(rest, "打")
+	promote := false
+	if _, ok := namePieces[rest]; !ok {
+		switch {
+		case strings.HasSuffix(rest, "不成"):
+			rest = strings.TrimSuffix(rest, "不成")
+		case strings.HasSuffix(rest, "成"):
+			rest, promote = strings.TrimSuffix(rest, "成"), true
+		}
+	}
+	piece, ok := namePieces[rest]
+	if !ok {
+		return shogi.Move(0), fmt.Errorf("invalid move '%s': unknown piece '%s'", text, rest)
+	}
+
+	usi := strings.ToUpper(piece.String()) + "*" + shogi.SquareString(to)
+	if from != "" && !drop {
+		usi = from + shogi.SquareString(to)
+		if promote {
+			usi += "+"
+		}
+	}
+	m, err := shogi.ParseMove(pos, usi)
+	if err != nil {
+		return shogi.Move(0), fmt.Errorf("move '%s': %w", text, err)
+	}
+	if !m.IsDrop() && blackPiece(pos.Board[m.From()]) != piece {
+		return shogi.Move(0), fmt.Errorf("move '%s': no %s on %s", text, rest, from)
+	}
+	return m, nil
+}
+
+// record returns the game read, linking the variations to their parent move.
+func (p *kifParser) record() (*shogi.Game, error) {
+	if err := p.startMoves(); err != nil {
+		return nil, err
+	}
+	p.rec.Moves = p.lines[0].nodes
+	for _, l := range p.lines[1:] {
+		if len(l.nodes) > 0 {
+			l.parent.Variations = append(l.parent.Variations, l.nodes)
+		}
+	}
+	return p.rec, nil
+}
+
+// ************************************************************* //
+// ************************ KIF writer ************************* //
+// ************************************************************* //
+
+// WriteKIF writes a game record in KIF format. The start position is written as a
+// handicap when it is a standard one, as a board diagram otherwise.
+func WriteKIF(w io.Writer, rec *shogi.Game) error {
+	pos, err := rec.Position()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	writeKifHeaders(bw, rec, pos)
+	fmt.Fprintln(bw, "手数----指手---------消費時間--")
+	writeKifComment(bw, rec.Comment)
+	kw := kifWriter{w: bw, names: playerNames(rec.StartPos)}
+	kw.writeLine(pos, rec.Moves, 1, kifWriterState{prevTo: 0, hasPrev: false, totals: [shogi.COLORS]time.Duration{}}, true)
+	return bw.Flush()
+}
+
+// writeKifHeaders writes the headers and the start position of a record, shared by KIF and KI2.
+func writeKifHeaders(w io.Writer, rec *shogi.Game, pos *shogi.Position) {
+	names := playerNames(rec.StartPos)
+	handicap, isStandard := handicapName(rec.StartPos)
+	written := false
+	for _, h := range kifHeaders(rec) {
+		if !written && isStandard && (h.Key == names[shogi.Black] || h.Key == names[shogi.White]) {
+			fmt.Fprintf(w, "手合割：%s\n", handicap)
+			written = true
+		}
+		fmt.Fprintf(w, "%s：%s\n", h.Key, h.Value)
+	}
+	switch {
+	case !isStandard:
+		writeBoardDiagram(w, pos)
+	case !written:
+		fmt.Fprintf(w, "手合割：%s\n", handicap)
+	}
+}
+
+type kifWriter struct {
+	w     *bufio.Writer
+	names [shogi.COLORS]string
+}
+
+// kifWriterState is the state needed to write a line from one of its moves.
+type kifWriterState struct {
+	prevTo  uint8
+	hasPrev bool
+	totals  [shogi.COLORS]time.Duration // time consumed by color
+}
+
+// writeLine writes the moves of a line from pos, then its variations from the last move to
+// the first, so that a reader attaches each variation to the last line containing its move number.
+func (kw *kifWriter) writeLine(pos *shogi.Position, nodes []*shogi.GameNode, number int, state kifWriterState, mainLine bool) {
+	type branch struct {
+		pos    *shogi.Position
+		number int
+		state  kifWriterState
+		node   *shogi.GameNode
+	}
+	var branches []branch
+
+	for _, n := range nodes {
+		if len(n.Variations) > 0 {
+			branches = append(branches, branch{pos: pos.Clone(), number: number, state: state, node: n})
+		}
+
+		var text string
+		if n.Special != "" {
+			text = specialName(n.Special, pos.Side)
+		} else {
+			text = kifMove(pos, n.Move, state.prevTo, state.hasPrev)
+		}
+		state.totals[pos.Side] += n.Time
+		mark := ""
+		if len(n.Variations) > 0 {
+			mark = "+"
+		}
+		padding := max(14-displayWidth(text), 1)
+		fmt.Fprintf(kw.w, "%4d %s%s(%s/%s)%s\n", number, text, strings.Repeat(" ", padding),
+			formatMoveTime(n.Time), formatTotalTime(state.totals[pos.Side]), mark)
+		writeKifComment(kw.w, n.Comment)
+
+		if n.Special == "" {
+			pos.DoMove(n.Move)
+			state.prevTo, state.hasPrev = n.Move.To(), true
+		}
+		number++
+	}
+
+	if mainLine && len(nodes) > 0 {
+		kw.writeResult(pos, nodes[len(nodes)-1].Special, number-2)
+	}
+
+	for i := len(branches) - 1; i >= 0; i-- {
+		b := branches[i]
+		for _, v := range b.node.Variations {
+			fmt.Fprintf(kw.w, "\n変化：%d手\n", b.number)
+			kw.writeLine(b.pos.Clone(), v, b.number, b.state, false)
+		}
+	}
+}
+
+// writeResult writes the conclusion of the game after the last move of the main line.
+func (kw *kifWriter) writeResult(pos *shogi.Position, special shogi.SpecialMove, moves int) {
+	switch special { //nolint:exhaustive
+	case shogi.SpecialResign, shogi.SpecialTsumi:
+		fmt.Fprintf(kw.w, "まで%d手で%sの勝ち\n", moves, kw.names[pos.Side.Opponent()])
+	case shogi.SpecialTimeUp:
+		fmt.Fprintf(kw.w, "まで%d手で時間切れにより%sの勝ち\n", moves, kw.names[pos.Side.Opponent()])
+	case shogi.SpecialAbort, shogi.SpecialSennichite, shogi.SpecialJishogi:
+		fmt.Fprintf(kw.w, "まで%d手で%s\n", moves, specialName(special, pos.Side))
+	}
+}
+
+// kifMove returns a move in KIF notation.
+func kifMove(pos *shogi.Position, m shogi.Move, prevTo uint8, hasPrev bool) string {
+	var sb strings.Builder
+	if hasPrev && m.To() == prevTo {
+		sb.WriteString("同　")
+	} else {
+		sb.WriteString(japaneseSquare(m.To()))
+	}
+
+	if m.IsDrop() {
+		sb.WriteString(pieceName(m.Piece()) + "打")
+		return sb.String()
+	}
+
+	from := m.From()
+	piece := pos.Board[from]
+	sb.WriteString(pieceName(piece))
+	switch {
+	case m.IsPromotion():
+		sb.WriteString("成")
+	case canPromote(pos, piece, from, m.To()):
+		sb.WriteString("不成")
+	}
+	fmt.Fprintf(&sb, "(%d%d)", shogi.SquareFile(from), shogi.SquareRank(from))
+	return sb.String()
+}
+
+// canPromote returns true if a piece moving between two squares can be promoted.
+func canPromote(pos *shogi.Position, piece shogi.Piece, from, to uint8) bool {
+	zone := pos.Variant.PromotionZone(piece.Color())
+	return piece.Promote() != piece && (zone.Bit(uint(from)) == 1 || zone.Bit(uint(to)) == 1)
+}
+
+// writeKifComment writes the lines of a comment.
+func writeKifComment(w io.Writer, comment string) {
+	if comment == "" {
+		return
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		fmt.Fprintf(w, "*%s\n", line)
+	}
+}
+
+// formatMoveTime formats the time of a move as " m:ss".
+func formatMoveTime(d time.Duration) string {
+	seconds := int(d.Seconds())
+	return fmt.Sprintf("%2d:%02d", seconds/60, seconds%60)
+}
+
+// formatTotalTime formats a total time as "hh:mm:ss".
+func formatTotalTime(d time.Duration) string {
+	seconds := int(d.Seconds())
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
+
+// displayWidth returns the width of a string on a terminal, full width characters counting for 2.
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if utf8.RuneLen(r) > 1 {
+			width += 2
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+// ************************************************************* //
+// *********************** Board diagram *********************** //
+// ************************************************************* //
+
+// A boardDiagram is the start position of a KIF record given as a board drawing (BOD).
+type boardDiagram struct {
+	pos  *shogi.Position
+	rank int // next rank to read
+}
+
+func newBoardDiagram() *boardDiagram {
+	pos, _ := shogi.NewPositionFromSfen("9/9/9/9/9/9/9/9/9 b - 1")
+	return &boardDiagram{pos: pos, rank: 0}
+}
+
+// isDiagramLine returns true for the lines of a board diagram, hands excepted.
+func isDiagramLine(text string) bool {
+	return strings.HasPrefix(text, "|") || strings.HasPrefix(text, "+--") || strings.HasPrefix(text, "９") ||
+		strings.HasSuffix(text, "手番")
+}
+
+func (d *boardDiagram) parseLine(text string) error {
+	switch {
+	case text == "先手番" || text == "下手番":
+		d.pos.Side = shogi.Black
+	case text == "後手番" || text == "上手番":
+		d.pos.Side = shogi.White
+	case strings.HasPrefix(text, "|"):
+		if d.rank >= shogi.RANKS {
+			return fmt.Errorf("board diagram has more than %d ranks", shogi.RANKS)
+		}
+		cells := []rune(strings.TrimPrefix(text, "|"))
+		if len(cells) < 2*shogi.FILES {
+			return fmt.Errorf("board diagram rank %d is too short", d.rank+1)
+		}
+		for file := 0; file < shogi.FILES; file++ {
+			prefix, char := cells[2*file], string(cells[2*file+1])
+			if char == "・" {
+				continue
+			}
+			piece, ok := namePieces[char]
+			if !ok {
+				return fmt.Errorf("board diagram rank %d: unknown piece '%s'", d.rank+1, char)
+			}
+			if prefix == 'v' {
+				piece = coloredPiece(piece, shogi.White)
+			}
+			d.pos.SetPiece(piece, uint8(d.rank*shogi.FILES+file))
+		}
+		d.rank++
+	}
+	return nil
+}
+
+// parseHand parses a hand like "先手の持駒：飛　歩二".
+func (d *boardDiagram) parseHand(key, value string) error {
+	c := shogi.Black
+	if strings.HasPrefix(key, "後手") || strings.HasPrefix(key, "上手") {
+		c = shogi.White
+	}
+	if value == "なし" {
+		return nil
+	}
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == '　' }) {
+		runes := []rune(item)
+		piece, ok := namePieces[string(runes[0])]
+		if !ok || piece.Promote() == piece && piece != shogi.BlackGold {
+			return fmt.Errorf("invalid piece in hand '%s'", item)
+		}
+		n := 1
+		if len(runes) > 1 {
+			var err error
+			if n, err = parseKanjiNumber(string(runes[1:])); err != nil {
+				return err
+			}
+		}
+		d.pos.Hands[c].SetCount(coloredPiece(piece, c), n)
+	}
+	return nil
+}
+
+// sfen returns the SFEN string of the board diagram.
+func (d *boardDiagram) sfen() (string, error) {
+	if d.rank != shogi.RANKS {
+		return "", fmt.Errorf("board diagram has %d ranks", d.rank)
+	}
+	return d.pos.Sfen(), nil
+}
+
+// writeBoardDiagram writes a position as a board diagram.
+func writeBoardDiagram(w io.Writer, pos *shogi.Position) {
+	writeDiagramHand(w, "後手の持駒", pos.Hands[shogi.White])
+	fmt.Fprintln(w, "  ９ ８ ７ ６ ５ ４ ３ ２ １")
+	fmt.Fprintln(w, "+---------------------------+")
+	for rank := 0; rank < shogi.RANKS; rank++ {
+		fmt.Fprint(w, "|")
+		for file := 0; file < shogi.FILES; file++ {
+			piece := pos.Board[rank*shogi.FILES+file]
+			switch {
+			case piece == shogi.NoPiece:
+				fmt.Fprint(w, " ・")
+			case piece.Color() == shogi.White:
+				fmt.Fprint(w, "v"+pieceChars[blackPiece(piece)])
+			default:
+				fmt.Fprint(w, " "+pieceChars[piece])
+			}
+		}
+		fmt.Fprintf(w, "|%c\n", kanjiNumerals[rank+1])
+	}
+	fmt.Fprintln(w, "+---------------------------+")
+	writeDiagramHand(w, "先手の持駒", pos.Hands[shogi.Black])
+	if pos.Side == shogi.White {
+		fmt.Fprintln(w, "後手番")
+	}
+}
+
+// writeDiagramHand writes the pieces of a hand, e.g. "飛　歩二　".
+func writeDiagramHand(w io.Writer, key string, hand shogi.Hand) {
+	var sb strings.Builder
+	for _, pieces := range []func() (shogi.Piece, int){hand.Rooks, hand.Bishops, hand.Golds, hand.Silvers, hand.Knights, hand.Lances, hand.Pawns} {
+		piece, n := pieces()
+		switch {
+		case n == 1:
+			sb.WriteString(pieceChars[blackPiece(piece)] + "　")
+		case n > 1:
+			sb.WriteString(pieceChars[blackPiece(piece)] + kanjiNumber(n) + "　")
+		}
+	}
+	if sb.Len() == 0 {
+		sb.WriteString("なし")
+	}
+	fmt.Fprintf(w, "%s：%s\n", key, sb.String())
+}
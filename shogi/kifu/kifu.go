@@ -0,0 +1,204 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+
+// Package kifu reads and writes shogi game records as shogi.Game.
+//
+// Records are written as UTF-8 text. They are read from UTF-8 text or from Shift_JIS,
+// the encoding of the files saved by most Japanese GUIs (usually with the .kif extension,
+// the .kifu extension being used for UTF-8), detected when the input is not valid UTF-8.
+package kifu
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	_ "github.com/vinymeuh/hifumi/shogi/movegen" // registers the shogi.MoveGenerator
+)
+
+// errNotJapaneseText is returned when a record is neither UTF-8 nor Shift_JIS text.
+var errNotJapaneseText = errors.New("input is neither UTF-8 nor Shift_JIS text")
+
+// utf8Text returns the text of a record as UTF-8, converting it from Shift_JIS when
+// it is not valid UTF-8.
+func utf8Text(r io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if utf8.Valid(data) {
+		return bytes.NewReader(data), nil
+	}
+	decoded, err := japanese.ShiftJIS.NewDecoder().Bytes(data)
+	if err != nil || bytes.ContainsRune(decoded, utf8.RuneError) {
+		return nil, errNotJapaneseText
+	}
+	return bytes.NewReader(decoded), nil
+}
+
+// Game informations are stored under the KIF header keys, shared by the KIF, KI2 and JKF formats.
+
+// kifDateLayouts are the layouts of the dates in headers, the first one being used for writing.
+var kifDateLayouts = []string{"2006/01/02 15:04:05", "2006/01/02 15:04", "2006/01/02"}
+
+// kifWeekdayRegexp matches the day of the week written after the date by some GUIs, e.g. "(土)".
+var kifWeekdayRegexp = regexp.MustCompile(`\(.\)`)
+
+// parseDate parses a date like "2023/04/01 10:00:00" or "2023/04/01", in local time.
+func parseDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(kifWeekdayRegexp.ReplaceAllString(value, ""))
+	for _, layout := range kifDateLayouts {
+		if d, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return d, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// formatDate formats a date, without the time if it is midnight.
+func formatDate(d time.Time) string {
+	if d.Hour() == 0 && d.Minute() == 0 && d.Second() == 0 {
+		return d.Format(kifDateLayouts[2])
+	}
+	return d.Format(kifDateLayouts[0])
+}
+
+// parseKifTimeControl parses a time control like "各10分", "1時間+秒読み30秒" or "5分+加算10秒".
+func parseKifTimeControl(value string) (shogi.TimeControl, bool) {
+	tc := shogi.TimeControl{Main: 0, Byoyomi: 0, Increment: 0}
+	for i, part := range strings.Split(strings.TrimPrefix(value, "各"), "+") {
+		var field *time.Duration
+		switch {
+		case strings.HasPrefix(part, "秒読み"):
+			field, part = &tc.Byoyomi, strings.TrimPrefix(part, "秒読み")
+		case strings.HasPrefix(part, "加算"):
+			field, part = &tc.Increment, strings.TrimPrefix(part, "加算")
+		case i == 0:
+			field = &tc.Main
+		default:
+			field = &tc.Byoyomi
+		}
+		d, ok := parseKifDuration(part)
+		if !ok {
+			return tc, false
+		}
+		*field = d
+	}
+	return tc, true
+}
+
+// kifDurationUnits are the units of the durations in time controls.
+var kifDurationUnits = []struct {
+	name string
+	unit time.Duration
+}{
+	{name: "時間", unit: time.Hour},
+	{name: "分", unit: time.Minute},
+	{name: "秒", unit: time.Second},
+}
+
+// parseKifDuration parses a duration like "1時間30分" or "30秒".
+func parseKifDuration(str string) (time.Duration, bool) {
+	var d time.Duration
+	for _, u := range kifDurationUnits {
+		before, after, found := strings.Cut(str, u.name)
+		if !found {
+			continue
+		}
+		n, err := strconv.Atoi(before)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		d, str = d+time.Duration(n)*u.unit, after
+	}
+	return d, str == ""
+}
+
+// formatKifTimeControl formats a time control, e.g. "各10分+秒読み30秒".
+func formatKifTimeControl(tc shogi.TimeControl) string {
+	text := "各" + formatKifDuration(tc.Main)
+	if tc.Byoyomi > 0 {
+		text += "+秒読み" + formatKifDuration(tc.Byoyomi)
+	}
+	if tc.Increment > 0 {
+		text += "+加算" + formatKifDuration(tc.Increment)
+	}
+	return text
+}
+
+// formatKifDuration formats a duration like "1時間30分".
+func formatKifDuration(d time.Duration) string {
+	if d == 0 {
+		return "0分"
+	}
+	var sb strings.Builder
+	for _, u := range kifDurationUnits {
+		if n := d / u.unit; n > 0 {
+			fmt.Fprintf(&sb, "%d%s", n, u.name)
+			d -= n * u.unit
+		}
+	}
+	return sb.String()
+}
+
+// setKifHeader sets the game information of a KIF header.
+func setKifHeader(h *shogi.GameHeader, key, value string) {
+	switch key {
+	case "先手", "下手":
+		h.Players[shogi.Black] = value
+		return
+	case "後手", "上手":
+		h.Players[shogi.White] = value
+		return
+	case "棋戦":
+		h.Event = value
+		return
+	case "場所":
+		h.Site = value
+		return
+	case "開始日時":
+		if d, ok := parseDate(value); ok {
+			h.Date = d
+			return
+		}
+	case "持ち時間":
+		if tc, ok := parseKifTimeControl(value); ok {
+			h.TimeControl = tc
+			return
+		}
+	}
+	h.Tags = append(h.Tags, shogi.GameTag{Key: key, Value: value})
+}
+
+// kifHeaders returns the KIF headers of a game, the players being the last ones.
+func kifHeaders(g *shogi.Game) []shogi.GameTag {
+	var headers []shogi.GameTag
+	add := func(key, value string) {
+		if value != "" {
+			headers = append(headers, shogi.GameTag{Key: key, Value: value})
+		}
+	}
+
+	if !g.Header.Date.IsZero() {
+		add("開始日時", formatDate(g.Header.Date))
+	}
+	headers = append(headers, g.Header.Tags...)
+	add("棋戦", g.Header.Event)
+	add("場所", g.Header.Site)
+	if g.Header.TimeControl != (shogi.TimeControl{Main: 0, Byoyomi: 0, Increment: 0}) {
+		add("持ち時間", formatKifTimeControl(g.Header.TimeControl))
+	}
+	names := playerNames(g.StartPos)
+	add(names[shogi.Black], g.Header.Players[shogi.Black])
+	add(names[shogi.White], g.Header.Players[shogi.White])
+	return headers
+}
@@ -0,0 +1,199 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package kifu
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// readCSAFile reads a CSA file from testdata.
+func readCSAFile(t *testing.T, name string) *shogi.Game {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rec, err := ReadCSA(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return rec
+}
+
+func TestReadCSA(t *testing.T) {
+	rec := readCSAFile(t, "even.csa")
+
+	// same game as the KIF version, without the variations
+	expected := readKIFFile(t, "even.kif")
+	if !reflect.DeepEqual(rec.Header, expected.Header) {
+		t.Errorf("expected header %v, got %v", expected.Header, rec.Header)
+	}
+	for _, n := range expected.Moves {
+		n.Variations = nil
+	}
+	if rec.StartPos != expected.StartPos || rec.Comment != expected.Comment {
+		t.Errorf("unexpected start position %s or comment '%s'", rec.StartPos, rec.Comment)
+	}
+	if !reflect.DeepEqual(rec.Moves, expected.Moves) {
+		t.Errorf("expected moves %s, got %s", usiMoves(expected.Moves), usiMoves(rec.Moves))
+	}
+}
+
+func TestReadCSAHandicap(t *testing.T) {
+	rec := readCSAFile(t, "handicap.csa")
+
+	if sfen, _ := shogi.HandicapSfen("lance"); !samePosition(rec.StartPos, sfen) {
+		t.Errorf("expected start position %s, got %s", sfen, rec.StartPos)
+	}
+	if rec.Header.Players != [shogi.COLORS]string{"Student", "Teacher"} {
+		t.Errorf("unexpected players %v", rec.Header.Players)
+	}
+	if moves := usiMoves(rec.Moves); moves != "3c3d 7g7f 2b8h+ 7i8h CHUDAN" {
+		t.Errorf("unexpected moves %s", moves)
+	}
+}
+
+func TestReadCSAPosition(t *testing.T) {
+	csa := strings.Join([]string{
+		"P-51OU",
+		"P+53FU59OU00KI00FU00FU",
+		"P-00AL",
+		"+",
+		"+0052KI",
+		"%TSUMI",
+	}, "\n")
+	rec, err := ReadCSA(strings.NewReader(csa))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "4k4/9/4P4/9/9/9/9/9/4K4 b G2P2r2b3g4s4n4l15p 1"; rec.StartPos != expected {
+		t.Errorf("expected start position %s, got %s", expected, rec.StartPos)
+	}
+	if moves := usiMoves(rec.Moves); moves != "G*5b TSUMI" {
+		t.Errorf("unexpected moves %s", moves)
+	}
+}
+
+func TestReadCSAErrors(t *testing.T) {
+	tests := []struct {
+		csa string
+		err string
+	}{
+		{csa: "PI\n+\n+7775FU", err: "CSA line 3: move '+7775FU': illegal move 7g7e: P can't move from 7g to 7e"},
+		{csa: "PI\n+\n-3334FU", err: "CSA line 3: move '-3334FU': not the turn of white"},
+		{csa: "PI\n+\n+7776KA", err: "CSA line 3: move '+7776KA': no KA on 77"},
+		{csa: "PI22HI\n+", err: "CSA line 1: PI: no HI on 22"},
+		{csa: "PI\n+\n%FOO", err: "CSA line 3: unknown special move '%FOO'"},
+		{csa: "PI\n+\nT12", err: "CSA line 3: time 'T12' before the first move"},
+		{csa: "P1-KY-KE", err: "CSA line 1: rank 1: invalid piece '   '"},
+		{csa: "PI\n+\n%TORYO\n-3334FU", err: "CSA line 4: move 2 follows the end of the game"},
+	}
+	for _, tc := range tests {
+		_, err := ReadCSA(strings.NewReader(tc.csa))
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%q: expected error '%s', got '%v'", tc.csa, tc.err, err)
+		}
+	}
+}
+
+func TestWriteCSA(t *testing.T) {
+	for _, name := range []string{"even.csa", "handicap.csa"} {
+		t.Run(name, func(t *testing.T) {
+			rec := readCSAFile(t, name)
+			var buf bytes.Buffer
+			if err := WriteCSA(&buf, rec); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := ReadCSA(&buf)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, rec) {
+				t.Errorf("records differ after a round trip")
+			}
+		})
+	}
+
+	rec := shogi.NewGame("4k4/9/4P4/9/9/9/9/9/4K4 b G2P 1")
+	rec.Header.Players[shogi.Black] = "Sente"
+	rec.Header.Site = "Tokyo"
+	rec.Header.TimeControl = shogi.TimeControl{Main: 10 * time.Minute, Byoyomi: 30 * time.Second, Increment: 0}
+	rec.Header.SetTag("備考", "test")
+	pos, _ := rec.Position()
+	m, _ := shogi.ParseMove(pos, "G*5b")
+	rec.Moves = []*shogi.GameNode{shogi.NewMoveNode(m), shogi.NewSpecialNode(shogi.SpecialTsumi)}
+	rec.Moves[0].Time = 1500 * time.Millisecond
+	rec.Moves[0].Comment = "mate"
+	expected := strings.Join([]string{
+		"V2.2",
+		"N+Sente",
+		"$SITE:Tokyo",
+		"$TIME_LIMIT:00:10+30",
+		"'備考：test",
+		"P1 *  *  *  * -OU *  *  *  * ",
+		"P2 *  *  *  *  *  *  *  *  * ",
+		"P3 *  *  *  * +FU *  *  *  * ",
+		"P4 *  *  *  *  *  *  *  *  * ",
+		"P5 *  *  *  *  *  *  *  *  * ",
+		"P6 *  *  *  *  *  *  *  *  * ",
+		"P7 *  *  *  *  *  *  *  *  * ",
+		"P8 *  *  *  *  *  *  *  *  * ",
+		"P9 *  *  *  * +OU *  *  *  * ",
+		"P+00KI00FU00FU",
+		"+",
+		"+0052KI",
+		"T1.5",
+		"'*mate",
+		"%TSUMI",
+		"",
+	}, "\n")
+	var buf bytes.Buffer
+	if err := WriteCSA(&buf, rec); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	rec = readCSAFile(t, "handicap.csa")
+	buf.Reset()
+	_ = WriteCSA(&buf, rec)
+	if !strings.Contains(buf.String(), "\nPI11KY\n-\n") {
+		t.Errorf("expected a PI start position, got:\n%s", buf.String())
+	}
+}
+
+func TestCSAMove(t *testing.T) {
+	pos, _ := shogi.NewGame("4k4/9/4P4/9/9/9/9/1B7/4K4 b G 1").Position()
+	testCases := []struct {
+		csa string
+		usi string
+	}{
+		{"+0052KI", "G*5b"},
+		{"+8833UM", "8h3c+"},
+		{"+5958OU", "5i5h"},
+	}
+	for _, tc := range testCases {
+		m, err := ParseCSAMove(pos, tc.csa)
+		if err != nil || m.String() != tc.usi {
+			t.Errorf("%s: expected %s, got %s, %v", tc.csa, tc.usi, m, err)
+			continue
+		}
+		if s := CSAMoveString(pos, m); s != tc.csa {
+			t.Errorf("%s: expected %s, got %s", tc.usi, tc.csa, s)
+		}
+	}
+	for _, csa := range []string{"-5152OU", "+5957OU", "+0052HI", "+7776"} {
+		if _, err := ParseCSAMove(pos, csa); err == nil {
+			t.Errorf("%s: expected an error", csa)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package kifu
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+func TestWriteJKF(t *testing.T) {
+	for _, name := range []string{"even.kif", "handicap.kif", "diagram.kif"} {
+		t.Run(name, func(t *testing.T) {
+			rec := readKIFFile(t, name)
+			var buf bytes.Buffer
+			if err := WriteJKF(&buf, rec); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := ReadJKF(&buf)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, rec) {
+				t.Errorf("records differ after a round trip")
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	_ = WriteJKF(&buf, readKIFFile(t, "even.kif"))
+	for _, expected := range []string{
+		`"header": {
+    "開始日時": "2023/04/01 10:00:00",`,
+		`"preset": "HIRATE"`,
+		`{
+      "comments": [
+        "Opening comment"
+      ]
+    },`,
+		`"move": {
+        "from": {
+          "x": 8,
+          "y": 8
+        },
+        "to": {
+          "x": 2,
+          "y": 2
+        },
+        "color": 0,
+        "piece": "KA",
+        "promote": true,
+        "capture": "KA"
+      },
+      "time": {
+        "now": {
+          "m": 0,
+          "s": 10
+        },
+        "total": {
+          "h": 0,
+          "m": 0,
+          "s": 13
+        }
+      },`,
+		`"same": true`,
+		`"special": "TORYO"`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("missing %s in:\n%s", expected, buf.String())
+		}
+	}
+}
+
+func TestReadJKFRelative(t *testing.T) {
+	jkf := `{"header": {"先手": "A"}, "initial": {"preset": "HIRATE"}, "moves": [
+		{},
+		{"move": {"to": {"x": 7, "y": 6}, "color": 0, "piece": "FU"}},
+		{"move": {"to": {"x": 3, "y": 4}, "color": 1, "piece": "FU"}},
+		{"move": {"to": {"x": 2, "y": 2}, "color": 0, "piece": "KA", "promote": true}},
+		{"move": {"same": true, "color": 1, "piece": "GI"}},
+		{"move": {"to": {"x": 5, "y": 8}, "color": 0, "piece": "KI", "relative": "R"}},
+		{"special": "TORYO"}
+	]}`
+	rec, err := ReadJKF(strings.NewReader(jkf))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if moves := usiMoves(rec.Moves); moves != "7g7f 3c3d 8h2b+ 3a2b 4i5h TORYO" {
+		t.Errorf("unexpected moves %s", moves)
+	}
+
+	pos, _ := shogi.NewPositionFromSfen("8k/9/9/9/9/9/9/3G1G3/K2G5 b - 1")
+	m, _ := shogi.ParseMove(pos, "6h5h")
+	if relative := jkfMoveOf(pos, m, 0, false).Relative; relative != "LM" {
+		t.Errorf("expected relative LM, got %s", relative)
+	}
+}
+
+var jkfEmptyFile = "[" + strings.Repeat("{},", 8) + "{}]"
+
+// jkfOther returns a JKF record with the initial position given by its board and hands, the files
+// of the board being completed with empty ones up to nine.
+func jkfOther(files []string, hands string) string {
+	for len(files) < 9 {
+		files = append(files, jkfEmptyFile)
+	}
+	return `{"header": {}, "initial": {"preset": "OTHER", "data": {"color": 0, "board": [` + strings.Join(files, ",") +
+		`], "hands": ` + hands + `}}, "moves": []}`
+}
+
+func TestReadJKFErrors(t *testing.T) {
+	kings := []string{`[{"color": 1, "kind": "OU"},{},{},{},{},{},{},{},{"color": 0, "kind": "OU"}]`}
+	emptyHands := `[{}, {}]`
+	tenFiles := append([]string{}, kings...)
+	for len(tenFiles) < 10 {
+		tenFiles = append(tenFiles, jkfEmptyFile)
+	}
+	tests := []struct {
+		jkf string
+		err string
+	}{
+		{jkf: `{"header": {}, "initial": {"preset": "3"}, "moves": []}`, err: "JKF: unknown preset '3'"},
+		{jkf: `{"header": {}, "moves": [{}, {"move": {"from": {"x": 7, "y": 7}, "to": {"x": 7, "y": 6}, "color": 0, "piece": "KA"}}]}`,
+			err: "JKF: move 1: no KA on 77"},
+		{jkf: `{"header": {}, "moves": [{}, {"move": {"from": {"x": 3, "y": 3}, "to": {"x": 3, "y": 4}, "color": 1, "piece": "FU"}}]}`,
+			err: "JKF: move 1: not the turn of white"},
+		{jkf: `{"header": {}, "moves": [{}, {"special": "TORYO"}, {"special": "TORYO"}]}`, err: "JKF: move 2 follows the end of the game"},
+		{jkf: `{"header": [], "moves": []}`, err: "JKF: header must be an object"},
+		{jkf: jkfOther(kings, `[{"FU": -3}, {}]`), err: "JKF: invalid count -3 of 'FU' in hand"},
+		{jkf: jkfOther(kings, `[{}, {"FU": 19}]`), err: "JKF: invalid count 19 of 'FU' in hand"},
+		{jkf: jkfOther(kings, `[{}]`), err: "JKF: hands must have 2 elements"},
+		{jkf: jkfOther([]string{`[{"color": 7, "kind": "OU"},{},{},{},{},{},{},{},{"color": 0, "kind": "OU"}]`}, emptyHands),
+			err: "JKF: invalid piece on 11"},
+		{jkf: jkfOther(tenFiles, emptyHands), err: "JKF: board must have 9 files"},
+		{jkf: jkfOther([]string{`[{},{}]`}, emptyHands), err: "JKF: file 1 must have 9 ranks"},
+	}
+	for _, tc := range tests {
+		_, err := ReadJKF(strings.NewReader(tc.jkf))
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%s: expected error '%s', got '%v'", tc.jkf, tc.err, err)
+		}
+	}
+}
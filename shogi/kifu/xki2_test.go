@@ -0,0 +1,194 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package kifu
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/movegen"
+)
+
+// clearTimes removes the times of all the moves of a line and its variations.
+func clearTimes(nodes []*shogi.GameNode) {
+	for _, n := range nodes {
+		n.Time = 0
+		for _, v := range n.Variations {
+			clearTimes(v)
+		}
+	}
+}
+
+func TestReadKI2(t *testing.T) {
+	f, err := os.Open("testdata/even.ki2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rec, err := ReadKI2(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := readKIFFile(t, "even.kif")
+	clearTimes(expected.Moves)
+	if !reflect.DeepEqual(rec, expected) {
+		t.Fatalf("KI2 and KIF records differ")
+	}
+
+	var buf bytes.Buffer
+	if err := WriteKI2(&buf, rec); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	source, _ := os.ReadFile("testdata/even.ki2")
+	if buf.String() != string(source) {
+		t.Fatalf("expected:\n%s\ngot:\n%s", source, buf.String())
+	}
+}
+
+func TestReadKI2ShiftJIS(t *testing.T) {
+	f, err := os.Open("testdata/kifuforwindows.ki2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rec, err := ReadKI2(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := readKIFFile(t, "kifuforwindows.kif")
+	if rec.Header.Players != expected.Header.Players || usiMoves(rec.Moves) != usiMoves(expected.Moves) {
+		t.Errorf("KI2 and KIF records differ: %v %s", rec.Header.Players, usiMoves(rec.Moves))
+	}
+}
+
+func TestKI2Moves(t *testing.T) {
+	tests := []struct {
+		sfen  string
+		moves map[string]string
+	}{
+		{ // same movement, left and right
+			sfen:  "8k/9/9/9/9/9/9/9/K2G1G3 b - 1",
+			moves: map[string]string{"6i5h": "５八金左", "4i5h": "５八金右"},
+		},
+		{ // movement
+			sfen:  "8k/9/9/9/9/9/9/5G3/K2G5 b - 1",
+			moves: map[string]string{"4h5h": "５八金寄", "6i5h": "５八金上"},
+		},
+		{ // straight up
+			sfen:  "8k/9/9/9/9/9/9/9/K2GGG3 b - 1",
+			moves: map[string]string{"6i5h": "５八金左", "5i5h": "５八金直", "4i5h": "５八金右"},
+		},
+		{ // dragons never go straight
+			sfen:  "9/+R7+R/9/9/3k5/9/9/9/K8 b - 1",
+			moves: map[string]string{"9b5b": "５二龍左", "1b5b": "５二龍右"},
+		},
+		{ // drop
+			sfen:  "8k/9/9/9/9/9/9/9/K4S3 b S 1",
+			moves: map[string]string{"S*5h": "５八銀打", "4i5h": "５八銀"},
+		},
+		{ // right and left from White's side
+			sfen:  "3g1g3/9/9/9/4k4/9/9/9/K8 w - 1",
+			moves: map[string]string{"6a5b": "５二金右", "4a5b": "５二金左"},
+		},
+		{ // down and promotion
+			sfen:  "8k/3S1S3/9/4S4/9/9/9/9/K8 b - 1",
+			moves: map[string]string{"6b5c": "５三銀左不成", "4b5c+": "５三銀右成", "5d5c": "５三銀上不成"},
+		},
+		{ // position and movement
+			sfen:  "8k/9/9/9/9/9/9/3G1G3/K2G5 b - 1",
+			moves: map[string]string{"4h5h": "５八金右", "6h5h": "５八金左寄", "6i5h": "５八金上"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.sfen, func(t *testing.T) {
+			for usi, expected := range tc.moves {
+				pos, _ := shogi.NewPositionFromSfen(tc.sfen)
+				m, err := shogi.ParseMove(pos, usi)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got := ki2Move(pos, m, 0, false); got != expected {
+					t.Errorf("%s: expected=%s, got=%s", usi, expected, got)
+				}
+				if parsed, err := parseKi2Move(expected, pos, 0, false); err != nil || parsed != m {
+					t.Errorf("%s: parsed %s as %s (%v)", expected, usi, parsed, err)
+				}
+			}
+		})
+	}
+}
+
+// TestKI2MovesRoundTrip checks that all the legal moves of random games are parsed back
+// from their KI2 notation.
+func TestKI2MovesRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for game := 0; game < 5; game++ {
+		pos, _ := shogi.NewPositionFromSfen(shogi.StartPos)
+		prevTo, hasPrev := uint8(0), false
+		for ply := 0; ply < 60; ply++ {
+			moves := movegen.LegalMoves(pos)
+			if len(moves) == 0 {
+				break
+			}
+			for _, m := range moves {
+				text := ki2Move(pos, m, prevTo, hasPrev)
+				parsed, err := parseKi2Move(text, pos, prevTo, hasPrev)
+				if err != nil || parsed != m {
+					t.Fatalf("%s: move %s written %s parsed as %s (%v)", pos.Sfen(), m, text, parsed, err)
+				}
+				if strings.ContainsAny(text, "右左直上引寄") && len(ki2Candidates(pos, moves, pos.Board[m.From()], m.To())) < 2 {
+					t.Fatalf("%s: unnecessary disambiguation %s", pos.Sfen(), text)
+				}
+			}
+			m := moves[rng.Intn(len(moves))]
+			pos.DoMove(m)
+			prevTo, hasPrev = m.To(), true
+		}
+	}
+}
+
+func TestParseMove(t *testing.T) {
+	pos, _ := shogi.NewPositionFromSfen(shogi.StartPos)
+	var prev shogi.Move
+	for _, tc := range []struct {
+		text     string
+		expected string
+		ki2      string
+	}{
+		{text: "▲７六歩", expected: "7g7f", ki2: "▲７六歩"},
+		{text: "34歩", expected: "3c3d", ki2: "△３四歩"},
+		{text: "２二角成(88)", expected: "8h2b+", ki2: "▲２二角成"},
+		{text: "同銀", expected: "3a2b", ki2: "△同　銀"},
+		{text: "☗４五角", expected: "B*4e", ki2: "▲４五角"},
+	} {
+		m, err := ParseMove(pos, tc.text, prev)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.text, err)
+		}
+		if m.String() != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.text, tc.expected, m)
+		}
+		if ki2 := MoveString(pos, m, prev); ki2 != tc.ki2 {
+			t.Errorf("%s: expected %s, got %s", tc.text, tc.ki2, ki2)
+		}
+		pos.DoMove(m)
+		prev = m
+	}
+
+	for text, expected := range map[string]string{
+		"▲３八飛": "move '▲３八飛' is not played by the side to move",
+		"同歩":   "illegal move '同歩': 歩 can't be dropped",
+		"７七象":  "invalid move '７七象': unknown piece",
+	} {
+		if _, err := ParseMove(pos, text, prev); err == nil || err.Error() != expected {
+			t.Errorf("%s: expected error '%s', got '%v'", text, expected, err)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package kifu
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// readKIFFile reads a KIF file from testdata.
+func readKIFFile(t *testing.T, name string) *shogi.Game {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rec, err := ReadKIF(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return rec
+}
+
+// usiMoves returns the moves of a line in USI notation.
+func usiMoves(nodes []*shogi.GameNode) string {
+	moves := make([]string, len(nodes))
+	for i, n := range nodes {
+		moves[i] = n.Move.String()
+		if n.Special != "" {
+			moves[i] = string(n.Special)
+		}
+	}
+	return strings.Join(moves, " ")
+}
+
+func TestReadKIF(t *testing.T) {
+	rec := readKIFFile(t, "even.kif")
+
+	if rec.StartPos != shogi.StartPos {
+		t.Errorf("expected start position %s, got %s", shogi.StartPos, rec.StartPos)
+	}
+	expectedHeader := shogi.GameHeader{
+		Players:     [shogi.COLORS]string{"Sente Player", "Gote Player"},
+		Event:       "hifumi test league",
+		Site:        "",
+		Date:        time.Date(2023, 4, 1, 10, 0, 0, 0, time.Local),
+		TimeControl: shogi.TimeControl{Main: 0, Byoyomi: 0, Increment: 0},
+		Tags:        []shogi.GameTag{{Key: "終了日時", Value: "2023/04/01 10:15:32"}},
+	}
+	if !reflect.DeepEqual(rec.Header, expectedHeader) {
+		t.Errorf("expected header %v, got %v", expectedHeader, rec.Header)
+	}
+	if rec.Comment != "Opening comment" {
+		t.Errorf("unexpected record comment '%s'", rec.Comment)
+	}
+	if moves := usiMoves(rec.Moves); moves != "7g7f 3c3d 8h2b+ 3a2b B*4e B*3c 4e6c+ TORYO" {
+		t.Errorf("unexpected main line %s", moves)
+	}
+	if rec.Moves[1].Comment != "A classical answer\non two lines" {
+		t.Errorf("unexpected comment '%s'", rec.Moves[1].Comment)
+	}
+	if rec.Moves[5].Time != time.Minute+2*time.Second {
+		t.Errorf("unexpected time %s", rec.Moves[5].Time)
+	}
+
+	variations := rec.Moves[2].Variations
+	if len(variations) != 2 {
+		t.Fatalf("expected 2 variations on move 3, got %d", len(variations))
+	}
+	if moves := usiMoves(variations[0]); moves != "6g6f 8c8d" {
+		t.Errorf("unexpected variation %s", moves)
+	}
+	if moves := usiMoves(variations[0][1].Variations[0]); moves != "8b4b" {
+		t.Errorf("unexpected variation %s", moves)
+	}
+	if moves := usiMoves(variations[1]); moves != "2g2f" {
+		t.Errorf("unexpected variation %s", moves)
+	}
+}
+
+// TestReadKIFExports reads records in the layouts of the files saved by Japanese GUIs:
+// Kifu for Windows writes .kif files in Shift_JIS, ShogiGUI writes .kifu files in UTF-8
+// with a byte order mark, both with CRLF line endings.
+func TestReadKIFExports(t *testing.T) {
+	for _, name := range []string{"kifuforwindows.kif", "shogigui.kifu"} {
+		t.Run(name, func(t *testing.T) {
+			rec := readKIFFile(t, name)
+			if rec.Header.Players != [shogi.COLORS]string{"山田太郎", "佐藤花子"} || rec.Header.Event != "将棋道場 平日リーグ" {
+				t.Errorf("unexpected header %v", rec.Header)
+			}
+			main := rec.MainLine()
+			if len(main) != 32 || main[31].String() != "2b5e" {
+				t.Errorf("unexpected main line %s", usiMoves(rec.Moves))
+			}
+			if r := rec.Result(); r.Winner != shogi.White || r.Reason != shogi.SpecialResign {
+				t.Errorf("unexpected result %v", r)
+			}
+			if rec.Moves[27].Time != 44*time.Second {
+				t.Errorf("unexpected time %s", rec.Moves[27].Time)
+			}
+		})
+	}
+}
+
+func TestReadKIFHandicap(t *testing.T) {
+	rec := readKIFFile(t, "handicap.kif")
+	lance, _ := shogi.HandicapSfen("lance")
+	if rec.StartPos != lance {
+		t.Errorf("expected start position %s, got %s", lance, rec.StartPos)
+	}
+	if moves := usiMoves(rec.Moves); moves != "3c3d 7g7f 2b8h+ 7i8h CHUDAN" {
+		t.Errorf("unexpected main line %s", moves)
+	}
+}
+
+func TestReadKIFDiagram(t *testing.T) {
+	rec := readKIFFile(t, "diagram.kif")
+	if expected := "4k4/9/4P4/9/9/9/9/9/4K4 b G2P 1"; rec.StartPos != expected {
+		t.Errorf("expected start position %s, got %s", expected, rec.StartPos)
+	}
+	if moves := usiMoves(rec.Moves); moves != "G*5b TSUMI" {
+		t.Errorf("unexpected main line %s", moves)
+	}
+}
+
+func TestReadKIFSameSquareMark(t *testing.T) {
+	// 仝 is another form of 同, not the promoted silver 全
+	rec, err := ReadKIF(strings.NewReader("   1 ７六歩(77)\n   2 ３四歩(33)\n   3 ２二角成(88)\n   4 仝　銀(31)\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if moves := usiMoves(rec.Moves); moves != "7g7f 3c3d 8h2b+ 3a2b" {
+		t.Errorf("unexpected main line %s", moves)
+	}
+}
+
+func TestReadKIFErrors(t *testing.T) {
+	tests := []struct {
+		kif string
+		err string
+	}{
+		{kif: "   1 ７六歩(77)\n   3 ３四歩(33)\n", err: "KIF line 2: expected move 2, found 3"},
+		{kif: "   1 ７五歩(77)\n", err: "KIF line 1: move '７五歩(77)': illegal move 7g7e: P can't move from 7g to 7e"},
+		{kif: "   1 ７六銀(77)\n", err: "KIF line 1: move '７六銀(77)': no 銀 on 7g"},
+		{kif: "   1 同　歩(77)\n", err: "KIF line 1: invalid move '同　歩(77)': no previous move"},
+		{kif: "   1 ７六歩(77)\n\n変化：3手\n", err: "KIF line 3: no move 3 for variation"},
+		{kif: "手合割：五枚落ち\n   1 ７六歩(77)\n", err: "KIF line 2: unknown handicap '五枚落ち'"},
+		{kif: "   1 \x82\x56\x82\xff\x95\xe0(77)\n", err: "KIF: input is neither UTF-8 nor Shift_JIS text"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.err, func(t *testing.T) {
+			_, err := ReadKIF(strings.NewReader(tc.kif))
+			if err == nil || err.Error() != tc.err {
+				t.Fatalf("expected error '%s', got '%v'", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestWriteKIF(t *testing.T) {
+	for _, name := range []string{"even.kif", "handicap.kif", "diagram.kif"} {
+		t.Run(name, func(t *testing.T) {
+			rec := readKIFFile(t, name)
+			var buf bytes.Buffer
+			if err := WriteKIF(&buf, rec); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			written, err := ReadKIF(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("can't read written KIF: %v\n%s", err, buf.String())
+			}
+			if !reflect.DeepEqual(rec, written) {
+				t.Fatalf("records differ after writing:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+func TestWriteKIFMoves(t *testing.T) {
+	rec := readKIFFile(t, "even.kif")
+	var buf bytes.Buffer
+	_ = WriteKIF(&buf, rec)
+	for _, line := range []string{
+		"手合割：平手\n先手：Sente Player\n",
+		"   3 ２二角成(88)  ( 0:10/00:00:13)+\n",
+		"   4 同　銀(31)    ( 0:02/00:00:07)\n",
+		"   5 ４五角打      ( 0:30/00:00:43)\n",
+		"まで7手で先手の勝ち\n",
+		"\n変化：3手\n   3 ２六歩(27)    ( 0:02/00:00:05)\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("expected '%s' in:\n%s", line, buf.String())
+		}
+	}
+
+	// a promotable piece which doesn't promote
+	rec = shogi.NewGame("4k4/9/9/9/9/5B3/9/9/4K4 b - 1")
+	pos, _ := rec.Position()
+	m, _ := shogi.ParseMove(pos, "4f2d")
+	rec.Moves = []*shogi.GameNode{shogi.NewMoveNode(m)}
+	buf.Reset()
+	_ = WriteKIF(&buf, rec)
+	if !strings.Contains(buf.String(), "   1 ２四角(46)") {
+		t.Errorf("unexpected move outside of the promotion zone:\n%s", buf.String())
+	}
+	pos, _ = rec.Position()
+	m, _ = shogi.ParseMove(pos, "4f1c")
+	rec.Moves = []*shogi.GameNode{shogi.NewMoveNode(m)}
+	buf.Reset()
+	_ = WriteKIF(&buf, rec)
+	if !strings.Contains(buf.String(), "   1 １三角不成(46)") {
+		t.Errorf("expected 不成 in:\n%s", buf.String())
+	}
+}
+
+func TestKifHeaders(t *testing.T) {
+	tests := []struct {
+		key    string
+		value  string
+		header shogi.GameHeader
+		text   string // written value
+	}{
+		{key: "開始日時", value: "2023/04/01(土) 10:00:00", text: "2023/04/01 10:00:00",
+			header: shogi.GameHeader{Date: time.Date(2023, 4, 1, 10, 0, 0, 0, time.Local)}},
+		{key: "開始日時", value: "2023/05/05", text: "2023/05/05",
+			header: shogi.GameHeader{Date: time.Date(2023, 5, 5, 0, 0, 0, 0, time.Local)}},
+		{key: "開始日時", value: "yesterday", text: "yesterday",
+			header: shogi.GameHeader{Tags: []shogi.GameTag{{Key: "開始日時", Value: "yesterday"}}}},
+		{key: "持ち時間", value: "各10分", text: "各10分",
+			header: shogi.GameHeader{TimeControl: shogi.TimeControl{Main: 10 * time.Minute}}},
+		{key: "持ち時間", value: "1時間30分+秒読み30秒", text: "各1時間30分+秒読み30秒",
+			header: shogi.GameHeader{TimeControl: shogi.TimeControl{Main: 90 * time.Minute, Byoyomi: 30 * time.Second}}},
+		{key: "持ち時間", value: "各5分+加算10秒", text: "各5分+加算10秒",
+			header: shogi.GameHeader{TimeControl: shogi.TimeControl{Main: 5 * time.Minute, Increment: 10 * time.Second}}},
+		{key: "持ち時間", value: "各10分（切れ負け）", text: "各10分（切れ負け）",
+			header: shogi.GameHeader{Tags: []shogi.GameTag{{Key: "持ち時間", Value: "各10分（切れ負け）"}}}},
+		{key: "上手", value: "Teacher", text: "Teacher",
+			header: shogi.GameHeader{Players: [shogi.COLORS]string{"", "Teacher"}}},
+	}
+	for _, tc := range tests {
+		var h shogi.GameHeader
+		setKifHeader(&h, tc.key, tc.value)
+		if !reflect.DeepEqual(h, tc.header) {
+			t.Errorf("%s：%s: expected %v, got %v", tc.key, tc.value, tc.header, h)
+		}
+		sfen := shogi.StartPos
+		if tc.key == "上手" {
+			sfen, _ = shogi.HandicapSfen("lance")
+		}
+		g := shogi.NewGame(sfen)
+		g.Header = h
+		if headers := kifHeaders(g); len(headers) != 1 || headers[0] != (shogi.GameTag{Key: tc.key, Value: tc.text}) {
+			t.Errorf("%s：%s: unexpected headers %v", tc.key, tc.value, headers)
+		}
+	}
+}
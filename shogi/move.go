@@ -8,10 +8,11 @@ import (
 
 // MoveFlags represents the type of a Shogi Move.
 const (
-	MoveFlagDrop      uint = 0b0001 // Drop: The move involves dropping a piece onto the board.
-	MoveFlagMove      uint = 0b0010 // Movement: The move involves moving a piece on the board.
-	MoveFlagPromotion uint = 0b0100 // Promotion: The moving piece will be promoted.
-	MoveFlagCapture   uint = 0b1000 // Capture: The move will result capturing an opponent's piece.
+	MoveFlagDrop      uint = 0b00001 // Drop: The move involves dropping a piece onto the board.
+	MoveFlagMove      uint = 0b00010 // Movement: The move involves moving a piece on the board.
+	MoveFlagPromotion uint = 0b00100 // Promotion: The moving piece will be promoted.
+	MoveFlagCapture   uint = 0b01000 // Capture: The move will result capturing an opponent's piece.
+	MoveFlagDemotion  uint = 0b10000 // Demotion: The moving piece will be unpromoted (Kyoto shogi).
 )
 
 // Move is the type to record information about a Shogi move. A Move can be
@@ -20,39 +21,54 @@ const (
 //
 // Move is implemented as a bitset with the following structure:
 //
-//	Piece 6 bits || To 8 bits || From 8 bits || MoveFlags 4 bits
+//	Piece 6 bits || To 8 bits || From 8 bits || MoveFlags 5 bits
 //
-// For a Drop, Piece is the dropped piece.
+// For a Drop, Piece is the dropped piece, which can be promoted in Kyoto shogi.
 // For a Capture, Piece is the captured piece.
 type Move uint
 
 // NewMove creates a new Move with the provided MoveFlags, From, To, and Piece.
 func NewMove(flags uint, from uint8, to uint8, piece Piece) Move {
-	m := Move(flags&0x0F) << 0
-	m |= Move(from&0xFF) << 4
-	m |= Move(to&0xFF) << 12
-	m |= Move(piece&0x3F) << 20
+	m := Move(flags&0x1F) << 0
+	m |= Move(from&0xFF) << 5
+	m |= Move(to&0xFF) << 13
+	m |= Move(piece&0x3F) << 21
 	return m
 }
 
 // flags returns the MoveFlags part of the Move.
 func (m Move) flags() uint {
-	return uint(m & 0x0F)
+	return uint(m & 0x1F)
 }
 
 // From returns the from part of the Move.
 func (m Move) From() uint8 {
-	return uint8((m >> 4) & 0xFF)
+	return uint8((m >> 5) & 0xFF)
 }
 
 // To returns the To part of the Move.
 func (m Move) To() uint8 {
-	return uint8((m >> 12) & 0xFF)
+	return uint8((m >> 13) & 0xFF)
 }
 
 // Piece returns the Piece part of the Move.
 func (m Move) Piece() Piece {
-	return Piece(uint((m >> 20) & 0x3F))
+	return Piece(uint((m >> 21) & 0x3F))
+}
+
+// IsDrop returns true if the Move is a drop.
+func (m Move) IsDrop() bool {
+	return m.flags()&MoveFlagDrop == MoveFlagDrop
+}
+
+// IsPromotion returns true if the moving piece is promoted.
+func (m Move) IsPromotion() bool {
+	return m.flags()&MoveFlagPromotion == MoveFlagPromotion
+}
+
+// IsCapture returns true if the Move captures an opponent's piece.
+func (m Move) IsCapture() bool {
+	return m.flags()&MoveFlagCapture == MoveFlagCapture
 }
 
 // destructure returns the four parts of the Move.
@@ -74,6 +90,9 @@ func (m Move) String() string {
 		if flags&MoveFlagPromotion == MoveFlagPromotion {
 			return SquareString(m.From()) + SquareString(m.To()) + "+"
 		}
+		if flags&MoveFlagDemotion == MoveFlagDemotion {
+			return SquareString(m.From()) + SquareString(m.To()) + "-"
+		}
 		return SquareString(m.From()) + SquareString(m.To())
 	}
 }
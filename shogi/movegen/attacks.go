@@ -44,3 +44,29 @@ func Checkers(position *shogi.Position, defender shogi.Color) []uint8 {
 	squares := Attackers(position, uint8(bbking.Lsb()))
 	return squares
 }
+
+// InCheck returns true if the king of the defender color is attacked.
+func InCheck(position *shogi.Position, defender shogi.Color) bool {
+	return len(Checkers(position, defender)) > 0
+}
+
+// moveGenerator is the shogi.MoveGenerator implemented by this package.
+type moveGenerator struct{}
+
+func (moveGenerator) InCheck(p *shogi.Position, c shogi.Color) bool {
+	return InCheck(p, c)
+}
+
+func (moveGenerator) LegalMoves(p *shogi.Position) []shogi.Move {
+	return LegalMoves(p)
+}
+
+func (moveGenerator) PseudoLegalMoves(p *shogi.Position) []shogi.Move {
+	var list MoveList
+	GenerateAllMoves(p, &list)
+	return append([]shogi.Move{}, list.Moves[:list.Count]...)
+}
+
+func init() {
+	shogi.RegisterMoveGenerator(moveGenerator{})
+}
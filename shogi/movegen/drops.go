@@ -10,7 +10,7 @@ import (
 func generateDrops(gs *shogi.Position, list *MoveList) {
 	myColor := gs.Side
 	myHand := gs.Hands[myColor]
-	emptySquares := gs.BBbyColor[shogi.Black].Or(gs.BBbyColor[shogi.White]).Not()
+	emptySquares := gs.BBbyColor[shogi.Black].Or(gs.BBbyColor[shogi.White]).Not().And(gs.Variant.Squares)
 
 	if p, n := myHand.Pawns(); n > 0 { // TODO: no direct checkmate rule is not enforced
 		mypawns := gs.BBbyPiece[p]
@@ -22,17 +22,17 @@ func generateDrops(gs *shogi.Position, list *MoveList) {
 		}
 		mypawnfiles = mypawnfiles.Not()
 
-		emptySquaresResticted := emptySquares.And(noDropZones[p]).And(mypawnfiles)
+		emptySquaresResticted := emptySquares.And(gs.Variant.DeadZone(p).Not()).And(mypawnfiles)
 		addDrops(p, emptySquaresResticted, list)
 	}
 
 	if p, n := myHand.Lances(); n > 0 {
-		emptySquaresResticted := emptySquares.And(noDropZones[p])
+		emptySquaresResticted := emptySquares.And(gs.Variant.DeadZone(p).Not())
 		addDrops(p, emptySquaresResticted, list)
 	}
 
 	if p, n := myHand.Knights(); n > 0 {
-		emptySquaresResticted := emptySquares.And(noDropZones[p])
+		emptySquaresResticted := emptySquares.And(gs.Variant.DeadZone(p).Not())
 		addDrops(p, emptySquaresResticted, list)
 	}
 
@@ -61,15 +61,6 @@ func addDrops(p shogi.Piece, emptySquares bitboard.Bitboard, list *MoveList) {
 	}
 }
 
-var noDropZones = map[shogi.Piece]bitboard.Bitboard{
-	shogi.BlackPawn:   bitboard.New(0b11111111111111111, 0b1111111111111111111111111111111111111111111111111111111000000000),
-	shogi.WhitePawn:   bitboard.New(0b00000000011111111, 0b1111111111111111111111111111111111111111111111111111111111111111),
-	shogi.BlackLance:  bitboard.New(0b11111111111111111, 0b1111111111111111111111111111111111111111111111111111111000000000),
-	shogi.WhiteLance:  bitboard.New(0b00000000011111111, 0b1111111111111111111111111111111111111111111111111111111111111111),
-	shogi.BlackKnight: bitboard.New(0b11111111111111111, 0b1111111111111111111111111111111111111111111111000000000000000000),
-	shogi.WhiteKnight: bitboard.New(0b00000000000000000, 0b0111111111111111111111111111111111111111111111111111111111111111),
-}
-
 var fileBitboards = [9]bitboard.Bitboard{
 	bitboard.New(0b10000000010000000, 0b0100000000100000000100000000100000000100000000100000000100000000),
 	bitboard.New(0b01000000001000000, 0b0010000000010000000010000000010000000010000000010000000010000000),
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package movegen
+
+import (
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// ************************************************************* //
+// ************ Piece Flipping Variants (Kyoto shogi) ********** //
+// ************************************************************* //
+
+// generateFlippingMoves generates pseudo-legal moves for variants where pieces flip at every move.
+// Flipped faces are: tokin (+L), gold (+N), bishop (+S) and rook (+P).
+func generateFlippingMoves(pos *shogi.Position, list *MoveList) {
+	if pos.Side == shogi.Black {
+		blackPawnMoveRules.generateMoves(shogi.BlackPawn, pos, list)
+		blackLanceMoveRules.generateMoves(shogi.BlackLance, pos, list)
+		blackKnightMoveRules.generateMoves(shogi.BlackKnight, pos, list)
+		blackSilverMoveRules.generateMoves(shogi.BlackSilver, pos, list)
+
+		kingMoveRules.generateMoves(shogi.BlackKing, pos, list)
+
+		blackRookHMoveRules.generateMoves(shogi.BlackPromotedPawn, pos, list)
+		blackRookVMoveRules.generateMoves(shogi.BlackPromotedPawn, pos, list)
+		blackGoldMoveRules.generateMoves(shogi.BlackPromotedLance, pos, list)
+		blackGoldMoveRules.generateMoves(shogi.BlackPromotedKnight, pos, list)
+		blackBishopMoveRules.generateMoves(shogi.BlackPromotedSilver, pos, list)
+	} else {
+		whitePawnMoveRules.generateMoves(shogi.WhitePawn, pos, list)
+		whiteLanceMoveRules.generateMoves(shogi.WhiteLance, pos, list)
+		whiteKnightMoveRules.generateMoves(shogi.WhiteKnight, pos, list)
+		whiteSilverMoveRules.generateMoves(shogi.WhiteSilver, pos, list)
+
+		kingMoveRules.generateMoves(shogi.WhiteKing, pos, list)
+
+		whiteRookHMoveRules.generateMoves(shogi.WhitePromotedPawn, pos, list)
+		whiteRookVMoveRules.generateMoves(shogi.WhitePromotedPawn, pos, list)
+		whiteGoldMoveRules.generateMoves(shogi.WhitePromotedLance, pos, list)
+		whiteGoldMoveRules.generateMoves(shogi.WhitePromotedKnight, pos, list)
+		whiteBishopMoveRules.generateMoves(shogi.WhitePromotedSilver, pos, list)
+	}
+
+	if pos.Hands[pos.Side].Count > 0 {
+		generateFlippingDrops(pos, list)
+	}
+}
+
+// generateFlippingDrops generates drops on both faces of the pieces in hand.
+// There is no restriction on drops: no two pawns rule and no dead zone.
+func generateFlippingDrops(gs *shogi.Position, list *MoveList) {
+	myHand := gs.Hands[gs.Side]
+	emptySquares := gs.BBbyColor[shogi.Black].Or(gs.BBbyColor[shogi.White]).Not().And(gs.Variant.Squares)
+
+	for _, pieces := range []func() (shogi.Piece, int){myHand.Pawns, myHand.Lances, myHand.Knights, myHand.Silvers} {
+		if p, n := pieces(); n > 0 {
+			addDrops(p, emptySquares, list)
+			addDrops(p.Promote(), emptySquares, list)
+		}
+	}
+}
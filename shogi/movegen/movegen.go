@@ -10,7 +10,9 @@ import (
 )
 
 // maxMoves is the maximum number of moves we expect to generate from a given shogi position.
-const maxMoves = 512
+// Legal positions can have up to 593 legal moves, and more pseudo-legal ones when a side
+// holds most of the pieces in hand (e.g. tsume problems).
+const maxMoves = 1024
 
 // MoveList is a list of Moves with a fixed maximum size.
 type MoveList struct {
@@ -32,6 +34,11 @@ func (ml *MoveList) Push(move shogi.Move) {
 
 // GenerateAllMoves generates pseudo-legal moves for the given position and adds them to the move list.
 func GenerateAllMoves(pos *shogi.Position, list *MoveList) {
+	if pos.Variant.PieceFlipping {
+		generateFlippingMoves(pos, list)
+		return
+	}
+
 	if pos.Side == shogi.Black {
 		blackPawnMoveRules.generateMoves(shogi.BlackPawn, pos, list)
 		blackLanceMoveRules.generateMoves(shogi.BlackLance, pos, list)
@@ -87,21 +94,57 @@ func GenerateAllMoves(pos *shogi.Position, list *MoveList) {
 	}
 }
 
+// LegalMoves returns the legal moves for the given position.
+func LegalMoves(pos *shogi.Position) []shogi.Move {
+	var list MoveList
+	GenerateAllMoves(pos, &list)
+
+	mySide := pos.Side
+	moves := make([]shogi.Move, 0, list.Count)
+	for i := 0; i < list.Count; i++ {
+		m := list.Moves[i]
+		pos.DoMove(m)
+		if !InCheck(pos, mySide) {
+			moves = append(moves, m)
+		}
+		pos.UndoMove(m)
+	}
+	return moves
+}
+
 // ********************************************* //
 // *** Sliding/Non Sliding shared functions **** //
 // ********************************************* //
 
-// promoteFunc is a function type that checks promotion rules for moves.
-type promoteFunc func(from, to uint8) (can, must bool)
+// promotion returns the promotion rules for a piece moving between two squares.
+func promotion(piece shogi.Piece, from, to uint8, v *shogi.Variant) (can, must bool) {
+	if piece.Promote() == piece {
+		return
+	}
+	if v.PieceFlipping {
+		return true, true
+	}
+	zone := v.PromotionZone(piece.Color())
+	can = zone.Bit(uint(from)) == 1 || zone.Bit(uint(to)) == 1
+	must = v.DeadZone(piece).Bit(uint(to)) == 1
+	return
+}
 
-func generateMoves(from uint8, attacks bitboard.Bitboard, pos *shogi.Position, promote promoteFunc, list *MoveList) {
+func generateMoves(piece shogi.Piece, from uint8, attacks bitboard.Bitboard, pos *shogi.Position, list *MoveList) {
 	mycolor := pos.Side
 	myopponent := mycolor.Opponent()
+	attacks = attacks.And(pos.Variant.Squares)
+
+	// with piece flipping, a promoted piece must be unpromoted when moving
+	var demotion uint
+	if pos.Variant.PieceFlipping && piece.UnPromote() != piece {
+		demotion = shogi.MoveFlagDemotion
+	}
 
 	// generate moves for the current piece on "from"
 	for attacks != bitboard.Zero {
 		to := uint8(attacks.Lsb())
-		canPromote, mustPromote := promote(from, to)
+		canPromote, mustPromote := promotion(piece, from, to, pos.Variant)
 
 		switch {
 		case pos.BBbyColor[myopponent].Bit(uint(to)) == 1: // capture
@@ -116,7 +159,7 @@ func generateMoves(from uint8, attacks bitboard.Bitboard, pos *shogi.Position, p
 			}
 			if !mustPromote {
 				list.Push(shogi.NewMove(
-					shogi.MoveFlagMove|shogi.MoveFlagCapture,
+					shogi.MoveFlagMove|shogi.MoveFlagCapture|demotion,
 					from,
 					to,
 					captured,
@@ -134,7 +177,7 @@ func generateMoves(from uint8, attacks bitboard.Bitboard, pos *shogi.Position, p
 			}
 			if !mustPromote {
 				list.Push(shogi.NewMove(
-					shogi.MoveFlagMove,
+					shogi.MoveFlagMove|demotion,
 					from,
 					to,
 					shogi.NoPiece,
@@ -11,7 +11,6 @@ import (
 // *************** Non Sliding Pieces Move Rules *************** //
 // ************************************************************* //
 type nonSlidingPieceMoveRules struct {
-	Promote promoteFunc
 	Attacks AttacksTable
 }
 
@@ -23,7 +22,7 @@ func (rules nonSlidingPieceMoveRules) generateMoves(piece shogi.Piece, pos *shog
 		from := uint8(mypieces.Lsb())
 		attacks := rules.Attacks[from]
 		// generate moves for the current piece on "from"
-		generateMoves(from, attacks, pos, rules.Promote, list)
+		generateMoves(piece, from, attacks, pos, list)
 		mypieces = mypieces.Clear(uint(from))
 	}
 }
@@ -34,17 +33,6 @@ var (
 		Attacks: newAttacksTable([]direction{
 			origin.toNorth(1),
 		}),
-		Promote: func(_, to uint8) (can, must bool) {
-			switch {
-			case shogi.SquareRank(to) <= 3 && shogi.SquareRank(to) > 1:
-				can = true
-				must = false
-			case shogi.SquareRank(to) == 1:
-				can = true
-				must = true
-			}
-			return
-		},
 	}
 
 	// WhitePawn
@@ -52,17 +40,6 @@ var (
 		Attacks: newAttacksTable([]direction{
 			origin.toSouth(1),
 		}),
-		Promote: func(_, to uint8) (can, must bool) {
-			switch {
-			case shogi.SquareRank(to) >= 7 && shogi.SquareRank(to) < 9:
-				can = true
-				must = false
-			case shogi.SquareRank(to) == 9:
-				can = true
-				must = true
-			}
-			return
-		},
 	}
 
 	// BlackKnight
@@ -71,17 +48,6 @@ var (
 			origin.toNorth(2).toEast(1),
 			origin.toNorth(2).toWest(1),
 		}),
-		Promote: func(_, to uint8) (can, must bool) {
-			switch {
-			case shogi.SquareRank(to) == 3:
-				can = true
-				must = false
-			case shogi.SquareRank(to) <= 2:
-				can = true
-				must = true
-			}
-			return
-		},
 	}
 
 	// WhiteKnight
@@ -90,17 +56,6 @@ var (
 			origin.toSouth(2).toEast(1),
 			origin.toSouth(2).toWest(1),
 		}),
-		Promote: func(_, to uint8) (can, must bool) {
-			switch {
-			case shogi.SquareRank(to) == 7:
-				can = true
-				must = false
-			case shogi.SquareRank(to) >= 8:
-				can = true
-				must = true
-			}
-			return
-		},
 	}
 
 	// BlackSilver
@@ -112,14 +67,6 @@ var (
 			origin.toSouth(1).toWest(1),
 			origin.toSouth(1).toEast(1),
 		}),
-		Promote: func(from, to uint8) (can, must bool) {
-			switch {
-			case (shogi.SquareRank(from) <= 3) || (shogi.SquareRank(to) <= 3):
-				can = true
-				must = false
-			}
-			return
-		},
 	}
 
 	// WhiteSilver
@@ -131,14 +78,6 @@ var (
 			origin.toSouth(1),
 			origin.toSouth(1).toEast(1),
 		}),
-		Promote: func(from, to uint8) (can, must bool) {
-			switch {
-			case (shogi.SquareRank(from) >= 7) || (shogi.SquareRank(to) >= 7):
-				can = true
-				must = false
-			}
-			return
-		},
 	}
 
 	// BlackGold
@@ -151,7 +90,6 @@ var (
 			origin.toSouth(1),
 			origin.toEast(1),
 		}),
-		Promote: func(_, _ uint8) (can, must bool) { return },
 	}
 
 	// WhiteGold
@@ -164,7 +102,6 @@ var (
 			origin.toSouth(1),
 			origin.toSouth(1).toEast(1),
 		}),
-		Promote: func(_, _ uint8) (can, must bool) { return },
 	}
 
 	// Kings
@@ -179,7 +116,6 @@ var (
 			origin.toSouth(1).toWest(1),
 			origin.toSouth(1).toEast(1),
 		}),
-		Promote: func(_, _ uint8) (can, must bool) { return },
 	}
 
 	// PromotedBishops (additional moves)
@@ -190,7 +126,6 @@ var (
 			origin.toEast(1),
 			origin.toSouth(1),
 		}),
-		Promote: func(_, _ uint8) (can, must bool) { return },
 	}
 
 	// PromotedRooks (additional moves)
@@ -201,6 +136,5 @@ var (
 			origin.toSouth(1).toWest(1),
 			origin.toSouth(1).toEast(1),
 		}),
-		Promote: func(_, _ uint8) (can, must bool) { return },
 	}
 )
@@ -11,19 +11,14 @@ import (
 // ***************** Sliding Pieces Move Rules ***************** //
 // ************************************************************* //
 type slidingPieceMoveRules struct {
-	promote promoteFunc
-	magics  magicsTable
+	magics magicsTable
 }
 
 // generateMoves generates moves for a sliding piece on the board.
 func (rules slidingPieceMoveRules) generateMoves(piece shogi.Piece, gs *shogi.Position, list *MoveList) {
 	mypieces := gs.BBbyPiece[piece]
-	occupied := gs.BBbyColor[shogi.Black].Or(gs.BBbyColor[shogi.White])
-
-	var promoteFn = rules.promote
-	if piece == shogi.BlackPromotedBishop || piece == shogi.WhitePromotedBishop || piece == shogi.BlackPromotedRook || piece == shogi.WhitePromotedRook {
-		promoteFn = func(_, _ uint8) (can, must bool) { return }
-	}
+	// squares outside of the variant board block sliding pieces like occupied squares
+	occupied := gs.BBbyColor[shogi.Black].Or(gs.BBbyColor[shogi.White]).Or(gs.Variant.Squares.Not())
 
 	// iterate over each of our pieces
 	for mypieces != bitboard.Zero {
@@ -33,7 +28,7 @@ func (rules slidingPieceMoveRules) generateMoves(piece shogi.Piece, gs *shogi.Po
 		index := magicIndex(blockers, me.magic, me.shift)
 		attacks := me.attacks[index]
 		// generate moves for the current piece on "from"
-		generateMoves(from, attacks, gs, promoteFn, list)
+		generateMoves(piece, from, attacks, gs, list)
 		mypieces = mypieces.Clear(uint(from))
 	}
 }
@@ -47,17 +42,6 @@ var (
 
 	blackLanceMoveRules = slidingPieceMoveRules{
 		magics: newMagicsTable(blackLanceMagics, blackLanceDirections, blackLanceEdges),
-		promote: func(_, to uint8) (can, must bool) {
-			switch {
-			case shogi.SquareRank(to) <= 3 && shogi.SquareRank(to) > 1:
-				can = true
-				must = false
-			case shogi.SquareRank(to) == 1:
-				can = true
-				must = true
-			}
-			return
-		},
 	}
 
 	blackLanceMagics = [shogi.SQUARES]uint64{
@@ -81,17 +65,6 @@ var (
 
 	whiteLanceMoveRules = slidingPieceMoveRules{
 		magics: newMagicsTable(whiteLanceMagics, whiteLanceDirections, whiteLanceEdges),
-		promote: func(_, to uint8) (can, must bool) {
-			switch {
-			case shogi.SquareRank(to) >= 7 && shogi.SquareRank(to) < 9:
-				can = true
-				must = false
-			case shogi.SquareRank(to) == 9:
-				can = true
-				must = true
-			}
-			return
-		},
 	}
 
 	whiteLanceMagics = [shogi.SQUARES]uint64{
@@ -118,26 +91,10 @@ var (
 
 	blackBishopMoveRules = slidingPieceMoveRules{
 		magics: newMagicsTable(bishopMagics, bishopDirections, bishopEdges),
-		promote: func(from, to uint8) (can, must bool) {
-			switch {
-			case shogi.SquareRank(from) <= 3 || shogi.SquareRank(to) <= 3:
-				can = true
-				must = false
-			}
-			return
-		},
 	}
 
 	whiteBishopMoveRules = slidingPieceMoveRules{
 		magics: newMagicsTable(bishopMagics, bishopDirections, bishopEdges),
-		promote: func(from, to uint8) (can, must bool) {
-			switch {
-			case shogi.SquareRank(from) >= 7 || shogi.SquareRank(to) >= 7:
-				can = true
-				must = false
-			}
-			return
-		},
 	}
 
 	bishopMagics = [shogi.SQUARES]uint64{
@@ -162,26 +119,10 @@ var (
 
 	blackRookHMoveRules = slidingPieceMoveRules{
 		magics: newMagicsTable(rookHMagics, rookHDirections, rookHEdges),
-		promote: func(from, to uint8) (can, must bool) {
-			switch {
-			case shogi.SquareRank(from) <= 3 || shogi.SquareRank(to) <= 3:
-				can = true
-				must = false
-			}
-			return
-		},
 	}
 
 	whiteRookHMoveRules = slidingPieceMoveRules{
 		magics: newMagicsTable(rookHMagics, rookHDirections, rookHEdges),
-		promote: func(from, to uint8) (can, must bool) {
-			switch {
-			case shogi.SquareRank(from) >= 7 || shogi.SquareRank(to) >= 7:
-				can = true
-				must = false
-			}
-			return
-		},
 	}
 
 	rookHMagics = [shogi.SQUARES]uint64{
@@ -206,26 +147,10 @@ var (
 
 	blackRookVMoveRules = slidingPieceMoveRules{
 		magics: newMagicsTable(rookVMagics, rookVDirections, rookVEdges),
-		promote: func(from, to uint8) (can, must bool) {
-			switch {
-			case shogi.SquareRank(from) <= 3 || shogi.SquareRank(to) <= 3:
-				can = true
-				must = false
-			}
-			return
-		},
 	}
 
 	whiteRookVMoveRules = slidingPieceMoveRules{
 		magics: newMagicsTable(rookVMagics, rookVDirections, rookVEdges),
-		promote: func(from, to uint8) (can, must bool) {
-			switch {
-			case shogi.SquareRank(from) >= 7 || shogi.SquareRank(to) >= 7:
-				can = true
-				must = false
-			}
-			return
-		},
 	}
 
 	rookVMagics = [shogi.SQUARES]uint64{
@@ -99,3 +99,43 @@ func TestCheckers(t *testing.T) {
 		})
 	}
 }
+
+func TestFlippingMoves(t *testing.T) {
+	tests := []struct { //nolint:govet
+		sfen     string
+		move     string
+		expected string
+	}{
+		{sfen: shogi.KyotoShogi.StartPos, move: "1e1d+", expected: "p+nks+l/5/5/4+P/+LSK+N1 w - 2"},
+		{sfen: shogi.KyotoShogi.StartPos, move: "5e5d-", expected: "p+nks+l/5/5/L4/1SK+NP w - 2"},
+		{sfen: "5/2k2/5/2K2/5 b Pl 1", move: "+P*3c", expected: "5/2k2/2+P2/2K2/5 w l 2"},
+		{sfen: "5/2k2/4+p/2K2/4+P b - 1", move: "1e1c-", expected: "5/2k2/4P/2K2/5 w P 2"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.sfen+"/"+tc.move, func(t *testing.T) {
+			g, err := shogi.KyotoShogi.NewPositionFromSfen(tc.sfen)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var list MoveList
+			GenerateAllMoves(g, &list)
+			for i := 0; i < list.Count; i++ {
+				m := list.Moves[i]
+				if m.String() != tc.move {
+					continue
+				}
+				g.DoMove(m)
+				if g.Sfen() != tc.expected {
+					t.Fatalf("DoMove: expected='%s', got='%s'", tc.expected, g.Sfen())
+				}
+				g.UndoMove(m)
+				if g.Sfen() != tc.sfen {
+					t.Fatalf("UndoMove: expected='%s', got='%s'", tc.sfen, g.Sfen())
+				}
+				return
+			}
+			t.Fatalf("move %s not generated", tc.move)
+		})
+	}
+}
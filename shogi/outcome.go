@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package shogi
+
+// Status represents the state of a game.
+type Status int
+
+const (
+	Ongoing         Status = iota // The game continues
+	Checkmate                     // The side to move is checkmated and loses
+	NoLegalMoves                  // The side to move is not in check but has no legal move, and loses
+	PawnDropMate                  // The last move checkmated by a pawn drop, which is forbidden: the side to move wins
+	Sennichite                    // The same position occurred four times, the game is a draw
+	PerpetualCheck                // Sennichite with continuous checks by one side, which loses
+	DeclarationWin                // The side to move wins by declaring under the entering king rule
+	TryWin                        // The side which has just moved wins by a try
+	IllegalPosition               // The side not to move is in check
+)
+
+var statusNames = []string{
+	"ongoing", "checkmate", "no legal moves", "pawn drop mate", "sennichite",
+	"perpetual check", "declaration win", "try win", "illegal position",
+}
+
+// String returns a description of the status.
+func (s Status) String() string {
+	return statusNames[s]
+}
+
+// An Outcome is the Status of a game and its Winner, NoColor for a draw or an ongoing game.
+type Outcome struct {
+	Status Status
+	Winner Color
+}
+
+// Status returns the state of the game. Requires package movegen.
+func (p *Position) Status() Status {
+	return p.Outcome().Status
+}
+
+// Outcome returns the state of the game and its winner. Repetitions are detected
+// using the moves played with DoMove since the Position was created. Requires package movegen.
+func (p *Position) Outcome() Outcome {
+	mg := mustMoveGenerator()
+
+	if mg.InCheck(p, p.Side.Opponent()) {
+		return Outcome{Status: IllegalPosition, Winner: NoColor}
+	}
+
+	if p.TryWin() {
+		return Outcome{Status: TryWin, Winner: p.Side.Opponent()}
+	}
+
+	if outcome, ok := p.repetition(); ok {
+		return outcome
+	}
+
+	if p.CanDeclareWin() {
+		return Outcome{Status: DeclarationWin, Winner: p.Side}
+	}
+
+	if len(mg.LegalMoves(p)) == 0 {
+		switch {
+		case !mg.InCheck(p, p.Side):
+			return Outcome{Status: NoLegalMoves, Winner: p.Side.Opponent()}
+		case p.lastMoveIsPawnDrop():
+			return Outcome{Status: PawnDropMate, Winner: p.Side}
+		default:
+			return Outcome{Status: Checkmate, Winner: p.Side.Opponent()}
+		}
+	}
+
+	return Outcome{Status: Ongoing, Winner: NoColor}
+}
+
+// repetition detects sennichite. When the current position occurs for the fourth
+// time, the moves since its previous occurrence are replayed backward to detect if
+// one side has given check at each of its moves.
+func (p *Position) repetition() (Outcome, bool) {
+	occurrences := 1
+	previous := -1
+	for i := len(p.history) - 1; i >= 0; i-- {
+		if p.history[i] == p.key {
+			occurrences++
+			if previous < 0 {
+				previous = i
+			}
+		}
+	}
+	if occurrences < 4 {
+		return Outcome{}, false //nolint:exhaustruct
+	}
+
+	// continuousChecks[c] stays true while all moves of c in the cycle gave check
+	continuousChecks := [COLORS]bool{true, true}
+	q := p.Clone()
+	for i := len(q.moves) - 1; i >= previous; i-- {
+		mover := q.Side.Opponent()
+		if !moveGenerator.InCheck(q, q.Side) {
+			continuousChecks[mover] = false
+		}
+		q.UndoMove(q.moves[i])
+	}
+
+	switch {
+	case continuousChecks[Black] && !continuousChecks[White]:
+		return Outcome{Status: PerpetualCheck, Winner: White}, true
+	case continuousChecks[White] && !continuousChecks[Black]:
+		return Outcome{Status: PerpetualCheck, Winner: Black}, true
+	}
+	return Outcome{Status: Sennichite, Winner: NoColor}, true
+}
+
+// lastMoveIsPawnDrop returns true if the last move played with DoMove is a pawn drop.
+// There is no pawn in hand in variants with piece flipping, only pawn/rook pieces.
+func (p *Position) lastMoveIsPawnDrop() bool {
+	if len(p.moves) == 0 || p.Variant.PieceFlipping {
+		return false
+	}
+	m := p.moves[len(p.moves)-1]
+	return m.flags() == MoveFlagDrop && (m.Piece() == BlackPawn || m.Piece() == WhitePawn)
+}
@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package shogi
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/vinymeuh/hifumi/shogi/bitboard"
+)
+
+// ParseMove decodes a USI move string (e.g. "7g7f", "8h2b+" or "P*5e") into the legal Move
+// it represents for the side to move of the Position. The returned error explains why the
+// move is invalid or illegal. Requires package movegen.
+func ParseMove(p *Position, str string) (Move, error) {
+	m, err := p.decodeMove(str)
+	if err != nil {
+		return Move(0), err
+	}
+
+	mg := mustMoveGenerator()
+	if slices.Contains(mg.LegalMoves(p), m) {
+		return m, nil
+	}
+	pseudoLegalMoves := mg.PseudoLegalMoves(p)
+	if slices.Contains(pseudoLegalMoves, m) {
+		return Move(0), fmt.Errorf("illegal move %s: king would be in check", str)
+	}
+	return Move(0), fmt.Errorf("illegal move %s: %s", str, p.explainIllegalMove(m, pseudoLegalMoves))
+}
+
+// decodeMove builds the Move described by a USI move string, with its flags and captured piece,
+// checking only what can be read from the board and the hands.
+func (p *Position) decodeMove(str string) (Move, error) {
+	// drop
+	if len(str) >= 4 && str[len(str)-3] == '*' {
+		to, err := p.parseSquare(str[len(str)-2:])
+		if err != nil {
+			return Move(0), fmt.Errorf("invalid move %s: %w", str, err)
+		}
+		letter := str[:len(str)-3]
+		piece, err := NewPiece(letter)
+		if err != nil || piece.Color() != Black || (piece.UnPromote() != piece && !p.Variant.PieceFlipping) {
+			return Move(0), fmt.Errorf("invalid move %s: can't drop '%s'", str, letter)
+		}
+		if p.Side == White {
+			piece += PIECE_TYPES
+		}
+		switch {
+		case p.Hands[p.Side].ByPiece[piece.UnPromote()] == 0:
+			return Move(0), fmt.Errorf("illegal move %s: no %s in hand", str, piece.UnPromote())
+		case p.Board[to] != NoPiece:
+			return Move(0), fmt.Errorf("illegal move %s: %s is not empty", str, SquareString(to))
+		}
+		return NewMove(MoveFlagDrop, 0, to, piece), nil
+	}
+
+	// move
+	if len(str) != 4 && len(str) != 5 {
+		return Move(0), fmt.Errorf("invalid move %s: expected a move like 7g7f, 8h2b+ or P*5e", str)
+	}
+	from, err := p.parseSquare(str[0:2])
+	if err != nil {
+		return Move(0), fmt.Errorf("invalid move %s: %w", str, err)
+	}
+	to, err := p.parseSquare(str[2:4])
+	if err != nil {
+		return Move(0), fmt.Errorf("invalid move %s: %w", str, err)
+	}
+	piece := p.Board[from]
+	switch {
+	case piece == NoPiece:
+		return Move(0), fmt.Errorf("illegal move %s: no piece on %s", str, SquareString(from))
+	case piece.Color() != p.Side:
+		return Move(0), fmt.Errorf("illegal move %s: piece on %s belongs to the opponent", str, SquareString(from))
+	case p.Board[to] != NoPiece && p.Board[to].Color() == p.Side:
+		return Move(0), fmt.Errorf("illegal move %s: %s is occupied by an own piece", str, SquareString(to))
+	}
+
+	flags, captured := MoveFlagMove, NoPiece
+	if p.Board[to] != NoPiece {
+		flags, captured = flags|MoveFlagCapture, p.Board[to]
+	}
+	if len(str) == 5 {
+		switch {
+		case str[4] == '+' && piece.Promote() != piece:
+			flags |= MoveFlagPromotion
+		case str[4] == '+':
+			return Move(0), fmt.Errorf("illegal move %s: %s can't be promoted", str, piece)
+		case str[4] == '-' && p.Variant.PieceFlipping && piece.UnPromote() != piece:
+			flags |= MoveFlagDemotion
+		case str[4] == '-' && p.Variant.PieceFlipping:
+			return Move(0), fmt.Errorf("illegal move %s: %s can't be flipped back", str, piece)
+		default:
+			return Move(0), fmt.Errorf("invalid move %s: unexpected suffix '%c'", str, str[4])
+		}
+	}
+	return NewMove(flags, from, to, captured), nil
+}
+
+// parseSquare returns the index of a square of the variant board from its USI coordinates.
+func (p *Position) parseSquare(str string) (uint8, error) {
+	if len(str) != 2 || str[0] < '1' || str[0] > '9' || str[1] < 'a' || str[1] > 'i' {
+		return 0, fmt.Errorf("invalid square '%s'", str)
+	}
+	sq := NewSquareIndex(str)
+	if !p.Variant.Contains(sq) {
+		return 0, fmt.Errorf("square %s is outside of the board", str)
+	}
+	return sq, nil
+}
+
+// explainIllegalMove returns why a decoded move is not one of the pseudo-legal moves.
+func (p *Position) explainIllegalMove(m Move, pseudoLegalMoves []Move) string {
+	flags, from, to, piece := m.destructure()
+
+	if flags == MoveFlagDrop {
+		if p.Variant.DeadZone(piece).Bit(uint(to)) == 1 {
+			return fmt.Sprintf("%s dropped on %s would have no legal move", piece, SquareString(to))
+		}
+		if piece == BlackPawn || piece == WhitePawn {
+			for pawns := p.BBbyPiece[piece]; pawns != bitboard.Zero; {
+				sq := uint8(pawns.Lsb())
+				if SquareFile(sq) == SquareFile(to) {
+					return fmt.Sprintf("two pawns on file %d", SquareFile(to))
+				}
+				pawns = pawns.Clear(uint(sq))
+			}
+		}
+		return fmt.Sprintf("%s can't be dropped on %s", piece, SquareString(to))
+	}
+
+	piece = p.Board[from]
+	reachable := slices.ContainsFunc(pseudoLegalMoves, func(pm Move) bool {
+		return pm.flags()&MoveFlagMove != 0 && pm.From() == from && pm.To() == to
+	})
+	zone := p.Variant.PromotionZone(p.Side)
+	switch {
+	case !reachable:
+		return fmt.Sprintf("%s can't move from %s to %s", piece, SquareString(from), SquareString(to))
+	case p.Variant.PieceFlipping && flags&(MoveFlagPromotion|MoveFlagDemotion) == 0:
+		return fmt.Sprintf("%s must be flipped", piece)
+	case !p.Variant.PieceFlipping && flags&MoveFlagPromotion != 0 && zone.Bit(uint(from)) == 0 && zone.Bit(uint(to)) == 0:
+		return fmt.Sprintf("%s can't be promoted outside of the promotion zone", piece)
+	case p.Variant.PieceFlipping:
+		return fmt.Sprintf("%s can't be flipped this way", piece)
+	}
+	return fmt.Sprintf("%s must be promoted on %s", piece, SquareString(to))
+}
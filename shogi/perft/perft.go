@@ -14,15 +14,6 @@ type Result struct {
 	NodesCount int
 }
 
-func (r Result) FindMove(str string) shogi.Move {
-	for m := range r.Moves {
-		if m.String() == str {
-			return m
-		}
-	}
-	return shogi.Move(0)
-}
-
 func Compute(position *shogi.Position, depth int) *Result {
 	var result Result
 	result.Moves = map[shogi.Move]int{}
@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package perft
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+func TestPerft(t *testing.T) {
+	tests := []struct { //nolint:govet
+		variant *shogi.Variant
+		sfen    string
+		nodes   []int // reference nodes count by depth, starting at depth 1
+	}{
+		{
+			variant: shogi.Standard,
+			sfen:    shogi.StartPos,
+			nodes:   []int{30, 900, 25470},
+		},
+		{
+			variant: shogi.Standard,
+			sfen:    "8l/1l+R2P3/p2pBG1pp/kps1p4/Nn1P2G2/P1P1P2PP/1PS6/1KSG3+r1/LN2+p3L w Sbgn3p 124",
+			nodes:   []int{178, 18041},
+		},
+		{
+			variant: shogi.Minishogi,
+			sfen:    shogi.Minishogi.StartPos,
+			nodes:   []int{14, 181, 2512, 35401},
+		},
+		// Judkins and Kyoto shogi values are given by the reference generator of xreference_test.go,
+		// which shares no code with movegen and is itself checked with the standard and minishogi values
+		{
+			variant: shogi.Judkins,
+			sfen:    shogi.Judkins.StartPos,
+			nodes:   []int{20, 336, 6183},
+		},
+		{
+			variant: shogi.KyotoShogi,
+			sfen:    shogi.KyotoShogi.StartPos,
+			nodes:   []int{12, 137, 1636, 18268},
+		},
+		{
+			variant: shogi.KyotoShogi,
+			sfen:    "5/2k2/5/2K2/5 b Pl 1",
+			nodes:   []int{51},
+		},
+	}
+
+	for _, tc := range tests {
+		for i, expected := range tc.nodes {
+			depth := i + 1
+			t.Run(fmt.Sprintf("%s/%s/%d", tc.variant.Name, tc.sfen, depth), func(t *testing.T) {
+				pos, err := tc.variant.NewPositionFromSfen(tc.sfen)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				result := Compute(pos, depth)
+				if result.NodesCount != expected {
+					t.Fatalf("expected=%d, got=%d", expected, result.NodesCount)
+				}
+			})
+		}
+	}
+}
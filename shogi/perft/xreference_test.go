@@ -0,0 +1,407 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package perft
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/movegen"
+)
+
+// ================================== //
+// === Reference move generator ===== //
+// ================================== //
+
+// The reference generator is a naive mailbox implementation of the rules, sharing no code with
+// movegen, used to check the perft values of the variants without published references.
+// As perft.Compute, it counts the pseudo-legal moves not leaving the king in check: the
+// rule forbidding a pawn drop giving mate is not applied.
+
+type refPiece struct {
+	kind     byte // one of PLNSGBRK, 0 for an empty square
+	promoted bool
+	black    bool
+}
+
+type refPosition struct {
+	files, ranks   int
+	promotionRanks int
+	flipping       bool
+	board          [][]refPiece // board[row][col], row 0 is rank a, col 0 is the highest file
+	hands          [2]map[byte]int
+	blackToMove    bool
+}
+
+type refMove struct {
+	drop     bool
+	fromRow  int
+	fromCol  int
+	toRow    int
+	toCol    int
+	moved    refPiece // piece before the move
+	piece    refPiece // dropped piece or piece after the move
+	captured refPiece
+}
+
+func newRefPosition(v *shogi.Variant, sfen string) (*refPosition, error) {
+	fields := strings.Fields(sfen)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid sfen '%s'", sfen)
+	}
+	rp := &refPosition{
+		files:          v.Files,
+		ranks:          v.Ranks,
+		promotionRanks: v.PromotionRanks,
+		flipping:       v.PieceFlipping,
+		board:          nil,
+		hands:          [2]map[byte]int{{}, {}},
+		blackToMove:    fields[1] == "b",
+	}
+	for _, row := range strings.Split(fields[0], "/") {
+		var pieces []refPiece
+		promoted := false
+		for _, c := range row {
+			switch {
+			case c >= '1' && c <= '9':
+				for i := 0; i < int(c-'0'); i++ {
+					pieces = append(pieces, refPiece{kind: 0, promoted: false, black: false})
+				}
+			case c == '+':
+				promoted = true
+			default:
+				pieces = append(pieces, refPiece{kind: byte(strings.ToUpper(string(c))[0]), promoted: promoted, black: c < 'a'})
+				promoted = false
+			}
+		}
+		if len(pieces) != rp.files {
+			return nil, fmt.Errorf("invalid row '%s'", row)
+		}
+		rp.board = append(rp.board, pieces)
+	}
+	if len(rp.board) != rp.ranks {
+		return nil, fmt.Errorf("invalid number of rows in '%s'", fields[0])
+	}
+	if fields[2] != "-" {
+		n := 0
+		for _, c := range fields[2] {
+			if c >= '0' && c <= '9' {
+				n = n*10 + int(c-'0')
+				continue
+			}
+			if n == 0 {
+				n = 1
+			}
+			side := 1
+			if c < 'a' {
+				side = 0
+			}
+			rp.hands[side][byte(strings.ToUpper(string(c))[0])] += n
+			n = 0
+		}
+	}
+	return rp, nil
+}
+
+func (rp *refPosition) side() int {
+	if rp.blackToMove {
+		return 0
+	}
+	return 1
+}
+
+func (rp *refPosition) inside(row, col int) bool {
+	return row >= 0 && row < rp.ranks && col >= 0 && col < rp.files
+}
+
+var (
+	refGoldSteps   = [][2]int{{-1, -1}, {-1, 0}, {-1, 1}, {0, -1}, {0, 1}, {1, 0}}
+	refSilverSteps = [][2]int{{-1, -1}, {-1, 0}, {-1, 1}, {1, -1}, {1, 1}}
+	refKingSteps   = [][2]int{{-1, -1}, {-1, 0}, {-1, 1}, {0, -1}, {0, 1}, {1, -1}, {1, 0}, {1, 1}}
+	refDiagonals   = [][2]int{{-1, -1}, {-1, 1}, {1, -1}, {1, 1}}
+	refOrthogonals = [][2]int{{-1, 0}, {0, -1}, {0, 1}, {1, 0}}
+)
+
+// movement returns the steps and the sliding directions of a piece, for black.
+func (rp *refPosition) movement(p refPiece) (steps, slides [][2]int) {
+	if rp.flipping && p.promoted {
+		switch p.kind {
+		case 'P':
+			return nil, refOrthogonals
+		case 'S':
+			return nil, refDiagonals
+		default: // tokin and gold
+			return refGoldSteps, nil
+		}
+	}
+	switch {
+	case p.kind == 'K':
+		return refKingSteps, nil
+	case p.kind == 'B' && p.promoted:
+		return refOrthogonals, refDiagonals
+	case p.kind == 'R' && p.promoted:
+		return refDiagonals, refOrthogonals
+	case p.kind == 'B':
+		return nil, refDiagonals
+	case p.kind == 'R':
+		return nil, refOrthogonals
+	case p.kind == 'G' || p.promoted:
+		return refGoldSteps, nil
+	case p.kind == 'P':
+		return [][2]int{{-1, 0}}, nil
+	case p.kind == 'L':
+		return nil, [][2]int{{-1, 0}}
+	case p.kind == 'N':
+		return [][2]int{{-2, -1}, {-2, 1}}, nil
+	case p.kind == 'S':
+		return refSilverSteps, nil
+	}
+	panic(fmt.Sprintf("unknown piece %c", p.kind))
+}
+
+// targets returns the squares reached by the piece on a square, empty or occupied.
+func (rp *refPosition) targets(row, col int) [][2]int {
+	p := rp.board[row][col]
+	forward := 1
+	if !p.black {
+		forward = -1
+	}
+	steps, slides := rp.movement(p)
+	var squares [][2]int
+	for _, s := range steps {
+		r, c := row+s[0]*forward, col+s[1]*forward
+		if rp.inside(r, c) {
+			squares = append(squares, [2]int{r, c})
+		}
+	}
+	for _, s := range slides {
+		r, c := row+s[0]*forward, col+s[1]*forward
+		for rp.inside(r, c) {
+			squares = append(squares, [2]int{r, c})
+			if rp.board[r][c].kind != 0 {
+				break
+			}
+			r, c = r+s[0]*forward, c+s[1]*forward
+		}
+	}
+	return squares
+}
+
+// farRows returns the distance of a row to the last rank of a side, 1 for the last rank.
+func (rp *refPosition) farRows(row int, black bool) int {
+	if black {
+		return row + 1
+	}
+	return rp.ranks - row
+}
+
+// deadRows is the number of last ranks where an unpromoted piece has no more move.
+func (rp *refPosition) deadRows(p refPiece) int {
+	if rp.flipping || p.promoted {
+		return 0
+	}
+	switch p.kind {
+	case 'P', 'L':
+		return 1
+	case 'N':
+		return 2
+	}
+	return 0
+}
+
+func (rp *refPosition) pseudoMoves() []refMove {
+	var moves []refMove
+	for row := 0; row < rp.ranks; row++ {
+		for col := 0; col < rp.files; col++ {
+			p := rp.board[row][col]
+			if p.kind == 0 || p.black != rp.blackToMove {
+				continue
+			}
+			for _, to := range rp.targets(row, col) {
+				captured := rp.board[to[0]][to[1]]
+				if captured.kind != 0 && captured.black == p.black {
+					continue
+				}
+				move := refMove{drop: false, fromRow: row, fromCol: col, toRow: to[0], toCol: to[1], moved: p, piece: p, captured: captured}
+				switch {
+				case p.kind == 'K':
+					moves = append(moves, move)
+				case rp.flipping:
+					move.piece.promoted = !p.promoted
+					moves = append(moves, move)
+				case p.promoted || p.kind == 'G':
+					moves = append(moves, move)
+				default:
+					canPromote := rp.farRows(row, p.black) <= rp.promotionRanks || rp.farRows(to[0], p.black) <= rp.promotionRanks
+					if rp.farRows(to[0], p.black) > rp.deadRows(p) {
+						moves = append(moves, move)
+					}
+					if canPromote {
+						move.piece.promoted = true
+						moves = append(moves, move)
+					}
+				}
+			}
+		}
+	}
+
+	for kind, n := range rp.hands[rp.side()] {
+		if n == 0 {
+			continue
+		}
+		for row := 0; row < rp.ranks; row++ {
+			for col := 0; col < rp.files; col++ {
+				if rp.board[row][col].kind != 0 {
+					continue
+				}
+				p := refPiece{kind: kind, promoted: false, black: rp.blackToMove}
+				empty := refPiece{kind: 0, promoted: false, black: false}
+				drop := refMove{drop: true, fromRow: 0, fromCol: 0, toRow: row, toCol: col, moved: empty, piece: p, captured: empty}
+				if rp.flipping {
+					moves = append(moves, drop)
+					drop.piece.promoted = true
+					moves = append(moves, drop)
+					continue
+				}
+				if rp.farRows(row, p.black) <= rp.deadRows(p) || (kind == 'P' && rp.pawnOnFile(col)) {
+					continue
+				}
+				moves = append(moves, drop)
+			}
+		}
+	}
+	return moves
+}
+
+// pawnOnFile returns true if the side to move has an unpromoted pawn on the column.
+func (rp *refPosition) pawnOnFile(col int) bool {
+	for row := 0; row < rp.ranks; row++ {
+		p := rp.board[row][col]
+		if p.kind == 'P' && !p.promoted && p.black == rp.blackToMove {
+			return true
+		}
+	}
+	return false
+}
+
+func (rp *refPosition) do(m refMove) {
+	side := rp.side()
+	if m.drop {
+		rp.hands[side][m.piece.kind]--
+	} else {
+		rp.board[m.fromRow][m.fromCol] = refPiece{kind: 0, promoted: false, black: false}
+		if m.captured.kind != 0 {
+			rp.hands[side][m.captured.kind]++
+		}
+	}
+	rp.board[m.toRow][m.toCol] = m.piece
+	rp.blackToMove = !rp.blackToMove
+}
+
+func (rp *refPosition) undo(m refMove) {
+	rp.blackToMove = !rp.blackToMove
+	side := rp.side()
+	rp.board[m.toRow][m.toCol] = m.captured
+	if m.drop {
+		rp.hands[side][m.piece.kind]++
+	} else {
+		rp.board[m.fromRow][m.fromCol] = m.moved
+		if m.captured.kind != 0 {
+			rp.hands[side][m.captured.kind]--
+		}
+	}
+}
+
+// kingAttacked returns true if the king of a side is attacked.
+func (rp *refPosition) kingAttacked(black bool) bool {
+	for row := 0; row < rp.ranks; row++ {
+		for col := 0; col < rp.files; col++ {
+			p := rp.board[row][col]
+			if p.kind == 0 || p.black == black {
+				continue
+			}
+			for _, to := range rp.targets(row, col) {
+				if t := rp.board[to[0]][to[1]]; t.kind == 'K' && t.black == black {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (rp *refPosition) perft(depth int) int {
+	if depth == 0 {
+		return 1
+	}
+	nodes := 0
+	black := rp.blackToMove
+	for _, m := range rp.pseudoMoves() {
+		rp.do(m)
+		if !rp.kingAttacked(black) {
+			nodes += rp.perft(depth - 1)
+		}
+		rp.undo(m)
+	}
+	return nodes
+}
+
+// ================================== //
+// ============= Tests ============== //
+// ================================== //
+
+func TestReferencePerft(t *testing.T) {
+	// the reference generator is checked with the published values of standard shogi and minishogi,
+	// then gives the values of Judkins and Kyoto shogi used by TestPerft
+	tests := []struct {
+		variant *shogi.Variant
+		nodes   []int
+	}{
+		{shogi.Standard, []int{30, 900, 25470}},
+		{shogi.Minishogi, []int{14, 181, 2512, 35401}},
+		{shogi.Judkins, []int{20, 336, 6183}},
+		{shogi.KyotoShogi, []int{12, 137, 1636, 18268}},
+	}
+	for _, tc := range tests {
+		for i, expected := range tc.nodes {
+			rp, err := newRefPosition(tc.variant, tc.variant.StartPos)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nodes := rp.perft(i + 1); nodes != expected {
+				t.Errorf("%s depth %d: expected %d nodes, got %d", tc.variant.Name, i+1, expected, nodes)
+			}
+		}
+	}
+}
+
+// TestPerftAgainstReference compares perft with the reference generator along random games.
+func TestPerftAgainstReference(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, v := range []*shogi.Variant{shogi.Minishogi, shogi.Judkins, shogi.KyotoShogi} {
+		for game := 0; game < 10; game++ {
+			pos, err := v.NewPositionFromSfen(v.StartPos)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for ply := 0; ply < 40; ply++ {
+				sfen := pos.Sfen()
+				rp, err := newRefPosition(v, sfen)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if expected, nodes := rp.perft(2), Compute(pos, 2).NodesCount; nodes != expected {
+					t.Fatalf("%s %s: expected %d nodes, got %d", v.Name, sfen, expected, nodes)
+				}
+				moves := movegen.LegalMoves(pos)
+				if len(moves) == 0 {
+					break
+				}
+				pos.DoMove(moves[r.Intn(len(moves))])
+			}
+		}
+	}
+}
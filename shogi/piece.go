@@ -20,6 +20,17 @@ const (
 	White
 )
 
+// String returns "black", "white" or "none" for NoColor.
+func (c Color) String() string {
+	switch c { //nolint:exhaustive
+	case Black:
+		return "black"
+	case White:
+		return "white"
+	}
+	return "none"
+}
+
 // Opponent returns the opponent's color.
 func (c Color) Opponent() Color {
 	switch c { //nolint:exhaustive
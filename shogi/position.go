@@ -10,6 +10,10 @@ import (
 
 // A Position represents the state of a Shogi game.
 type Position struct {
+	// Variant played
+	Variant *Variant
+	// Rule for games where kings have entered the opponent camp
+	EnteringKingRule EnteringKingRule
 	// Hand for each color
 	Hands [COLORS]Hand
 	// The mailbox representation of the Shogi board
@@ -22,13 +26,21 @@ type Position struct {
 	BBbyColor [COLORS]bitboard.Bitboard
 	// Bitboards of pieces by piece
 	BBbyPiece [COLORS * PIECE_TYPES]bitboard.Bitboard
+	// Hash key of the position
+	key uint64
+	// Hash keys of the positions before each move played with DoMove
+	history []uint64
+	// Moves played with DoMove
+	moves []Move
 }
 
 // New creates an empty Position with no pieces on the board or in the hands.
 // Should rarely called directly, NewFromSfen is the constructor you are looking for.
-func newPosition() *Position {
+func newPosition(v *Variant) *Position {
 	p := Position{
-		Board: NewBoard(),
+		Variant:          v,
+		EnteringKingRule: NoEnteringKing,
+		Board:            NewBoard(),
 		Hands: [COLORS]Hand{
 			NewBlackHand(),
 			NewWhiteHand(),
@@ -37,6 +49,9 @@ func newPosition() *Position {
 		Ply:       0,
 		BBbyColor: [COLORS]bitboard.Bitboard{},
 		BBbyPiece: [COLORS * PIECE_TYPES]bitboard.Bitboard{},
+		key:       0,
+		history:   nil,
+		moves:     nil,
 	}
 
 	return &p
@@ -50,6 +65,7 @@ func (p *Position) SetPiece(piece Piece, square uint8) {
 func (p *Position) SetBitboards(piece Piece, square uint8) {
 	p.BBbyColor[piece.Color()] = p.BBbyColor[piece.Color()].Set(uint(square))
 	p.BBbyPiece[piece] = p.BBbyPiece[piece].Set(uint(square))
+	p.key ^= zobristBoard[piece][square]
 }
 
 func (p *Position) ClearPiece(piece Piece, square uint8) {
@@ -60,16 +76,20 @@ func (p *Position) ClearPiece(piece Piece, square uint8) {
 func (p *Position) ClearBitboards(piece Piece, square uint8) {
 	p.BBbyColor[piece.Color()] = p.BBbyColor[piece.Color()].Clear(uint(square))
 	p.BBbyPiece[piece] = p.BBbyPiece[piece].Clear(uint(square))
+	p.key ^= zobristBoard[piece][square]
 }
 
 // DoMove updates Position based on provided Move.
 func (p *Position) DoMove(m Move) {
+	p.history = append(p.history, p.key)
+	p.moves = append(p.moves, m)
+
 	flags, from, to, mPiece := m.destructure()
 	switch flags {
 	case MoveFlagDrop:
 		piece := mPiece
 		p.SetPiece(piece, to)
-		p.Hands[p.Side].Pop(piece)
+		p.handPop(p.Side, piece.UnPromote())
 	case MoveFlagMove:
 		piece := p.Board[from]
 		p.ClearPiece(piece, from)
@@ -78,24 +98,36 @@ func (p *Position) DoMove(m Move) {
 		piece := p.Board[from]
 		p.ClearPiece(piece, from)
 		p.SetPiece(piece.Promote(), to)
+	case MoveFlagMove | MoveFlagDemotion:
+		piece := p.Board[from]
+		p.ClearPiece(piece, from)
+		p.SetPiece(piece.UnPromote(), to)
 	case MoveFlagMove | MoveFlagCapture:
 		piece := p.Board[from]
 		captured := p.Board[to]
 		p.ClearPiece(piece, from)
 		p.ClearBitboards(captured, to)
 		p.SetPiece(piece, to)
-		p.Hands[p.Side].Push(captured.ToOpponentHand())
+		p.handPush(p.Side, captured.ToOpponentHand())
 	case MoveFlagMove | MoveFlagCapture | MoveFlagPromotion:
 		piece := p.Board[from]
 		captured := p.Board[to]
 		p.ClearPiece(piece, from)
 		p.ClearBitboards(captured, to)
 		p.SetPiece(piece.Promote(), to)
-		p.Hands[p.Side].Push(captured.ToOpponentHand())
+		p.handPush(p.Side, captured.ToOpponentHand())
+	case MoveFlagMove | MoveFlagCapture | MoveFlagDemotion:
+		piece := p.Board[from]
+		captured := p.Board[to]
+		p.ClearPiece(piece, from)
+		p.ClearBitboards(captured, to)
+		p.SetPiece(piece.UnPromote(), to)
+		p.handPush(p.Side, captured.ToOpponentHand())
 	}
 
 	p.Ply++
 	p.Side = p.Side.Opponent()
+	p.key ^= zobristSide
 }
 
 // UndoMove updates Position based on provided Move.
@@ -105,7 +137,7 @@ func (p *Position) UndoMove(m Move) {
 	case MoveFlagDrop:
 		piece := mPiece
 		p.ClearPiece(piece, to)
-		p.Hands[p.Side.Opponent()].Push(piece)
+		p.handPush(p.Side.Opponent(), piece.UnPromote())
 	case MoveFlagMove:
 		piece := p.Board[to]
 		p.ClearPiece(piece, to)
@@ -114,22 +146,53 @@ func (p *Position) UndoMove(m Move) {
 		piece := p.Board[to]
 		p.ClearPiece(piece, to)
 		p.SetPiece(piece.UnPromote(), from)
+	case MoveFlagMove | MoveFlagDemotion:
+		piece := p.Board[to]
+		p.ClearPiece(piece, to)
+		p.SetPiece(piece.Promote(), from)
 	case MoveFlagMove | MoveFlagCapture:
 		piece := p.Board[to]
 		captured := mPiece
 		p.SetPiece(piece, from)
 		p.ClearBitboards(piece, to)
 		p.SetPiece(captured, to)
-		p.Hands[p.Side.Opponent()].Pop(captured.ToOpponentHand())
+		p.handPop(p.Side.Opponent(), captured.ToOpponentHand())
 	case MoveFlagMove | MoveFlagCapture | MoveFlagPromotion:
 		piece := p.Board[to]
 		captured := mPiece
 		p.SetPiece(piece.UnPromote(), from)
 		p.ClearBitboards(piece, to)
 		p.SetPiece(captured, to)
-		p.Hands[p.Side.Opponent()].Pop(captured.ToOpponentHand())
+		p.handPop(p.Side.Opponent(), captured.ToOpponentHand())
+	case MoveFlagMove | MoveFlagCapture | MoveFlagDemotion:
+		piece := p.Board[to]
+		captured := mPiece
+		p.SetPiece(piece.Promote(), from)
+		p.ClearBitboards(piece, to)
+		p.SetPiece(captured, to)
+		p.handPop(p.Side.Opponent(), captured.ToOpponentHand())
 	}
 
 	p.Ply--
 	p.Side = p.Side.Opponent()
+	p.key ^= zobristSide
+
+	if n := len(p.moves); n > 0 {
+		p.history = p.history[:n-1]
+		p.moves = p.moves[:n-1]
+	}
+}
+
+// Clone returns a deep copy of the Position, safe to be modified independently.
+func (p *Position) Clone() *Position {
+	c := *p
+	c.history = append([]uint64(nil), p.history...)
+	c.moves = append([]Move(nil), p.moves...)
+	for color := range p.Hands {
+		c.Hands[color].ByPiece = make(map[Piece]int, len(p.Hands[color].ByPiece))
+		for piece, n := range p.Hands[color].ByPiece {
+			c.Hands[color].ByPiece[piece] = n
+		}
+	}
+	return &c
 }
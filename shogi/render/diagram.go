@@ -0,0 +1,319 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package render
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// Diagrams are drawn as a list of shapes, written as SVG elements or rasterised to PNG.
+
+// DefaultSquareSize is the size in pixels of a square of the diagrams.
+const DefaultSquareSize = 48
+
+// DiagramOptions are the options of the SVG and PNG diagrams.
+type DiagramOptions struct {
+	// Flip draws the board seen from White's side
+	Flip bool
+	// LastMove is highlighted, unless zero
+	LastMove shogi.Move
+	// Arrows are drawn from the origin to the destination of the moves,
+	// from the hand of the moving side for drops. Moves don't need to be legal.
+	Arrows []shogi.Move
+	// SquareSize is the size in pixels of a square, DefaultSquareSize if zero
+	SquareSize int
+}
+
+var (
+	boardColor     = color.RGBA{R: 0xf2, G: 0xd0, B: 0x8e, A: 0xff}
+	lineColor      = color.RGBA{R: 0x00, G: 0x00, B: 0x00, A: 0xff}
+	pieceColor     = color.RGBA{R: 0xfc, G: 0xf4, B: 0xdc, A: 0xff}
+	highlightColor = color.RGBA{R: 0xff, G: 0xe0, B: 0x00, A: 0x80}
+	arrowColor     = color.RGBA{R: 0xc0, G: 0x00, B: 0x00, A: 0xa0}
+	whiteColor     = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+)
+
+type point struct {
+	x, y float64
+}
+
+// A polygon is a closed shape, filled and optionally stroked.
+type polygon struct {
+	points      []point
+	fill        color.RGBA
+	stroke      color.RGBA
+	strokeWidth float64 // no stroke if zero
+}
+
+// A text is drawn with a font in SVG and with the built-in bitmap font in PNG.
+type text struct {
+	center     point
+	size       float64
+	text       string // SVG text
+	label      string // PNG text, ASCII only
+	start      bool   // anchored at its start instead of its center
+	upsideDown bool
+}
+
+// A diagram is a drawing of a position.
+type diagram struct {
+	width, height int
+	polygons      []polygon // drawn first
+	texts         []text
+	arrows        []polygon // drawn over the pieces
+}
+
+// rectangle returns the polygon of a rectangle.
+func rectangle(x, y, w, h float64, fill color.RGBA) polygon {
+	return polygon{
+		points:      []point{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}},
+		fill:        fill,
+		stroke:      color.RGBA{R: 0, G: 0, B: 0, A: 0},
+		strokeWidth: 0,
+	}
+}
+
+// segment returns the polygon of a line of a given width.
+func segment(from, to point, width float64, fill color.RGBA) polygon {
+	dx, dy := to.x-from.x, to.y-from.y
+	length := math.Hypot(dx, dy)
+	nx, ny := -dy/length*width/2, dx/length*width/2
+	return polygon{
+		points:      []point{{from.x + nx, from.y + ny}, {to.x + nx, to.y + ny}, {to.x - nx, to.y - ny}, {from.x - nx, from.y - ny}},
+		fill:        fill,
+		stroke:      color.RGBA{R: 0, G: 0, B: 0, A: 0},
+		strokeWidth: 0,
+	}
+}
+
+// pentagon returns the polygon of a piece of half height h, pointing up for Black.
+func pentagon(c point, h float64, side shogi.Color, fill color.RGBA) polygon {
+	dir := 1.0
+	if side == shogi.White {
+		dir = -1.0
+	}
+	return polygon{
+		points: []point{
+			{c.x, c.y - dir*h},
+			{c.x + 0.7*h, c.y - dir*0.7*h},
+			{c.x + 0.85*h, c.y + dir*h},
+			{c.x - 0.85*h, c.y + dir*h},
+			{c.x - 0.7*h, c.y - dir*0.7*h},
+		},
+		fill:        fill,
+		stroke:      lineColor,
+		strokeWidth: math.Max(1, h/20),
+	}
+}
+
+// newDiagram lays out the drawing of a position.
+func newDiagram(pos *shogi.Position, opts DiagramOptions) *diagram {
+	b := board{pos: pos, flip: opts.Flip}
+	files, ranks := b.files(), b.ranks()
+	s := float64(opts.SquareSize)
+	if s <= 0 {
+		s = DefaultSquareSize
+	}
+	pad := s / 2
+	x0, y0 := pad, pad+1.5*s // board origin
+	w, h := float64(len(files))*s, float64(len(ranks))*s
+	d := &diagram{
+		width:    int(math.Ceil(x0 + w + s/2 + pad)),
+		height:   int(math.Ceil(y0 + h + s + pad)),
+		polygons: nil,
+		texts:    nil,
+		arrows:   nil,
+	}
+	flipped := func(c shogi.Color) shogi.Color {
+		if opts.Flip {
+			return c.Opponent()
+		}
+		return c
+	}
+	center := func(sq uint8) point {
+		col, row := pos.Variant.Files-shogi.SquareFile(sq), shogi.SquareRank(sq)-1
+		if opts.Flip {
+			col, row = len(files)-1-col, len(ranks)-1-row
+		}
+		return point{x0 + (float64(col)+0.5)*s, y0 + (float64(row)+0.5)*s}
+	}
+	top, bottom := b.colors()
+	handCenter := map[shogi.Color]point{
+		top:    {x0 + s/2, pad + s/2},
+		bottom: {x0 + s/2, y0 + h + s/2},
+	}
+
+	// board and highlighted squares
+	d.polygons = append(d.polygons, rectangle(0, 0, float64(d.width), float64(d.height), whiteColor))
+	d.polygons = append(d.polygons, rectangle(x0, y0, w, h, boardColor))
+	if opts.LastMove != 0 {
+		squares := []uint8{opts.LastMove.To()}
+		if !opts.LastMove.IsDrop() {
+			squares = append(squares, opts.LastMove.From())
+		}
+		for _, sq := range squares {
+			c := center(sq)
+			d.polygons = append(d.polygons, rectangle(c.x-s/2, c.y-s/2, s, s, highlightColor))
+		}
+	}
+	lineWidth := math.Max(1, s/48)
+	for i := 0; i <= len(files); i++ {
+		x := x0 + float64(i)*s
+		d.polygons = append(d.polygons, segment(point{x, y0 - lineWidth/2}, point{x, y0 + h + lineWidth/2}, lineWidth, lineColor))
+	}
+	for i := 0; i <= len(ranks); i++ {
+		y := y0 + float64(i)*s
+		d.polygons = append(d.polygons, segment(point{x0 - lineWidth/2, y}, point{x0 + w + lineWidth/2, y}, lineWidth, lineColor))
+	}
+
+	// coordinates
+	for i, file := range files {
+		d.texts = append(d.texts, text{
+			center: point{x0 + (float64(i)+0.5)*s, y0 - s/4}, size: s / 3,
+			text: string(fullWidthDigits[file]), label: strconv.Itoa(file), start: false, upsideDown: false,
+		})
+	}
+	for i, rank := range ranks {
+		d.texts = append(d.texts, text{
+			center: point{x0 + w + s/4, y0 + (float64(i)+0.5)*s}, size: s / 3,
+			text: string(kanjiNumerals[rank+1]), label: string(rune('a' + rank)), start: false, upsideDown: false,
+		})
+	}
+
+	// pieces
+	for _, file := range files {
+		for _, rank := range ranks {
+			piece := b.piece(file, rank)
+			if piece == shogi.NoPiece {
+				continue
+			}
+			side := flipped(piece.Color())
+			c := center(uint8(rank*shogi.FILES + shogi.FILES - file))
+			d.polygons = append(d.polygons, pentagon(c, 0.42*s, side, pieceColor))
+			if side == shogi.White {
+				c.y -= 0.05 * s
+			} else {
+				c.y += 0.05 * s
+			}
+			d.texts = append(d.texts, text{
+				center: c, size: 0.55 * s,
+				text: kanji(piece), label: strings.ToUpper(piece.String()), start: false, upsideDown: side == shogi.White,
+			})
+		}
+	}
+
+	// hands, marked with a black piece for Black and a white one for White
+	for _, c := range []shogi.Color{top, bottom} {
+		mark := handCenter[c]
+		fill := whiteColor
+		if c == shogi.Black {
+			fill = lineColor
+		}
+		d.polygons = append(d.polygons, pentagon(mark, 0.3*s, shogi.Black, fill))
+		var sfen strings.Builder
+		hand := b.pos.Hands[c]
+		hand.SfenString(&sfen)
+		label := strings.ToUpper(sfen.String())
+		if label == "" {
+			label = "-"
+		}
+		d.texts = append(d.texts, text{
+			center: point{mark.x + s/2, mark.y}, size: 0.4 * s,
+			text: b.kanjiHand(c), label: label, start: true, upsideDown: false,
+		})
+	}
+
+	// arrows
+	for _, m := range opts.Arrows {
+		to := center(m.To())
+		var from point
+		if m.IsDrop() {
+			from = handCenter[m.Piece().Color()]
+		} else {
+			from = center(m.From())
+		}
+		length := math.Hypot(to.x-from.x, to.y-from.y)
+		if length == 0 {
+			continue
+		}
+		ux, uy := (to.x-from.x)/length, (to.y-from.y)/length
+		head := 0.35 * s
+		base := point{to.x - ux*head, to.y - uy*head}
+		d.arrows = append(d.arrows, segment(from, base, s/8, arrowColor))
+		d.arrows = append(d.arrows, polygon{
+			points: []point{
+				to,
+				{base.x - uy*head/2, base.y + ux*head/2},
+				{base.x + uy*head/2, base.y - ux*head/2},
+			},
+			fill:        arrowColor,
+			stroke:      color.RGBA{R: 0, G: 0, B: 0, A: 0},
+			strokeWidth: 0,
+		})
+	}
+	return d
+}
+
+// svgNumber formats a coordinate with at most two decimals.
+func svgNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+// svgColor formats a color and its opacity as SVG attributes.
+func svgColor(attr string, c color.RGBA) string {
+	s := fmt.Sprintf(`%s="#%02x%02x%02x"`, attr, c.R, c.G, c.B)
+	if c.A != 0xff {
+		s += fmt.Sprintf(` %s-opacity="%s"`, attr, svgNumber(float64(c.A)/0xff))
+	}
+	return s
+}
+
+// WriteSVG writes the diagram of a position as a SVG image.
+func WriteSVG(w io.Writer, pos *shogi.Position, opts DiagramOptions) error {
+	d := newDiagram(pos, opts)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		d.width, d.height, d.width, d.height)
+
+	for _, p := range d.polygons {
+		writeSvgPolygon(&sb, p)
+	}
+	sb.WriteString(`<g font-family="serif" text-anchor="middle" dominant-baseline="central">` + "\n")
+	for _, t := range d.texts {
+		fmt.Fprintf(&sb, `<text x="%s" y="%s" font-size="%s"`, svgNumber(t.center.x), svgNumber(t.center.y), svgNumber(t.size))
+		if t.start {
+			sb.WriteString(` text-anchor="start"`)
+		}
+		if t.upsideDown {
+			fmt.Fprintf(&sb, ` transform="rotate(180 %s %s)"`, svgNumber(t.center.x), svgNumber(t.center.y))
+		}
+		fmt.Fprintf(&sb, ">%s</text>\n", t.text)
+	}
+	sb.WriteString("</g>\n")
+	for _, p := range d.arrows {
+		writeSvgPolygon(&sb, p)
+	}
+	sb.WriteString("</svg>\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func writeSvgPolygon(sb *strings.Builder, p polygon) {
+	points := make([]string, len(p.points))
+	for i, pt := range p.points {
+		points[i] = svgNumber(pt.x) + "," + svgNumber(pt.y)
+	}
+	fmt.Fprintf(sb, `<polygon points="%s" %s`, strings.Join(points, " "), svgColor("fill", p.fill))
+	if p.strokeWidth > 0 {
+		fmt.Fprintf(sb, ` %s stroke-width="%s"`, svgColor("stroke", p.stroke), svgNumber(p.strokeWidth))
+	}
+	sb.WriteString("/>\n")
+}
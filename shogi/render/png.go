@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// Image returns the diagram of a position as an image.
+//
+// The image is drawn without anti-aliasing and its texts use a built-in 5x7 bitmap font:
+// pieces are shown with their SFEN letters, upside down for the opponent of the viewer.
+func Image(pos *shogi.Position, opts DiagramOptions) *image.RGBA {
+	d := newDiagram(pos, opts)
+	img := image.NewRGBA(image.Rect(0, 0, d.width, d.height))
+	for _, p := range d.polygons {
+		drawPolygon(img, p)
+	}
+	for _, t := range d.texts {
+		drawText(img, t)
+	}
+	for _, p := range d.arrows {
+		drawPolygon(img, p)
+	}
+	return img
+}
+
+// WritePNG writes the diagram of a position as a PNG image.
+func WritePNG(w io.Writer, pos *shogi.Position, opts DiagramOptions) error {
+	return png.Encode(w, Image(pos, opts))
+}
+
+// blend draws a pixel over the image, using the alpha of the color.
+func blend(img *image.RGBA, x, y int, c color.RGBA) {
+	if !(image.Point{X: x, Y: y}).In(img.Rect) {
+		return
+	}
+	if c.A == 0xff {
+		img.SetRGBA(x, y, c)
+		return
+	}
+	dst := img.RGBAAt(x, y)
+	mix := func(s, d uint8) uint8 {
+		return uint8((uint(s)*uint(c.A) + uint(d)*(0xff-uint(c.A)) + 0x7f) / 0xff)
+	}
+	img.SetRGBA(x, y, color.RGBA{R: mix(c.R, dst.R), G: mix(c.G, dst.G), B: mix(c.B, dst.B), A: 0xff})
+}
+
+// fillPolygon fills the pixels whose center is inside a polygon, using the even-odd rule.
+func fillPolygon(img *image.RGBA, points []point, c color.RGBA) {
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+	}
+	var xs []float64
+	for y := int(math.Floor(minY)); y <= int(math.Ceil(maxY)); y++ {
+		yc := float64(y) + 0.5
+		xs = xs[:0]
+		for i, p := range points {
+			q := points[(i+1)%len(points)]
+			if (p.y <= yc && yc < q.y) || (q.y <= yc && yc < p.y) {
+				xs = append(xs, p.x+(yc-p.y)*(q.x-p.x)/(q.y-p.y))
+			}
+		}
+		sort.Float64s(xs)
+		for i := 0; i+1 < len(xs); i += 2 {
+			for x := int(math.Ceil(xs[i] - 0.5)); x < int(math.Ceil(xs[i+1]-0.5)); x++ {
+				blend(img, x, y, c)
+			}
+		}
+	}
+}
+
+// drawPolygon fills a polygon then strokes its edges.
+func drawPolygon(img *image.RGBA, p polygon) {
+	fillPolygon(img, p.points, p.fill)
+	if p.strokeWidth == 0 {
+		return
+	}
+	for i, from := range p.points {
+		to := p.points[(i+1)%len(p.points)]
+		fillPolygon(img, segment(from, to, p.strokeWidth, p.stroke).points, p.stroke)
+	}
+}
+
+// drawText draws the label of a text with the bitmap font, at a scale matching its size.
+func drawText(img *image.RGBA, t text) {
+	scale := int(math.Max(1, math.Round(t.size*0.6/glyphHeight)))
+	width := (len(t.label)*(glyphWidth+1) - 1) * scale
+	x0, y0 := int(math.Round(t.center.x)), int(math.Round(t.center.y))-glyphHeight*scale/2
+	if !t.start {
+		x0 -= width / 2
+	}
+	for i, r := range t.label {
+		glyph := bitmapFont[r]
+		for gy, row := range glyph {
+			for gx := 0; gx < glyphWidth; gx++ {
+				if row&(1<<(glyphWidth-1-gx)) == 0 {
+					continue
+				}
+				x, y := x0+(i*(glyphWidth+1)+gx)*scale, y0+gy*scale
+				if t.upsideDown {
+					x, y = 2*x0+width-x-scale, 2*y0+glyphHeight*scale-y-scale
+				}
+				for dy := 0; dy < scale; dy++ {
+					for dx := 0; dx < scale; dx++ {
+						blend(img, x+dx, y+dy, lineColor)
+					}
+				}
+			}
+		}
+	}
+}
+
+const (
+	glyphWidth  = 5
+	glyphHeight = 7
+)
+
+// bitmapFont holds the glyphs needed by the diagrams, one byte per row with the leftmost pixel
+// in bit 4. Missing characters are drawn as spaces.
+var bitmapFont = map[rune][glyphHeight]uint8{
+	'0': {0x0e, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0e},
+	'1': {0x04, 0x0c, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	'2': {0x0e, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1f},
+	'3': {0x1f, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0e},
+	'4': {0x02, 0x06, 0x0a, 0x12, 0x1f, 0x02, 0x02},
+	'5': {0x1f, 0x10, 0x1e, 0x01, 0x01, 0x11, 0x0e},
+	'6': {0x06, 0x08, 0x10, 0x1e, 0x11, 0x11, 0x0e},
+	'7': {0x1f, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8': {0x0e, 0x11, 0x11, 0x0e, 0x11, 0x11, 0x0e},
+	'9': {0x0e, 0x11, 0x11, 0x0f, 0x01, 0x02, 0x0c},
+	'+': {0x00, 0x04, 0x04, 0x1f, 0x04, 0x04, 0x00},
+	'-': {0x00, 0x00, 0x00, 0x1f, 0x00, 0x00, 0x00},
+	'B': {0x1e, 0x11, 0x11, 0x1e, 0x11, 0x11, 0x1e},
+	'G': {0x0e, 0x11, 0x10, 0x17, 0x11, 0x11, 0x0f},
+	'K': {0x11, 0x12, 0x14, 0x18, 0x14, 0x12, 0x11},
+	'L': {0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x1f},
+	'N': {0x11, 0x11, 0x19, 0x15, 0x13, 0x11, 0x11},
+	'P': {0x1e, 0x11, 0x11, 0x1e, 0x10, 0x10, 0x10},
+	'R': {0x1e, 0x11, 0x11, 0x1e, 0x14, 0x12, 0x11},
+	'S': {0x0f, 0x10, 0x10, 0x0e, 0x01, 0x01, 0x1e},
+	'a': {0x00, 0x00, 0x0e, 0x01, 0x0f, 0x11, 0x0f},
+	'b': {0x10, 0x10, 0x16, 0x19, 0x11, 0x11, 0x1e},
+	'c': {0x00, 0x00, 0x0e, 0x10, 0x10, 0x11, 0x0e},
+	'd': {0x01, 0x01, 0x0d, 0x13, 0x11, 0x11, 0x0f},
+	'e': {0x00, 0x00, 0x0e, 0x11, 0x1f, 0x10, 0x0e},
+	'f': {0x06, 0x09, 0x08, 0x1c, 0x08, 0x08, 0x08},
+	'g': {0x00, 0x0f, 0x11, 0x11, 0x0f, 0x01, 0x0e},
+	'h': {0x10, 0x10, 0x16, 0x19, 0x11, 0x11, 0x11},
+	'i': {0x04, 0x00, 0x0c, 0x04, 0x04, 0x04, 0x0e},
+}
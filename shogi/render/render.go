@@ -0,0 +1,294 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+
+// Package render draws shogi positions as text: ASCII with SFEN letters, traditional
+// Japanese diagrams with kanji, Unicode boards and colour ANSI terminals,
+// and as SVG or PNG images.
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// A Style is a way to draw a position.
+type Style int
+
+const (
+	ASCII   Style = iota // SFEN letters in an ASCII grid
+	Kanji                // Traditional Japanese diagram: kanji pieces, 一..九 ranks, ▲/△ hands
+	Unicode              // Kanji pieces in a Unicode box drawing grid, ☗/☖ hands
+	ANSI                 // Unicode grid with colours, White's pieces in red
+)
+
+var styleNames = []string{"ascii", "kanji", "unicode", "ansi"}
+
+// Styles returns the names of the supported styles.
+func Styles() []string {
+	return append([]string{}, styleNames...)
+}
+
+// NewStyle returns the Style from its name.
+func NewStyle(name string) (Style, error) {
+	for i, n := range styleNames {
+		if n == name {
+			return Style(i), nil
+		}
+	}
+	return ASCII, fmt.Errorf("unknown style '%s'", name)
+}
+
+// String returns the name of the style.
+func (s Style) String() string {
+	return styleNames[s]
+}
+
+// Options are the rendering options.
+type Options struct {
+	// Style of the drawing
+	Style Style
+	// Flip draws the board seen from White's side
+	Flip bool
+}
+
+// Render returns the drawing of a position, board and hands, ending with a new line.
+func Render(pos *shogi.Position, opts Options) string {
+	b := board{pos: pos, flip: opts.Flip}
+	switch opts.Style {
+	case Kanji:
+		return b.kanji()
+	case Unicode:
+		return b.unicode(false)
+	case ANSI:
+		return b.unicode(true)
+	}
+	return b.ascii()
+}
+
+var (
+	fullWidthDigits = []rune("０１２３４５６７８９")
+	kanjiNumerals   = []rune("〇一二三四五六七八九")
+)
+
+// kanjiPieces are the one character names of the black pieces.
+var kanjiPieces = map[shogi.Piece]string{
+	shogi.BlackPawn:           "歩",
+	shogi.BlackLance:          "香",
+	shogi.BlackKnight:         "桂",
+	shogi.BlackSilver:         "銀",
+	shogi.BlackGold:           "金",
+	shogi.BlackBishop:         "角",
+	shogi.BlackRook:           "飛",
+	shogi.BlackKing:           "玉",
+	shogi.BlackPromotedPawn:   "と",
+	shogi.BlackPromotedLance:  "杏",
+	shogi.BlackPromotedKnight: "圭",
+	shogi.BlackPromotedSilver: "全",
+	shogi.BlackPromotedBishop: "馬",
+	shogi.BlackPromotedRook:   "龍",
+}
+
+// kanji returns the one character name of a piece of any color.
+func kanji(p shogi.Piece) string {
+	return kanjiPieces[p%shogi.PIECE_TYPES]
+}
+
+// kanjiCount returns a count from 1 to 18 in kanji, e.g. "十八".
+func kanjiCount(n int) string {
+	if n < 10 {
+		return string(kanjiNumerals[n])
+	}
+	if n == 10 {
+		return "十"
+	}
+	return "十" + string(kanjiNumerals[n-10])
+}
+
+// colorMarks are the marks of the colors, traditional and Unicode.
+var colorMarks = [2][shogi.COLORS]string{
+	{"▲", "△"},
+	{"☗", "☖"},
+}
+
+// A board is a position being drawn.
+type board struct {
+	pos  *shogi.Position
+	flip bool
+}
+
+// files returns the file numbers from left to right.
+func (b board) files() []int {
+	files := make([]int, 0, b.pos.Variant.Files)
+	for file := b.pos.Variant.Files; file > 0; file-- {
+		files = append(files, file)
+	}
+	if b.flip {
+		for i, j := 0, len(files)-1; i < j; i, j = i+1, j-1 {
+			files[i], files[j] = files[j], files[i]
+		}
+	}
+	return files
+}
+
+// ranks returns the rank indexes from top to bottom, 0 being rank a.
+func (b board) ranks() []int {
+	ranks := make([]int, 0, b.pos.Variant.Ranks)
+	for rank := 0; rank < b.pos.Variant.Ranks; rank++ {
+		ranks = append(ranks, rank)
+	}
+	if b.flip {
+		for i, j := 0, len(ranks)-1; i < j; i, j = i+1, j-1 {
+			ranks[i], ranks[j] = ranks[j], ranks[i]
+		}
+	}
+	return ranks
+}
+
+// piece returns the piece on a square.
+func (b board) piece(file, rank int) shogi.Piece {
+	return b.pos.Board[rank*shogi.FILES+shogi.FILES-file]
+}
+
+// colors returns the colors of the players at the top and at the bottom of the board.
+func (b board) colors() (top, bottom shogi.Color) {
+	if b.flip {
+		return shogi.Black, shogi.White
+	}
+	return shogi.White, shogi.Black
+}
+
+// kanjiHand returns the pieces in hand of a color, e.g. "飛　歩二", or "なし".
+func (b board) kanjiHand(c shogi.Color) string {
+	var pieces []string
+	hand := b.pos.Hands[c]
+	for _, count := range []func() (shogi.Piece, int){hand.Rooks, hand.Bishops, hand.Golds, hand.Silvers, hand.Knights, hand.Lances, hand.Pawns} {
+		piece, n := count()
+		switch {
+		case n == 1:
+			pieces = append(pieces, kanji(piece))
+		case n > 1:
+			pieces = append(pieces, kanji(piece)+kanjiCount(n))
+		}
+	}
+	if len(pieces) == 0 {
+		return "なし"
+	}
+	return strings.Join(pieces, "　")
+}
+
+func (b board) ascii() string {
+	var sb strings.Builder
+	hLine := " +" + strings.Repeat("---+", b.pos.Variant.Files)
+	top, bottom := b.colors()
+	ranks := b.ranks()
+
+	for _, file := range b.files() {
+		fmt.Fprintf(&sb, "%4d", file)
+	}
+	fmt.Fprintf(&sb, "\n%s\n", hLine)
+	for i, rank := range ranks {
+		sb.WriteString(" |")
+		for _, file := range b.files() {
+			fmt.Fprintf(&sb, "%2s |", b.piece(file, rank))
+		}
+		fmt.Fprintf(&sb, "%c", 'a'+rank)
+		for _, c := range []shogi.Color{top, bottom} {
+			if (c == top && i != 0) || (c == bottom && i != len(ranks)-1) {
+				continue
+			}
+			if b.pos.Side == c {
+				sb.WriteString(" * [")
+			} else {
+				sb.WriteString("   [")
+			}
+			hand := b.pos.Hands[c]
+			hand.SfenString(&sb)
+			sb.WriteString("]")
+		}
+		fmt.Fprintf(&sb, "\n%s\n", hLine)
+	}
+	return sb.String()
+}
+
+func (b board) kanji() string {
+	var sb strings.Builder
+	marks := colorMarks[0]
+	top, bottom := b.colors()
+	border := "+" + strings.Repeat("---", b.pos.Variant.Files) + "+"
+
+	fmt.Fprintf(&sb, "%s持駒：%s\n", marks[top], b.kanjiHand(top))
+	sb.WriteString(" ")
+	for _, file := range b.files() {
+		sb.WriteString(" " + string(fullWidthDigits[file]))
+	}
+	fmt.Fprintf(&sb, "\n%s\n", border)
+	for _, rank := range b.ranks() {
+		sb.WriteString("|")
+		for _, file := range b.files() {
+			switch piece := b.piece(file, rank); {
+			case piece == shogi.NoPiece:
+				sb.WriteString(" ・")
+			case piece.Color() == shogi.White:
+				sb.WriteString("v" + kanji(piece))
+			default:
+				sb.WriteString(" " + kanji(piece))
+			}
+		}
+		fmt.Fprintf(&sb, "|%c\n", kanjiNumerals[rank+1])
+	}
+	fmt.Fprintf(&sb, "%s\n", border)
+	fmt.Fprintf(&sb, "%s持駒：%s\n", marks[bottom], b.kanjiHand(bottom))
+	fmt.Fprintf(&sb, "%s手番\n", marks[b.pos.Side])
+	return sb.String()
+}
+
+// ANSI escape sequences.
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+)
+
+func (b board) unicode(colors bool) string {
+	var sb strings.Builder
+	marks := colorMarks[1]
+	top, bottom := b.colors()
+	files := b.files()
+	line := func(left, middle, right string) string {
+		return left + strings.Repeat("───"+middle, len(files)-1) + "───" + right + "\n"
+	}
+
+	fmt.Fprintf(&sb, "%s持駒：%s\n", marks[top], b.kanjiHand(top))
+	for _, file := range files {
+		sb.WriteString("  " + string(fullWidthDigits[file]))
+	}
+	sb.WriteString("\n" + line("┌", "┬", "┐"))
+	for i, rank := range b.ranks() {
+		if i > 0 {
+			sb.WriteString(line("├", "┼", "┤"))
+		}
+		sb.WriteString("│")
+		for _, file := range files {
+			switch piece := b.piece(file, rank); {
+			case piece == shogi.NoPiece:
+				sb.WriteString("   ")
+			case colors && piece.Color() == shogi.White:
+				sb.WriteString(" " + ansiRed + kanji(piece) + ansiReset)
+			case colors:
+				sb.WriteString(" " + ansiBold + kanji(piece) + ansiReset)
+			case piece.Color() == shogi.White:
+				sb.WriteString("v" + kanji(piece))
+			default:
+				sb.WriteString(" " + kanji(piece))
+			}
+			sb.WriteString("│")
+		}
+		fmt.Fprintf(&sb, "%c\n", kanjiNumerals[rank+1])
+	}
+	sb.WriteString(line("└", "┴", "┘"))
+	fmt.Fprintf(&sb, "%s持駒：%s\n", marks[bottom], b.kanjiHand(bottom))
+	fmt.Fprintf(&sb, "%s手番\n", marks[b.pos.Side])
+	return sb.String()
+}
@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package render
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+// diagramPosition returns the position after 7g7f, with a rook in White's hand.
+func diagramPosition() (*shogi.Position, DiagramOptions) {
+	pos, _ := shogi.NewPositionFromSfen("lnsgkgsnl/1r5b1/ppppppppp/9/9/2P6/PP1PPPPPP/1B5R1/LNSGKGSNL w r 2")
+	opts := DiagramOptions{
+		Flip:     false,
+		LastMove: shogi.NewMove(0, shogi.NewSquareIndex("7g"), shogi.NewSquareIndex("7f"), shogi.NoPiece),
+		Arrows: []shogi.Move{
+			shogi.NewMove(0, shogi.NewSquareIndex("2b"), shogi.NewSquareIndex("8h"), shogi.NoPiece),
+			shogi.NewMove(shogi.MoveFlagDrop, 0, shogi.NewSquareIndex("5e"), shogi.WhiteRook),
+		},
+		SquareSize: 0,
+	}
+	return pos, opts
+}
+
+func TestWriteSVG(t *testing.T) {
+	pos, opts := diagramPosition()
+	var buf bytes.Buffer
+	if err := WriteSVG(&buf, pos, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	svg := buf.String()
+	for _, expected := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg" width="504" height="600" viewBox="0 0 504 600">`,
+		// highlighted 7f and 7g
+		`<polygon points="120,336 168,336 168,384 120,384" fill="#ffe000" fill-opacity="0.5"/>`,
+		`<polygon points="120,384 168,384 168,432 120,432" fill="#ffe000" fill-opacity="0.5"/>`,
+		// White's lance on 9a, upside down, and Black's pawn on 7f
+		`<text x="48" y="117.6" font-size="26.4" transform="rotate(180 48 117.6)">香</text>`,
+		`<text x="144" y="362.4" font-size="26.4">歩</text>`,
+		`<text x="468" y="120" font-size="16">一</text>`,
+		`<text x="72" y="48" font-size="19.2" text-anchor="start">飛</text>`,
+		`<text x="72" y="552" font-size="19.2" text-anchor="start">なし</text>`,
+	} {
+		if !strings.Contains(svg, expected) {
+			t.Errorf("missing %s", expected)
+		}
+	}
+	if n := strings.Count(svg, `fill="#c00000" fill-opacity="0.63"`); n != 4 {
+		t.Errorf("expected 2 arrows of 2 polygons, got %d polygons", n)
+	}
+	if strings.LastIndex(svg, "<text") > strings.Index(svg, `fill="#c00000"`) {
+		t.Errorf("arrows must be drawn over the pieces")
+	}
+}
+
+func TestWritePNG(t *testing.T) {
+	pos, opts := diagramPosition()
+	var buf bytes.Buffer
+	if err := WritePNG(&buf, pos, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if size := img.Bounds().Size(); size.X != 504 || size.Y != 600 {
+		t.Fatalf("unexpected size %v", size)
+	}
+	rgba := func(x, y int) color.RGBA {
+		r, g, b, a := img.At(x, y).RGBA()
+		return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}
+	}
+	tests := []struct {
+		x, y     int
+		expected color.RGBA
+		what     string
+	}{
+		{x: 2, y: 2, expected: whiteColor, what: "background"},
+		{x: 26, y: 290, expected: boardColor, what: "empty square 9e"},
+		{x: 23, y: 290, expected: lineColor, what: "grid"},
+		{x: 122, y: 386, expected: color.RGBA{R: 0xf9, G: 0xd8, B: 0x47, A: 0xff}, what: "highlighted square 7g"},
+	}
+	for _, tc := range tests {
+		if got := rgba(tc.x, tc.y); got != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.what, tc.expected, got)
+		}
+	}
+	if c := rgba(139, 173); c.R < 0xa0 || c.G > 0x60 {
+		t.Errorf("drop arrow: expected red, got %v", c)
+	}
+}
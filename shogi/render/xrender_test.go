@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package render
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vinymeuh/hifumi/shogi"
+)
+
+func TestStyles(t *testing.T) {
+	for _, name := range Styles() {
+		style, err := NewStyle(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if style.String() != name {
+			t.Errorf("expected style %s, got %s", name, style)
+		}
+	}
+	if _, err := NewStyle("sixel"); err == nil {
+		t.Errorf("expected an error for an unknown style")
+	}
+}
+
+func TestRender(t *testing.T) {
+	v, _ := shogi.VariantByName("minishogi")
+	pos, _ := v.NewPositionFromSfen("rb1gk/4p/2s2/P4/KG1BR w Ps2p 2")
+
+	tests := []struct {
+		opts     Options
+		expected string
+	}{
+		{
+			opts: Options{Style: ASCII, Flip: false},
+			expected: `   5   4   3   2   1
+ +---+---+---+---+---+
+ | r | b |   | g | k |a * [s2p]
+ +---+---+---+---+---+
+ |   |   |   |   | p |b
+ +---+---+---+---+---+
+ |   |   | s |   |   |c
+ +---+---+---+---+---+
+ | P |   |   |   |   |d
+ +---+---+---+---+---+
+ | K | G |   | B | R |e   [P]
+ +---+---+---+---+---+
+`,
+		},
+		{
+			opts: Options{Style: Kanji, Flip: false},
+			expected: `△持駒：銀　歩二
+  ５ ４ ３ ２ １
++---------------+
+|v飛v角 ・v金v玉|一
+| ・ ・ ・ ・v歩|二
+| ・ ・v銀 ・ ・|三
+| 歩 ・ ・ ・ ・|四
+| 玉 金 ・ 角 飛|五
++---------------+
+▲持駒：歩
+△手番
+`,
+		},
+		{
+			opts: Options{Style: Kanji, Flip: true},
+			expected: `▲持駒：歩
+  １ ２ ３ ４ ５
++---------------+
+| 飛 角 ・ 金 玉|五
+| ・ ・ ・ ・ 歩|四
+| ・ ・v銀 ・ ・|三
+|v歩 ・ ・ ・ ・|二
+|v玉v金 ・v角v飛|一
++---------------+
+△持駒：銀　歩二
+△手番
+`,
+		},
+		{
+			opts: Options{Style: Unicode, Flip: false},
+			expected: `☖持駒：銀　歩二
+  ５  ４  ３  ２  １
+┌───┬───┬───┬───┬───┐
+│v飛│v角│   │v金│v玉│一
+├───┼───┼───┼───┼───┤
+│   │   │   │   │v歩│二
+├───┼───┼───┼───┼───┤
+│   │   │v銀│   │   │三
+├───┼───┼───┼───┼───┤
+│ 歩│   │   │   │   │四
+├───┼───┼───┼───┼───┤
+│ 玉│ 金│   │ 角│ 飛│五
+└───┴───┴───┴───┴───┘
+☗持駒：歩
+☖手番
+`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.opts.Style.String(), func(t *testing.T) {
+			if got := Render(pos, tc.opts); got != tc.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tc.expected, got)
+			}
+		})
+	}
+
+	ansi := Render(pos, Options{Style: ANSI, Flip: false})
+	if !strings.Contains(ansi, "│ "+ansiRed+"飛"+ansiReset+"│") || !strings.Contains(ansi, "│ "+ansiBold+"玉"+ansiReset+"│") {
+		t.Errorf("unexpected ANSI colors:\n%s", ansi)
+	}
+}
+
+func TestKanjiHand(t *testing.T) {
+	pos, _ := shogi.NewPositionFromSfen("4k4/9/9/9/9/9/9/9/4K4 b RB2G3S4N4L18P 1")
+	b := board{pos: pos, flip: false}
+	if hand := b.kanjiHand(shogi.Black); hand != "飛　角　金二　銀三　桂四　香四　歩十八" {
+		t.Errorf("unexpected hand %s", hand)
+	}
+	if hand := b.kanjiHand(shogi.White); hand != "なし" {
+		t.Errorf("unexpected hand %s", hand)
+	}
+}
@@ -0,0 +1,32 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package shogi
+
+// A MoveGenerator provides the parts of the rules which depend on move generation.
+//
+// Package movegen imports package shogi, so it registers its MoveGenerator at
+// initialization rather than being imported here: programs using the Position
+// methods documented as requiring it must import package movegen.
+type MoveGenerator interface {
+	// InCheck returns true if the king of the color is attacked.
+	InCheck(p *Position, c Color) bool
+	// LegalMoves returns the legal moves of the side to move.
+	LegalMoves(p *Position) []Move
+	// PseudoLegalMoves returns the moves of the side to move, including those leaving its king in check.
+	PseudoLegalMoves(p *Position) []Move
+}
+
+var moveGenerator MoveGenerator
+
+// RegisterMoveGenerator sets the MoveGenerator used by Position.
+func RegisterMoveGenerator(g MoveGenerator) {
+	moveGenerator = g
+}
+
+// mustMoveGenerator returns the registered MoveGenerator or panics.
+func mustMoveGenerator() MoveGenerator {
+	if moveGenerator == nil {
+		panic("no MoveGenerator registered, package movegen must be imported")
+	}
+	return moveGenerator
+}
@@ -4,23 +4,28 @@ package shogi
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
-	"unicode"
 )
 
 // StartPos is a SFEN string corresponding to the default Shogi starting position.
 const StartPos = "lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1"
 
-// NewPositionFromSfen creates a new Position from a SFEN string, returns nil if input is not valid.
+// NewPositionFromSfen creates a new standard shogi Position from a SFEN string, returns nil if input is not valid.
 func NewPositionFromSfen(sfen string) (*Position, error) {
+	return Standard.NewPositionFromSfen(sfen)
+}
+
+// NewPositionFromSfen creates a new Position of the variant from a SFEN string, returns nil if input is not valid.
+func (v *Variant) NewPositionFromSfen(sfen string) (*Position, error) {
 	fields := strings.Fields(sfen)
 	if len(fields) < 3 || len(fields) > 4 {
 		return nil, fmt.Errorf("SFEN string must have between 3 and 4 parts")
 	}
 
 	// board state
-	g := newPosition()
+	g := newPosition(v)
 	if err := g.sfenParseBoard(fields[0]); err != nil {
 		return nil, err
 	}
@@ -52,6 +57,7 @@ func NewPositionFromSfen(sfen string) (*Position, error) {
 		g.Ply = 1
 	}
 
+	g.key = g.computeKey()
 	return g, nil
 }
 
@@ -61,8 +67,9 @@ func (p Position) Sfen() string {
 
 	// board
 	var emptySquare int
-	for i, k := range p.Board {
-		if i%FILES == 0 && i > 0 {
+	for i := 0; i < p.Variant.Files*p.Variant.Ranks; i++ {
+		k := p.Board[p.Variant.square(i)]
+		if i%p.Variant.Files == 0 && i > 0 {
 			if emptySquare > 0 {
 				sb.WriteString(strconv.Itoa(emptySquare))
 				emptySquare = 0
@@ -80,6 +87,9 @@ func (p Position) Sfen() string {
 			sb.WriteString(k.String())
 		}
 	}
+	if emptySquare > 0 {
+		sb.WriteString(strconv.Itoa(emptySquare))
+	}
 
 	// side to move
 	switch p.Side {
@@ -106,49 +116,79 @@ func (p Position) Sfen() string {
 	return sb.String()
 }
 
+// sfenParseBoard parses the board part of a SFEN string, rank by rank from the top.
 func (p *Position) sfenParseBoard(str string) error {
-	for ch, sq := 0, 0; sq < SQUARES; ch, sq = ch+1, sq+1 {
-		token := string(str[ch])
-		switch {
-		case sq == 0 && token == "/":
-			return fmt.Errorf("SFEN can't begin with a '/'")
-		case strings.Contains("123456789", token): //nolint:gocritic
-			n, _ := strconv.Atoi(token)
-			sq += n - 1
-		case token == "/":
-			sq-- // move back current square counter as '/' does not represent a square
-			if sq%FILES != 0 {
-				sq = FILES*((sq+FILES)/FILES) - 1
+	files, ranks := p.Variant.Files, strings.Split(str, "/")
+	if len(ranks) != p.Variant.Ranks {
+		return fmt.Errorf("SFEN board must have %d ranks separated by '/', found %d", p.Variant.Ranks, len(ranks))
+	}
+
+	for r, rank := range ranks {
+		file := 0 // count of squares already read in the rank
+		for i := 0; i < len(rank); i++ {
+			var token string
+			switch ch := rank[i]; {
+			case ch >= '1' && ch <= '9':
+				file += int(ch - '0')
+				continue
+			case ch == '+':
+				if i+1 == len(rank) {
+					return fmt.Errorf("SFEN board rank %c: '+' at position %d must be followed by a piece", 'a'+r, i+1)
+				}
+				token = rank[i : i+2]
+				i++
+			default:
+				token = rank[i : i+1]
 			}
-		case token == "+":
-			ch++
-			token += string(str[ch])
-			fallthrough
-		default:
+
 			k, err := NewPiece(token)
 			if err != nil {
-				return fmt.Errorf("SFEN invalid character in board")
+				return fmt.Errorf("SFEN board rank %c: invalid piece %q at position %d", 'a'+r, token, i+1)
+			}
+			if file >= files {
+				return fmt.Errorf("SFEN board rank %c must have %d squares", 'a'+r, files)
 			}
-			p.SetPiece(k, uint8(sq))
+			p.SetPiece(k, p.Variant.square(r*files+file))
+			file++
+		}
+		if file != files {
+			return fmt.Errorf("SFEN board rank %c must have %d squares", 'a'+r, files)
 		}
 	}
 	return nil
 }
 
-func (p *Position) sfenParseHands(txt string) error {
-	var n = 1
-	for _, ch := range txt {
-		switch {
-		case unicode.IsDigit(ch):
-			n, _ = strconv.Atoi(string(ch))
-		default:
-			pc, err := NewPiece(string(ch))
-			if err == nil {
-				p.Hands[pc.Color()].SetCount(pc, n)
-			} else {
-				return fmt.Errorf("SFEN invalid character in hand")
+// sfenParseHands parses the hands part of a SFEN string, where each piece is
+// optionally preceded by its count (e.g. "S2Pb18p").
+func (p *Position) sfenParseHands(str string) error {
+	n, digits := 0, false
+	for i := 0; i < len(str); i++ {
+		ch := str[i]
+		if ch >= '0' && ch <= '9' {
+			n, digits = 10*n+int(ch-'0'), true
+			if n > maxHandCount {
+				return fmt.Errorf("SFEN hand count at position %d can't be greater than %d", i+1, maxHandCount)
 			}
+			continue
+		}
+
+		pc, err := NewPiece(string(ch))
+		if err != nil || !slices.Contains(handPieces[pc.Color()], pc) {
+			return fmt.Errorf("SFEN hand: invalid piece %q at position %d", ch, i+1)
 		}
+		switch {
+		case digits && n == 0:
+			return fmt.Errorf("SFEN hand count for piece at position %d must be positive", i+1)
+		case p.Hands[pc.Color()].ByPiece[pc] > 0:
+			return fmt.Errorf("SFEN hand: piece %q at position %d already found", ch, i+1)
+		case !digits:
+			n = 1
+		}
+		p.Hands[pc.Color()].SetCount(pc, n)
+		n, digits = 0, false
+	}
+	if digits {
+		return fmt.Errorf("SFEN hand can't end with a count")
 	}
 	return nil
 }
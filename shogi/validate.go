@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package shogi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A KingCountError reports a color without exactly one king on the board.
+type KingCountError struct {
+	Color Color
+	Count int
+}
+
+func (e KingCountError) Error() string {
+	return fmt.Sprintf("%s has %d kings on the board", e.Color, e.Count)
+}
+
+// A DoublePawnError reports two unpromoted pawns of a color on the same file (nifu).
+type DoublePawnError struct {
+	Color Color
+	File  int
+}
+
+func (e DoublePawnError) Error() string {
+	return fmt.Sprintf("%s has two pawns on file %d", e.Color, e.File)
+}
+
+// A DeadPieceError reports a piece standing on a square where it has no more legal move.
+type DeadPieceError struct {
+	Piece  Piece
+	Square uint8
+}
+
+func (e DeadPieceError) Error() string {
+	return fmt.Sprintf("piece %s can't stand on %s", e.Piece, SquareString(e.Square))
+}
+
+// An OpponentInCheckError reports that the king of the side not to move is attacked.
+type OpponentInCheckError struct {
+	Color Color
+}
+
+func (e OpponentInCheckError) Error() string {
+	return fmt.Sprintf("%s is in check but is not the side to move", e.Color)
+}
+
+// A TooManyPiecesError reports more pieces of a kind, promoted or not, on the board
+// and in the hands than in the set of the variant.
+type TooManyPiecesError struct {
+	Piece Piece
+	Count int
+	Max   int
+}
+
+func (e TooManyPiecesError) Error() string {
+	return fmt.Sprintf("%d pieces %s found, the set contains %d", e.Count, e.Piece, e.Max)
+}
+
+// Validate checks that the Position can happen in a game of its variant. All violations are
+// returned joined, each one being a KingCountError, a DoublePawnError, a DeadPieceError or
+// a TooManyPiecesError. An OpponentInCheckError is only returned for an otherwise valid Position.
+// Requires package movegen.
+func (p *Position) Validate() error {
+	var errs []error
+
+	var kings [COLORS]int
+	var pawnFiles [COLORS][FILES + 1]bool
+	var pieces [PIECE_TYPES]int
+	for sq := uint8(0); sq < SQUARES; sq++ {
+		piece := p.Board[sq]
+		if piece == NoPiece {
+			continue
+		}
+		c := piece.Color()
+		pieces[piece.UnPromote()%PIECE_TYPES]++
+
+		switch piece {
+		case BlackKing, WhiteKing:
+			kings[c]++
+		case BlackPawn, WhitePawn:
+			file := SquareFile(sq)
+			if pawnFiles[c][file] && !p.Variant.PieceFlipping {
+				errs = append(errs, DoublePawnError{Color: c, File: file})
+			}
+			pawnFiles[c][file] = true
+		}
+
+		if p.Variant.DeadZone(piece).Bit(uint(sq)) == 1 {
+			errs = append(errs, DeadPieceError{Piece: piece, Square: sq})
+		}
+	}
+	for c := range p.Hands {
+		for piece, n := range p.Hands[c].ByPiece {
+			pieces[piece.UnPromote()%PIECE_TYPES] += n
+		}
+	}
+
+	for _, c := range []Color{Black, White} {
+		if kings[c] != 1 {
+			errs = append(errs, KingCountError{Color: c, Count: kings[c]})
+		}
+	}
+
+	for piece := BlackPawn; piece <= BlackRook; piece++ { // kings are checked above
+		if limit := p.Variant.pieces[piece]; pieces[piece] > limit {
+			errs = append(errs, TooManyPiecesError{Piece: piece, Count: pieces[piece], Max: limit})
+		}
+	}
+
+	if len(errs) == 0 && mustMoveGenerator().InCheck(p, p.Side.Opponent()) {
+		errs = append(errs, OpponentInCheckError{Color: p.Side.Opponent()})
+	}
+
+	return errors.Join(errs...)
+}
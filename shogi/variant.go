@@ -0,0 +1,171 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package shogi
+
+import (
+	"fmt"
+
+	"github.com/vinymeuh/hifumi/shogi/bitboard"
+)
+
+// A Variant describes the geometry and the rules of a shogi variant.
+//
+// Boards smaller than 9x9 are mapped on the upper right corner of the 9x9 board,
+// so that square indexes and USI coordinates are the same for all variants
+// (e.g. the Minishogi board goes from "5a" to "1e").
+type Variant struct {
+	// Name is the USI_Variant value for the variant
+	Name string
+	// Number of vertical lines
+	Files int
+	// Number of horizontal lines
+	Ranks int
+	// SFEN string of the starting position
+	StartPos string
+	// Number of ranks of the promotion zone
+	PromotionRanks int
+	// PieceFlipping is true when pieces must flip between their two faces at every
+	// move and can be dropped on either face (Kyoto shogi)
+	PieceFlipping bool
+	// Bitboard of the squares belonging to the board
+	Squares bitboard.Bitboard
+	// Bitboards of the promotion zone by color
+	promotionZones [COLORS]bitboard.Bitboard
+	// Bitboards of the last ranks by color, from 1 to 2 ranks
+	lastRanks [COLORS][2]bitboard.Bitboard
+	// Count of pieces by unpromoted black piece in the starting position
+	pieces [PIECE_TYPES]int
+}
+
+var (
+	// Standard is the default 9x9 shogi.
+	Standard = newVariant("shogi", 9, 9, StartPos, 3)
+	// Minishogi is the 5x5 variant where the promotion zone is the farthest rank.
+	Minishogi = newVariant("minishogi", 5, 5, "rbsgk/4p/5/P4/KGSBR b - 1", 1)
+	// Judkins is the 6x6 variant where the promotion zone is the two farthest ranks.
+	Judkins = newVariant("judkins", 6, 6, "rbnsgk/5p/6/6/P5/KGSNBR b - 1", 2)
+	// KyotoShogi is the 5x5 variant where pieces flip at every move: lance and tokin,
+	// knight and gold, silver and bishop, pawn and rook. A flipped piece is represented
+	// as the promoted piece of its unflipped face (e.g. +P moves like a rook).
+	KyotoShogi = newFlippingVariant("kyotoshogi", 5, 5, "p+nks+l/5/5/5/+LSK+NP b - 1")
+)
+
+// variants is the list of supported variants.
+var variants = []*Variant{Standard, Minishogi, Judkins, KyotoShogi}
+
+func init() {
+	for _, v := range variants {
+		start, err := v.NewPositionFromSfen(v.StartPos)
+		if err != nil {
+			panic(fmt.Sprintf("invalid starting position of %s: %v", v.Name, err))
+		}
+		for _, piece := range start.Board {
+			if piece != NoPiece {
+				v.pieces[piece.UnPromote()%PIECE_TYPES]++
+			}
+		}
+	}
+}
+
+func newVariant(name string, files, ranks int, startpos string, promotionRanks int) *Variant {
+	v := Variant{
+		Name:           name,
+		Files:          files,
+		Ranks:          ranks,
+		StartPos:       startpos,
+		PromotionRanks: promotionRanks,
+		PieceFlipping:  false,
+		Squares:        bitboard.Zero,
+		promotionZones: [COLORS]bitboard.Bitboard{},
+		lastRanks:      [COLORS][2]bitboard.Bitboard{},
+		pieces:         [PIECE_TYPES]int{},
+	}
+
+	for sq := uint8(0); sq < SQUARES; sq++ {
+		file, rank := SquareFile(sq), SquareRank(sq)
+		if file > files || rank > ranks {
+			continue
+		}
+		v.Squares = v.Squares.Set(uint(sq))
+		if rank <= promotionRanks {
+			v.promotionZones[Black] = v.promotionZones[Black].Set(uint(sq))
+		}
+		if rank > ranks-promotionRanks {
+			v.promotionZones[White] = v.promotionZones[White].Set(uint(sq))
+		}
+		for n := 0; n < 2; n++ {
+			if rank <= n+1 {
+				v.lastRanks[Black][n] = v.lastRanks[Black][n].Set(uint(sq))
+			}
+			if rank >= ranks-n {
+				v.lastRanks[White][n] = v.lastRanks[White][n].Set(uint(sq))
+			}
+		}
+	}
+	return &v
+}
+
+// newFlippingVariant creates a variant where pieces flip at every move, anywhere on the board.
+func newFlippingVariant(name string, files, ranks int, startpos string) *Variant {
+	v := newVariant(name, files, ranks, startpos, ranks)
+	v.PieceFlipping = true
+	return v
+}
+
+// Variants returns the names of the supported variants.
+func Variants() []string {
+	names := make([]string, len(variants))
+	for i, v := range variants {
+		names[i] = v.Name
+	}
+	return names
+}
+
+// VariantByName returns the Variant for a USI_Variant name.
+func VariantByName(name string) (*Variant, error) {
+	for _, v := range variants {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown variant '%s'", name)
+}
+
+// Contains returns true if the square belongs to the board of the variant.
+func (v *Variant) Contains(sq uint8) bool {
+	return v.Squares.Bit(uint(sq)) == 1
+}
+
+// PromotionZone returns the bitboard of the promotion zone for a color.
+func (v *Variant) PromotionZone(c Color) bitboard.Bitboard {
+	return v.promotionZones[c]
+}
+
+// DeadZone returns the bitboard of the squares where an unpromoted piece would
+// have no more legal move: last rank for pawns and lances, last two ranks for knights.
+// A piece can't be dropped on these squares and must promote when moving to them.
+// There is no dead zone in variants with piece flipping.
+func (v *Variant) DeadZone(p Piece) bitboard.Bitboard {
+	if v.PieceFlipping {
+		return bitboard.Zero
+	}
+	switch p { //nolint:exhaustive
+	case BlackPawn, BlackLance:
+		return v.lastRanks[Black][0]
+	case WhitePawn, WhiteLance:
+		return v.lastRanks[White][0]
+	case BlackKnight:
+		return v.lastRanks[Black][1]
+	case WhiteKnight:
+		return v.lastRanks[White][1]
+	}
+	return bitboard.Zero
+}
+
+// square returns the square index of the n-th square of the variant board,
+// squares being numbered from the upper left corner, rank by rank.
+func (v *Variant) square(n int) uint8 {
+	rank := n / v.Files
+	file := n % v.Files
+	return uint8(rank*FILES + (FILES - v.Files) + file)
+}
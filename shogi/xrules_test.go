@@ -0,0 +1,547 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package shogi_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vinymeuh/hifumi/shogi"
+	"github.com/vinymeuh/hifumi/shogi/movegen"
+)
+
+func TestDeclaration(t *testing.T) {
+	tests := []struct { //nolint:govet
+		sfen     string
+		rule     shogi.EnteringKingRule
+		points   int
+		expected bool
+	}{
+		// 10 pieces in camp, 28 points
+		{sfen: "+R+BGGSS+N+N+L/4K3L/9/9/9/9/9/9/4k4 b RB 1", rule: shogi.CSARule27, points: 28, expected: true},
+		{sfen: "+R+BGGSS+N+N+L/4K3L/9/9/9/9/9/9/4k4 b RB 1", rule: shogi.CSARule24, points: 28, expected: false},
+		{sfen: "+R+BGGSS+N+N+L/4K3L/9/9/9/9/9/9/4k4 b RB 1", rule: shogi.NoEnteringKing, points: 28, expected: false},
+		{sfen: "+R+BGGSS+N+N+L/4K3L/9/9/9/9/9/9/4k4 b RB4P 1", rule: shogi.CSARule24, points: 32, expected: true},
+		// 27 points is not enough for Black
+		{sfen: "+R+BGGSS+N+N+L/4K3L/9/9/9/9/9/9/4k4 b RG 1", rule: shogi.CSARule27, points: 24, expected: false},
+		// king not in camp
+		{sfen: "+R+BGGSSNNL/8L/9/4K4/9/9/9/9/4k4 b RB 1", rule: shogi.CSARule27, points: 28, expected: false},
+		// only 9 pieces in camp
+		{sfen: "+R+BGGSSNNL/4K4/9/9/9/9/9/9/4k4 b RBL 1", rule: shogi.CSARule27, points: 28, expected: false},
+		// king in check
+		{sfen: "+R+BGGSS+N+N+L/4K3L/5s3/9/9/9/9/9/4k4 b RB 1", rule: shogi.CSARule27, points: 28, expected: false},
+		// White needs 27 points
+		{sfen: "4K4/9/9/9/9/9/9/4k3l/+r+bggss+n+n+l w r4p 1", rule: shogi.CSARule27, points: 27, expected: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.rule.String()+"/"+tc.sfen, func(t *testing.T) {
+			p, err := shogi.NewPositionFromSfen(tc.sfen)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			p.EnteringKingRule = tc.rule
+			if points, _ := p.DeclarationPoints(p.Side); points != tc.points {
+				t.Fatalf("DeclarationPoints: expected=%d, got=%d", tc.points, points)
+			}
+			if p.CanDeclareWin() != tc.expected {
+				t.Fatalf("CanDeclareWin: expected=%v, got=%v", tc.expected, !tc.expected)
+			}
+		})
+	}
+}
+
+func TestEnteringKingRuleNames(t *testing.T) {
+	for _, name := range shogi.EnteringKingRules() {
+		rule, err := shogi.NewEnteringKingRule(name)
+		if err != nil || rule.String() != name {
+			t.Fatalf("%s: unexpected rule %s or error %v", name, rule, err)
+		}
+	}
+	if _, err := shogi.NewEnteringKingRule("CSARule99"); err == nil {
+		t.Fatalf("expected an error for an unknown rule")
+	}
+}
+
+func TestTryRule(t *testing.T) {
+	tests := []struct { //nolint:govet
+		sfen     string
+		move     shogi.Move
+		rule     shogi.EnteringKingRule
+		expected bool
+	}{
+		{
+			sfen:     "9/4K4/9/9/9/9/9/9/4k4 b - 1",
+			move:     shogi.NewMove(shogi.MoveFlagMove, shogi.NewSquareIndex("5b"), shogi.NewSquareIndex("5a"), shogi.NoPiece),
+			rule:     shogi.TryRule,
+			expected: true,
+		},
+		{
+			sfen:     "9/4K4/9/9/9/9/9/9/4k4 b - 1",
+			move:     shogi.NewMove(shogi.MoveFlagMove, shogi.NewSquareIndex("5b"), shogi.NewSquareIndex("5a"), shogi.NoPiece),
+			rule:     shogi.CSARule27,
+			expected: false,
+		},
+		{
+			sfen:     "9/4K4/9/9/9/9/9/9/4k4 b - 1",
+			move:     shogi.NewMove(shogi.MoveFlagMove, shogi.NewSquareIndex("5b"), shogi.NewSquareIndex("4a"), shogi.NoPiece),
+			rule:     shogi.TryRule,
+			expected: false,
+		},
+		{
+			sfen:     "4K4/9/9/9/9/9/9/4k4/9 w - 1",
+			move:     shogi.NewMove(shogi.MoveFlagMove, shogi.NewSquareIndex("5h"), shogi.NewSquareIndex("5i"), shogi.NoPiece),
+			rule:     shogi.TryRule,
+			expected: true,
+		},
+		{
+			sfen:     "3g5/4K4/9/9/9/9/9/9/4k4 b - 1",
+			move:     shogi.NewMove(shogi.MoveFlagMove|shogi.MoveFlagCapture, shogi.NewSquareIndex("5b"), shogi.NewSquareIndex("6a"), shogi.WhiteGold),
+			rule:     shogi.TryRule,
+			expected: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.rule.String()+"/"+tc.sfen+"/"+tc.move.String(), func(t *testing.T) {
+			p, err := shogi.NewPositionFromSfen(tc.sfen)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			p.EnteringKingRule = tc.rule
+			p.DoMove(tc.move)
+			if p.TryWin() != tc.expected {
+				t.Fatalf("TryWin: expected=%v, got=%v", tc.expected, !tc.expected)
+			}
+		})
+	}
+}
+
+// play applies USI moves to the position, failing on illegal moves.
+func play(t *testing.T, p *shogi.Position, moves ...string) {
+	t.Helper()
+	for _, str := range moves {
+		m, err := shogi.ParseMove(p, str)
+		if err != nil {
+			t.Fatalf("%v in %s", err, p.Sfen())
+		}
+		p.DoMove(m)
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	shuffle := []string{"5i5h", "5a5b", "5h5i", "5b5a"}
+	repeat := func(moves []string, n int) []string {
+		var all []string
+		for i := 0; i < n; i++ {
+			all = append(all, moves...)
+		}
+		return all
+	}
+
+	tests := []struct { //nolint:govet
+		name     string
+		sfen     string
+		rule     shogi.EnteringKingRule
+		moves    []string
+		expected shogi.Outcome
+	}{
+		{
+			name:     "ongoing",
+			sfen:     shogi.StartPos,
+			moves:    []string{"7g7f"},
+			expected: shogi.Outcome{Status: shogi.Ongoing, Winner: shogi.NoColor},
+		},
+		{
+			name:     "checkmate",
+			sfen:     "4k4/4G4/4P4/9/9/9/9/9/4K4 w - 1",
+			expected: shogi.Outcome{Status: shogi.Checkmate, Winner: shogi.Black},
+		},
+		{
+			name:     "no legal moves",
+			sfen:     "8k/6+R2/9/9/9/9/9/9/K8 w - 1",
+			expected: shogi.Outcome{Status: shogi.NoLegalMoves, Winner: shogi.Black},
+		},
+		{
+			name:     "pawn drop mate",
+			sfen:     "7lk/7p1/8G/9/9/9/9/9/K8 b P 1",
+			moves:    []string{"P*1b"},
+			expected: shogi.Outcome{Status: shogi.PawnDropMate, Winner: shogi.White},
+		},
+		{
+			name:     "third occurrence",
+			sfen:     "4k4/9/9/9/9/9/9/9/4K4 b - 1",
+			moves:    repeat(shuffle, 2),
+			expected: shogi.Outcome{Status: shogi.Ongoing, Winner: shogi.NoColor},
+		},
+		{
+			name:     "sennichite",
+			sfen:     "4k4/9/9/9/9/9/9/9/4K4 b - 1",
+			moves:    repeat(shuffle, 3),
+			expected: shogi.Outcome{Status: shogi.Sennichite, Winner: shogi.NoColor},
+		},
+		{
+			name:     "perpetual check",
+			sfen:     "4k4/R8/9/9/9/9/9/9/4K4 b - 1",
+			moves:    repeat([]string{"9b9a", "5a5b", "9a9b", "5b5a"}, 3),
+			expected: shogi.Outcome{Status: shogi.PerpetualCheck, Winner: shogi.White},
+		},
+		{
+			name:     "illegal position",
+			sfen:     "4k4/4R4/9/9/9/9/9/9/4K4 b - 1",
+			expected: shogi.Outcome{Status: shogi.IllegalPosition, Winner: shogi.NoColor},
+		},
+		{
+			name:     "declaration",
+			sfen:     "+R+BGGSS+N+N+L/4K3L/9/9/9/9/9/9/4k4 b RB 1",
+			rule:     shogi.CSARule27,
+			expected: shogi.Outcome{Status: shogi.DeclarationWin, Winner: shogi.Black},
+		},
+		{
+			name:     "try",
+			sfen:     "9/4K4/9/9/9/9/9/9/4k4 b - 1",
+			rule:     shogi.TryRule,
+			moves:    []string{"5b5a"},
+			expected: shogi.Outcome{Status: shogi.TryWin, Winner: shogi.Black},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := shogi.NewPositionFromSfen(tc.sfen)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			p.EnteringKingRule = tc.rule
+			play(t, p, tc.moves...)
+			if outcome := p.Outcome(); outcome != tc.expected {
+				t.Fatalf("expected=%v, got=%v", tc.expected, outcome)
+			}
+		})
+	}
+}
+
+func TestGameResult(t *testing.T) {
+	perpetual := []string{"9b9a", "5a5b", "9a9b", "5b5a"}
+	tests := []struct {
+		name     string
+		variant  *shogi.Variant
+		sfen     string
+		moves    []string
+		special  shogi.SpecialMove
+		expected shogi.GameResult
+	}{
+		{
+			name:     "unfinished",
+			sfen:     shogi.StartPos,
+			moves:    []string{"7g7f"},
+			expected: shogi.GameResult{Winner: shogi.NoColor, Reason: ""},
+		},
+		{
+			name:     "resign",
+			sfen:     shogi.StartPos,
+			moves:    []string{"7g7f"},
+			special:  shogi.SpecialResign,
+			expected: shogi.GameResult{Winner: shogi.Black, Reason: shogi.SpecialResign},
+		},
+		{
+			name:     "declaration",
+			sfen:     "+R+BGGSS+N+N+L/4K3L/9/9/9/9/9/9/4k4 b RB 1",
+			special:  shogi.SpecialKachi,
+			expected: shogi.GameResult{Winner: shogi.Black, Reason: shogi.SpecialKachi},
+		},
+		{
+			name:     "illegal action",
+			sfen:     shogi.StartPos,
+			special:  shogi.SpecialWhiteIllegalAction,
+			expected: shogi.GameResult{Winner: shogi.Black, Reason: shogi.SpecialWhiteIllegalAction},
+		},
+		{
+			name:     "draw",
+			sfen:     "4k4/9/9/9/9/9/9/9/4K4 b - 1",
+			moves:    []string{"5i5h", "5a5b", "5h5i", "5b5a"},
+			special:  shogi.SpecialSennichite,
+			expected: shogi.GameResult{Winner: shogi.NoColor, Reason: shogi.SpecialSennichite},
+		},
+		{
+			name:     "perpetual check",
+			sfen:     "4k4/R8/9/9/9/9/9/9/4K4 b - 1",
+			moves:    append(append(append([]string{}, perpetual...), perpetual...), perpetual...),
+			special:  shogi.SpecialSennichite,
+			expected: shogi.GameResult{Winner: shogi.White, Reason: shogi.SpecialSennichite},
+		},
+		{
+			name:     "abort",
+			sfen:     shogi.StartPos,
+			special:  shogi.SpecialAbort,
+			expected: shogi.GameResult{Winner: shogi.NoColor, Reason: shogi.SpecialAbort},
+		},
+		{
+			name:     "variant",
+			variant:  shogi.Minishogi,
+			sfen:     shogi.Minishogi.StartPos,
+			moves:    []string{"5d5c"},
+			special:  shogi.SpecialTimeUp,
+			expected: shogi.GameResult{Winner: shogi.Black, Reason: shogi.SpecialTimeUp},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			g := shogi.NewGame(tc.sfen)
+			g.Variant = tc.variant
+			p, err := g.Position()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, str := range tc.moves {
+				m, err := shogi.ParseMove(p, str)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				p.DoMove(m)
+				g.AddMove(m, time.Second)
+			}
+			if tc.special != "" {
+				g.End(tc.special, 0)
+			}
+			if g.IsOver() != (tc.special != "") {
+				t.Errorf("unexpected IsOver %v", g.IsOver())
+			}
+			if result := g.Result(); result != tc.expected {
+				t.Fatalf("expected=%v, got=%v", tc.expected, result)
+			}
+			if moves := g.MainLine(); len(moves) != len(tc.moves) {
+				t.Errorf("expected %d moves in the main line, got %d", len(tc.moves), len(moves))
+			}
+		})
+	}
+}
+
+func TestOutcomeSpecialMove(t *testing.T) {
+	tests := []struct {
+		outcome  shogi.Outcome
+		expected shogi.SpecialMove
+	}{
+		{outcome: shogi.Outcome{Status: shogi.Ongoing, Winner: shogi.NoColor}, expected: ""},
+		{outcome: shogi.Outcome{Status: shogi.Checkmate, Winner: shogi.White}, expected: shogi.SpecialTsumi},
+		{outcome: shogi.Outcome{Status: shogi.PawnDropMate, Winner: shogi.Black}, expected: shogi.SpecialWhiteIllegalAction},
+		{outcome: shogi.Outcome{Status: shogi.PerpetualCheck, Winner: shogi.Black}, expected: shogi.SpecialSennichite},
+		{outcome: shogi.Outcome{Status: shogi.DeclarationWin, Winner: shogi.Black}, expected: shogi.SpecialKachi},
+		{outcome: shogi.Outcome{Status: shogi.TryWin, Winner: shogi.Black}, expected: shogi.SpecialTsumi},
+	}
+	for _, tc := range tests {
+		if special := tc.outcome.SpecialMove(); special != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.outcome.Status, tc.expected, special)
+		}
+	}
+}
+
+func TestClock(t *testing.T) {
+	tests := []struct {
+		name      string
+		tc        shogi.TimeControl
+		used      []time.Duration // alternately by Black and White
+		remaining [shogi.COLORS]time.Duration
+		timeUp    bool // on the last move
+	}{
+		{
+			name: "unlimited",
+			used: []time.Duration{time.Hour, time.Hour},
+		},
+		{
+			name:      "sudden death",
+			tc:        shogi.TimeControl{Main: time.Minute},
+			used:      []time.Duration{40 * time.Second, 10 * time.Second, 30 * time.Second},
+			remaining: [shogi.COLORS]time.Duration{0, 50 * time.Second},
+			timeUp:    true,
+		},
+		{
+			name:      "byoyomi",
+			tc:        shogi.TimeControl{Main: time.Minute, Byoyomi: 10 * time.Second},
+			used:      []time.Duration{65 * time.Second, 10 * time.Second, 10 * time.Second},
+			remaining: [shogi.COLORS]time.Duration{0, 50 * time.Second},
+		},
+		{
+			name:      "fischer",
+			tc:        shogi.TimeControl{Main: time.Minute, Increment: 5 * time.Second},
+			used:      []time.Duration{30 * time.Second, 61 * time.Second},
+			remaining: [shogi.COLORS]time.Duration{35 * time.Second, 0},
+			timeUp:    true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := shogi.NewClock(tc.tc)
+			ok := true
+			for i, used := range tc.used {
+				ok = clock.Consume(shogi.Color(i%2), used)
+			}
+			if ok == tc.timeUp {
+				t.Errorf("expected time up %v", tc.timeUp)
+			}
+			if clock.Remaining != tc.remaining {
+				t.Errorf("expected remaining %v, got %v", tc.remaining, clock.Remaining)
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	p, _ := shogi.NewPositionFromSfen(shogi.StartPos)
+	play(t, p, "7g7f", "3c3d", "8h2b+", "3a2b", "B*4e")
+	q, _ := shogi.NewPositionFromSfen(p.Sfen())
+	if p.Key() != q.Key() {
+		t.Fatalf("incremental key %x differs from computed key %x", p.Key(), q.Key())
+	}
+	start, _ := shogi.NewPositionFromSfen(shogi.StartPos)
+	if p.Key() == start.Key() {
+		t.Fatalf("different positions must have different keys")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct { //nolint:govet
+		name     string
+		variant  *shogi.Variant
+		sfen     string
+		expected []error
+	}{
+		{name: "startpos", variant: shogi.Standard, sfen: shogi.StartPos},
+		{name: "handicap", variant: shogi.Standard, sfen: "lnsgkgsnl/9/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL w - 1"},
+		{name: "kyotoshogi", variant: shogi.KyotoShogi, sfen: shogi.KyotoShogi.StartPos},
+		{name: "kyotoshogi doubled pawns", variant: shogi.KyotoShogi, sfen: "4k/4p/4p/5/K4 b - 1"},
+		{
+			name:     "two kings",
+			variant:  shogi.Standard,
+			sfen:     "4k4/9/9/9/9/9/9/9/3KK4 b - 1",
+			expected: []error{shogi.KingCountError{Color: shogi.Black, Count: 2}},
+		},
+		{
+			name:    "no kings",
+			variant: shogi.Standard,
+			sfen:    "9/9/9/9/9/9/9/9/9 b - 1",
+			expected: []error{
+				shogi.KingCountError{Color: shogi.Black, Count: 0},
+				shogi.KingCountError{Color: shogi.White, Count: 0},
+			},
+		},
+		{
+			name:     "nifu",
+			variant:  shogi.Standard,
+			sfen:     "4k4/9/9/9/9/P8/P8/9/4K4 b - 1",
+			expected: []error{shogi.DoublePawnError{Color: shogi.Black, File: 9}},
+		},
+		{
+			name:    "dead pieces",
+			variant: shogi.Standard,
+			sfen:    "P3k4/1N7/9/9/9/9/9/9/4K3l b - 1",
+			expected: []error{
+				shogi.DeadPieceError{Piece: shogi.BlackPawn, Square: shogi.NewSquareIndex("9a")},
+				shogi.DeadPieceError{Piece: shogi.BlackKnight, Square: shogi.NewSquareIndex("8b")},
+				shogi.DeadPieceError{Piece: shogi.WhiteLance, Square: shogi.NewSquareIndex("1i")},
+			},
+		},
+		{
+			name:     "too many pieces",
+			variant:  shogi.Standard,
+			sfen:     "4k4/9/9/9/9/9/9/9/4K4 b 3Rb 1",
+			expected: []error{shogi.TooManyPiecesError{Piece: shogi.BlackRook, Count: 3, Max: 2}},
+		},
+		{
+			name:     "too many pieces in minishogi",
+			variant:  shogi.Minishogi,
+			sfen:     "4k/5/5/5/K4 b N 1",
+			expected: []error{shogi.TooManyPiecesError{Piece: shogi.BlackKnight, Count: 1, Max: 0}},
+		},
+		{
+			name:     "side not to move in check",
+			variant:  shogi.Standard,
+			sfen:     "4k4/4R4/9/9/9/9/9/9/4K4 b - 1",
+			expected: []error{shogi.OpponentInCheckError{Color: shogi.White}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := tc.variant.NewPositionFromSfen(tc.sfen)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err = p.Validate()
+			if len(tc.expected) == 0 && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(tc.expected) > 0 && err == nil {
+				t.Fatalf("expected errors %v, got none", tc.expected)
+			}
+			for _, e := range tc.expected {
+				if !errors.Is(err, e) {
+					t.Errorf("expected error '%v' in '%v'", e, err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseMove(t *testing.T) {
+	tests := []struct { //nolint:govet
+		name    string
+		variant *shogi.Variant
+		sfen    string
+		move    string
+		err     string
+	}{
+		{name: "move", sfen: shogi.StartPos, move: "7g7f"},
+		{name: "capture with promotion", sfen: "lnsgkgsnl/1r5b1/pppppp1pp/6p2/9/2P6/PP1PPPPPP/1B5R1/LNSGKGSNL b - 3", move: "8h2b+"},
+		{name: "capture without promotion", sfen: "lnsgkgsnl/1r5b1/pppppp1pp/6p2/9/2P6/PP1PPPPPP/1B5R1/LNSGKGSNL b - 3", move: "8h2b"},
+		{name: "drop", sfen: "4k4/9/9/9/9/9/9/9/4K4 w p 1", move: "P*5e"},
+		{name: "kyotoshogi flip", variant: shogi.KyotoShogi, sfen: shogi.KyotoShogi.StartPos, move: "1e1d+"},
+		{name: "kyotoshogi flip back", variant: shogi.KyotoShogi, sfen: shogi.KyotoShogi.StartPos, move: "5e5d-"},
+		{name: "kyotoshogi drop flipped", variant: shogi.KyotoShogi, sfen: "2k2/5/5/5/2K2 b P 1", move: "+P*3c"},
+		{name: "syntax", sfen: shogi.StartPos, move: "7g7", err: "invalid move 7g7: expected a move like 7g7f, 8h2b+ or P*5e"},
+		{name: "bad square", sfen: shogi.StartPos, move: "7g7z", err: "invalid move 7g7z: invalid square '7z'"},
+		{name: "outside of the board", variant: shogi.Minishogi, sfen: shogi.Minishogi.StartPos, move: "1e6e", err: "invalid move 1e6e: square 6e is outside of the board"},
+		{name: "bad suffix", sfen: shogi.StartPos, move: "7g7f=", err: "invalid move 7g7f=: unexpected suffix '='"},
+		{name: "empty square", sfen: shogi.StartPos, move: "5e5d", err: "illegal move 5e5d: no piece on 5e"},
+		{name: "opponent piece", sfen: shogi.StartPos, move: "3c3d", err: "illegal move 3c3d: piece on 3c belongs to the opponent"},
+		{name: "own piece", sfen: shogi.StartPos, move: "9i9g", err: "illegal move 9i9g: 9g is occupied by an own piece"},
+		{name: "geometry", sfen: shogi.StartPos, move: "7g6f", err: "illegal move 7g6f: P can't move from 7g to 6f"},
+		{name: "gold promotion", sfen: shogi.StartPos, move: "6i5h+", err: "illegal move 6i5h+: G can't be promoted"},
+		{name: "promotion outside of the zone", sfen: shogi.StartPos, move: "7g7f+", err: "illegal move 7g7f+: P can't be promoted outside of the promotion zone"},
+		{name: "must promote", sfen: "4k4/P8/9/9/9/9/9/9/4K4 b - 1", move: "9b9a", err: "illegal move 9b9a: P must be promoted on 9a"},
+		{name: "pinned", sfen: "4k4/4r4/9/9/9/9/9/4G4/4K4 b - 1", move: "5h6h", err: "illegal move 5h6h: king would be in check"},
+		{name: "not in hand", sfen: shogi.StartPos, move: "P*5e", err: "illegal move P*5e: no P in hand"},
+		{name: "drop on a piece", sfen: "4k4/9/9/9/9/9/9/9/4K4 b P 1", move: "P*5i", err: "illegal move P*5i: 5i is not empty"},
+		{name: "nifu", sfen: "4k4/9/9/9/9/9/4P4/9/4K4 b P 1", move: "P*5e", err: "illegal move P*5e: two pawns on file 5"},
+		{name: "dead drop", sfen: "4k4/9/9/9/9/9/9/9/4K4 b N 1", move: "N*1b", err: "illegal move N*1b: N dropped on 1b would have no legal move"},
+		{name: "promoted drop", sfen: "4k4/9/9/9/9/9/9/9/4K4 b P 1", move: "+P*5e", err: "invalid move +P*5e: can't drop '+P'"},
+		{name: "kyotoshogi no flip", variant: shogi.KyotoShogi, sfen: shogi.KyotoShogi.StartPos, move: "1e1d", err: "illegal move 1e1d: P must be flipped"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := tc.variant
+			if v == nil {
+				v = shogi.Standard
+			}
+			p, err := v.NewPositionFromSfen(tc.sfen)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			m, err := shogi.ParseMove(p, tc.move)
+			switch {
+			case tc.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tc.err == "" && !slices.Contains(movegen.LegalMoves(p), m):
+				t.Fatalf("move %s is not one of the generated moves", m)
+			case tc.err == "" && m.String() != tc.move:
+				t.Fatalf("expected=%s, got=%s", tc.move, m)
+			case tc.err != "" && (err == nil || err.Error() != tc.err):
+				t.Fatalf("expected error '%s', got '%v'", tc.err, err)
+			}
+		})
+	}
+}
@@ -38,6 +38,7 @@ func TestSFEN(t *testing.T) {
 		{sfen: StartPos},
 		{sfen: "lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/1NSGKGSNL w - 1"},
 		{sfen: "8l/1l+R2P3/p2pBG1pp/kps1p4/Nn1P2G2/P1P1P2PP/1PS6/1KSG3+r1/LN2+p3L w Sbgn3p 124"},
+		{sfen: "4k4/9/9/9/9/9/9/9/4K4 b 2R2B4G4S4N4L18p 1"},
 	}
 
 	for _, tc := range tests {
@@ -52,3 +53,128 @@ func TestSFEN(t *testing.T) {
 		})
 	}
 }
+
+func TestHandicaps(t *testing.T) {
+	tests := []struct {
+		name string
+		sfen string
+	}{
+		{name: "lance", sfen: "lnsgkgsn1/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL w - 1"},
+		{name: "bishop", sfen: "lnsgkgsnl/1r7/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL w - 1"},
+		{name: "rook", sfen: "lnsgkgsnl/7b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL w - 1"},
+		{name: "two-piece", sfen: "lnsgkgsnl/9/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL w - 1"},
+		{name: "ten-piece", sfen: "4k4/9/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL w - 1"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sfen, err := HandicapSfen(tc.name)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sfen != tc.sfen {
+				t.Fatalf("expected='%s', got='%s'", tc.sfen, sfen)
+			}
+		})
+	}
+
+	for _, name := range Handicaps() {
+		p, err := NewPositionFromHandicap(name)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if p.Side != White {
+			t.Fatalf("%s: White must move first", name)
+		}
+	}
+
+	if _, err := HandicapSfen("queen"); err == nil {
+		t.Fatalf("expected an error for an unknown handicap")
+	}
+}
+
+func TestVariantSFEN(t *testing.T) {
+	tests := []struct {
+		variant *Variant
+		sfen    string
+	}{
+		{variant: Minishogi, sfen: Minishogi.StartPos},
+		{variant: Minishogi, sfen: "r3k/2+B1p/2S2/P1g2/KG2R w Bsp 12"},
+		{variant: Standard, sfen: "lnsgkgsn1/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSN1 w - 1"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.variant.Name+"/"+tc.sfen, func(t *testing.T) {
+			g, err := tc.variant.NewPositionFromSfen(tc.sfen)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if g.Sfen() != tc.sfen {
+				t.Fatalf("expected='%s', got='%s'", tc.sfen, g.Sfen())
+			}
+		})
+	}
+
+	g, _ := Minishogi.NewPositionFromSfen(Minishogi.StartPos)
+	if g.Board[NewSquareIndex("1e")] != BlackRook || g.Board[NewSquareIndex("5a")] != WhiteRook {
+		t.Fatalf("Minishogi board must be mapped from 5a to 1e")
+	}
+}
+
+func TestSFENErrors(t *testing.T) {
+	tests := []struct {
+		sfen string
+		err  string
+	}{
+		{sfen: "lnsgkgsnl/1r5b1/ppppppppp b - 1", err: "SFEN board must have 9 ranks separated by '/', found 3"},
+		{sfen: "lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSN b - 1", err: "SFEN board rank i must have 9 squares"},
+		{sfen: "lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNLL b - 1", err: "SFEN board rank i must have 9 squares"},
+		{sfen: "lnsgkgsnl/1r5b2/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1", err: "SFEN board rank b must have 9 squares"},
+		{sfen: "lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSN+ b - 1", err: "SFEN board rank i: '+' at position 9 must be followed by a piece"},
+		{sfen: "lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSG+KGSNL b - 1", err: "SFEN board rank i: invalid piece \"+K\" at position 6"},
+		{sfen: "lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL x - 1", err: "SFEN second part must be 'b' for black or 'w' for white"},
+		{sfen: "4k4/9/9/9/9/9/9/9/4K4 b 19p 1", err: "SFEN hand count at position 2 can't be greater than 18"},
+		{sfen: "4k4/9/9/9/9/9/9/9/4K4 b 0p 1", err: "SFEN hand count for piece at position 2 must be positive"},
+		{sfen: "4k4/9/9/9/9/9/9/9/4K4 b PK 1", err: "SFEN hand: invalid piece 'K' at position 2"},
+		{sfen: "4k4/9/9/9/9/9/9/9/4K4 b P2P 1", err: "SFEN hand: piece 'P' at position 3 already found"},
+		{sfen: "4k4/9/9/9/9/9/9/9/4K4 b P2 1", err: "SFEN hand can't end with a count"},
+		{sfen: "4k4/9/9/9/9/9/9/9/4K4 b - 0", err: "SFEN fourth part must be a non null positive integer"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.sfen, func(t *testing.T) {
+			_, err := NewPositionFromSfen(tc.sfen)
+			if err == nil || err.Error() != tc.err {
+				t.Fatalf("expected error '%s', got '%v'", tc.err, err)
+			}
+		})
+	}
+}
+
+func FuzzSFEN(f *testing.F) {
+	for _, v := range variants {
+		f.Add(uint8(0), v.StartPos)
+	}
+	f.Add(uint8(0), "8l/1l+R2P3/p2pBG1pp/kps1p4/Nn1P2G2/P1P1P2PP/1PS6/1KSG3+r1/LN2+p3L w Sbgn3p 124")
+	f.Add(uint8(1), "r3k/2+B1p/2S2/P1g2/KG2R w Bsp 12")
+	f.Add(uint8(0), "4k4/9/9/9/9/9/9/9/4K4 b 2R2B4G4S4N4L18p 1")
+
+	f.Fuzz(func(t *testing.T, n uint8, sfen string) {
+		v := variants[int(n)%len(variants)]
+		g, err := v.NewPositionFromSfen(sfen)
+		if err != nil {
+			return
+		}
+		s := g.Sfen()
+		h, err := v.NewPositionFromSfen(s)
+		if err != nil {
+			t.Fatalf("can't parse '%s' obtained from '%s': %v", s, sfen, err)
+		}
+		if h.Sfen() != s {
+			t.Fatalf("expected='%s', got='%s'", s, h.Sfen())
+		}
+		if h.Board != g.Board || h.Key() != g.Key() {
+			t.Fatalf("'%s' and '%s' give different positions", sfen, s)
+		}
+	})
+}
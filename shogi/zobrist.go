@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2023 VinyMeuh
+// SPDX-License-Identifier: MIT
+package shogi
+
+import "math/rand"
+
+// maxHandCount is the maximum count of a piece in hand (18 pawns).
+const maxHandCount = 18
+
+// Zobrist keys used to compute the hash key of a Position.
+// https://www.chessprogramming.org/Zobrist_Hashing
+var (
+	zobristBoard [COLORS * PIECE_TYPES][SQUARES]uint64
+	zobristHand  [COLORS * PIECE_TYPES][maxHandCount + 1]uint64
+	zobristSide  uint64
+)
+
+func init() {
+	rng := rand.New(rand.NewSource(0x31F3)) //nolint:gosec // a fixed seed gives reproducible keys
+	for piece := range zobristBoard {
+		for sq := range zobristBoard[piece] {
+			zobristBoard[piece][sq] = rng.Uint64()
+		}
+		for n := range zobristHand[piece] {
+			zobristHand[piece][n] = rng.Uint64()
+		}
+	}
+	zobristSide = rng.Uint64()
+}
+
+// handPieces are the pieces which can be in hand by color.
+var handPieces = [COLORS][]Piece{
+	{BlackRook, BlackBishop, BlackGold, BlackSilver, BlackKnight, BlackLance, BlackPawn},
+	{WhiteRook, WhiteBishop, WhiteGold, WhiteSilver, WhiteKnight, WhiteLance, WhitePawn},
+}
+
+// Key returns the hash key of the Position, which identifies the board, the hands
+// and the side to move. It is used to detect repetitions.
+func (p *Position) Key() uint64 {
+	return p.key
+}
+
+// computeKey computes the hash key of the Position from scratch.
+func (p *Position) computeKey() uint64 {
+	var key uint64
+	for sq := uint8(0); sq < SQUARES; sq++ {
+		if piece := p.Board[sq]; piece != NoPiece {
+			key ^= zobristBoard[piece][sq]
+		}
+	}
+	for c := range p.Hands {
+		for _, piece := range handPieces[c] {
+			key ^= zobristHand[piece][min(p.Hands[c].ByPiece[piece], maxHandCount)]
+		}
+	}
+	if p.Side == White {
+		key ^= zobristSide
+	}
+	return key
+}
+
+// handPush adds a piece into the hand of a color, updating the hash key.
+func (p *Position) handPush(c Color, piece Piece) {
+	n := p.Hands[c].ByPiece[piece]
+	p.key ^= zobristHand[piece][min(n, maxHandCount)] ^ zobristHand[piece][min(n+1, maxHandCount)]
+	p.Hands[c].Push(piece)
+}
+
+// handPop removes a piece from the hand of a color, updating the hash key.
+func (p *Position) handPop(c Color, piece Piece) {
+	n := p.Hands[c].ByPiece[piece]
+	p.key ^= zobristHand[piece][min(n, maxHandCount)] ^ zobristHand[piece][max(n-1, 0)]
+	p.Hands[c].Pop(piece)
+}